@@ -0,0 +1,32 @@
+package quicktemplate
+
+func appendCSSEscape(dst []byte, src string) []byte {
+	n := len(src)
+	if n > 0 {
+		// Hint the compiler to remove bounds checks in the loop below.
+		_ = src[n-1]
+	}
+	for i := 0; i < n; i++ {
+		c := src[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '#' || c == '%' || c == ',' || c >= 0x80 {
+			dst = append(dst, c)
+			continue
+		}
+		// See https://www.w3.org/TR/css-syntax-3/#consume-escaped-code-point .
+		// The trailing space terminates the escape sequence.
+		dst = append(dst, '\\')
+		if c >= 16 {
+			dst = append(dst, hexCharLower(c>>4))
+		}
+		dst = append(dst, hexCharLower(c&15), ' ')
+	}
+	return dst
+}
+
+func hexCharLower(c byte) byte {
+	if c < 10 {
+		return '0' + c
+	}
+	return c - 10 + 'a'
+}
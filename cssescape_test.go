@@ -0,0 +1,25 @@
+package quicktemplate
+
+import (
+	"testing"
+)
+
+func TestAppendCSSEscape(t *testing.T) {
+	testAppendCSSEscape(t, "", "")
+	testAppendCSSEscape(t, "red", "red")
+	testAppendCSSEscape(t, "#ddd", "#ddd")
+	testAppendCSSEscape(t, "1.5em", "1.5em")
+	testAppendCSSEscape(t, "Times New Roman", `Times\20 New\20 Roman`)
+	testAppendCSSEscape(t, "привет", "привет")
+	testAppendCSSEscape(t, "\x00\n", `\0 \a `)
+	testAppendCSSEscape(t, `red;background:url("javascript:alert(1)")`,
+		`red\3b background\3a url\28 \22 javascript\3a alert\28 1\29 \22 \29 `)
+	testAppendCSSEscape(t, "</style><script>", `\3c \2f style\3e \3c script\3e `)
+}
+
+func testAppendCSSEscape(t *testing.T, s, expectedResult string) {
+	result := appendCSSEscape(nil, s)
+	if string(result) != expectedResult {
+		t.Fatalf("unexpected result %q. Expecting %q. str=%q", result, expectedResult, s)
+	}
+}
@@ -1,10 +1,12 @@
 // This file is automatically generated by qtc from "basepage.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: 1400a1a685fa11fea9264868f9f7d8321bd9f564a1ba1bd5aa6e83ca41c5b603
 
-//line templates/basepage.qtpl:1
+//line examples/basicserver/templates/basepage.qtpl:1:1
 package templates
 
-//line templates/basepage.qtpl:1
+//line examples/basicserver/templates/basepage.qtpl:1:1
 import (
 	qtio422016 "io"
 
@@ -14,41 +16,38 @@ import (
 // This is a base page template. All the other template pages implement this interface.
 //
 
-//line templates/basepage.qtpl:3
+//line examples/basicserver/templates/basepage.qtpl:3:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line templates/basepage.qtpl:4
+//line examples/basicserver/templates/basepage.qtpl:4:1
 type Page interface {
-	//line templates/basepage.qtpl:4
 	Title() string
-	//line templates/basepage.qtpl:4
+//line examples/basicserver/templates/basepage.qtpl:5:1
 	StreamTitle(qw422016 *qt422016.Writer)
-	//line templates/basepage.qtpl:4
+//line examples/basicserver/templates/basepage.qtpl:5:1
 	WriteTitle(qq422016 qtio422016.Writer)
-	//line templates/basepage.qtpl:4
 	Body() string
-	//line templates/basepage.qtpl:4
+//line examples/basicserver/templates/basepage.qtpl:6:1
 	StreamBody(qw422016 *qt422016.Writer)
-	//line templates/basepage.qtpl:4
+//line examples/basicserver/templates/basepage.qtpl:6:1
 	WriteBody(qq422016 qtio422016.Writer)
-//line templates/basepage.qtpl:4
 }
 
 // Page prints a page implementing Page interface.
 
-//line templates/basepage.qtpl:12
+//line examples/basicserver/templates/basepage.qtpl:12:9
 func StreamPageTemplate(qw422016 *qt422016.Writer, p Page) {
-	//line templates/basepage.qtpl:12
+//line examples/basicserver/templates/basepage.qtpl:12:31
 	qw422016.N().S(`
 <html>
 	<head>
 		<title>`)
-	//line templates/basepage.qtpl:15
+//line examples/basicserver/templates/basepage.qtpl:15:13
 	p.StreamTitle(qw422016)
-	//line templates/basepage.qtpl:15
+//line examples/basicserver/templates/basepage.qtpl:15:25
 	qw422016.N().S(`</title>
 	</head>
 	<body>
@@ -56,108 +55,110 @@ func StreamPageTemplate(qw422016 *qt422016.Writer, p Page) {
 			<a href="/">return to main page</a>
 		</div>
 		`)
-	//line templates/basepage.qtpl:21
+//line examples/basicserver/templates/basepage.qtpl:21:6
 	p.StreamBody(qw422016)
-	//line templates/basepage.qtpl:21
+//line examples/basicserver/templates/basepage.qtpl:21:17
 	qw422016.N().S(`
 	</body>
 </html>
 `)
-//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:12
 }
 
-//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:12
 func WritePageTemplate(qq422016 qtio422016.Writer, p Page) {
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	StreamPageTemplate(qw422016, p)
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:12
 }
 
-//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:12
 func PageTemplate(p Page) string {
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	WritePageTemplate(qb422016, p)
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	qs422016 := string(qb422016.B)
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:11
 	return qs422016
-//line templates/basepage.qtpl:24
+//line examples/basicserver/templates/basepage.qtpl:24:12
 }
 
 // Base page implementation. Other pages may inherit from it if they need
 // overriding only certain Page methods
 
-//line templates/basepage.qtpl:29
+//line examples/basicserver/templates/basepage.qtpl:29:9
 type BasePage struct{}
 
-//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:9
 func (p *BasePage) StreamTitle(qw422016 *qt422016.Writer) {
-//line templates/basepage.qtpl:30
-qw422016.N().S(`This is a base title`) }
+//line examples/basicserver/templates/basepage.qtpl:30:32
+	qw422016.N().S(`This is a base title`)
+//line examples/basicserver/templates/basepage.qtpl:30:64
+}
 
-//line templates/basepage.qtpl:30
-//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:64
 func (p *BasePage) WriteTitle(qq422016 qtio422016.Writer) {
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	p.StreamTitle(qw422016)
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	qt422016.ReleaseWriter(qw422016)
-//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:64
 }
 
-//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:64
 func (p *BasePage) Title() string {
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	p.WriteTitle(qb422016)
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	qs422016 := string(qb422016.B)
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:63
 	return qs422016
-//line templates/basepage.qtpl:30
+//line examples/basicserver/templates/basepage.qtpl:30:64
 }
 
-//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:9
 func (p *BasePage) StreamBody(qw422016 *qt422016.Writer) {
-//line templates/basepage.qtpl:31
-qw422016.N().S(`This is a base body`) }
+//line examples/basicserver/templates/basepage.qtpl:31:31
+	qw422016.N().S(`This is a base body`)
+//line examples/basicserver/templates/basepage.qtpl:31:62
+}
 
-//line templates/basepage.qtpl:31
-//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:62
 func (p *BasePage) WriteBody(qq422016 qtio422016.Writer) {
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	p.StreamBody(qw422016)
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	qt422016.ReleaseWriter(qw422016)
-//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:62
 }
 
-//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:62
 func (p *BasePage) Body() string {
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	p.WriteBody(qb422016)
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	qs422016 := string(qb422016.B)
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:61
 	return qs422016
-//line templates/basepage.qtpl:31
+//line examples/basicserver/templates/basepage.qtpl:31:62
 }
@@ -1,10 +1,12 @@
 // This file is automatically generated by qtc from "errorpage.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: 75c73420b3a17f39eff958122b94b4c2c64ea643fbd0b13b120b4a215c494bbd
 
-//line templates/errorpage.qtpl:1
+//line examples/basicserver/templates/errorpage.qtpl:1:1
 package templates
 
-//line templates/errorpage.qtpl:1
+//line examples/basicserver/templates/errorpage.qtpl:1:1
 import (
 	qtio422016 "io"
 
@@ -14,13 +16,13 @@ import (
 // Error page template. Implements BasePage methods.
 //
 
-//line templates/errorpage.qtpl:3
+//line examples/basicserver/templates/errorpage.qtpl:3:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line templates/errorpage.qtpl:4
+//line examples/basicserver/templates/errorpage.qtpl:4:1
 type ErrorPage struct {
 	// inherit from base page, so its' title is used in error page.
 	BasePage
@@ -29,49 +31,49 @@ type ErrorPage struct {
 	Path []byte
 }
 
-//line templates/errorpage.qtpl:14
+//line examples/basicserver/templates/errorpage.qtpl:14:9
 func (p *ErrorPage) StreamBody(qw422016 *qt422016.Writer) {
-	//line templates/errorpage.qtpl:14
+//line examples/basicserver/templates/errorpage.qtpl:14:32
 	qw422016.N().S(`
 	<h1>Error page</h1>
 	</div>
 		Unsupported path <b>`)
-	//line templates/errorpage.qtpl:17
-	qw422016.E().Z(p.Path)
-	//line templates/errorpage.qtpl:17
+//line examples/basicserver/templates/errorpage.qtpl:17:26
+	qw422016.E().Z( /*line examples/basicserver/templates/errorpage.qtpl:17:26*/ p.Path)
+//line examples/basicserver/templates/errorpage.qtpl:17:35
 	qw422016.N().S(`</b>.
 	</div>
 	Base page body: `)
-	//line templates/errorpage.qtpl:19
+//line examples/basicserver/templates/errorpage.qtpl:19:21
 	p.BasePage.StreamBody(qw422016)
-	//line templates/errorpage.qtpl:19
+//line examples/basicserver/templates/errorpage.qtpl:19:41
 	qw422016.N().S(`
 `)
-//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:12
 }
 
-//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:12
 func (p *ErrorPage) WriteBody(qq422016 qtio422016.Writer) {
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	p.StreamBody(qw422016)
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:12
 }
 
-//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:12
 func (p *ErrorPage) Body() string {
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	p.WriteBody(qb422016)
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	qs422016 := string(qb422016.B)
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:11
 	return qs422016
-//line templates/errorpage.qtpl:20
+//line examples/basicserver/templates/errorpage.qtpl:20:12
 }
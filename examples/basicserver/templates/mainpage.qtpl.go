@@ -1,10 +1,12 @@
 // This file is automatically generated by qtc from "mainpage.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: 4d387f63d02041f3eea5da081ab79ae6d2ed98fce3f93bf28b85f51f561b23c0
 
-//line templates/mainpage.qtpl:1
+//line examples/basicserver/templates/mainpage.qtpl:1:1
 package templates
 
-//line templates/mainpage.qtpl:1
+//line examples/basicserver/templates/mainpage.qtpl:1:1
 import (
 	qtio422016 "io"
 
@@ -14,58 +16,58 @@ import (
 // Main page template. Implements BasePage methods.
 //
 
-//line templates/mainpage.qtpl:3
-import "github.com/valyala/fasthttp"
+//line examples/basicserver/templates/mainpage.qtpl:3:11
+import /*line examples/basicserver/templates/mainpage.qtpl:3:10*/ "github.com/valyala/fasthttp"
 
-//line templates/mainpage.qtpl:5
+//line examples/basicserver/templates/mainpage.qtpl:5:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line templates/mainpage.qtpl:6
+//line examples/basicserver/templates/mainpage.qtpl:6:1
 type MainPage struct {
 	CTX *fasthttp.RequestCtx
 }
 
-//line templates/mainpage.qtpl:12
+//line examples/basicserver/templates/mainpage.qtpl:12:9
 func (p *MainPage) StreamTitle(qw422016 *qt422016.Writer) {
-	//line templates/mainpage.qtpl:12
+//line examples/basicserver/templates/mainpage.qtpl:12:32
 	qw422016.N().S(`
 	This is the main page
 `)
-//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:12
 }
 
-//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:12
 func (p *MainPage) WriteTitle(qq422016 qtio422016.Writer) {
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	p.StreamTitle(qw422016)
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:12
 }
 
-//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:12
 func (p *MainPage) Title() string {
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	p.WriteTitle(qb422016)
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	qs422016 := string(qb422016.B)
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:11
 	return qs422016
-//line templates/mainpage.qtpl:14
+//line examples/basicserver/templates/mainpage.qtpl:14:12
 }
 
-//line templates/mainpage.qtpl:17
+//line examples/basicserver/templates/mainpage.qtpl:17:9
 func (p *MainPage) StreamBody(qw422016 *qt422016.Writer) {
-	//line templates/mainpage.qtpl:17
+//line examples/basicserver/templates/mainpage.qtpl:17:31
 	qw422016.N().S(`
 	<h1>Main page</h1>
 	<div>
@@ -78,42 +80,42 @@ func (p *MainPage) StreamBody(qw422016 *qt422016.Writer) {
 	<div>
 		Some info about you:<br/>
 		IP: <b>`)
-	//line templates/mainpage.qtpl:28
-	qw422016.E().S(p.CTX.RemoteIP().String())
-	//line templates/mainpage.qtpl:28
+//line examples/basicserver/templates/mainpage.qtpl:28:13
+	qw422016.E().S( /*line examples/basicserver/templates/mainpage.qtpl:28:13*/ p.CTX.RemoteIP().String())
+//line examples/basicserver/templates/mainpage.qtpl:28:41
 	qw422016.N().S(`</b><br/>
 		User-Agent: <b>`)
-	//line templates/mainpage.qtpl:29
-	qw422016.E().Z(p.CTX.UserAgent())
-	//line templates/mainpage.qtpl:29
+//line examples/basicserver/templates/mainpage.qtpl:29:21
+	qw422016.E().Z( /*line examples/basicserver/templates/mainpage.qtpl:29:21*/ p.CTX.UserAgent())
+//line examples/basicserver/templates/mainpage.qtpl:29:41
 	qw422016.N().S(`</b><br/>
 	</div>
 `)
-//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:12
 }
 
-//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:12
 func (p *MainPage) WriteBody(qq422016 qtio422016.Writer) {
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	p.StreamBody(qw422016)
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:12
 }
 
-//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:12
 func (p *MainPage) Body() string {
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	p.WriteBody(qb422016)
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	qs422016 := string(qb422016.B)
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:11
 	return qs422016
-//line templates/mainpage.qtpl:31
+//line examples/basicserver/templates/mainpage.qtpl:31:12
 }
@@ -1,10 +1,12 @@
 // This file is automatically generated by qtc from "tablepage.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: 7e82f974e580e106dc998e553a687196ad80b7f9a79be21e975fcb7ba8b52bd8
 
-//line templates/tablepage.qtpl:1
+//line examples/basicserver/templates/tablepage.qtpl:1:1
 package templates
 
-//line templates/tablepage.qtpl:1
+//line examples/basicserver/templates/tablepage.qtpl:1:1
 import (
 	qtio422016 "io"
 
@@ -14,120 +16,120 @@ import (
 // Table page template. Implements BasePage methods.
 //
 
-//line templates/tablepage.qtpl:3
+//line examples/basicserver/templates/tablepage.qtpl:3:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line templates/tablepage.qtpl:4
+//line examples/basicserver/templates/tablepage.qtpl:4:1
 type TablePage struct {
 	Rows []string
 }
 
-//line templates/tablepage.qtpl:10
+//line examples/basicserver/templates/tablepage.qtpl:10:9
 func (p *TablePage) StreamTitle(qw422016 *qt422016.Writer) {
-	//line templates/tablepage.qtpl:10
+//line examples/basicserver/templates/tablepage.qtpl:10:33
 	qw422016.N().S(`
 	This is table page
 `)
-//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:12
 }
 
-//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:12
 func (p *TablePage) WriteTitle(qq422016 qtio422016.Writer) {
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	p.StreamTitle(qw422016)
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:12
 }
 
-//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:12
 func (p *TablePage) Title() string {
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	p.WriteTitle(qb422016)
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	qs422016 := string(qb422016.B)
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:11
 	return qs422016
-//line templates/tablepage.qtpl:12
+//line examples/basicserver/templates/tablepage.qtpl:12:12
 }
 
-//line templates/tablepage.qtpl:15
+//line examples/basicserver/templates/tablepage.qtpl:15:9
 func (p *TablePage) StreamBody(qw422016 *qt422016.Writer) {
-	//line templates/tablepage.qtpl:15
+//line examples/basicserver/templates/tablepage.qtpl:15:32
 	qw422016.N().S(`
 	<h1>Table page</h1>
 
 	`)
-	//line templates/tablepage.qtpl:18
+//line examples/basicserver/templates/tablepage.qtpl:18:5
 	p.streamform(qw422016)
-	//line templates/tablepage.qtpl:18
+//line examples/basicserver/templates/tablepage.qtpl:18:16
 	qw422016.N().S(`
 
 	`)
-	//line templates/tablepage.qtpl:20
-	if len(p.Rows) == 0 {
-		//line templates/tablepage.qtpl:20
+//line examples/basicserver/templates/tablepage.qtpl:20:7
+	if /*line examples/basicserver/templates/tablepage.qtpl:20:7*/ len(p.Rows) == 0 {
+//line examples/basicserver/templates/tablepage.qtpl:20:25
 		qw422016.N().S(`
 		No rows. Click <a href="/table?rowsCount=5">here</a>.
 	`)
-		//line templates/tablepage.qtpl:22
+//line examples/basicserver/templates/tablepage.qtpl:22:9
 	} else {
-		//line templates/tablepage.qtpl:22
+//line examples/basicserver/templates/tablepage.qtpl:22:10
 		qw422016.N().S(`
 		<table>
 			`)
-		//line templates/tablepage.qtpl:24
-		streamemitRows(qw422016, p.Rows)
-		//line templates/tablepage.qtpl:24
+//line examples/basicserver/templates/tablepage.qtpl:24:6
+		streamemitRows(qw422016 /*line examples/basicserver/templates/tablepage.qtpl:24:15*/, p.Rows)
+//line examples/basicserver/templates/tablepage.qtpl:24:25
 		qw422016.N().S(`
 		</table>
 	`)
-		//line templates/tablepage.qtpl:26
+//line examples/basicserver/templates/tablepage.qtpl:26:10
 	}
-	//line templates/tablepage.qtpl:26
+//line examples/basicserver/templates/tablepage.qtpl:26:12
 	qw422016.N().S(`
 `)
-//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:12
 }
 
-//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:12
 func (p *TablePage) WriteBody(qq422016 qtio422016.Writer) {
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	p.StreamBody(qw422016)
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:12
 }
 
-//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:12
 func (p *TablePage) Body() string {
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	p.WriteBody(qb422016)
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	qs422016 := string(qb422016.B)
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:11
 	return qs422016
-//line templates/tablepage.qtpl:27
+//line examples/basicserver/templates/tablepage.qtpl:27:12
 }
 
-//line templates/tablepage.qtpl:29
+//line examples/basicserver/templates/tablepage.qtpl:29:9
 func streamemitRows(qw422016 *qt422016.Writer, rows []string) {
-	//line templates/tablepage.qtpl:29
+//line examples/basicserver/templates/tablepage.qtpl:29:34
 	qw422016.N().S(`
 	<tr>
 		<th>#</th>
@@ -135,131 +137,131 @@ func streamemitRows(qw422016 *qt422016.Writer, rows []string) {
 	</tr>
 
 	`)
-	//line templates/tablepage.qtpl:35
-	for n, r := range rows {
-		//line templates/tablepage.qtpl:35
+//line examples/basicserver/templates/tablepage.qtpl:35:8
+	for /*line examples/basicserver/templates/tablepage.qtpl:35:8*/ n, r := range rows {
+//line examples/basicserver/templates/tablepage.qtpl:35:28
 		qw422016.N().S(`
 		`)
-		//line templates/tablepage.qtpl:36
-		if r == "bingo" {
-			//line templates/tablepage.qtpl:36
+//line examples/basicserver/templates/tablepage.qtpl:36:7
+		if /*line examples/basicserver/templates/tablepage.qtpl:36:8*/ r == "bingo" {
+//line examples/basicserver/templates/tablepage.qtpl:36:21
 			qw422016.N().S(`
 			<tr><td colspan="2"><h1>BINGO!</h1></td></tr>
 			`)
-			//line templates/tablepage.qtpl:38
+//line examples/basicserver/templates/tablepage.qtpl:38:11
 			return
-			//line templates/tablepage.qtpl:39
-		} else if n == 42 {
-			//line templates/tablepage.qtpl:39
+//line examples/basicserver/templates/tablepage.qtpl:39:11
+		} else if /*line examples/basicserver/templates/tablepage.qtpl:39:12*/ n == 42 {
+//line examples/basicserver/templates/tablepage.qtpl:39:20
 			qw422016.N().S(`
 			<tr><td colspan="2">42 rows already generated</td></tr>
 			`)
-			//line templates/tablepage.qtpl:41
+//line examples/basicserver/templates/tablepage.qtpl:41:10
 			break
-			//line templates/tablepage.qtpl:42
+//line examples/basicserver/templates/tablepage.qtpl:42:10
 		}
-		//line templates/tablepage.qtpl:42
+//line examples/basicserver/templates/tablepage.qtpl:42:12
 		qw422016.N().S(`
 
 		<tr style="background: `)
-		//line templates/tablepage.qtpl:44
-		if n&1 == 1 {
-			//line templates/tablepage.qtpl:44
+//line examples/basicserver/templates/tablepage.qtpl:44:30
+		if /*line examples/basicserver/templates/tablepage.qtpl:44:31*/ n&1 == 1 {
+//line examples/basicserver/templates/tablepage.qtpl:44:40
 			qw422016.N().S(`white`)
-			//line templates/tablepage.qtpl:44
+//line examples/basicserver/templates/tablepage.qtpl:44:54
 		} else {
-			//line templates/tablepage.qtpl:44
+//line examples/basicserver/templates/tablepage.qtpl:44:55
 			qw422016.N().S(`#ddd`)
-			//line templates/tablepage.qtpl:44
+//line examples/basicserver/templates/tablepage.qtpl:44:69
 		}
-		//line templates/tablepage.qtpl:44
+//line examples/basicserver/templates/tablepage.qtpl:44:71
 		qw422016.N().S(`">
 			<td>`)
-		//line templates/tablepage.qtpl:45
-		qw422016.N().D(n + 1)
-		//line templates/tablepage.qtpl:45
+//line examples/basicserver/templates/tablepage.qtpl:45:10
+		qw422016.N().D( /*line examples/basicserver/templates/tablepage.qtpl:45:11*/ n + 1)
+//line examples/basicserver/templates/tablepage.qtpl:45:16
 		qw422016.N().S(`</td>
 			<td>`)
-		//line templates/tablepage.qtpl:46
-		qw422016.E().S(r)
-		//line templates/tablepage.qtpl:46
+//line examples/basicserver/templates/tablepage.qtpl:46:10
+		qw422016.E().S( /*line examples/basicserver/templates/tablepage.qtpl:46:11*/ r)
+//line examples/basicserver/templates/tablepage.qtpl:46:14
 		qw422016.N().S(`</td>
 		</tr>
 	`)
-		//line templates/tablepage.qtpl:48
+//line examples/basicserver/templates/tablepage.qtpl:48:11
 	}
-	//line templates/tablepage.qtpl:48
+//line examples/basicserver/templates/tablepage.qtpl:48:13
 	qw422016.N().S(`
 
 	<tr><td colspan="2">No bingo found</td></tr>
 `)
-//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:12
 }
 
-//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:12
 func writeemitRows(qq422016 qtio422016.Writer, rows []string) {
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	streamemitRows(qw422016, rows)
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:12
 }
 
-//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:12
 func emitRows(rows []string) string {
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	writeemitRows(qb422016, rows)
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	qs422016 := string(qb422016.B)
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:11
 	return qs422016
-//line templates/tablepage.qtpl:51
+//line examples/basicserver/templates/tablepage.qtpl:51:12
 }
 
-//line templates/tablepage.qtpl:53
+//line examples/basicserver/templates/tablepage.qtpl:53:9
 func (p *TablePage) streamform(qw422016 *qt422016.Writer) {
-	//line templates/tablepage.qtpl:53
+//line examples/basicserver/templates/tablepage.qtpl:53:32
 	qw422016.N().S(`
 	<form>
 		Rows: <input type="text" name="rowsCount" value="`)
-	//line templates/tablepage.qtpl:55
-	qw422016.N().D(len(p.Rows))
-	//line templates/tablepage.qtpl:55
+//line examples/basicserver/templates/tablepage.qtpl:55:55
+	qw422016.N().D( /*line examples/basicserver/templates/tablepage.qtpl:55:55*/ len(p.Rows))
+//line examples/basicserver/templates/tablepage.qtpl:55:69
 	qw422016.N().S(`"/><br/>
 		<input type="submit" value="Generate!"/>
 	</form>
 `)
-//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:12
 }
 
-//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:12
 func (p *TablePage) writeform(qq422016 qtio422016.Writer) {
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	p.streamform(qw422016)
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	qt422016.ReleaseWriter(qw422016)
-//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:12
 }
 
-//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:12
 func (p *TablePage) form() string {
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	p.writeform(qb422016)
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	qs422016 := string(qb422016.B)
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:11
 	return qs422016
-//line templates/tablepage.qtpl:58
+//line examples/basicserver/templates/tablepage.qtpl:58:12
 }
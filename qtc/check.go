@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// checkTemplates checks whether the Go files generated for template files
+// of the given jobs are up to date.
+//
+// Template files are compiled in memory, so nothing is written to disk.
+// checkTemplates exits with non-zero code if stale or missing Go files
+// or broken template files are found.
+func checkTemplates(jobs []*compileJob) {
+	_, errs := runJobs(jobs, checkJobFile)
+	reportErrors(errs)
+	if len(errs) > 0 {
+		logger.Fatalf("Found %d problems with stale, missing or broken files. Run qtc in order to re-generate them", len(errs))
+	}
+	logger.Printf("All the generated files are up to date")
+}
+
+// checkJobFile checks the Go file generated for the template file
+// of the given job.
+func checkJobFile(j *compileJob) error {
+	problem, err := checkFile(j.filename, j.errorFuncs, j.packageName)
+	if err != nil {
+		return err
+	}
+	if len(problem) > 0 {
+		outfile, _ := getOutFilename(j.filename)
+		return fmt.Errorf("%q %s", outfile, problem)
+	}
+	return nil
+}
+
+// checkFile compiles the given template file in memory and compares
+// the generated code to the Go file on disk.
+//
+// It returns the description of the problem with the Go file
+// or empty string if the file is up to date.
+func checkFile(infile string, errorFuncs map[string]bool, packageName string) (string, error) {
+	tf, err := readTemplateFile(infile, errorFuncs, packageName)
+	if err != nil {
+		return "", err
+	}
+	outfile := tf.outfile
+	code, err := tf.generateCode()
+	if err != nil {
+		return "", err
+	}
+	if *typeCheck {
+		if err := typeCheckTemplate(infile, errorFuncs, packageName); err != nil {
+			return "", err
+		}
+	}
+	oldCode, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "is missing", nil
+		}
+		return "", fmt.Errorf("cannot read file %q: %s", outfile, err)
+	}
+	if !bytes.Equal(code, oldCode) {
+		return "is out of date", nil
+	}
+	return "", nil
+}
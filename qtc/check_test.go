@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFile(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	infile := filepath.Join(dir, "a.qtpl")
+	outfile := infile + ".go"
+	writeWatchedFile(t, infile, `{% func A() %}a{% endfunc %}`)
+
+	// missing file mustn't be created
+	testCheckFile(t, infile, "is missing")
+	if _, err := os.Stat(outfile); !os.IsNotExist(err) {
+		t.Fatalf("unexpected file %q created by check: %v", outfile, err)
+	}
+
+	// up to date file
+	if _, err := compileFile(infile, nil, getTestPackageName(t, infile)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testCheckFile(t, infile, "")
+
+	// stale file mustn't be updated
+	writeWatchedFile(t, infile, `{% func A() %}b{% endfunc %}`)
+	testCheckFile(t, infile, "is out of date")
+	testCheckFile(t, infile, "is out of date")
+
+	// broken template
+	writeWatchedFile(t, infile, `{% func A() %}`)
+	if _, err := checkFile(infile, nil, getTestPackageName(t, infile)); err == nil {
+		t.Fatalf("expecting non-nil error for broken template")
+	}
+}
+
+func testCheckFile(t *testing.T, infile, expectedProblem string) {
+	t.Helper()
+	problem, err := checkFile(infile, nil, getTestPackageName(t, infile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if problem != expectedProblem {
+		t.Fatalf("unexpected problem for %q: %q. Expecting %q", infile, problem, expectedProblem)
+	}
+}
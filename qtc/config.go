@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// configFilename is the name of the file with qtc settings for template
+// files in the directory containing the file and in its' subdirectories.
+const configFilename = "qtc.toml"
+
+// defaultRuntimePath is the import path of quicktemplate package
+// used by the generated code.
+const defaultRuntimePath = "github.com/valyala/quicktemplate"
+
+// config contains qtc settings for template files in a directory.
+//
+// The settings are read from qtc.toml files located in the directory
+// and in its' parent directories. Settings in nested directories override
+// settings in parent directories, while explicitly set flags override
+// settings in qtc.toml files. See getConfig for details.
+type config struct {
+	// exts contains the extensions of template files starting with dot.
+	exts []string
+
+	// textMode disables html escaping in output tags, so the templates
+	// may generate plain text such as emails or config files.
+	textMode bool
+
+	// runtimePath is the import path of quicktemplate package.
+	runtimePath string
+
+	// header is the comment written at the top of the generated files
+	// such as license header. It is empty if no header is set.
+	header string
+
+	// whitespace is the whitespace mode for text in template funcs.
+	whitespace whitespaceMode
+
+	// variants and unexportedVariants contain the variants generated
+	// for exported and unexported template funcs respectively.
+	variants           funcVariants
+	unexportedVariants funcVariants
+}
+
+// whitespaceMode controls whitespace in the text of template funcs
+// outside stripspace and collapsespace tags.
+type whitespaceMode uint8
+
+const (
+	whitespaceKeep whitespaceMode = iota
+	whitespaceStrip
+	whitespaceCollapse
+)
+
+var whitespaceModeNames = []string{
+	whitespaceKeep:     "keep",
+	whitespaceStrip:    "strip",
+	whitespaceCollapse: "collapse",
+}
+
+func (m whitespaceMode) String() string {
+	return whitespaceModeNames[m]
+}
+
+func parseWhitespaceMode(s string) (whitespaceMode, error) {
+	for m, name := range whitespaceModeNames {
+		if name == s {
+			return whitespaceMode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported whitespace mode %q. Supported modes: %s", s, strings.Join(whitespaceModeNames, ", "))
+}
+
+var (
+	dirConfigsLock sync.Mutex
+
+	// dirConfigs contains configs per absolute directory path
+	// before applying flags.
+	dirConfigs = make(map[string]*config)
+)
+
+// resetConfigs drops cached configs, so qtc.toml files are read again.
+func resetConfigs() {
+	dirConfigsLock.Lock()
+	dirConfigs = make(map[string]*config)
+	dirConfigsLock.Unlock()
+}
+
+// getConfig returns qtc settings for template files in the given dir.
+//
+// Settings missing in qtc.toml files are set from flags.
+func getConfig(dir string) (*config, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot determine absolute path for %q: %s", dir, err)
+	}
+	dirConfigsLock.Lock()
+	cfg, err := getDirConfig(absDir)
+	dirConfigsLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.applyFlags()
+	return &c, nil
+}
+
+// getConfigOrFlags returns qtc settings for template files in the given dir.
+//
+// Settings set from flags are returned if qtc.toml files are broken,
+// since their' errors are reported when compiling template files.
+func getConfigOrFlags(dir string) *config {
+	cfg, err := getConfig(dir)
+	if err != nil {
+		cfg = &config{}
+		cfg.applyFlags()
+	}
+	return cfg
+}
+
+// getFileConfig returns qtc settings for the given template file.
+//
+// The extension of the file is always included in the returned exts,
+// so the file is compiled together with other template files
+// in the directory even if it is passed explicitly.
+// See getConfigOrFlags for details.
+func getFileConfig(filename string) *config {
+	cfg := getConfigOrFlags(filepath.Dir(filename))
+	if ext := filepath.Ext(filename); len(ext) > 0 && !hasString(cfg.exts, ext) {
+		cfg.exts = append(append([]string(nil), cfg.exts...), ext)
+	}
+	return cfg
+}
+
+// getDirConfig returns the config for absDir merged with configs
+// from parent directories.
+//
+// dirConfigsLock must be held by the caller.
+func getDirConfig(absDir string) (*config, error) {
+	if cfg := dirConfigs[absDir]; cfg != nil {
+		return cfg, nil
+	}
+	cfg := &config{}
+	if parent := filepath.Dir(absDir); parent != absDir {
+		parentCfg, err := getDirConfig(parent)
+		if err != nil {
+			return nil, err
+		}
+		*cfg = *parentCfg
+	}
+	filename := filepath.Join(absDir, configFilename)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot read %q: %s", filename, err)
+	}
+	if err == nil {
+		if err = cfg.parse(data, filename); err != nil {
+			return nil, err
+		}
+	}
+	dirConfigs[absDir] = cfg
+	return cfg, nil
+}
+
+// applyFlags sets settings missing in c from flags.
+//
+// Explicitly set flags override the settings from qtc.toml files.
+func (c *config) applyFlags() {
+	if len(c.exts) == 0 || isFlagSet("ext") {
+		c.exts = nil
+		if len(*ext) > 0 {
+			c.exts = []string{normalizeExt(*ext)}
+		}
+	}
+	if len(c.runtimePath) == 0 {
+		c.runtimePath = defaultRuntimePath
+	}
+	if c.variants == 0 || isFlagSet("variants") {
+		c.variants = defaultVariants
+	}
+	if c.unexportedVariants == 0 || isFlagSet("unexportedvariants") {
+		c.unexportedVariants = defaultUnexportedVariants
+	}
+}
+
+// isFlagSet returns true if the flag with the given name is set
+// on the command line.
+func isFlagSet(name string) bool {
+	found := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+// normalizeExt returns ext starting with dot.
+func normalizeExt(ext string) string {
+	if !strings.HasPrefix(ext, ".") {
+		return "." + ext
+	}
+	return ext
+}
+
+// hasTemplateExt returns true if filename ends with one of exts.
+func hasTemplateExt(filename string, exts []string) bool {
+	for _, ext := range exts {
+		if strings.HasSuffix(filename, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// globTemplates returns sorted template files with the given exts in dir.
+func globTemplates(dir string, exts []string) ([]string, error) {
+	var filenames []string
+	for _, ext := range exts {
+		a, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read files in %q: %s", dir, err)
+		}
+		for _, filename := range a {
+			if !hasString(filenames, filename) {
+				filenames = append(filenames, filename)
+			}
+		}
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+func hasString(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parse applies the settings from qtc.toml file contents to c.
+//
+// Only a subset of TOML is supported: comments, key = value pairs
+// with string values and arrays of strings. Tables aren't supported.
+func (c *config) parse(data []byte, filename string) error {
+	cp := &configParser{
+		data: data,
+		line: 1,
+	}
+	seen := make(map[string]bool)
+	for {
+		cp.skipSpaceAndNewlines()
+		if cp.eof() {
+			return nil
+		}
+		line := cp.line
+		key, value, err := cp.parseKeyValue()
+		if err == nil {
+			if seen[key] {
+				err = fmt.Errorf("duplicate key %q", key)
+			} else {
+				seen[key] = true
+				err = c.set(key, value)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("invalid config at %s:%d: %s", filename, line, err)
+		}
+	}
+}
+
+// set applies the given value for the given key to c.
+//
+// value is either string or []string.
+func (c *config) set(key string, value interface{}) error {
+	switch key {
+	case "extensions":
+		exts, err := stringsValue(key, value)
+		if err != nil {
+			return err
+		}
+		if len(exts) == 0 {
+			return fmt.Errorf("extensions cannot be empty")
+		}
+		c.exts = nil
+		for _, ext := range exts {
+			if len(strings.Trim(ext, ".")) == 0 {
+				return fmt.Errorf("extension cannot be empty")
+			}
+			c.exts = append(c.exts, normalizeExt(ext))
+		}
+	case "mode":
+		s, err := stringValue(key, value)
+		if err != nil {
+			return err
+		}
+		switch s {
+		case "html":
+			c.textMode = false
+		case "text":
+			c.textMode = true
+		default:
+			return fmt.Errorf("unsupported mode %q. Supported modes: html, text", s)
+		}
+	case "runtime":
+		s, err := stringValue(key, value)
+		if err != nil {
+			return err
+		}
+		if len(s) == 0 || strings.ContainsAny(s, " \t\r\n\"`\\") {
+			return fmt.Errorf("invalid runtime import path %q", s)
+		}
+		c.runtimePath = s
+	case "header":
+		s, err := stringValue(key, value)
+		if err != nil {
+			return err
+		}
+		c.header = headerComment(s)
+	case "whitespace":
+		s, err := stringValue(key, value)
+		if err != nil {
+			return err
+		}
+		if c.whitespace, err = parseWhitespaceMode(s); err != nil {
+			return err
+		}
+	case "variants", "unexported_variants":
+		names, err := stringsValue(key, value)
+		if err != nil {
+			return err
+		}
+		v, err := parseVariants(strings.Join(names, ","))
+		if err != nil {
+			return err
+		}
+		if key == "variants" {
+			c.variants = v
+		} else {
+			c.unexportedVariants = v
+		}
+	default:
+		return fmt.Errorf("unknown key %q. Supported keys: extensions, mode, runtime, header, whitespace, variants, unexported_variants", key)
+	}
+	return nil
+}
+
+func stringValue(key string, value interface{}) (string, error) {
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return s, nil
+}
+
+func stringsValue(key string, value interface{}) ([]string, error) {
+	a, ok := value.([]string)
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of strings", key)
+	}
+	return a, nil
+}
+
+// headerComment converts the given header text into Go comment.
+//
+// Lines already starting with // are left as is. Empty string is returned
+// for empty header, so nested directories may drop the header set
+// in parent directories.
+func headerComment(s string) string {
+	s = strings.TrimRight(s, " \t\r\n")
+	if len(s) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		switch {
+		case strings.HasPrefix(line, "//"):
+			b.WriteString(line)
+		case len(line) == 0:
+			b.WriteString("//")
+		default:
+			b.WriteString("// ")
+			b.WriteString(line)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// configParser parses qtc.toml file contents.
+type configParser struct {
+	data []byte
+	n    int
+	line int
+}
+
+func (cp *configParser) eof() bool {
+	return cp.n >= len(cp.data)
+}
+
+func (cp *configParser) peek() byte {
+	if cp.eof() {
+		return 0
+	}
+	return cp.data[cp.n]
+}
+
+// skipSpace skips spaces and comments up to the end of line.
+func (cp *configParser) skipSpace() {
+	for !cp.eof() {
+		switch cp.peek() {
+		case ' ', '\t', '\r':
+			cp.n++
+		case '#':
+			for !cp.eof() && cp.peek() != '\n' {
+				cp.n++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// skipSpaceAndNewlines skips spaces, comments and newlines.
+func (cp *configParser) skipSpaceAndNewlines() {
+	for {
+		cp.skipSpace()
+		if cp.peek() != '\n' {
+			return
+		}
+		cp.n++
+		cp.line++
+	}
+}
+
+func (cp *configParser) parseKeyValue() (string, interface{}, error) {
+	if cp.peek() == '[' {
+		return "", nil, fmt.Errorf("tables aren't supported")
+	}
+	start := cp.n
+	for !cp.eof() && isConfigKeyChar(cp.peek()) {
+		cp.n++
+	}
+	key := string(cp.data[start:cp.n])
+	if len(key) == 0 {
+		return "", nil, fmt.Errorf("missing key")
+	}
+	cp.skipSpace()
+	if cp.peek() != '=' {
+		return "", nil, fmt.Errorf("missing '=' after key %q", key)
+	}
+	cp.n++
+	cp.skipSpace()
+	value, err := cp.parseValue()
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid value for key %q: %s", key, err)
+	}
+	cp.skipSpace()
+	if !cp.eof() && cp.peek() != '\n' {
+		return "", nil, fmt.Errorf("unexpected data after the value for key %q", key)
+	}
+	return key, value, nil
+}
+
+func isConfigKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (cp *configParser) parseValue() (interface{}, error) {
+	if cp.peek() != '[' {
+		return cp.parseString()
+	}
+	cp.n++
+	a := []string{}
+	for {
+		cp.skipSpaceAndNewlines()
+		if cp.peek() == ']' {
+			cp.n++
+			return a, nil
+		}
+		s, err := cp.parseString()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, s)
+		cp.skipSpaceAndNewlines()
+		switch cp.peek() {
+		case ',':
+			cp.n++
+		case ']':
+		default:
+			return nil, fmt.Errorf("missing ',' or ']' in array")
+		}
+	}
+}
+
+// parseString parses basic and literal strings. Multi-line strings
+// are enclosed in triple quotes.
+//
+// Escape sequences in multi-line strings aren't supported.
+func (cp *configParser) parseString() (string, error) {
+	rest := cp.data[cp.n:]
+	for _, delim := range []string{`"""`, `'''`} {
+		if !bytes.HasPrefix(rest, []byte(delim)) {
+			continue
+		}
+		rest = rest[len(delim):]
+		n := bytes.Index(rest, []byte(delim))
+		if n < 0 {
+			return "", fmt.Errorf("missing closing %s", delim)
+		}
+		s := string(rest[:n])
+		cp.n += 2*len(delim) + n
+		cp.line += strings.Count(s, "\n")
+		// A newline immediately following the opening delimiter is trimmed.
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "\r"), "\n")
+		return s, nil
+	}
+	if len(rest) == 0 || (rest[0] != '"' && rest[0] != '\'') {
+		return "", fmt.Errorf("expecting string")
+	}
+	delim := rest[0]
+	n := 1
+	for n < len(rest) && rest[n] != delim && rest[n] != '\n' {
+		if rest[n] == '\\' && delim == '"' {
+			n++
+		}
+		n++
+	}
+	if n >= len(rest) || rest[n] != delim {
+		return "", fmt.Errorf("missing closing %c", delim)
+	}
+	s := string(rest[:n+1])
+	cp.n += n + 1
+	if delim == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	v, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("cannot unquote %s: %s", s, err)
+	}
+	return v, nil
+}
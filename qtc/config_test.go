@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigParseSuccess(t *testing.T) {
+	testConfigParseSuccess(t, ``, &config{})
+	testConfigParseSuccess(t, "# comment\n\n", &config{})
+	testConfigParseSuccess(t, `extensions = ["qtpl", ".qtxt"]`, &config{exts: []string{".qtpl", ".qtxt"}})
+	testConfigParseSuccess(t, `
+extensions = [
+	"qtpl", # html templates
+	'qtxt',
+]
+mode = "text" # emails
+runtime = 'example.com/quicktemplate'
+whitespace = "collapse"
+variants = ["stream", "write"]
+unexported_variants = ["stream"]
+`, &config{
+		exts:               []string{".qtpl", ".qtxt"},
+		textMode:           true,
+		runtimePath:        "example.com/quicktemplate",
+		whitespace:         whitespaceCollapse,
+		variants:           variantStream | variantWrite,
+		unexportedVariants: variantStream,
+	})
+	testConfigParseSuccess(t, `header = "Copyright \"Foo\"\n\nLicensed under MIT"`, &config{
+		header: "// Copyright \"Foo\"\n//\n// Licensed under MIT\n",
+	})
+	testConfigParseSuccess(t, `header = """
+// Copyright Foo
+Licensed under MIT
+"""
+mode = "html"`, &config{
+		header: "// Copyright Foo\n// Licensed under MIT\n",
+	})
+}
+
+func testConfigParseSuccess(t *testing.T, s string, expectedCfg *config) {
+	t.Helper()
+	var cfg config
+	if err := cfg.parse([]byte(s), configFilename); err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", s, err)
+	}
+	if !reflect.DeepEqual(&cfg, expectedCfg) {
+		t.Fatalf("unexpected config for %q\n%+v\nExpecting\n%+v", s, &cfg, expectedCfg)
+	}
+}
+
+func TestConfigParseFailure(t *testing.T) {
+	testConfigParseFailure(t, `foo = "bar"`, "qtc.toml:1: unknown key")
+	testConfigParseFailure(t, "\n[qtc]\nmode = \"text\"", "qtc.toml:2: tables aren't supported")
+	testConfigParseFailure(t, "mode = \"text\"\nmode = \"html\"", "qtc.toml:2: duplicate key")
+	testConfigParseFailure(t, `mode = "xml"`, "unsupported mode")
+	testConfigParseFailure(t, `mode = ["text"]`, "mode must be a string")
+	testConfigParseFailure(t, `mode "text"`, "missing '='")
+	testConfigParseFailure(t, `mode = text`, "expecting string")
+	testConfigParseFailure(t, `mode = "text`, "missing closing")
+	testConfigParseFailure(t, `mode = "text" "html"`, "unexpected data")
+	testConfigParseFailure(t, `extensions = "qtpl"`, "extensions must be an array of strings")
+	testConfigParseFailure(t, `extensions = []`, "extensions cannot be empty")
+	testConfigParseFailure(t, `extensions = ["qtpl", "."]`, "extension cannot be empty")
+	testConfigParseFailure(t, `extensions = ["qtpl" "qtxt"]`, "missing ',' or ']'")
+	testConfigParseFailure(t, `runtime = "foo bar"`, "invalid runtime import path")
+	testConfigParseFailure(t, `whitespace = "trim"`, "unsupported whitespace mode")
+	testConfigParseFailure(t, `variants = ["write"]`, "stream variant is required")
+	testConfigParseFailure(t, "header = \"\"\"\nfoo", "missing closing")
+}
+
+func testConfigParseFailure(t *testing.T, s, expectedErr string) {
+	t.Helper()
+	var cfg config
+	err := cfg.parse([]byte(s), configFilename)
+	if err == nil {
+		t.Fatalf("expecting error when parsing %q", s)
+	}
+	if !strings.Contains(err.Error(), expectedErr) {
+		t.Fatalf("unexpected error when parsing %q: %s. Expecting %q", s, err, expectedErr)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	subDir := filepath.Join(dir, "emails")
+	if err := os.Mkdir(subDir, 0777); err != nil {
+		t.Fatalf("cannot create dir: %s", err)
+	}
+	writeWatchedFile(t, filepath.Join(dir, configFilename), `
+extensions = ["qtpl", "qtxt"]
+header = "Copyright Foo"
+whitespace = "strip"
+`)
+	writeWatchedFile(t, filepath.Join(subDir, configFilename), `
+mode = "text"
+header = ""
+whitespace = "keep"
+`)
+
+	// settings missing in qtc.toml are set from flags
+	cfg, err := getConfig(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedCfg := &config{
+		exts:               []string{".qtpl", ".qtxt"},
+		runtimePath:        defaultRuntimePath,
+		header:             "// Copyright Foo\n",
+		whitespace:         whitespaceStrip,
+		variants:           defaultVariants,
+		unexportedVariants: defaultUnexportedVariants,
+	}
+	if !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Fatalf("unexpected config\n%+v\nExpecting\n%+v", cfg, expectedCfg)
+	}
+
+	// nested directories override parent directories
+	cfg, err = getConfig(subDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedCfg.textMode = true
+	expectedCfg.header = ""
+	expectedCfg.whitespace = whitespaceKeep
+	if !reflect.DeepEqual(cfg, expectedCfg) {
+		t.Fatalf("unexpected config\n%+v\nExpecting\n%+v", cfg, expectedCfg)
+	}
+
+	// the extension of template file is added to the extensions
+	cfg = getFileConfig(filepath.Join(subDir, "a.tpl"))
+	if !reflect.DeepEqual(cfg.exts, []string{".qtpl", ".qtxt", ".tpl"}) {
+		t.Fatalf("unexpected exts: %q", cfg.exts)
+	}
+
+	// errors in parent directories are reported for nested directories
+	writeWatchedFile(t, filepath.Join(dir, configFilename), `whitespace = "trim"`)
+	resetConfigs()
+	if _, err := getConfig(subDir); err == nil || !strings.Contains(err.Error(), configFilename+":1:") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	// text mode
+	testVariants(t, &parseOptions{textMode: true}, `{% func A(s string) %}<a href="{%s s %}">{%s s %}</a>{%q s %}{% endfunc %}`,
+		[]string{"qw422016.N().S(s)\n", "qw422016.N().Q(s)\n"}, []string{".E()."})
+	testVariants(t, &parseOptions{textMode: true}, `{% func A(s string) %}{% if s == "" %}<a href="{% else %}<b>{% endif %}{%s s %}{% endfunc %}`,
+		[]string{"qw422016.N().S(s)\n"}, nil)
+
+	// runtime import path
+	testVariants(t, &parseOptions{runtimePath: "example.com/quicktemplate"}, `{% func A() %}a{% endfunc %}`,
+		[]string{"\tqt422016 \"example.com/quicktemplate\"\n"}, []string{`"github.com/valyala/quicktemplate"`})
+
+	// header
+	testVariants(t, &parseOptions{header: "// Copyright Foo\n"}, `{% func A() %}a{% endfunc %}`,
+		[]string{"// Copyright Foo\n\n// This file is automatically generated"}, nil)
+
+	// whitespace mode applies only to func bodies outside stripspace and collapsespace tags
+	testVariants(t, &parseOptions{whitespace: whitespaceStrip}, "Doc\n  comment\n{% func A() %}\n\t<p>\n\t\ta\n\t</p>\n{% collapsespace %}\n\t<p>\n\t\tb\n\t</p>\n{% endcollapsespace %}{% endfunc %}",
+		[]string{"// Doc\n//   comment\n", "qw422016.N().S(`<p>a</p>`)", "qw422016.N().S(`<p> b </p> `)"}, nil)
+	testVariants(t, &parseOptions{whitespace: whitespaceCollapse}, "{% func A() %}\n\t<p>\n\t\ta\n\t</p>\n{% plain %}\n\tb\n{% endplain %}{% endfunc %}",
+		[]string{"qw422016.N().S(`<p> a </p> `)", "qw422016.N().S(`\n\tb\n`)"}, nil)
+}
+
+func TestCompileFileConfig(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	configFile := filepath.Join(dir, configFilename)
+	writeWatchedFile(t, configFile, `
+extensions = ["qtxt"]
+mode = "text"
+header = "Copyright Foo"
+`)
+	infile := filepath.Join(dir, "a.qtxt")
+	writeWatchedFile(t, infile, `{% func A(s string) %}{%s s %}{% endfunc %}`)
+	writeWatchedFile(t, filepath.Join(dir, "b.qtpl"), `{% func B() %}b{% endfunc %}`)
+
+	jobs := getDirJobs(dir, nil)
+	if len(jobs) != 1 || jobs[0].filename != infile {
+		t.Fatalf("unexpected jobs: %d", len(jobs))
+	}
+	testCompileFile(t, infile, jobs[0].errorFuncs, true)
+	code, err := ioutil.ReadFile(infile + ".go")
+	if err != nil {
+		t.Fatalf("cannot read the generated file: %s", err)
+	}
+	if !strings.HasPrefix(string(code), "// Copyright Foo\n\n") || !strings.Contains(string(code), "qw422016.N().S(") {
+		t.Fatalf("unexpected generated code:\n%s", code)
+	}
+	testCompileFile(t, infile, jobs[0].errorFuncs, false)
+
+	// changed settings are detected via source hash
+	writeWatchedFile(t, configFile, `
+extensions = ["qtxt"]
+header = "Copyright Foo"
+`)
+	resetConfigs()
+	testCompileFile(t, infile, jobs[0].errorFuncs, true)
+	code, err = ioutil.ReadFile(infile + ".go")
+	if err != nil {
+		t.Fatalf("cannot read the generated file: %s", err)
+	}
+	if !strings.Contains(string(code), "qw422016.E().S(") {
+		t.Fatalf("unexpected generated code:\n%s", code)
+	}
+}
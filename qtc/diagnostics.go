@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// diagnostic is machine-readable description of the problem found
+// in template files. See -json flag.
+type diagnostic struct {
+	// File is the path to the file with the problem.
+	// It is empty if the problem isn't related to a file.
+	File string `json:"file,omitempty"`
+
+	// Line and Col contain 1-based position of the tag with the problem.
+	// Col is measured in bytes. Both are zero if the position is unknown.
+	Line int `json:"line,omitempty"`
+	Col  int `json:"col,omitempty"`
+
+	// EndLine and EndCol contain 1-based position following the end
+	// of the tag with the problem.
+	EndLine int `json:"endLine,omitempty"`
+	EndCol  int `json:"endCol,omitempty"`
+
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+
+	// Tag is the name of the tag with the problem.
+	Tag string `json:"tag,omitempty"`
+
+	// Check is the name of qtc vet check, which found the problem.
+	Check string `json:"check,omitempty"`
+}
+
+const (
+	// severityError is the severity of problems preventing template compilation.
+	severityError = "error"
+
+	// severityWarning is the severity of problems found by qtc vet.
+	severityWarning = "warning"
+)
+
+// newDiagnostic returns diagnostic for the given error.
+func newDiagnostic(err error) *diagnostic {
+	switch e := err.(type) {
+	case *parseError:
+		severity := severityError
+		if len(e.check) > 0 {
+			severity = severityWarning
+		}
+		return &diagnostic{
+			File:     e.filePath,
+			Line:     e.line,
+			Col:      e.col,
+			EndLine:  e.endLine,
+			EndCol:   e.endCol,
+			Severity: severity,
+			Message:  e.message(),
+			Tag:      e.tagName,
+			Check:    e.check,
+		}
+	case *fileError:
+		return &diagnostic{
+			File:     e.filename,
+			Severity: severityError,
+			Message:  e.err.Error(),
+		}
+	default:
+		return &diagnostic{
+			Severity: severityError,
+			Message:  err.Error(),
+		}
+	}
+}
+
+// reportErrors reports the given errors.
+//
+// The errors are logged unless -json flag is set. Otherwise they are written
+// to stdout as JSON-encoded diagnostics one per line.
+func reportErrors(errs []error) {
+	if !*jsonOutput {
+		for _, err := range errs {
+			logger.Printf("%s", err)
+		}
+		return
+	}
+	writeDiagnostics(os.Stdout, errs)
+}
+
+// writeDiagnostics writes JSON-encoded diagnostics for errs to w one per line.
+func writeDiagnostics(w io.Writer, errs []error) {
+	enc := json.NewEncoder(w)
+	for _, err := range errs {
+		if err := enc.Encode(newDiagnostic(err)); err != nil {
+			logger.Fatalf("cannot write diagnostics: %s", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWriteDiagnostics(t *testing.T) {
+	var w bytes.Buffer
+	err := parse(&w, bytes.NewBufferString("{% func A() %}\n\t{% edfor\n %}{% endfunc %}"), "foo.qtpl", "memory")
+	if err == nil {
+		t.Fatalf("expecting non-nil error")
+	}
+	errs := []error{
+		err.(parseErrors)[0],
+		&fileError{
+			filename: "bar.qtpl",
+			err:      fmt.Errorf("cannot read file"),
+		},
+		fmt.Errorf("cannot list files"),
+	}
+
+	var bb bytes.Buffer
+	writeDiagnostics(&bb, errs)
+	expected := `{"file":"foo.qtpl","line":2,"col":2,"endLine":3,"endCol":4,"severity":"error","message":"unexpected tag found in \"func A()\": \"edfor\". Did you mean \"endfor\"?","tag":"edfor"}
+{"file":"bar.qtpl","severity":"error","message":"cannot read file"}
+{"severity":"error","message":"cannot list files"}
+`
+	if bb.String() != expected {
+		t.Fatalf("unexpected diagnostics\n%s\nExpecting\n%s", bb.String(), expected)
+	}
+}
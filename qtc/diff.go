@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines around changes
+// in unified diff.
+const diffContextLines = 3
+
+// unifiedDiff returns unified diff between a and b for the given file.
+//
+// Empty result is returned if a and b are equal.
+func unifiedDiff(filename string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	linesA := splitLines(a)
+	linesB := splitLines(b)
+	ops := diffLines(linesA, linesB)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s.orig\n+++ %s\n", filename, filename)
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Collect the hunk containing changes separated by up to
+		// 2*diffContextLines unchanged lines.
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(ops) && ops[n].kind == ' ' {
+				n++
+			}
+			if n == len(ops) || n-end > 2*diffContextLines {
+				end += diffContextLines
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = n
+		}
+
+		lineA, lineB := ops[start].lineA, ops[start].lineB
+		countA, countB := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", diffRange(lineA, countA), diffRange(lineB, countB))
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+	return buf.Bytes()
+}
+
+func diffRange(line, count int) string {
+	if count == 0 {
+		// The range is empty, so it points to the line before the change.
+		return fmt.Sprintf("%d,0", line)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line+1)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
+
+// diffOp is a line in the diff.
+type diffOp struct {
+	// kind is ' ' for unchanged lines, '-' for deleted lines
+	// and '+' for inserted lines.
+	kind byte
+	line string
+
+	// lineA and lineB contain 0-based line numbers in the original
+	// and the changed text.
+	lineA int
+	lineB int
+}
+
+// diffLines returns the shortest edit script transforming a into b.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i], lineA: i, lineB: j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: a[i], lineA: i, lineB: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j], lineA: i, lineB: j})
+			j++
+		}
+	}
+	return ops
+}
+
+// splitLines splits s into lines including line endings.
+func splitLines(s []byte) []string {
+	lines := strings.SplitAfter(string(s), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testUnifiedDiff(t, "a\nb\n", "a\nb\n", "")
+	testUnifiedDiff(t, "a\nb\nc\n", "a\nB\nc\n", "--- f.orig\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n")
+	testUnifiedDiff(t, "", "a\n", "--- f.orig\n+++ f\n@@ -0,0 +1 @@\n+a\n")
+	testUnifiedDiff(t, "a", "b", "--- f.orig\n+++ f\n@@ -1 +1 @@\n-a\n\\ No newline at end of file\n+b\n\\ No newline at end of file\n")
+
+	// distant changes are put into distinct hunks
+	testUnifiedDiff(t, "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "0\n2\n3\n4\n5\n6\n7\n8\n9\n11\n",
+		"--- f.orig\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+0\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+11\n")
+}
+
+func testUnifiedDiff(t *testing.T, a, b, expected string) {
+	t.Helper()
+	diff := string(unifiedDiff("f", []byte(a), []byte(b)))
+	if diff != expected {
+		t.Fatalf("unexpected diff between %q and %q\n%s\nExpecting\n%s", a, b, diff, expected)
+	}
+}
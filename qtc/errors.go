@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// parseError is the error found in the template.
+type parseError struct {
+	filePath string
+
+	// line and col contain 1-based position of the tag containing the error.
+	// col is measured in bytes.
+	line int
+	col  int
+
+	// endLine and endCol contain 1-based position following the end
+	// of the tag containing the error.
+	endLine int
+	endCol  int
+
+	// tagName is the name of the tag containing the error.
+	tagName string
+
+	// check is the name of vet check, which found the problem.
+	// It is empty for errors preventing template compilation.
+	check string
+
+	// excerpt contains the source line with the error and a caret
+	// under the tag.
+	excerpt string
+
+	err error
+}
+
+func (e *parseError) Error() string {
+	msg := e.err.Error()
+	if len(e.check) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, e.check)
+	}
+	if len(e.excerpt) == 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", e.filePath, e.line, e.col, msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s\n%s", e.filePath, e.line, e.col, msg, e.excerpt)
+}
+
+// message returns the error message for structured diagnostics.
+//
+// The message doesn't contain template positions returned
+// by scanner.Context, since diagnostics report the position
+// in separate fields. See errorf for details.
+func (e *parseError) message() string {
+	if ce, ok := e.err.(*contextError); ok {
+		return ce.bareMsg
+	}
+	return e.err.Error()
+}
+
+// tagContext is the template position returned by scanner.Context.
+type tagContext struct {
+	filePath string
+	line     int
+	col      int
+}
+
+func (c tagContext) String() string {
+	return fmt.Sprintf("%s:%d:%d", c.filePath, c.line, c.col)
+}
+
+// contextError is the error returned by errorf.
+type contextError struct {
+	msg string
+
+	// bareMsg is the message without template positions.
+	bareMsg string
+}
+
+func (e *contextError) Error() string {
+	return e.msg
+}
+
+// errorf returns the error formatted according to format like fmt.Errorf.
+//
+// Additionally the error contains the message without template positions.
+// tagContext args are omitted from this message together with the preceding
+// " at ", while the nested errors returned by errorf are replaced
+// by their' messages without template positions.
+func errorf(format string, args ...interface{}) error {
+	var bareFormat []byte
+	var bareArgs []interface{}
+	n := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			bareFormat = append(bareFormat, c)
+			continue
+		}
+		// Find the verb following the optional flags and width.
+		j := i + 1
+		for j < len(format) && strings.IndexByte("+-# 0123456789.", format[j]) >= 0 {
+			j++
+		}
+		if j == len(format) || format[j] == '%' || n >= len(args) {
+			bareFormat = append(bareFormat, format[i:j+1]...)
+			i = j
+			continue
+		}
+		arg := args[n]
+		n++
+		switch x := arg.(type) {
+		case tagContext:
+			bareFormat = bytes.TrimSuffix(bareFormat, []byte(" at "))
+		case *contextError:
+			bareFormat = append(bareFormat, format[i:j+1]...)
+			bareArgs = append(bareArgs, x.bareMsg)
+		default:
+			bareFormat = append(bareFormat, format[i:j+1]...)
+			bareArgs = append(bareArgs, arg)
+		}
+		i = j
+	}
+	return &contextError{
+		msg:     fmt.Sprintf(format, args...),
+		bareMsg: fmt.Sprintf(string(bareFormat), bareArgs...),
+	}
+}
+
+// parseErrors contains all the errors found in the template.
+type parseErrors []*parseError
+
+func (errs parseErrors) Error() string {
+	a := make([]string, len(errs))
+	for i, err := range errs {
+		a[i] = err.Error()
+	}
+	return strings.Join(a, "\n")
+}
+
+// newParseError returns parseError for err pointing to the last tag
+// read by the scanner.
+func (p *parser) newParseError(err error) *parseError {
+	s := p.s
+	endLine, endPos := tagEnd(p.src, s.tagLine, s.tagPos)
+	return &parseError{
+		filePath: s.filePath,
+		line:     s.tagLine + 1,
+		col:      s.tagPos + 1,
+		endLine:  endLine + 1,
+		endCol:   endPos + 1,
+		tagName:  s.lastTagName,
+		excerpt:  sourceExcerpt(p.src, s.tagLine, s.tagPos),
+		err:      err,
+	}
+}
+
+// lineOffset returns the offset of the given 0-based line in src.
+//
+// -1 is returned if src doesn't contain the line.
+func lineOffset(src []byte, line int) int {
+	offset := 0
+	for i := 0; i < line; i++ {
+		n := bytes.IndexByte(src[offset:], '\n')
+		if n < 0 {
+			return -1
+		}
+		offset += n + 1
+	}
+	return offset
+}
+
+// tagEnd returns the position following the end of the tag starting
+// at the given position in src.
+//
+// The end of the line is returned if the tag isn't closed.
+func tagEnd(src []byte, line, pos int) (int, int) {
+	offset := lineOffset(src, line)
+	if offset < 0 || offset+pos > len(src) {
+		return line, pos
+	}
+	start := offset + pos
+	end := bytes.Index(src[start:], []byte("%}"))
+	if end < 0 {
+		end = bytes.IndexByte(src[start:], '\n')
+		if end < 0 {
+			end = len(src) - start
+		}
+	} else {
+		end += len("%}")
+	}
+	end += start
+	for _, c := range src[start:end] {
+		if c == '\n' {
+			line++
+			pos = 0
+		} else {
+			pos++
+		}
+	}
+	return line, pos
+}
+
+// sourceExcerpt returns the given line of src with a caret under
+// the given pos.
+//
+// Empty string is returned if src doesn't contain the line.
+func sourceExcerpt(src []byte, line, pos int) string {
+	offset := lineOffset(src, line)
+	if offset < 0 {
+		return ""
+	}
+	src = src[offset:]
+	if n := bytes.IndexByte(src, '\n'); n >= 0 {
+		src = src[:n]
+	}
+	src = bytes.TrimSuffix(src, []byte("\r"))
+	if pos > len(src) {
+		pos = len(src)
+	}
+
+	// Tabs are preserved, so the caret is aligned with the source line.
+	var caret []byte
+	for _, c := range string(src[:pos]) {
+		if c != '\t' {
+			c = ' '
+		}
+		caret = append(caret, byte(c))
+	}
+	caret = append(caret, '^')
+	return fmt.Sprintf("\t%s\n\t%s", src, caret)
+}
+
+// fileError is the error occurred when processing the given file.
+type fileError struct {
+	filename string
+	err      error
+}
+
+func (e *fileError) Error() string {
+	return e.err.Error()
+}
+
+// knownTags contains the names of all the tags recognized by qtc.
+var knownTags = []string{
+	"package", "import", "variants", "interface", "iface", "code", "func", "endfunc",
+	"extends", "block", "endblock", "slot", "endslot",
+	"call", "endcall", "capture", "endcapture", "push", "endpush", "stack",
+	"fragment", "endfragment", "return", "break", "continue",
+	"for", "endfor", "if", "elseif", "else", "endif",
+	"switch", "case", "default", "endswitch", "cat",
+	"comment", "endcomment", "plain", "endplain",
+	"collapsespace", "endcollapsespace", "stripspace", "endstripspace",
+	"space", "newline",
+	"s", "v", "d", "f", "q", "z", "j", "u",
+	"s=", "v=", "d=", "f=", "q=", "z=", "j=", "u=",
+	"sz", "qz", "jz", "uz",
+	"sz=", "qz=", "jz=", "uz=", "=",
+}
+
+// maxSuggestionDistance is the maximum edit distance between unknown tag
+// name and the suggested tag name.
+const maxSuggestionDistance = 2
+
+// suggestTag returns a suggestion for the unknown tag with the given name.
+//
+// Empty string is returned if the tag is known or if there are no known tags
+// with similar names.
+func suggestTag(tagName []byte) string {
+	name, _ := splitTagNamePrec(string(tagName))
+	suggestion := ""
+	minDistance := maxSuggestionDistance + 1
+	for _, known := range knownTags {
+		if name == known {
+			return ""
+		}
+		d := editDistance(name, known)
+		if d < minDistance && d < len(name) {
+			suggestion = known
+			minDistance = d
+		}
+	}
+	if len(suggestion) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(". Did you mean %q?", suggestion)
+}
+
+// editDistance returns Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			d := prev[j-1]
+			if a[i-1] != b[j-1] {
+				d++
+			}
+			if prev[j]+1 < d {
+				d = prev[j] + 1
+			}
+			if cur[j-1]+1 < d {
+				d = cur[j-1] + 1
+			}
+			cur[j] = d
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
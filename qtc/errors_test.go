@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorsRecovery(t *testing.T) {
+	s := `{% func A() %}
+	{% edfor %}
+{% endfunc %}
+{% func B() %}ok{% endfunc %}
+{% fnc C() %}
+{% func D() %}{% if true %}{% endfunc %}
+{% func E() %}{% for %}{% endfor %}{% endfunc %}
+{% func F() %}{% s= ( %}{% endfunc %}`
+	errs := testParseErrors(t, s)
+	testParseErrorsPositions(t, errs, []parseError{
+		{line: 2, col: 2, tagName: "edfor"},
+		{line: 5, col: 1, tagName: "fnc"},
+		{line: 6, col: 28, tagName: "endfunc"},
+		{line: 8, col: 15, tagName: "s="},
+	})
+	if !strings.Contains(errs[0].Error(), `Did you mean "endfor"?`) {
+		t.Fatalf("missing suggestion in %q", errs[0])
+	}
+	if !strings.Contains(errs[1].Error(), `Did you mean "func"?`) {
+		t.Fatalf("missing suggestion in %q", errs[1])
+	}
+
+	// errors at the end tag don't skip the next func
+	s = `{% func A() %}{% endfunc foo %}{% func B() %}{% edfor %}{% endfunc %}`
+	errs = testParseErrors(t, s)
+	testParseErrorsPositions(t, errs, []parseError{
+		{line: 1, col: 15, tagName: "endfunc"},
+		{line: 1, col: 46, tagName: "edfor"},
+	})
+
+	// block overrides are skipped up to endblock
+	s = `{% extends (p *Page) Layout %}{% block Body %}{% edfor %}{% endblock %}{% block Title %}{% endfr %}{% endblock %}`
+	errs = testParseErrors(t, s)
+	testParseErrorsPositions(t, errs, []parseError{
+		{line: 1, col: 47, tagName: "edfor"},
+		{line: 1, col: 89, tagName: "endfr"},
+	})
+
+	// scanner errors stop parsing
+	s = `{% func A() %}{% fo$ %}{% endfunc %}{% func B() %}{% edfor %}{% endfunc %}`
+	errs = testParseErrors(t, s)
+	testParseErrorsPositions(t, errs, []parseError{
+		{line: 1, col: 15, tagName: "func"},
+	})
+}
+
+func testParseErrors(t *testing.T, s string) parseErrors {
+	t.Helper()
+	var w bytes.Buffer
+	err := parse(&w, bytes.NewBufferString(s), "foo.qtpl", "memory")
+	if err == nil {
+		t.Fatalf("expecting error when parsing %q", s)
+	}
+	errs, ok := err.(parseErrors)
+	if !ok {
+		t.Fatalf("unexpected error type %T: %s", err, err)
+	}
+	return errs
+}
+
+func testParseErrorsPositions(t *testing.T, errs parseErrors, expected []parseError) {
+	t.Helper()
+	if len(errs) != len(expected) {
+		t.Fatalf("unexpected number of errors: %d. Expecting %d. Errors:\n%s", len(errs), len(expected), errs)
+	}
+	for i, err := range errs {
+		e := expected[i]
+		if err.filePath != "foo.qtpl" || err.line != e.line || err.col != e.col || err.tagName != e.tagName {
+			t.Fatalf("unexpected error #%d position %s:%d:%d in tag %q. Expecting foo.qtpl:%d:%d in tag %q. Error: %s",
+				i, err.filePath, err.line, err.col, err.tagName, e.line, e.col, e.tagName, err)
+		}
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	// messages don't contain template positions regardless of file path
+	s := `{% func A() %}{% if true %}{% edfor %}{% endif %}{% endfunc %}`
+	for _, filePath := range []string{"foo.qtpl", "./a+b/(c)[d].qtpl", "a at b:1:2.qtpl"} {
+		var w bytes.Buffer
+		err := parse(&w, bytes.NewBufferString(s), filePath, "memory")
+		errs, ok := err.(parseErrors)
+		if !ok || len(errs) != 1 {
+			t.Fatalf("unexpected error for %q: %v", filePath, err)
+		}
+		expected := `error in "func A()": unexpected tag found in "if true": "edfor". Did you mean "endfor"?`
+		if msg := errs[0].message(); msg != expected {
+			t.Fatalf("unexpected message for %q: %q. Expecting %q", filePath, msg, expected)
+		}
+		if !strings.Contains(errs[0].Error(), filePath+":1:") {
+			t.Fatalf("missing position in %q", errs[0])
+		}
+	}
+}
+
+func TestErrorf(t *testing.T) {
+	ctx := tagContext{filePath: "a(b).qtpl", line: 1, col: 2}
+	inner := errorf("invalid %q at %s", "x", ctx)
+	err := errorf("error at %s: %s. 100%% %s%d", ctx, inner, "done", 1)
+	testErrorf(t, err, `error at a(b).qtpl:1:2: invalid "x" at a(b).qtpl:1:2. 100% done1`, `error: invalid "x". 100% done1`)
+
+	// errors without positions
+	testErrorf(t, errorf("foo %s", "bar"), "foo bar", "foo bar")
+}
+
+func testErrorf(t *testing.T, err error, expectedMsg, expectedBareMsg string) {
+	t.Helper()
+	ce := err.(*contextError)
+	if ce.msg != expectedMsg {
+		t.Fatalf("unexpected message: %q. Expecting %q", ce.msg, expectedMsg)
+	}
+	if ce.bareMsg != expectedBareMsg {
+		t.Fatalf("unexpected bare message: %q. Expecting %q", ce.bareMsg, expectedBareMsg)
+	}
+}
+
+func TestSourceExcerpt(t *testing.T) {
+	src := []byte("foo\n\tbar {% baz %}\r\nнет {% x %}")
+	testSourceExcerpt(t, src, 0, 0, "\tfoo\n\t^")
+	testSourceExcerpt(t, src, 1, 5, "\t\tbar {% baz %}\n\t\t    ^")
+	testSourceExcerpt(t, src, 2, 7, "\tнет {% x %}\n\t    ^")
+
+	// missing line
+	testSourceExcerpt(t, src, 3, 0, "")
+}
+
+func testSourceExcerpt(t *testing.T, src []byte, line, pos int, expected string) {
+	t.Helper()
+	excerpt := sourceExcerpt(src, line, pos)
+	if excerpt != expected {
+		t.Fatalf("unexpected excerpt for line %d, pos %d: %q. Expecting %q", line, pos, excerpt, expected)
+	}
+}
+
+func TestTagEnd(t *testing.T) {
+	src := []byte("foo {% bar %}\n{% baz\n qwe %} {% x")
+	testTagEnd(t, src, 0, 4, 0, 13)
+	testTagEnd(t, src, 1, 0, 2, 7)
+
+	// unclosed tag
+	testTagEnd(t, src, 2, 8, 2, 12)
+
+	// missing line
+	testTagEnd(t, src, 3, 0, 3, 0)
+}
+
+func testTagEnd(t *testing.T, src []byte, line, pos, expectedLine, expectedPos int) {
+	t.Helper()
+	endLine, endPos := tagEnd(src, line, pos)
+	if endLine != expectedLine || endPos != expectedPos {
+		t.Fatalf("unexpected tag end for line %d, pos %d: line %d, pos %d. Expecting line %d, pos %d",
+			line, pos, endLine, endPos, expectedLine, expectedPos)
+	}
+}
+
+func TestSuggestTag(t *testing.T) {
+	testSuggestTag(t, "edfor", "endfor")
+	testSuggestTag(t, "endfro", "endfor")
+	testSuggestTag(t, "fnc", "func")
+	testSuggestTag(t, "Func", "func")
+	testSuggestTag(t, "stripsapce", "stripspace")
+
+	// known tags
+	testSuggestTag(t, "endfor", "")
+	testSuggestTag(t, "f.2=", "")
+
+	// no similar tags
+	testSuggestTag(t, "x", "")
+	testSuggestTag(t, "foobarbaz", "")
+}
+
+func testSuggestTag(t *testing.T, tagName, expected string) {
+	t.Helper()
+	s := suggestTag([]byte(tagName))
+	if len(expected) > 0 {
+		expected = `. Did you mean "` + expected + `"?`
+	}
+	if s != expected {
+		t.Fatalf("unexpected suggestion for %q: %q. Expecting %q", tagName, s, expected)
+	}
+}
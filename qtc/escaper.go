@@ -0,0 +1,773 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// escState is the state of html parser tracking the context
+// of the static text in template funcs.
+type escState uint8
+
+const (
+	// stateText is ordinary html text.
+	stateText escState = iota
+
+	// stateLT is html text followed by '<'.
+	stateLT
+
+	// stateEndTagOpen is html text followed by '</'.
+	stateEndTagOpen
+
+	// stateBang is html text followed by '<!'.
+	stateBang
+
+	// stateTagName is tag name, i.e. '<foo'.
+	stateTagName
+
+	// stateTag is the space between attributes inside a tag.
+	stateTag
+
+	// stateAttrName is attribute name.
+	stateAttrName
+
+	// stateAfterName is the space after attribute name.
+	stateAfterName
+
+	// stateBeforeValue is the space after '=' in attribute.
+	stateBeforeValue
+
+	// stateAttr is attribute value.
+	stateAttr
+
+	// stateComment is html comment, i.e. '<!-- ... -->'.
+	stateComment
+
+	// stateBogus is '<!DOCTYPE ...>' and other non-tag markup.
+	stateBogus
+
+	// stateScript is raw text inside <script> element.
+	stateScript
+
+	// stateStyle is raw text inside <style> element.
+	stateStyle
+
+	// stateRCDATA is text inside <textarea> and <title> elements.
+	stateRCDATA
+)
+
+var escStateStrs = [...]string{
+	stateText:        "text",
+	stateLT:          "text",
+	stateEndTagOpen:  "end tag",
+	stateBang:        "markup declaration",
+	stateTagName:     "tag name",
+	stateTag:         "tag",
+	stateAttrName:    "attribute name",
+	stateAfterName:   "attribute name",
+	stateBeforeValue: "attribute value",
+	stateAttr:        "attribute value",
+	stateComment:     "comment",
+	stateBogus:       "markup declaration",
+	stateScript:      "script",
+	stateStyle:       "style",
+	stateRCDATA:      "text",
+}
+
+// escAttr is the type of attribute value.
+type escAttr uint8
+
+const (
+	attrNormal escAttr = iota
+	attrJS
+	attrCSS
+	attrURL
+)
+
+// jsState is the state of js parser inside <script> and event handlers.
+type jsState uint8
+
+const (
+	jsCode jsState = iota
+	jsSlash
+	jsDQ
+	jsSQ
+	jsTmpl
+	jsLineComment
+	jsBlockComment
+	jsBlockStar
+	jsRegexp
+	jsRegexpClass
+)
+
+// urlPart is the part of url inside url attribute.
+type urlPart uint8
+
+const (
+	urlStart urlPart = iota
+	urlPath
+	urlQuery
+)
+
+// escContext is the html context of the generated output at the given
+// point of template func.
+//
+// escContext must remain comparable, since contexts at the end
+// of if, for and switch branches are compared with each other.
+type escContext struct {
+	state escState
+
+	// elem is the name of the current element in lower case.
+	elem    string
+	closing bool
+	nonJS   bool
+
+	attr     escAttr
+	attrName string
+	attrVal  string
+	delim    byte
+
+	js    jsState
+	jsEsc bool
+
+	// jsDiv is set if '/' in js code is a division operator
+	// instead of the start of regular expression literal.
+	jsDiv bool
+
+	// jsWord is the identifier preceding the current position in js code.
+	// It is used for detecting regular expressions after keywords
+	// such as return.
+	jsWord string
+
+	url urlPart
+
+	// match is the number of matched bytes from the string terminating
+	// the current state, i.e. '-->' for comments and '</script' for scripts.
+	match int
+}
+
+// String returns human-readable description of c for error messages.
+func (c *escContext) String() string {
+	s := escStateStrs[c.state]
+	switch c.state {
+	case stateAttr, stateBeforeValue:
+		s = fmt.Sprintf("%s of %q", s, c.attrName)
+	}
+	if len(c.elem) > 0 {
+		s = fmt.Sprintf("%s in <%s>", s, c.elem)
+	}
+	if c.isJS() {
+		switch c.js {
+		case jsDQ, jsSQ, jsTmpl:
+			s += ", js string"
+		case jsLineComment, jsBlockComment, jsBlockStar:
+			s += ", js comment"
+		case jsRegexp, jsRegexpClass:
+			s += ", js regular expression"
+		}
+	}
+	return s
+}
+
+// feed advances c over the given static text.
+func (c *escContext) feed(b []byte) {
+	for i := 0; i < len(b); i++ {
+		c.step(b[i])
+	}
+}
+
+func (c *escContext) step(ch byte) {
+	switch c.state {
+	case stateText:
+		if ch == '<' {
+			c.state = stateLT
+		}
+	case stateLT:
+		switch {
+		case isASCIILetter(ch):
+			c.startTag(ch, false)
+		case ch == '/':
+			c.state = stateEndTagOpen
+		case ch == '!':
+			c.state = stateBang
+			c.match = 0
+		case ch == '<':
+			// stay in stateLT
+		default:
+			c.state = stateText
+		}
+	case stateEndTagOpen:
+		if isASCIILetter(ch) {
+			c.startTag(ch, true)
+		} else {
+			c.state = stateBogus
+		}
+	case stateBang:
+		if ch != '-' {
+			c.state = stateBogus
+			c.step(ch)
+			return
+		}
+		c.match++
+		if c.match == 2 {
+			c.state = stateComment
+			c.match = 0
+		}
+	case stateBogus:
+		if ch == '>' {
+			*c = escContext{}
+		}
+	case stateComment:
+		if c.match == 2 && ch == '-' {
+			// '--->' still terminates the comment
+			return
+		}
+		c.match = matchTerminator(c.match, ch, "-->")
+		if c.match == len("-->") {
+			*c = escContext{}
+		}
+	case stateTagName:
+		switch {
+		case isSpace(ch) || ch == '/':
+			c.state = stateTag
+		case ch == '>':
+			c.endTag()
+		default:
+			c.elem += string(toLowerASCII(ch))
+		}
+	case stateTag:
+		switch {
+		case isSpace(ch) || ch == '/':
+		case ch == '>':
+			c.endTag()
+		default:
+			c.startAttr(ch)
+		}
+	case stateAttrName:
+		switch {
+		case ch == '=':
+			c.state = stateBeforeValue
+		case isSpace(ch):
+			c.state = stateAfterName
+		case ch == '/':
+			c.state = stateTag
+		case ch == '>':
+			c.endTag()
+		default:
+			c.attrName += string(toLowerASCII(ch))
+		}
+	case stateAfterName:
+		switch {
+		case isSpace(ch):
+		case ch == '=':
+			c.state = stateBeforeValue
+		case ch == '/':
+			c.state = stateTag
+		case ch == '>':
+			c.endTag()
+		default:
+			c.startAttr(ch)
+		}
+	case stateBeforeValue:
+		switch {
+		case isSpace(ch):
+		case ch == '"' || ch == '\'':
+			c.startAttrValue(ch)
+		case ch == '>':
+			c.endTag()
+		default:
+			c.startAttrValue(0)
+			c.step(ch)
+		}
+	case stateAttr:
+		if (c.delim != 0 && ch == c.delim) || (c.delim == 0 && isSpace(ch)) {
+			c.endAttrValue()
+			return
+		}
+		if c.delim == 0 && ch == '>' {
+			c.endAttrValue()
+			c.endTag()
+			return
+		}
+		c.stepAttrValue(ch)
+	case stateScript, stateStyle, stateRCDATA:
+		c.match = matchTerminator(c.match, toLowerASCII(ch), "</"+c.elem)
+		if c.match == len(c.elem)+2 {
+			c.state = stateTag
+			c.closing = true
+			c.match = 0
+			return
+		}
+		if c.state == stateScript && !c.nonJS {
+			c.stepJS(ch)
+		}
+	default:
+		panic(fmt.Sprintf("BUG: unexpected state %d", c.state))
+	}
+}
+
+func (c *escContext) startTag(ch byte, closing bool) {
+	*c = escContext{
+		state:   stateTagName,
+		elem:    string(toLowerASCII(ch)),
+		closing: closing,
+	}
+}
+
+func (c *escContext) endTag() {
+	elem := c.elem
+	closing := c.closing
+	nonJS := c.nonJS
+	*c = escContext{}
+	if closing {
+		return
+	}
+	switch elem {
+	case "script":
+		c.state = stateScript
+		c.nonJS = nonJS
+	case "style":
+		c.state = stateStyle
+	case "textarea", "title":
+		c.state = stateRCDATA
+	default:
+		return
+	}
+	c.elem = elem
+}
+
+func (c *escContext) startAttr(ch byte) {
+	c.state = stateAttrName
+	c.attrName = string(toLowerASCII(ch))
+	c.attrVal = ""
+}
+
+func (c *escContext) startAttrValue(delim byte) {
+	c.state = stateAttr
+	c.delim = delim
+	c.attr = attrType(c.attrName)
+	c.resetJS()
+	c.url = urlStart
+}
+
+func (c *escContext) endAttrValue() {
+	if c.elem == "script" && c.attrName == "type" {
+		c.nonJS = !isJSType(c.attrVal)
+	}
+	c.state = stateTag
+	c.attr = attrNormal
+	c.attrName = ""
+	c.attrVal = ""
+	c.delim = 0
+	c.resetJS()
+	c.url = urlStart
+}
+
+func (c *escContext) stepAttrValue(ch byte) {
+	switch c.attr {
+	case attrJS:
+		c.stepJS(ch)
+	case attrURL:
+		switch {
+		case ch == '?' || ch == '#':
+			c.url = urlQuery
+		case c.url == urlStart:
+			c.url = urlPath
+		}
+	}
+	if c.elem == "script" && c.attrName == "type" {
+		c.attrVal += string(toLowerASCII(ch))
+	}
+}
+
+func (c *escContext) resetJS() {
+	c.js = jsCode
+	c.jsEsc = false
+	c.jsDiv = false
+	c.jsWord = ""
+}
+
+// jsRegexpKeywords contains keywords, which may be followed
+// by regular expression literal.
+var jsRegexpKeywords = map[string]bool{
+	"return": true, "typeof": true, "instanceof": true, "in": true, "of": true,
+	"new": true, "delete": true, "void": true, "throw": true, "case": true,
+	"do": true, "else": true, "yield": true, "await": true,
+}
+
+// maxJSWordLen is the maximum length of jsWord. It exceeds the length
+// of the longest keyword in jsRegexpKeywords.
+const maxJSWordLen = 16
+
+func isJSIdentChar(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+		ch == '_' || ch == '$' || ch >= 0x80
+}
+
+// endJSWord ends the identifier preceding the current position in js code.
+//
+// '/' after identifier is a division unless the identifier is a keyword
+// such as return.
+func (c *escContext) endJSWord() {
+	if len(c.jsWord) > 0 {
+		c.jsDiv = !jsRegexpKeywords[c.jsWord]
+		c.jsWord = ""
+	}
+}
+
+func (c *escContext) stepJS(ch byte) {
+	switch c.js {
+	case jsCode:
+		if isJSIdentChar(ch) {
+			if len(c.jsWord) < maxJSWordLen {
+				c.jsWord += string(ch)
+			}
+			return
+		}
+		c.endJSWord()
+		if isSpace(ch) {
+			return
+		}
+		switch ch {
+		case '"':
+			c.js = jsDQ
+		case '\'':
+			c.js = jsSQ
+		case '`':
+			c.js = jsTmpl
+		case '/':
+			c.js = jsSlash
+		case ')', ']':
+			c.jsDiv = true
+		default:
+			c.jsDiv = false
+		}
+	case jsSlash:
+		switch ch {
+		case '/':
+			c.js = jsLineComment
+		case '*':
+			c.js = jsBlockComment
+		default:
+			if c.jsDiv {
+				c.js = jsCode
+				c.jsDiv = false
+				c.stepJS(ch)
+				return
+			}
+			c.js = jsRegexp
+			c.stepJS(ch)
+		}
+	case jsRegexp, jsRegexpClass:
+		if c.jsEsc {
+			c.jsEsc = false
+			return
+		}
+		switch {
+		case ch == '\\':
+			c.jsEsc = true
+		case ch == '\n':
+			// Broken regular expression.
+			c.resetJS()
+		case ch == '[':
+			c.js = jsRegexpClass
+		case ch == ']' && c.js == jsRegexpClass:
+			c.js = jsRegexp
+		case ch == '/' && c.js == jsRegexp:
+			// Flags following the regular expression are skipped
+			// as identifier.
+			c.js = jsCode
+			c.jsDiv = true
+		}
+	case jsDQ, jsSQ, jsTmpl:
+		if c.jsEsc {
+			c.jsEsc = false
+			return
+		}
+		switch {
+		case ch == '\\':
+			c.jsEsc = true
+		case ch == '"' && c.js == jsDQ, ch == '\'' && c.js == jsSQ, ch == '`' && c.js == jsTmpl:
+			c.js = jsCode
+			c.jsDiv = true
+		}
+	case jsLineComment:
+		if ch == '\n' {
+			c.js = jsCode
+		}
+	case jsBlockComment:
+		if ch == '*' {
+			c.js = jsBlockStar
+		}
+	case jsBlockStar:
+		switch ch {
+		case '/':
+			c.js = jsCode
+		case '*':
+		default:
+			c.js = jsBlockComment
+		}
+	}
+}
+
+// afterOutput advances c after the output tag.
+func (c *escContext) afterOutput() {
+	if c.state == stateAttr && c.attr == attrURL && c.url == urlStart {
+		c.url = urlPath
+	}
+	if c.isJS() && (c.js == jsCode || c.js == jsSlash) {
+		// The output is a value, so the following '/' is a division.
+		// The output after '/' was a divisor.
+		c.js = jsCode
+		c.jsDiv = true
+		c.jsWord = ""
+	}
+}
+
+func (c *escContext) isJS() bool {
+	return (c.state == stateScript && !c.nonJS) || (c.state == stateAttr && c.attr == attrJS)
+}
+
+func (c *escContext) isCSS() bool {
+	return c.state == stateStyle || (c.state == stateAttr && c.attr == attrCSS)
+}
+
+// joinEscContexts returns the context for the point where branches
+// ending with a and b meet.
+//
+// false is returned if a and b cannot be joined.
+func joinEscContexts(a, b escContext) (escContext, bool) {
+	// Identifiers at the end of branches matter only for detecting
+	// regular expressions.
+	a.endJSWord()
+	b.endJSWord()
+	if a.jsDiv != b.jsDiv {
+		// The following '/' is treated as the start of regular expression,
+		// so output tags cannot be used until its end.
+		a.jsDiv, b.jsDiv = false, false
+	}
+	if a == b {
+		return a, true
+	}
+	if a.state == stateAttr && a.attr == attrURL {
+		// urlStart joined with other url part must be escaped as urlPath,
+		// so unsafe schemes cannot sneak in.
+		au, bu := a.url, b.url
+		a.url, b.url = urlPath, urlPath
+		if au == urlQuery || bu == urlQuery {
+			a.url, b.url = urlQuery, urlQuery
+		}
+		if a == b {
+			return a, true
+		}
+	}
+	return a, false
+}
+
+// outputMethod returns the QWriter accessor and the method, which must be
+// used for html-escaped output tag with the given name in the context c.
+//
+// tagName must be passed without trailing '='.
+func (c *escContext) outputMethod(tagName string) (string, string, error) {
+	method := strings.ToUpper(tagName)
+	switch c.state {
+	case stateText, stateLT, stateComment, stateRCDATA:
+		return "E()", method, nil
+	case stateScript:
+		if c.nonJS {
+			return "E()", method, nil
+		}
+		return c.outputMethodJS("N()", tagName, method)
+	case stateStyle:
+		return outputMethodCSS("N()", tagName, method)
+	case stateAttr:
+		if c.delim == 0 {
+			return "", "", fmt.Errorf("output tag cannot be used in unquoted value of attribute %q. Put the value into quotes", c.attrName)
+		}
+		switch c.attr {
+		case attrJS:
+			return c.outputMethodJS("E()", tagName, method)
+		case attrCSS:
+			return outputMethodCSS("E()", tagName, method)
+		case attrURL:
+			return c.outputMethodURL(tagName, method)
+		}
+		return "E()", method, nil
+	case stateBeforeValue:
+		return "", "", fmt.Errorf("output tag cannot be used in unquoted value of attribute %q. Put the value into quotes", c.attrName)
+	}
+	return "", "", fmt.Errorf("output tag cannot be used in html %s context. Only text and quoted attribute values may contain output tags. "+
+		"Use unescaped output tag such as {%%s= %%} for trusted values or pass -autoescape=false to qtc", c)
+}
+
+func (c *escContext) outputMethodJS(filter, tagName, method string) (string, string, error) {
+	switch c.js {
+	case jsTmpl:
+		// J doesn't escape '`' and '${', so the output could break out
+		// of template literal.
+		return "", "", fmt.Errorf("output tag cannot be used in js template literal. Use string literal instead")
+	case jsRegexp, jsRegexpClass:
+		return "", "", fmt.Errorf("output tag cannot be used in js regular expression literal")
+	case jsSlash:
+		if !c.jsDiv {
+			return "", "", fmt.Errorf("output tag cannot be used in js regular expression literal")
+		}
+	}
+	inString := c.js != jsCode && c.js != jsSlash
+	switch tagName {
+	case "s", "z", "sz", "q", "qz", "j", "jz":
+		// The output is quoted in js code, so it cannot contain
+		// arbitrary code, while it is escaped without quotes inside
+		// string literals, so it cannot break out of the string.
+		if inString {
+			return filter, "J" + zSuffix(tagName), nil
+		}
+		return filter, "Q" + zSuffix(tagName), nil
+	case "v":
+		return "", "", errOutputTagV("js")
+	}
+	return filter, method, nil
+}
+
+func outputMethodCSS(filter, tagName, method string) (string, string, error) {
+	switch tagName {
+	case "s", "z", "sz":
+		return filter, "CSS" + zSuffix(tagName), nil
+	case "v":
+		return "", "", errOutputTagV("css")
+	}
+	return filter, method, nil
+}
+
+func (c *escContext) outputMethodURL(tagName, method string) (string, string, error) {
+	switch tagName {
+	case "s", "z", "sz":
+		if c.url == urlStart {
+			return "E()", "URL" + zSuffix(tagName), nil
+		}
+		return "E()", "U" + zSuffix(tagName), nil
+	case "v":
+		return "", "", errOutputTagV("url")
+	}
+	return "E()", method, nil
+}
+
+// zSuffix returns "Z" for output tags accepting byte slices.
+func zSuffix(tagName string) string {
+	if strings.HasSuffix(tagName, "z") {
+		return "Z"
+	}
+	return ""
+}
+
+func errOutputTagV(ctx string) error {
+	return fmt.Errorf("{%%v %%} tag cannot be used in %s context. Convert the value to string and use {%%s %%} tag instead", ctx)
+}
+
+func attrType(name string) escAttr {
+	name = strings.TrimPrefix(name, "data-")
+	if n := strings.IndexByte(name, ':'); n >= 0 {
+		// Namespaced attribute such as xlink:href
+		if name[:n] == "xmlns" {
+			return attrURL
+		}
+		name = name[n+1:]
+	}
+	if strings.HasPrefix(name, "on") {
+		return attrJS
+	}
+	switch name {
+	case "style":
+		return attrCSS
+	case "action", "archive", "background", "cite", "classid", "codebase", "data",
+		"formaction", "href", "icon", "longdesc", "manifest", "ping", "poster",
+		"profile", "src", "srcset", "usemap", "xmlns":
+		return attrURL
+	}
+	if strings.Contains(name, "src") || strings.Contains(name, "uri") || strings.Contains(name, "url") {
+		return attrURL
+	}
+	return attrNormal
+}
+
+func isJSType(mimeType string) bool {
+	if n := strings.IndexByte(mimeType, ';'); n >= 0 {
+		mimeType = mimeType[:n]
+	}
+	switch strings.TrimSpace(mimeType) {
+	case "", "module",
+		"application/ecmascript", "application/javascript", "application/json",
+		"application/ld+json", "application/x-ecmascript", "application/x-javascript",
+		"text/ecmascript", "text/javascript", "text/javascript1.0", "text/javascript1.1",
+		"text/javascript1.2", "text/javascript1.3", "text/javascript1.4",
+		"text/javascript1.5", "text/jscript", "text/livescript", "text/x-ecmascript",
+		"text/x-javascript":
+		return true
+	}
+	return false
+}
+
+// matchTerminator returns the number of matched bytes from term
+// after appending ch to the already matched n bytes.
+func matchTerminator(n int, ch byte, term string) int {
+	if ch == term[n] {
+		return n + 1
+	}
+	if ch == term[0] {
+		return 1
+	}
+	return 0
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func toLowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// escJoiner joins html contexts at the end of if, for and switch branches.
+type escJoiner struct {
+	ctx escContext
+	n   int
+	err error
+}
+
+// add adds ctx at the end of the current branch to bj.
+//
+// Branches terminated by return, break or continue are ignored.
+func (bj *escJoiner) add(p *parser, ctx escContext) {
+	if p.escDead {
+		p.escDead = false
+		return
+	}
+	if bj.n == 0 {
+		bj.ctx = ctx
+	} else if bj.err == nil {
+		joined, ok := joinEscContexts(bj.ctx, ctx)
+		if !ok {
+			bj.err = fmt.Errorf("ambiguous html context after the statement: branches end in %s and %s", &bj.ctx, &ctx)
+		}
+		bj.ctx = joined
+	}
+	bj.n++
+}
+
+// finish sets the html context after the statement to p.
+//
+// start is the html context before the statement.
+func (bj *escJoiner) finish(p *parser, start escContext) error {
+	if bj.err != nil {
+		return bj.err
+	}
+	if bj.n == 0 {
+		p.esc = start
+		return nil
+	}
+	p.esc = bj.ctx
+	return nil
+}
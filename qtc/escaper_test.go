@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEscContextOutputMethod(t *testing.T) {
+	// html text
+	testEscContextOutputMethod(t, "", "s", "E().S")
+	testEscContextOutputMethod(t, "foo <b>bar</b> ", "s", "E().S")
+	testEscContextOutputMethod(t, "a < b and c > d ", "z", "E().Z")
+	testEscContextOutputMethod(t, "<!-- comment --> ", "v", "E().V")
+	testEscContextOutputMethod(t, "<!DOCTYPE html><p>", "s", "E().S")
+	testEscContextOutputMethod(t, "<title>", "s", "E().S")
+	testEscContextOutputMethod(t, "<textarea>", "sz", "E().SZ")
+	testEscContextOutputMethod(t, "<script>foo()</script>", "s", "E().S")
+	testEscContextOutputMethod(t, "<SCRIPT>foo()</Script >", "s", "E().S")
+
+	// attribute values
+	testEscContextOutputMethod(t, `<div class="`, "s", "E().S")
+	testEscContextOutputMethod(t, `<div class='foo `, "v", "E().V")
+	testEscContextOutputMethod(t, `<div title="a" class="`, "q", "E().Q")
+
+	// url attributes
+	testEscContextOutputMethod(t, `<a href="`, "s", "E().URL")
+	testEscContextOutputMethod(t, `<img src='`, "sz", "E().URLZ")
+	testEscContextOutputMethod(t, `<div data-url="`, "s", "E().URL")
+	testEscContextOutputMethod(t, `<a href="/foo/`, "s", "E().U")
+	testEscContextOutputMethod(t, `<a href="?q=`, "z", "E().UZ")
+	testEscContextOutputMethod(t, `<a href="`, "u", "E().U")
+
+	// js
+	testEscContextOutputMethod(t, `<script>var a = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script type="text/javascript">var a = `, "z", "N().QZ")
+	testEscContextOutputMethod(t, `<script>var a = "`, "s", "N().J")
+	testEscContextOutputMethod(t, `<script>var a = 'foo\'`, "s", "N().J")
+	testEscContextOutputMethod(t, `<script>var a = "foo"; var b = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>var a = 1; // comment
+		var b = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>/* "comment */ var a = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>var a = "</script><script>var b = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>var a = "`, "j", "N().J")
+	testEscContextOutputMethod(t, `<script>var a = `, "q", "N().Q")
+	testEscContextOutputMethod(t, `<script>var a = `, "j", "N().Q")
+	testEscContextOutputMethod(t, `<script>var a = `, "jz", "N().QZ")
+	testEscContextOutputMethod(t, `<script>var a = "`, "q", "N().J")
+	testEscContextOutputMethod(t, `<script>var a = "`, "qz", "N().JZ")
+	testEscContextOutputMethod(t, "<script>var a = `${b}`; var c = ", "s", "N().Q")
+
+	// js regular expressions and divisions
+	testEscContextOutputMethod(t, `<script>var re = /"/; var a = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>var re = /[/"]/g, a = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>if (/'/.test(x)) foo(`, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>return /\/"/; var a = `, "s", "N().Q")
+	testEscContextOutputMethod(t, `<script>var a = x / 2 / y; var b = "`, "s", "N().J")
+	testEscContextOutputMethod(t, `<script>var a = (x) / "`, "s", "N().J")
+	testEscContextOutputMethod(t, `<script>var a = b[0] /`, "s", "N().Q")
+	testEscContextOutputMethod(t, `<button onclick="foo(`, "s", "E().Q")
+	testEscContextOutputMethod(t, `<button onclick="foo('`, "sz", "E().JZ")
+	testEscContextOutputMethod(t, `<script type="text/template"><div>`, "s", "E().S")
+
+	// css
+	testEscContextOutputMethod(t, `<style>.foo { color: `, "s", "N().CSS")
+	testEscContextOutputMethod(t, `<div style="color: `, "z", "E().CSSZ")
+}
+
+func testEscContextOutputMethod(t *testing.T, s, tagName, expectedMethod string) {
+	var c escContext
+	c.feed([]byte(s))
+	filter, method, err := c.outputMethod(tagName)
+	if err != nil {
+		t.Fatalf("unexpected error for %q in %q: %s", tagName, s, err)
+	}
+	result := filter + "." + method
+	if result != expectedMethod {
+		t.Fatalf("unexpected method for %q in %q: %q. Expecting %q", tagName, s, result, expectedMethod)
+	}
+}
+
+func TestEscContextOutputMethodFailure(t *testing.T) {
+	// inside tags
+	testEscContextOutputMethodFailure(t, "<div ", "s")
+	testEscContextOutputMethodFailure(t, "<div", "s")
+	testEscContextOutputMethodFailure(t, "<div cl", "s")
+	testEscContextOutputMethodFailure(t, `<div class="foo" `, "s")
+
+	// unquoted attributes
+	testEscContextOutputMethodFailure(t, "<div class=", "s")
+	testEscContextOutputMethodFailure(t, "<div class=foo", "s")
+
+	// {%v %} in non-html contexts
+	testEscContextOutputMethodFailure(t, "<script>var a = ", "v")
+	testEscContextOutputMethodFailure(t, `<a href="`, "v")
+	testEscContextOutputMethodFailure(t, `<div style="`, "v")
+
+	// js template literals and regular expressions
+	testEscContextOutputMethodFailure(t, "<script>var a = `", "s")
+	testEscContextOutputMethodFailure(t, "<script>var a = `${b}", "j")
+	testEscContextOutputMethodFailure(t, "<script>var a = /", "s")
+	testEscContextOutputMethodFailure(t, "<script>var a = /foo", "s")
+	testEscContextOutputMethodFailure(t, "<script>var a = /[/", "q")
+	testEscContextOutputMethodFailure(t, "<script>return /", "s")
+}
+
+func testEscContextOutputMethodFailure(t *testing.T, s, tagName string) {
+	var c escContext
+	c.feed([]byte(s))
+	if _, _, err := c.outputMethod(tagName); err == nil {
+		t.Fatalf("expecting error for %q in %q", tagName, s)
+	}
+}
+
+func TestParseEscapingSuccess(t *testing.T) {
+	testParseEscapingSuccess(t, `{% func a(s string) %}<script>var a = {%s s %};</script><p>{%s s %}{% endfunc %}`,
+		"qw422016.N().Q(s)", "qw422016.E().S(s)")
+	testParseEscapingSuccess(t, `{% func a(s string) %}<a onclick="foo('{%s s %}')" href="{%s s %}">{% endfunc %}`,
+		"qw422016.E().J(s)", "qw422016.E().URL(s)")
+
+	// unescaped tags are left as is
+	testParseEscapingSuccess(t, `{% func a(s string) %}<script>{%s= s %}</script>{% endfunc %}`, "qw422016.N().S(s)")
+
+	// branches ending in the same context
+	testParseEscapingSuccess(t, `{% func a(s string) %}<div style="{% if s == "" %}color: red{% else %}color: blue{% endif %}">{%s s %}{% endfunc %}`,
+		"qw422016.E().S(s)")
+	testParseEscapingSuccess(t, `{% func a(s []string) %}{% for _, x := range s %}<li>{%s x %}</li>{% endfor %}{%s "" %}{% endfunc %}`,
+		"qw422016.E().S(x)")
+	testParseEscapingSuccess(t, `{% func a(s string) %}{% switch s %}{% case "a" %}<b>{% case "b" %}<i>{% default %}<u>{% endswitch %}{%s s %}{% endfunc %}`,
+		"qw422016.E().S(s)")
+
+	// branches terminated with return are ignored
+	testParseEscapingSuccess(t, `{% func a(s string) %}{% if s == "" %}<script>{% return %}{% endif %}{%s s %}{% endfunc %}`,
+		"qw422016.E().S(s)")
+
+	// url parts are joined
+	testParseEscapingSuccess(t, `{% func a(s string) %}<a href="{% if s == "" %}/foo{% endif %}{%s s %}">{% endfunc %}`,
+		"qw422016.E().U(s)")
+
+	// js values are joined
+	testParseEscapingSuccess(t, `{% func a(s string) %}<script>var x = {% if s == "" %}1{% else %}foo{% endif %} / {%s s %}</script>{% endfunc %}`,
+		"qw422016.N().Q(s)")
+	testParseEscapingSuccess(t, `{% func a(s string) %}<script>var x = {% if s == "" %}{%s s %}{% endif %};{%s s %}</script>{% endfunc %}`,
+		"qw422016.N().Q(s)")
+}
+
+func TestParseNoAutoEscape(t *testing.T) {
+	opts := &parseOptions{noAutoEscape: true}
+	testParseEscapingWithOptions(t, opts, `{% func a(s string) %}if a<b then {%s s %}{% endfunc %}`, "qw422016.E().S(s)")
+	testParseEscapingWithOptions(t, opts, `{% func a(s string) %}<div {%s s %}><script>var a = {%j s %}</script>{% endfunc %}`,
+		"qw422016.E().S(s)", "qw422016.E().J(s)")
+	testParseEscapingWithOptions(t, opts, `{% func a(s string) %}{% if s == "" %}<a href="{% else %}<b>{% endif %}{%s s %}{% endfunc %}`,
+		"qw422016.E().S(s)")
+
+	// the code generated before context-aware escaping is kept
+	testParseEscapingWithOptions(t, opts, `{% func a(s string) %}<script>var a = "{%s s %}"; var b = {%s= s %};</script>{% endfunc %}`,
+		"qw422016.E().S(s)", "qw422016.N().S(s)")
+	testParseEscapingWithOptions(t, opts, `{% func a(s string) %}<style>a { color: {%s s %} }</style><a href="{%s s %}" style="{%s s %}">{% endfunc %}`,
+		"qw422016.E().S(s)")
+	testParseEscapingWithOptions(t, opts, `{% func a(s string) %}<a onclick="run('{%s s %}')">{%v s %}</a>{% endfunc %}`,
+		"qw422016.E().S(s)", "qw422016.E().V(s)")
+	testParseNoEscapers(t, opts, `{% func a(s string) %}<script>{%s s %}</script><style>{%s s %}</style><a href="{%s s %}">{% endfunc %}`)
+}
+
+func testParseNoEscapers(t *testing.T, opts *parseOptions, str string) {
+	t.Helper()
+	r := bytes.NewBufferString(str)
+	w := &bytes.Buffer{}
+	if err := parseWithOptions(w, r, "./foobar.tpl", "memory", opts); err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", str, err)
+	}
+	code := string(removeLineDirectives(w.Bytes()))
+	for _, call := range []string{".Q(", ".U(", ".C(", ".CSS(", ".URL("} {
+		if strings.Contains(code, call) {
+			t.Fatalf("unexpected %q in the code generated for %q:\n%s", call, str, code)
+		}
+	}
+}
+
+func testParseEscapingSuccess(t *testing.T, str string, expectedCalls ...string) {
+	t.Helper()
+	testParseEscapingWithOptions(t, &parseOptions{}, str, expectedCalls...)
+}
+
+func testParseEscapingWithOptions(t *testing.T, opts *parseOptions, str string, expectedCalls ...string) {
+	t.Helper()
+	r := bytes.NewBufferString(str)
+	w := &bytes.Buffer{}
+	if err := parseWithOptions(w, r, "./foobar.tpl", "memory", opts); err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", str, err)
+	}
+	code := string(removeLineDirectives(w.Bytes()))
+	for _, call := range expectedCalls {
+		if !strings.Contains(code, call) {
+			t.Fatalf("cannot find %q in the code generated for %q:\n%s", call, str, code)
+		}
+	}
+}
+
+func TestParseEscapingFailure(t *testing.T) {
+	// output inside tag
+	testParseFailure(t, `{% func a(s string) %}<div {%s s %}>{% endfunc %}`)
+
+	// unquoted attribute value
+	testParseFailure(t, `{% func a(s string) %}<div class={%s s %}>{% endfunc %}`)
+
+	// ambiguous context after if
+	testParseFailure(t, `{% func a(s string) %}{% if s == "" %}<script>{% endif %}{%s s %}{% endfunc %}`)
+
+	// '/' after branches ending in js value and operator may start regular expression
+	testParseFailure(t, `{% func a(s string) %}<script>var x = {% if s == "" %}1{% endif %} /{%s s %}/</script>{% endfunc %}`)
+	testParseFailure(t, `{% func a(s string) %}{% if s == "" %}<a href="{% else %}<b>{% endif %}{% endfunc %}`)
+
+	// ambiguous context after for
+	testParseFailure(t, `{% func a(s []string) %}{% for range s %}<div title="{% endfor %}{% endfunc %}`)
+
+	// ambiguous context after switch without default
+	testParseFailure(t, `{% func a(s string) %}{% switch s %}{% case "a" %}<style>{% endswitch %}{% endfunc %}`)
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fmtToken is the token of template source used by formatTemplate.
+//
+// Unlike scanner tokens, fmtToken preserves the original text,
+// so whitespace control tags are kept as is.
+type fmtToken struct {
+	// text contains the text for text tokens and the raw text
+	// between start and end tags for comment and plain tags.
+	text []byte
+
+	// isTag is set for tags.
+	isTag bool
+
+	name     string
+	contents string
+
+	// endContents contains the contents of the end tag for comment
+	// and plain tags.
+	endContents string
+}
+
+// scanFmtTokens splits the given template source into tokens.
+//
+// The source must be successfully parsed before calling scanFmtTokens.
+func scanFmtTokens(src []byte) ([]*fmtToken, error) {
+	var tokens []*fmtToken
+	for len(src) > 0 {
+		n := bytes.Index(src, strTagOpen)
+		if n < 0 {
+			tokens = append(tokens, &fmtToken{
+				text: src,
+			})
+			break
+		}
+		if n > 0 {
+			tokens = append(tokens, &fmtToken{
+				text: src[:n],
+			})
+		}
+		name, contents, tail, err := readFmtTag(src[n:])
+		if err != nil {
+			return nil, err
+		}
+		t := &fmtToken{
+			isTag:    true,
+			name:     name,
+			contents: contents,
+		}
+		src = tail
+		if name == "comment" || name == "plain" {
+			endName := "end" + name
+			m := indexFmtTag(src, endName)
+			if m < 0 {
+				return nil, fmt.Errorf("cannot find %s tag", endName)
+			}
+			t.text = src[:m]
+			_, t.endContents, src, err = readFmtTag(src[m:])
+			if err != nil {
+				return nil, err
+			}
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+// readFmtTag reads the tag at the start of src.
+//
+// It returns the tag name, the tag contents and the source following the tag.
+func readFmtTag(src []byte) (string, string, []byte, error) {
+	src = src[len(strTagOpen):]
+	src = bytes.TrimLeftFunc(src, isSpaceRune)
+	n := 0
+	for n < len(src) && isTagNameByte(src[n]) {
+		n++
+	}
+	name := string(src[:n])
+	src = src[n:]
+	m := bytes.Index(src, strTagClose)
+	if m < 0 {
+		return "", "", nil, fmt.Errorf("cannot find the end of %q tag", name)
+	}
+	contents := string(bytes.TrimFunc(src[:m], isSpaceRune))
+	return name, contents, src[m+len(strTagClose):], nil
+}
+
+// indexFmtTag returns the index of the tag with the given name in src
+// or -1 if the tag isn't found.
+func indexFmtTag(src []byte, tagName string) int {
+	offset := 0
+	for {
+		n := bytes.Index(src[offset:], strTagOpen)
+		if n < 0 {
+			return -1
+		}
+		n += offset
+		offset = n + len(strTagOpen)
+		s := bytes.TrimLeftFunc(src[offset:], isSpaceRune)
+		if !bytes.HasPrefix(s, []byte(tagName)) {
+			continue
+		}
+		s = s[len(tagName):]
+		if len(s) > 0 && !isTagNameByte(s[0]) {
+			return n
+		}
+	}
+}
+
+var strTagClose = []byte("%}")
+
+func isTagNameByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '=' || c == '.'
+}
+
+func isSpaceRune(r rune) bool {
+	return r < 0x80 && isSpace(byte(r))
+}
+
+// formatTemplate returns the canonical form of the given template source.
+//
+// The canonical form generates the same code as the original template,
+// so the template output remains the same:
+//
+//   - Tags are written as {%s x %} for output tags and as {% if x %}
+//     for other tags.
+//   - Go code in code, import, func and control flow tags
+//     and in output tags is formatted with go/format.
+//   - Lines inside stripspace and collapsespace tags are re-indented
+//     according to the nesting of template blocks, since leading
+//     whitespace is insignificant there. Whitespace outside these tags
+//     is the part of template output, so it remains untouched.
+func formatTemplate(src []byte, filePath string) ([]byte, error) {
+	expectedCode, err := fmtGeneratedCode(src, filePath)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanFmtTokens(src)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %s", filePath, err)
+	}
+	f := &formatter{}
+	for i, t := range tokens {
+		if t.isTag {
+			f.writeTag(t)
+			continue
+		}
+		nextTag := ""
+		if i+1 < len(tokens) && tokens[i+1].isTag {
+			nextTag = tokens[i+1].name
+		}
+		f.writeText(t.text, nextTag)
+	}
+	result := f.buf.Bytes()
+
+	code, err := fmtGeneratedCode(result, filePath)
+	if err != nil || !bytes.Equal(code, expectedCode) {
+		return nil, fmt.Errorf("BUG: formatting changes the code generated for %q. Please report this issue", filePath)
+	}
+	return result, nil
+}
+
+// fmtGeneratedCode returns the code generated for the given template source
+// without //line comments, so it may be compared for templates
+// with distinct layout.
+func fmtGeneratedCode(src []byte, filePath string) ([]byte, error) {
+	var w bytes.Buffer
+	if err := parse(&w, bytes.NewReader(src), filePath, "templates"); err != nil {
+		return nil, err
+	}
+	result, err := format.Source(removeLineDirectives(w.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("cannot format the code generated for %q: %s", filePath, err)
+	}
+	return result, nil
+}
+
+// formatter writes the canonical form of template tokens to buf.
+type formatter struct {
+	buf bytes.Buffer
+
+	// blocks contains the currently open block tags.
+	blocks []fmtBlock
+
+	stripSpaceDepth    int
+	collapseSpaceDepth int
+
+	// lineIndent and lineNewIndent contain the original and the canonical
+	// indentation levels of the current line.
+	lineIndent    int
+	lineNewIndent int
+}
+
+// fmtBlock is the block tag such as if or for.
+type fmtBlock struct {
+	name string
+
+	// indent and newIndent contain the original and the canonical
+	// indentation levels of the line with the block tag.
+	indent    int
+	newIndent int
+}
+
+// bodyIndent returns the indentation level of block body lines relative
+// to the line with the block tag.
+//
+// The bodies of funcs and whitespace control tags aren't indented
+// by convention.
+func (b *fmtBlock) bodyIndent() int {
+	switch b.name {
+	case "func", "stripspace", "collapsespace":
+		return 0
+	default:
+		return 1
+	}
+}
+
+// fmtBlockEnds maps block tags to the corresponding end tags.
+var fmtBlockEnds = map[string]string{
+	"func":          "endfunc",
+	"if":            "endif",
+	"for":           "endfor",
+	"switch":        "endswitch",
+	"block":         "endblock",
+	"call":          "endcall",
+	"slot":          "endslot",
+	"capture":       "endcapture",
+	"push":          "endpush",
+	"fragment":      "endfragment",
+	"stripspace":    "endstripspace",
+	"collapsespace": "endcollapsespace",
+}
+
+// isFmtAlignedTag returns true if the tag with the given name
+// must be aligned with the line containing the current block tag.
+func isFmtAlignedTag(tagName string) bool {
+	switch tagName {
+	case "else", "elseif", "case", "default":
+		return true
+	}
+	return strings.HasPrefix(tagName, "end")
+}
+
+// canIndent returns true if the indentation of the current text
+// is insignificant for template output.
+func (f *formatter) canIndent() bool {
+	if f.stripSpaceDepth == 0 && f.collapseSpaceDepth == 0 {
+		return false
+	}
+	for _, b := range f.blocks {
+		if b.name == "func" || b.name == "block" {
+			return true
+		}
+	}
+	// Text outside funcs is emitted into Go comments.
+	return false
+}
+
+func (f *formatter) writeText(text []byte, nextTag string) {
+	if !f.canIndent() {
+		f.buf.Write(text)
+		for {
+			n := bytes.IndexByte(text, '\n')
+			if n < 0 {
+				return
+			}
+			text = text[n+1:]
+			f.lineIndent = indentLevel(text)
+			f.lineNewIndent = f.lineIndent
+		}
+	}
+
+	lines := bytes.Split(text, []byte("\n"))
+	for i, line := range lines {
+		isLast := i == len(lines)-1
+		if !isLast {
+			// Trailing whitespace is insignificant before newlines.
+			line = bytes.TrimRightFunc(line, isSpaceRune)
+		}
+		if i == 0 {
+			f.buf.Write(line)
+			continue
+		}
+		f.buf.WriteByte('\n')
+		rest := bytes.TrimLeftFunc(line, isSpaceRune)
+		f.lineIndent = indentLevel(line)
+		f.lineNewIndent = f.newIndent(isLast && len(rest) == 0, nextTag)
+		if len(rest) == 0 && !isLast {
+			// Skip indentation of empty lines.
+			continue
+		}
+		f.buf.WriteString(strings.Repeat("\t", f.lineNewIndent))
+		f.buf.Write(rest)
+	}
+}
+
+// newIndent returns the canonical indentation level for the current line.
+//
+// startsWithTag must be set if the line starts with the tag
+// with the given name.
+func (f *formatter) newIndent(startsWithTag bool, tagName string) int {
+	if len(f.blocks) == 0 {
+		return f.lineIndent
+	}
+	b := &f.blocks[len(f.blocks)-1]
+	if startsWithTag && isFmtAlignedTag(tagName) {
+		return b.newIndent
+	}
+	delta := f.lineIndent - (b.indent + b.bodyIndent())
+	if delta < 0 {
+		delta = 0
+	}
+	return b.newIndent + b.bodyIndent() + delta
+}
+
+// indentLevel returns the indentation level of the given line.
+//
+// Tabs and groups of 4 spaces are counted as a single level.
+func indentLevel(line []byte) int {
+	tabs := 0
+	spaces := 0
+	for _, c := range line {
+		switch c {
+		case '\t':
+			tabs++
+		case ' ':
+			spaces++
+		default:
+			return tabs + spaces/4
+		}
+	}
+	return tabs + spaces/4
+}
+
+func (f *formatter) writeTag(t *fmtToken) {
+	switch t.name {
+	case "stripspace":
+		f.stripSpaceDepth++
+	case "endstripspace":
+		f.stripSpaceDepth--
+	case "collapsespace":
+		f.collapseSpaceDepth++
+	case "endcollapsespace":
+		f.collapseSpaceDepth--
+	}
+	if _, ok := fmtBlockEnds[t.name]; ok {
+		f.blocks = append(f.blocks, fmtBlock{
+			name:      t.name,
+			indent:    f.lineIndent,
+			newIndent: f.lineNewIndent,
+		})
+	} else if n := len(f.blocks); n > 0 && fmtBlockEnds[f.blocks[n-1].name] == t.name {
+		f.blocks = f.blocks[:n-1]
+	}
+
+	inFunc := false
+	for _, b := range f.blocks {
+		if b.name == "func" || b.name == "block" {
+			inFunc = true
+		}
+	}
+	contents, ok := f.formatContents(t.name, t.contents, inFunc)
+	if ok && (t.name == "code" || t.name == "import") && strings.Contains(contents, "\n") {
+		f.writeCodeTag(t.name, contents, inFunc)
+	} else {
+		f.buf.WriteString(formatTag(t.name, contents))
+	}
+	if t.name == "comment" || t.name == "plain" {
+		f.buf.Write(t.text)
+		f.buf.WriteString(formatTag("end"+t.name, t.endContents))
+	}
+}
+
+// formatTag returns the canonical form of the tag with the given name
+// and contents.
+func formatTag(tagName, contents string) string {
+	open := "{% "
+	if isOutputTagName(tagName) {
+		open = "{%"
+	}
+	if len(contents) == 0 {
+		return open + tagName + " %}"
+	}
+	return open + tagName + " " + contents + " %}"
+}
+
+// writeCodeTag writes the tag with multi-line Go code.
+//
+// import tags are written as {% import (...) %}, while the code in code tags
+// is placed on separate lines. Code lines are indented relative
+// to the line with the tag inside funcs.
+func (f *formatter) writeCodeTag(tagName, code string, inFunc bool) {
+	indent := strings.Repeat("\t", f.lineNewIndent)
+	codeIndent := indent
+	if inFunc {
+		codeIndent += "\t"
+	}
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		if len(line) > 0 && (tagName == "code" || i > 0) {
+			lines[i] = codeIndent + line
+		}
+	}
+	code = strings.Join(lines, "\n")
+	if tagName == "import" {
+		f.buf.WriteString("{% import " + code[len(codeIndent):] + " %}")
+		return
+	}
+	f.buf.WriteString("{% code\n" + code + "\n" + indent + "%}")
+}
+
+// isOutputTagName returns true if the tag with the given name outputs
+// the value of Go expression such as {%s x %} or {%= Foo() %}.
+func isOutputTagName(tagName string) bool {
+	tagNameStr, _ := splitTagNamePrec(tagName)
+	return tagNameStr == "=" || (isOutputTag(tagNameStr) && tagNameStr != "call" && tagNameStr != "push" && tagNameStr != "stack")
+}
+
+// formatContents returns the contents of the given tag with formatted Go code.
+//
+// The original contents is returned together with false
+// if the code cannot be formatted.
+func (f *formatter) formatContents(tagName, contents string, inFunc bool) (string, bool) {
+	if len(contents) == 0 {
+		return contents, false
+	}
+	var code string
+	var ok bool
+	switch tagName {
+	case "code":
+		if inFunc {
+			code, ok = formatGoStmts(contents)
+		} else {
+			code, ok = formatGoDecls(contents)
+		}
+	case "import":
+		code, ok = formatGoDecls("import " + contents)
+		code, ok = trimFmtPrefix(code, ok, "import ")
+	case "func":
+		code, ok = formatGoDecls("func " + contents + " {}")
+		code, ok = trimFmtPrefix(code, ok, "func ")
+		code, ok = trimFmtSuffix(code, ok, " {}")
+	case "if", "elseif", "for", "switch":
+		stmt := tagName
+		if stmt == "elseif" {
+			stmt = "if"
+		}
+		code, ok = formatGoStmts(stmt + " " + contents + " {\n}")
+		code, ok = trimFmtPrefix(code, ok, stmt+" ")
+		code, ok = trimFmtSuffix(code, ok, " {\n}")
+	case "case":
+		code, ok = formatGoStmts("switch {\ncase " + contents + ":\n}")
+		code, ok = trimFmtPrefix(code, ok, "switch {\ncase ")
+		code, ok = trimFmtSuffix(code, ok, ":\n}")
+	case "return":
+		code, ok = formatGoStmts("return " + contents)
+		code, ok = trimFmtPrefix(code, ok, "return ")
+	default:
+		if !isOutputTagName(tagName) {
+			return contents, false
+		}
+		code, ok = formatGoStmts("_ = " + contents)
+		code, ok = trimFmtPrefix(code, ok, "_ = ")
+	}
+	if !ok {
+		return contents, false
+	}
+	if tagName != "code" && tagName != "import" && strings.Contains(code, "\n") {
+		// Multi-line expressions are kept as is.
+		return contents, false
+	}
+	return code, true
+}
+
+func trimFmtPrefix(code string, ok bool, prefix string) (string, bool) {
+	if !ok || !strings.HasPrefix(code, prefix) {
+		return "", false
+	}
+	return code[len(prefix):], true
+}
+
+func trimFmtSuffix(code string, ok bool, suffix string) (string, bool) {
+	if !ok || !strings.HasSuffix(code, suffix) {
+		return "", false
+	}
+	return code[:len(code)-len(suffix)], true
+}
+
+// formatGoDecls formats the given Go declarations.
+func formatGoDecls(code string) (string, bool) {
+	const header = "package p\n\n"
+	result, err := format.Source([]byte(header + code + "\n"))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(result), header)), true
+}
+
+// formatGoStmts formats the given Go statements.
+//
+// The statements are formatted inside for loop, since break and continue
+// are allowed in code tags inside loops.
+func formatGoStmts(code string) (string, bool) {
+	const header = "package p\n\nfunc _() {\n\tfor {\n"
+	const footer = "\n\t}\n}\n"
+	result, err := format.Source([]byte(header + code + footer))
+	if err != nil {
+		return "", false
+	}
+	s := string(result)
+	if !strings.HasPrefix(s, header) || !strings.HasSuffix(s, footer) {
+		return "", false
+	}
+	s = s[len(header) : len(s)-len(footer)]
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, "\t\t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), true
+}
+
+// formatFile formats the given template file.
+//
+// It returns the original and the formatted contents of the file.
+func formatFile(filename string) ([]byte, []byte, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read file %q: %s", filename, err)
+	}
+	result, err := formatTemplate(src, filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, result, nil
+}
+
+// runFmt runs qtc fmt command with the given args.
+//
+// Template files are formatted in place if -w flag is set. Otherwise
+// the formatted files are written to stdout. Template source is read
+// from stdin if no paths are given.
+//
+// runFmt returns false if errors occurred.
+func runFmt(args []string) bool {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	list := fs.Bool("l", false, "List template files whose formatting differs from qtc fmt's")
+	write := fs.Bool("w", false, "Write the result to the source file instead of stdout")
+	diff := fs.Bool("d", false, "Display diffs instead of rewriting files")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: qtc fmt [flags] [path ...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		if *write {
+			logger.Printf("cannot use -w with standard input")
+			return false
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			logger.Printf("cannot read standard input: %s", err)
+			return false
+		}
+		result, err := formatTemplate(src, "<standard input>")
+		if err != nil {
+			reportErrors([]error{err})
+			return false
+		}
+		return reportFmtResult("<standard input>", src, result, *list, false, *diff)
+	}
+
+	ok := true
+	for _, path := range fs.Args() {
+		filenames, err := fmtFilenames(path)
+		if err != nil {
+			logger.Printf("%s", err)
+			ok = false
+			continue
+		}
+		for _, filename := range filenames {
+			src, result, err := formatFile(filename)
+			if err != nil {
+				if pe, isParseErrs := err.(parseErrors); isParseErrs {
+					for _, e := range pe {
+						reportErrors([]error{e})
+					}
+				} else {
+					reportErrors([]error{&fileError{filename: filename, err: err}})
+				}
+				ok = false
+				continue
+			}
+			if !reportFmtResult(filename, src, result, *list, *write, *diff) {
+				ok = false
+			}
+		}
+	}
+	return ok
+}
+
+// fmtFilenames returns template files at the given path.
+//
+// Directories are walked recursively for files with -ext extension
+// or with extensions set in qtc.toml files.
+func fmtFilenames(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot format %q: %s", path, err)
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	var filenames []string
+	err = filepath.Walk(path, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && hasTemplateExt(filename, getConfigOrFlags(filepath.Dir(filename)).exts) {
+			filenames = append(filenames, filename)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read files in %q: %s", path, err)
+	}
+	return filenames, nil
+}
+
+// reportFmtResult lists, writes or displays the formatted template file
+// depending on the given flags.
+func reportFmtResult(filename string, src, result []byte, list, write, diff bool) bool {
+	if !list && !write && !diff {
+		os.Stdout.Write(result)
+		return true
+	}
+	if bytes.Equal(src, result) {
+		return true
+	}
+	if list {
+		fmt.Fprintln(os.Stdout, filename)
+	}
+	if write {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			logger.Printf("cannot stat file %q: %s", filename, err)
+			return false
+		}
+		if err := ioutil.WriteFile(filename, result, fi.Mode().Perm()); err != nil {
+			logger.Printf("cannot write file %q: %s", filename, err)
+			return false
+		}
+	}
+	if diff {
+		os.Stdout.Write(unifiedDiff(filename, src, result))
+	}
+	return true
+}
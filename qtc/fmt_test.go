@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFormatTemplateTags(t *testing.T) {
+	// tag spacing
+	testFormatTemplate(t, "{%func   A()%}{%s x%}{%=  B( )   %}{%f.2= 1.5%}{%if x>1%}a{%else%}b{%endif%}{%endfunc%}",
+		"{% func A() %}{%s x %}{%= B() %}{%f.2= 1.5 %}{% if x > 1 %}a{% else %}b{% endif %}{% endfunc %}")
+
+	// go code
+	testFormatTemplate(t, "{% import \"fmt\" %}\n{% code type A struct {X int;Y string} %}\n{% func (a *A)  B(n int) %}{% code x:=n*2 %}{% for i:=0;i<x;i++ %}{%d i+1 %}{% switch i %}{% case 1,2 %}c{% endswitch %}{% endfor %}{% endfunc %}",
+		"{% import \"fmt\" %}\n{% code\ntype A struct {\n\tX int\n\tY string\n}\n%}\n{% func (a *A) B(n int) %}{% code x := n * 2 %}{% for i := 0; i < x; i++ %}{%d i + 1 %}{% switch i %}{% case 1, 2 %}c{% endswitch %}{% endfor %}{% endfunc %}")
+
+	// multi-line imports
+	testFormatTemplate(t, "{% import (\n\"fmt\"\n  \"strings\"\n) %}\n{% func A() %}{%s fmt.Sprint(strings.ToLower(\"A\")) %}{% endfunc %}",
+		"{% import (\n\t\"fmt\"\n\t\"strings\"\n) %}\n{% func A() %}{%s fmt.Sprint(strings.ToLower(\"A\")) %}{% endfunc %}")
+
+	// multi-line code inside funcs is indented relative to the tag line
+	testFormatTemplate(t, "{% func A() %}\n\t{% code\nx := 1\nif x > 0 { x++ }\n%}{%d x %}{% endfunc %}",
+		"{% func A() %}\n\t{% code\n\t\tx := 1\n\t\tif x > 0 {\n\t\t\tx++\n\t\t}\n\t%}{%d x %}{% endfunc %}")
+
+	// multi-line expressions are kept as is
+	testFormatTemplate(t, "{% func A() %}{%q `a\n  b` %}{% endfunc %}", "{% func A() %}{%q `a\n  b` %}{% endfunc %}")
+
+	// comment and plain contents are kept as is
+	testFormatTemplate(t, "{% func A() %}{%comment%} {%foo %} {%endcomment  %}{%plain x%}{%s  y%}{%endplain%}{% endfunc %}",
+		"{% func A() %}{% comment %} {%foo %} {% endcomment %}{% plain x %}{%s  y%}{% endplain %}{% endfunc %}")
+}
+
+func TestFormatTemplateWhitespace(t *testing.T) {
+	// whitespace outside stripspace and collapsespace is the part of output
+	src := "Comment  \n   indented\n{% func A() %}\n  {% if true %}  \n      foo  \n {% endif %}\n{% endfunc %}"
+	testFormatTemplate(t, src, src)
+
+	// whitespace inside stripspace and collapsespace is re-indented
+	testFormatTemplate(t, "{% func A(xs []string) %}\n{% stripspace %}\n<ul>  \n\t\t{% for _, x := range xs %}\n\t\t\t\t<li>\n\t\t\t\t{% if x != \"\" %}\n\t\t\t\t\t<b>{%s x %}</b>\n\t\t\t{% endif %}\n\n\t\t</li>\n\t\t\t{% endfor %}\n</ul>\n{% endstripspace %}\n{% endfunc %}",
+		"{% func A(xs []string) %}\n{% stripspace %}\n<ul>\n\t\t{% for _, x := range xs %}\n\t\t\t\t<li>\n\t\t\t\t{% if x != \"\" %}\n\t\t\t\t\t<b>{%s x %}</b>\n\t\t\t\t{% endif %}\n\n\t\t\t</li>\n\t\t{% endfor %}\n</ul>\n{% endstripspace %}\n{% endfunc %}")
+	testFormatTemplate(t, "{% func A(n int) %}{% collapsespace %}\n  {% switch n %}\n {% case 1 %}\n   one\n    {% default %}\nother {% endswitch %}\n{% endcollapsespace %}{% endfunc %}",
+		"{% func A(n int) %}{% collapsespace %}\n{% switch n %}\n{% case 1 %}\n\tone\n{% default %}\n\tother {% endswitch %}\n{% endcollapsespace %}{% endfunc %}")
+}
+
+func TestFormatTemplateFailure(t *testing.T) {
+	if _, err := formatTemplate([]byte("{% func A() %}{% edfor %}{% endfunc %}"), "foo.qtpl"); err == nil {
+		t.Fatalf("expecting error for broken template")
+	}
+}
+
+func testFormatTemplate(t *testing.T, src, expected string) {
+	t.Helper()
+	result, err := formatTemplate([]byte(src), "foo.qtpl")
+	if err != nil {
+		t.Fatalf("unexpected error when formatting %q: %s", src, err)
+	}
+	if string(result) != expected {
+		t.Fatalf("unexpected result when formatting\n%s\n\nResult\n%s\n\nExpecting\n%s", src, result, expected)
+	}
+
+	// formatting is idempotent
+	result2, err := formatTemplate(result, "foo.qtpl")
+	if err != nil {
+		t.Fatalf("unexpected error when formatting %q: %s", result, err)
+	}
+	if string(result2) != string(result) {
+		t.Fatalf("formatting isn't idempotent for\n%s\n\nResult\n%s", result, result2)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// fragmentArg is the name of the fragment func arg containing
+// the name of the rendered fragment.
+const fragmentArg = "qfragment" + mangleSuffix
+
+// fragmentFunc returns the func rendering the fragments of f.
+func fragmentFunc(f *funcType) *funcType {
+	ff := *f
+	ff.name = f.name + "Fragment"
+	ff.args = fmt.Sprintf(", %s string%s", fragmentArg, f.args)
+	ff.argNames = ", " + fragmentArg + f.argNames
+	return &ff
+}
+
+// parseFragment parses fragment tag.
+//
+// The fragment is rendered inline by the func containing it. Additionally
+// the fragment func generated by emitFragmentFunc renders only the fragment
+// with the given name.
+func (p *parser) parseFragment() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	fragmentStr := "fragment " + string(t.Value)
+	name, err := strconv.Unquote(string(t.Value))
+	if err != nil || len(name) == 0 {
+		return errorf("invalid %q at %s: fragment name must be non-empty string literal", fragmentStr, s.Context())
+	}
+	if p.layoutFunc == nil || len(p.bufferTag) > 0 {
+		return errorf("fragments may be used only in func body outside block, call, capture and push tags. Found %q at %s",
+			fragmentStr, s.Context())
+	}
+	if p.inFragment {
+		return errorf("nested fragments are not allowed. Found %q at %s", fragmentStr, s.Context())
+	}
+
+	if !p.fragmentPass {
+		for _, n := range p.fragments {
+			if n == name {
+				return errorf("duplicate %q at %s", fragmentStr, s.Context())
+			}
+		}
+		p.fragments = append(p.fragments, name)
+		p.inFragment = true
+		if err = p.parseFuncBody(fragmentStr, "endfragment"); err != nil {
+			return err
+		}
+		p.inFragment = false
+		return nil
+	}
+
+	p.Printf("if %s == %q {", fragmentArg, name)
+	p.prefix += "\t"
+	p.inFragment = true
+	p.skipFragmentOutput = false
+	if err = p.parseFuncBody(fragmentStr, "endfragment"); err != nil {
+		return err
+	}
+	p.inFragment = false
+	p.skipFragmentOutput = true
+	if !p.escDead && p.forDepth == 0 {
+		// The rest of the func cannot render the fragment.
+		if p.funcWithErrors {
+			p.Printf("return qw%s.Err()", mangleSuffix)
+		} else {
+			p.Printf("return")
+		}
+	}
+	p.prefix = p.prefix[1:]
+	p.Printf("}")
+	return nil
+}
+
+// parseSkippedOutputTag parses output tag outside the rendered fragment.
+//
+// The tag is emitted into dead branch, so the variables used only
+// by the skipped output remain used.
+func (p *parser) parseSkippedOutputTag(tagBytes []byte) (bool, error) {
+	p.Printf("if false {")
+	p.prefix += "\t"
+	p.skipFragmentOutput = false
+	ok, err := p.tryParseCommonTags(tagBytes)
+	if err != nil {
+		return false, err
+	}
+	p.skipFragmentOutput = true
+	p.prefix = p.prefix[1:]
+	p.Printf("}")
+	return ok, nil
+}
+
+// emitFragmentFunc emits the func rendering the fragments of f.
+//
+// The func body is parsed again from the given tokens recorded
+// when parsing f. The code outside fragments is executed as is
+// with all its side effects, while its output is skipped. Only the args
+// of the skipped output tags aren't evaluated. See parseSkippedOutputTag.
+func (p *parser) emitFragmentFunc(f *funcType, funcStr string, tokens []token, lineComment []byte) error {
+	ff := fragmentFunc(f)
+	state := *p
+	var code bytes.Buffer
+	p.s = newReplayScanner(tokens, state.s.filePath)
+	p.w = &code
+	p.layoutFunc = ff
+	p.fragmentPass = true
+	p.skipFragmentOutput = true
+	p.emitFuncStart(ff)
+	if err := p.parseFuncBody(funcStr, "endfunc"); err != nil {
+		return err
+	}
+	funcTerminated := p.funcTerminated
+	*p = state
+	p.funcTerminated = funcTerminated
+	fmt.Fprintf(p.w, "%sfunc %s {\n", lineComment, ff.DefStream("qw"+mangleSuffix))
+	if _, err := p.w.Write(code.Bytes()); err != nil {
+		return err
+	}
+	p.emitFuncEnd(ff)
+	return nil
+}
+
+// isOutputTag returns true if the tag with the given name emits output.
+func isOutputTag(tagNameStr string) bool {
+	switch tagNameStr {
+	case "s", "v", "d", "f", "q", "z", "j", "u",
+		"s=", "v=", "d=", "f=", "q=", "z=", "j=", "u=",
+		"sz", "qz", "jz", "uz",
+		"sz=", "qz=", "jz=", "uz=",
+		"=", "call", "push", "stack":
+		return true
+	default:
+		return false
+	}
+}
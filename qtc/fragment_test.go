@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFragment(t *testing.T) {
+	opts := &parseOptions{}
+	testParseWithOptions(t, opts, `{% func Table(rows []string) %}<h1>{%d len(rows) %}</h1><table>{% fragment "rows" %}{% for _, r := range rows %}<td>{%s r %}</td>{% endfor %}{% endfragment %}</table>{% endfunc %}`,
+		// the func renders the fragment inline
+		"func StreamTable(qw422016 *qt422016.Writer, rows []string) {\n\tqw422016.N().S(`<h1>`)\n\tqw422016.N().D(len(rows))\n\tqw422016.N().S(`</h1><table>`)\n\tfor _, r := range rows {",
+
+		// the fragment func skips the output outside the fragment
+		"func StreamTableFragment(qw422016 *qt422016.Writer, qfragment422016 string, rows []string) {\n\tif false {\n\t\tqw422016.N().D(len(rows))\n\t}\n\tif qfragment422016 == \"rows\" {\n\t\tfor _, r := range rows {\n\t\t\tqw422016.N().S(`<td>`)\n\t\t\tqw422016.E().S(r)\n",
+		"\t\t\tqw422016.N().S(`</td>`)\n\t\t}\n\t\treturn\n\t}\n}",
+		"func WriteTableFragment(qq422016 qtio422016.Writer, qfragment422016 string, rows []string) {",
+		"func TableFragment(qfragment422016 string, rows []string) string {")
+
+	// fragments inside loops are rendered on every iteration
+	testParseWithOptions(t, opts, `{% func (p *Page) Body() %}{% for _, r := range p.Rows %}{% fragment "row" %}{%s r %}{% endfragment %}{% endfor %}{% endfunc %}`,
+		"func (p *Page) StreamBodyFragment(qw422016 *qt422016.Writer, qfragment422016 string) {\n\tfor _, r := range p.Rows {\n\t\tif qfragment422016 == \"row\" {\n\t\t\tqw422016.E().S(r)\n\t\t}\n\t}\n}")
+
+	// the code outside fragments is executed
+	testParseWithOptions(t, opts, `{% func A() %}{% code n := 1 %}{% capture s %}{%d n %}{% endcapture %}{% fragment "a" %}{%s s %}{% endfragment %}{% fragment "b" %}{% return %}{% endfragment %}{%= B() %}{% endfunc %}`,
+		"if qfragment422016 == \"a\" {\n\t\tqw422016.E().S(s)\n\t\treturn\n\t}\n\tif qfragment422016 == \"b\" {\n\t\treturn\n\t}\n\tif false {\n\t\tStreamB(qw422016)\n\t}")
+
+	// fragment funcs return errors
+	testParseWithOptions(t, &parseOptions{withErrors: true}, `{% func A() %}{% fragment "a" %}a{% endfragment %}{% endfunc %}`,
+		"func StreamAFragment(qw422016 *qt422016.Writer, qfragment422016 string) error {\n\tif qfragment422016 == \"a\" {\n\t\tqw422016.N().S(`a`)\n\t\treturn qw422016.Err()\n\t}\n\treturn qw422016.Err()\n}")
+}
+
+func TestParseFragmentFailure(t *testing.T) {
+	// invalid fragment name
+	testParseFailure(t, `{% func A() %}{% fragment %}{% endfragment %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% fragment "" %}{% endfragment %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% fragment rows %}{% endfragment %}{% endfunc %}`)
+
+	// missing endfragment
+	testParseFailure(t, `{% func A() %}{% fragment "a" %}{% endfunc %}`)
+
+	// nested and duplicate fragments
+	testParseFailure(t, `{% func A() %}{% fragment "a" %}{% fragment "b" %}{% endfragment %}{% endfragment %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% fragment "a" %}{% endfragment %}{% fragment "a" %}{% endfragment %}{% endfunc %}`)
+
+	// fragments inside buffering tags, slots and blocks
+	testParseFailure(t, `{% func A() %}{% capture s %}{% fragment "a" %}{% endfragment %}{% endcapture %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% push "s" %}{% fragment "a" %}{% endfragment %}{% endpush %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% call B() %}{% fragment "a" %}{% endfragment %}{% endcall %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% block b %}{% fragment "a" %}{% endfragment %}{% endblock %}{% endfunc %}`)
+
+	// fragments in layouts
+	testParseFailure(t, `{% func A() %}{% block b %}{% endblock %}{% fragment "a" %}{% endfragment %}{% endfunc %}`)
+
+	// fragment outside func
+	testParseFailure(t, `{% fragment "a" %}{% endfragment %}`)
+}
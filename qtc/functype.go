@@ -4,89 +4,143 @@ import (
 	"fmt"
 	"go/ast"
 	goparser "go/parser"
+	gotoken "go/token"
 	"strings"
 )
 
 type funcType struct {
-	name       string
+	name string
+
+	// typeParams is the type parameter list of generic func definition
+	// such as "[T any, S ~[]T]".
+	typeParams string
+
+	// typeArgs is the type argument list for generic func calls
+	// such as "[T, S]".
+	typeArgs string
+
 	defPrefix  string
 	callPrefix string
 	argNames   string
 	args       string
+
+	// recvType is the receiver type name of method definition
+	// without pointer and type args.
+	recvType string
+
+	// withErrors is set if Stream* and Write* funcs return error.
+	withErrors bool
+
+	// errorResult is set if the func is declared with error result
+	// in the template. String variant of such a func returns error too.
+	errorResult bool
+
+	// errorsAssumed is set if the call is assumed to return error,
+	// since the receiver type is unknown. See parser.resolveCallErrors.
+	errorsAssumed bool
+
+	// withContext is set if all the generated funcs accept ctx
+	// as the first arg.
+	withContext bool
+
+	// slotArgs contains the names of quicktemplate.Slot args.
+	// See parser.findSlotArgs for details.
+	slotArgs map[string]bool
+
+	// variants contains the generated variants of the func.
+	variants funcVariants
 }
 
 func parseFuncDef(b []byte) (*funcType, error) {
 	defStr := string(b)
 
 	// extract func name
-	n := strings.Index(defStr, "(")
+	n := indexArgsParen(defStr)
 	if n < 0 {
-		return nil, fmt.Errorf("cannot find '(' in function definition")
+		return nil, errorf("cannot find '(' in function definition")
 	}
 	name := defStr[:n]
 	defStr = defStr[n+1:]
 	defPrefix := ""
 	callPrefix := ""
+	recvType := ""
 	if len(name) == 0 {
 		// Either empty func name or valid method definition. Let's check.
 
 		// parse method receiver
 		n = strings.Index(defStr, ")")
 		if n < 0 {
-			return nil, fmt.Errorf("cannot find ')' in func")
+			return nil, errorf("cannot find ')' in func")
 		}
 		recvStr := defStr[:n]
 		defStr = defStr[n+1:]
 		exprStr := fmt.Sprintf("func (%s)", recvStr)
 		expr, err := goparser.ParseExpr(exprStr)
 		if err != nil {
-			return nil, fmt.Errorf("invalid method definition: %s", err)
+			return nil, errorf("invalid method definition: %s", err)
 		}
 		ft := expr.(*ast.FuncType)
 		if len(ft.Params.List) != 1 || len(ft.Params.List[0].Names) != 1 {
 			// method receiver must contain only one param
-			return nil, fmt.Errorf("missing func or method name")
+			return nil, errorf("missing func or method name")
 		}
 		recvName := ft.Params.List[0].Names[0].Name
 		defPrefix = fmt.Sprintf("(%s) ", recvStr)
 		callPrefix = recvName + "."
+		recvType = typeName(ft.Params.List[0].Type)
 
 		// extract method name
 		n = strings.Index(defStr, "(")
 		if n < 0 {
-			return nil, fmt.Errorf("missing func name")
+			return nil, errorf("missing func name")
 		}
 		name = string(stripLeadingSpace([]byte(defStr[:n])))
 		if len(name) == 0 {
-			return nil, fmt.Errorf("missing method name")
+			return nil, errorf("missing method name")
+		}
+		if strings.IndexByte(name, '[') >= 0 {
+			return nil, errorf("methods cannot have type parameters")
 		}
 		defStr = defStr[n+1:]
 	}
 
+	// extract type parameters
+	name, typeParams, typeArgs, err := parseTypeParams(name)
+	if err != nil {
+		return nil, err
+	}
+
 	// validate and collect func args
-	if len(defStr) == 0 || defStr[len(defStr)-1] != ')' {
-		return nil, fmt.Errorf("missing ')' at the end of func")
+	if len(defStr) == 0 {
+		return nil, errorf("missing ')' at the end of func")
 	}
-	args := defStr[:len(defStr)-1]
-	exprStr := fmt.Sprintf("func (%s)", args)
+	exprStr := "func (" + defStr
 	expr, err := goparser.ParseExpr(exprStr)
 	if err != nil {
-		return nil, fmt.Errorf("invalid func args: %s", err)
+		return nil, errorf("invalid func args: %s", err)
 	}
-	ft := expr.(*ast.FuncType)
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return nil, errorf("unexpected code found after func args")
+	}
+	args := exprStr[len("func (") : ft.Params.Closing-1]
+	errorResult := false
 	if ft.Results != nil {
-		return nil, fmt.Errorf("func mustn't return any results")
+		if !isErrorResult(ft.Results) {
+			return nil, errorf("func may return only error")
+		}
+		errorResult = true
 	}
 
 	// extract arg names
 	var tmp []string
 	for _, f := range ft.Params.List {
 		if len(f.Names) == 0 {
-			return nil, fmt.Errorf("func cannot contain untyped arguments")
+			return nil, errorf("func cannot contain untyped arguments")
 		}
 		for _, n := range f.Names {
 			if n == nil {
-				return nil, fmt.Errorf("func cannot contain untyped arguments")
+				return nil, errorf("func cannot contain untyped arguments")
 			}
 			if _, isVariadic := f.Type.(*ast.Ellipsis); isVariadic {
 				tmp = append(tmp, n.Name+"...")
@@ -105,13 +159,333 @@ func parseFuncDef(b []byte) (*funcType, error) {
 	}
 	return &funcType{
 		name:       name,
+		typeParams: typeParams,
+		typeArgs:   typeArgs,
 		defPrefix:  defPrefix,
 		callPrefix: callPrefix,
+		recvType:   recvType,
 		argNames:   argNames,
 		args:       args,
+
+		withErrors:  errorResult,
+		errorResult: errorResult,
 	}, nil
 }
 
+// findSlotArgs returns the names of f args with quicktemplate.Slot type.
+//
+// The package name must refer to the imported quicktemplate package,
+// so Slot types from other packages aren't treated as slots.
+func (p *parser) findSlotArgs(f *funcType) map[string]bool {
+	var slotArgs map[string]bool
+	for name, typ := range funcVarTypes(f) {
+		sel, ok := typ.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Slot" {
+			continue
+		}
+		ident, ok := sel.X.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		importPath := p.imports[ident.Name]
+		if importPath != defaultRuntimePath && (len(p.opts.runtimePath) == 0 || importPath != p.opts.runtimePath) {
+			continue
+		}
+		if slotArgs == nil {
+			slotArgs = make(map[string]bool)
+		}
+		slotArgs[name] = true
+	}
+	return slotArgs
+}
+
+func isErrorResult(results *ast.FieldList) bool {
+	if len(results.List) != 1 || len(results.List[0].Names) > 0 {
+		return false
+	}
+	ident, ok := results.List[0].Type.(*ast.Ident)
+	return ok && ident.Name == "error"
+}
+
+// errorFuncKey returns the key for f definition in parseOptions.errorFuncs.
+//
+// Methods are keyed by receiver type name, so methods with the same name
+// declared on distinct types don't clash. See parser.resolveCallErrors
+// for resolving func calls.
+func (f *funcType) errorFuncKey() string {
+	if len(f.recvType) > 0 {
+		return f.recvType + "." + f.name
+	}
+	return f.name
+}
+
+// resolveCallErrors sets f.withErrors if the func called by f returns error.
+//
+// Method calls are resolved via the types of the current func receiver,
+// args, range loop vars and vars declared in code tags. Methods called
+// on receivers with unknown types are assumed to return error if methods
+// with the same name returning error are declared in the templates,
+// so the Go compiler rejects the generated code if the assumption is wrong
+// instead of dropping the error. Funcs from other packages are assumed
+// to return no error.
+func (p *parser) resolveCallErrors(f *funcType) {
+	if len(f.callPrefix) == 0 {
+		f.withErrors = p.opts.errorFuncs[f.name]
+		return
+	}
+	recvName := strings.TrimSuffix(f.callPrefix, ".")
+	if typ := p.varTypes[recvName]; typ != nil {
+		name := typeName(typ)
+		f.withErrors = len(name) > 0 && p.opts.errorFuncs[name+"."+f.name]
+		return
+	}
+	if _, ok := p.varTypes[recvName]; !ok && len(p.imports[recvName]) > 0 {
+		return
+	}
+	suffix := "." + f.name
+	for key := range p.opts.errorFuncs {
+		if strings.HasSuffix(key, suffix) {
+			f.withErrors = true
+			f.errorsAssumed = true
+			return
+		}
+	}
+}
+
+// funcVarTypes returns the types of f receiver and args keyed by names.
+func funcVarTypes(f *funcType) map[string]ast.Expr {
+	varTypes := make(map[string]ast.Expr)
+	if len(f.recvType) > 0 {
+		varTypes[strings.TrimSuffix(f.callPrefix, ".")] = ast.NewIdent(f.recvType)
+	}
+	expr, err := goparser.ParseExpr(fmt.Sprintf("func(%s)", strings.TrimPrefix(f.args, ", ")))
+	if err != nil {
+		return varTypes
+	}
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return varTypes
+	}
+	for _, field := range ft.Params.List {
+		for _, name := range field.Names {
+			varTypes[name.Name] = field.Type
+		}
+	}
+	return varTypes
+}
+
+// rangeVarTypes returns varTypes updated with the types of vars declared
+// by the given for statement.
+//
+// Only element types of slices, arrays and maps with known types
+// are resolved. Other declared vars shadow the outer vars with unknown type.
+func rangeVarTypes(stmt []byte, varTypes map[string]ast.Expr) map[string]ast.Expr {
+	expr, err := goparser.ParseExpr(fmt.Sprintf("func () { for %s {} }", stmt))
+	if err != nil {
+		return varTypes
+	}
+	fl, ok := expr.(*ast.FuncLit)
+	if !ok || len(fl.Body.List) != 1 {
+		return varTypes
+	}
+	var vars []ast.Expr
+	var types []ast.Expr
+	switch x := fl.Body.List[0].(type) {
+	case *ast.RangeStmt:
+		if x.Tok != gotoken.DEFINE {
+			return varTypes
+		}
+		vars = []ast.Expr{x.Key, x.Value}
+		types = make([]ast.Expr, 2)
+		if ident, ok := x.X.(*ast.Ident); ok {
+			switch typ := varTypes[ident.Name].(type) {
+			case *ast.ArrayType:
+				types[1] = typ.Elt
+			case *ast.MapType:
+				types[0], types[1] = typ.Key, typ.Value
+			}
+		}
+	case *ast.ForStmt:
+		as, ok := x.Init.(*ast.AssignStmt)
+		if !ok || as.Tok != gotoken.DEFINE {
+			return varTypes
+		}
+		vars = as.Lhs
+		types = make([]ast.Expr, len(vars))
+	default:
+		return varTypes
+	}
+
+	m := make(map[string]ast.Expr, len(varTypes)+len(vars))
+	for name, typ := range varTypes {
+		m[name] = typ
+	}
+	for i, v := range vars {
+		if ident, ok := v.(*ast.Ident); ok {
+			m[ident.Name] = types[i]
+		}
+	}
+	return m
+}
+
+// codeVarTypes returns varTypes updated with the types of vars declared
+// by the given code.
+//
+// Only the vars declared with explicit types and the vars initialized
+// with composite literals such as T{} and &T{} are resolved.
+// Other declared vars shadow the outer vars with unknown type.
+func codeVarTypes(code []byte, varTypes map[string]ast.Expr) map[string]ast.Expr {
+	expr, err := goparser.ParseExpr(fmt.Sprintf("func () { %s\n}", code))
+	if err != nil {
+		return varTypes
+	}
+	fl, ok := expr.(*ast.FuncLit)
+	if !ok {
+		return varTypes
+	}
+	// varTypes is shared with the outer scopes, so it is copied
+	// before the first update.
+	m := varTypes
+	copied := false
+	setType := func(ident *ast.Ident, typ ast.Expr) {
+		if !copied {
+			m = make(map[string]ast.Expr, len(varTypes)+1)
+			for name, typ := range varTypes {
+				m[name] = typ
+			}
+			copied = true
+		}
+		m[ident.Name] = typ
+	}
+	for _, stmt := range fl.Body.List {
+		switch x := stmt.(type) {
+		case *ast.AssignStmt:
+			if x.Tok != gotoken.DEFINE {
+				continue
+			}
+			for i, v := range x.Lhs {
+				if ident, ok := v.(*ast.Ident); ok {
+					var typ ast.Expr
+					if len(x.Rhs) == len(x.Lhs) {
+						typ = compositeLitType(x.Rhs[i])
+					}
+					setType(ident, typ)
+				}
+			}
+		case *ast.DeclStmt:
+			gd, ok := x.Decl.(*ast.GenDecl)
+			if !ok || gd.Tok != gotoken.VAR {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, ident := range vs.Names {
+					typ := vs.Type
+					if typ == nil && len(vs.Values) == len(vs.Names) {
+						typ = compositeLitType(vs.Values[i])
+					}
+					setType(ident, typ)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// compositeLitType returns the type of T{} and &T{} expressions.
+//
+// nil is returned for other expressions.
+func compositeLitType(expr ast.Expr) ast.Expr {
+	if ue, ok := expr.(*ast.UnaryExpr); ok && ue.Op == gotoken.AND {
+		expr = ue.X
+	}
+	if cl, ok := expr.(*ast.CompositeLit); ok {
+		return cl.Type
+	}
+	return nil
+}
+
+// typeName returns the name of the type declared in the current package
+// for the given type expression.
+//
+// Pointers and type args are stripped. An empty string is returned
+// for types from other packages and for unnamed types.
+func typeName(typ ast.Expr) string {
+	for {
+		switch x := typ.(type) {
+		case *ast.Ident:
+			return x.Name
+		case *ast.StarExpr:
+			typ = x.X
+		case *ast.ParenExpr:
+			typ = x.X
+		case *ast.IndexExpr:
+			typ = x.X
+		case *ast.IndexListExpr:
+			typ = x.X
+		default:
+			return ""
+		}
+	}
+}
+
+// indexArgsParen returns the index of '(' starting func args in s.
+//
+// Parens inside type parameter list are skipped.
+func indexArgsParen(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '(':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseTypeParams splits generic func name such as "List[T any]"
+// into name, type parameter list and type argument list for calling
+// the func with the same type parameters.
+func parseTypeParams(s string) (string, string, string, error) {
+	n := strings.IndexByte(s, '[')
+	if n < 0 {
+		return s, "", "", nil
+	}
+	name := string(stripTrailingSpace([]byte(s[:n])))
+	typeParams := s[n:]
+	if len(name) == 0 {
+		return "", "", "", errorf("missing func name")
+	}
+
+	src := fmt.Sprintf("package foo\nfunc f%s() {}", typeParams)
+	fset := gotoken.NewFileSet()
+	f, err := goparser.ParseFile(fset, "", src, 0)
+	if err != nil {
+		return "", "", "", errorf("invalid type parameters %q: %s", typeParams, err)
+	}
+	if len(f.Decls) != 1 {
+		return "", "", "", errorf("unexpected code found after type parameters %q", typeParams)
+	}
+	fd, ok := f.Decls[0].(*ast.FuncDecl)
+	if !ok || fd.Type.TypeParams == nil || len(fd.Type.TypeParams.List) == 0 {
+		return "", "", "", errorf("invalid type parameters %q", typeParams)
+	}
+	var names []string
+	for _, field := range fd.Type.TypeParams.List {
+		for _, n := range field.Names {
+			names = append(names, n.Name)
+		}
+	}
+	typeArgs := "[" + strings.Join(names, ", ") + "]"
+	return name, typeParams, typeArgs, nil
+}
+
 func parseFuncCall(b []byte) (*funcType, error) {
 	exprStr := string(b)
 	expr, err := goparser.ParseExpr(exprStr)
@@ -120,9 +494,22 @@ func parseFuncCall(b []byte) (*funcType, error) {
 	}
 	ce, ok := expr.(*ast.CallExpr)
 	if !ok {
-		return nil, fmt.Errorf("missing function call")
+		return nil, errorf("missing function call")
 	}
-	callPrefix, name, err := getCallName(ce)
+
+	// extract type arguments of generic func call
+	fun := ce.Fun
+	typeArgs := ""
+	switch x := fun.(type) {
+	case *ast.IndexExpr:
+		typeArgs = exprStr[x.Lbrack-1 : x.Rbrack]
+		fun = x.X
+	case *ast.IndexListExpr:
+		typeArgs = exprStr[x.Lbrack-1 : x.Rbrack]
+		fun = x.X
+	}
+
+	callPrefix, name, err := getCallName(fun)
 	if err != nil {
 		return nil, err
 	}
@@ -133,25 +520,26 @@ func parseFuncCall(b []byte) (*funcType, error) {
 	}
 	return &funcType{
 		name:       name,
+		typeArgs:   typeArgs,
 		callPrefix: callPrefix,
 		argNames:   argNames,
 	}, nil
 }
 
 func (f *funcType) DefStream(dst string) string {
-	return fmt.Sprintf("%s%s%s(%s *qt%s.Writer%s)", f.defPrefix, f.prefixStream(), f.name, dst, mangleSuffix, f.args)
+	return fmt.Sprintf("%s%s%s%s(%s%s *qt%s.Writer%s)%s", f.defPrefix, f.prefixStream(), f.name, f.typeParams, f.defContext(), dst, mangleSuffix, f.args, f.results())
 }
 
 func (f *funcType) CallStream(dst string) string {
-	return fmt.Sprintf("%s%s%s(%s%s)", f.callPrefix, f.prefixStream(), f.name, dst, f.argNames)
+	return fmt.Sprintf("%s%s%s%s(%s%s%s)", f.callPrefix, f.prefixStream(), f.name, f.typeArgs, f.callContext(), dst, f.argNames)
 }
 
 func (f *funcType) DefWrite(dst string) string {
-	return fmt.Sprintf("%s%s%s(%s qtio%s.Writer%s)", f.defPrefix, f.prefixWrite(), f.name, dst, mangleSuffix, f.args)
+	return fmt.Sprintf("%s%s%s%s(%s%s qtio%s.Writer%s)%s", f.defPrefix, f.prefixWrite(), f.name, f.typeParams, f.defContext(), dst, mangleSuffix, f.args, f.results())
 }
 
 func (f *funcType) CallWrite(dst string) string {
-	return fmt.Sprintf("%s%s%s(%s%s)", f.callPrefix, f.prefixWrite(), f.name, dst, f.argNames)
+	return fmt.Sprintf("%s%s%s%s(%s%s%s)", f.callPrefix, f.prefixWrite(), f.name, f.typeArgs, f.callContext(), dst, f.argNames)
 }
 
 func (f *funcType) DefString() string {
@@ -160,7 +548,42 @@ func (f *funcType) DefString() string {
 		// skip the first ', '
 		args = args[2:]
 	}
-	return fmt.Sprintf("%s%s(%s) string", f.defPrefix, f.name, args)
+	ctx := f.defContext()
+	if len(args) == 0 && len(ctx) > 0 {
+		// skip the trailing ', '
+		ctx = ctx[:len(ctx)-2]
+	}
+	results := " string"
+	if f.errorResult {
+		results = " (string, error)"
+	}
+	return fmt.Sprintf("%s%s%s(%s%s)%s", f.defPrefix, f.name, f.typeParams, ctx, args, results)
+}
+
+// contextArg is the name of context.Context arg in the generated funcs.
+//
+// The name isn't mangled, so it may be used in templates.
+const contextArg = "ctx"
+
+func (f *funcType) defContext() string {
+	if f.withContext {
+		return fmt.Sprintf("%s qtctx%s.Context, ", contextArg, mangleSuffix)
+	}
+	return ""
+}
+
+func (f *funcType) callContext() string {
+	if f.withContext {
+		return contextArg + ", "
+	}
+	return ""
+}
+
+func (f *funcType) results() string {
+	if f.withErrors {
+		return " error"
+	}
+	return ""
 }
 
 func (f *funcType) prefixWrite() string {
@@ -179,10 +602,9 @@ func (f *funcType) prefixStream() string {
 	return s
 }
 
-func getCallName(ce *ast.CallExpr) (string, string, error) {
+func getCallName(expr ast.Expr) (string, string, error) {
 	callPrefix := ""
 	name := ""
-	expr := ce.Fun
 	for {
 		switch x := expr.(type) {
 		case *ast.Ident:
@@ -199,7 +621,7 @@ func getCallName(ce *ast.CallExpr) (string, string, error) {
 			}
 			expr = x.X
 		default:
-			return "", "", fmt.Errorf("unexpected function name")
+			return "", "", errorf("unexpected function name")
 		}
 	}
 }
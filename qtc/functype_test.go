@@ -20,6 +20,10 @@ func TestParseFuncCallSuccess(t *testing.T) {
 	// chained method
 	testParseFuncCallSuccess(t, "foo.bar.Baz(x, y)", "foo.bar.StreamBaz(qw422016, x, y)")
 
+	// generic func
+	testParseFuncCallSuccess(t, "List[Row](rows)", "StreamList[Row](qw422016, rows)")
+	testParseFuncCallSuccess(t, "foo.Map[string, []int](m)", "foo.StreamMap[string, []int](qw422016, m)")
+
 	// complex args
 	testParseFuncCallSuccess(t, `as.ffs.SS(
 		func(x int, y string) {
@@ -109,6 +113,31 @@ func TestParseFuncDefSuccess(t *testing.T) {
 	testParseFuncDefSuccess(t, "(t TPL) Head(name string, num int, otherNames ...string)", "(t TPL) Head(name string, num int, otherNames ...string) string",
 		"(t TPL) StreamHead(qw422016 *qt422016.Writer, name string, num int, otherNames ...string)", "t.StreamHead(qw422016, name, num, otherNames...)",
 		"(t TPL) WriteHead(qq422016 qtio422016.Writer, name string, num int, otherNames ...string)", "t.WriteHead(qq422016, name, num, otherNames...)")
+
+	// generic func
+	testParseFuncDefSuccess(t, "List[T Item](items []T)", "List[T Item](items []T) string",
+		"StreamList[T Item](qw422016 *qt422016.Writer, items []T)", "StreamList[T](qw422016, items)",
+		"WriteList[T Item](qq422016 qtio422016.Writer, items []T)", "WriteList[T](qq422016, items)")
+
+	// generic func with multiple type parameters containing parens
+	testParseFuncDefSuccess(t, "table[K comparable, V interface{ Cell() string }](m map[K]V)", "table[K comparable, V interface{ Cell() string }](m map[K]V) string",
+		"streamtable[K comparable, V interface{ Cell() string }](qw422016 *qt422016.Writer, m map[K]V)", "streamtable[K, V](qw422016, m)",
+		"writetable[K comparable, V interface{ Cell() string }](qq422016 qtio422016.Writer, m map[K]V)", "writetable[K, V](qq422016, m)")
+
+	// method on generic receiver
+	testParseFuncDefSuccess(t, "(l *List[T]) Body(n int)", "(l *List[T]) Body(n int) string",
+		"(l *List[T]) StreamBody(qw422016 *qt422016.Writer, n int)", "l.StreamBody(qw422016, n)",
+		"(l *List[T]) WriteBody(qq422016 qtio422016.Writer, n int)", "l.WriteBody(qq422016, n)")
+
+	// func returning error
+	testParseFuncDefSuccess(t, "F(a int) error", "F(a int) (string, error)",
+		"StreamF(qw422016 *qt422016.Writer, a int) error", "StreamF(qw422016, a)",
+		"WriteF(qq422016 qtio422016.Writer, a int) error", "WriteF(qq422016, a)")
+
+	// method returning error
+	testParseFuncDefSuccess(t, "(x XX) f() error", "(x XX) f() (string, error)",
+		"(x XX) streamf(qw422016 *qt422016.Writer) error", "x.streamf(qw422016)",
+		"(x XX) writef(qq422016 qtio422016.Writer) error", "x.writef(qq422016)")
 }
 
 func TestParseFuncDefFailure(t *testing.T) {
@@ -132,6 +161,17 @@ func TestParseFuncDefFailure(t *testing.T) {
 	testParseFuncDefFailure(t, "f() (int, string)")
 	testParseFuncDefFailure(t, "(x XX) f() string")
 	testParseFuncDefFailure(t, "(x XX) f(a int) (int, string)")
+	testParseFuncDefFailure(t, "f() (err error)")
+	testParseFuncDefFailure(t, "f() (string, error)")
+
+	// invalid type parameters
+	testParseFuncDefFailure(t, "f[]()")
+	testParseFuncDefFailure(t, "f[T]()")
+	testParseFuncDefFailure(t, "[T any]()")
+	testParseFuncDefFailure(t, "f[T any]]()")
+
+	// methods cannot have type parameters
+	testParseFuncDefFailure(t, "(x XX) f[T any]()")
 }
 
 func testParseFuncDefFailure(t *testing.T, s string) {
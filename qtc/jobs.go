@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+)
+
+// compileJob is the template file processed by runJobs.
+type compileJob struct {
+	filename string
+
+	// errorFuncs contains error funcs in the directory with the file.
+	// See getErrorFuncs for details.
+	errorFuncs map[string]bool
+
+	// packageName is the package name for templates in the directory
+	// with the file. See getFilePackageName for details.
+	packageName string
+
+	// logs contains the messages logged by the job.
+	logs []string
+
+	// skipped is set if the job skipped the up to date file.
+	skipped bool
+
+	err  error
+	done chan struct{}
+}
+
+func newCompileJob(filename string, errorFuncs map[string]bool, packageName string) *compileJob {
+	return &compileJob{
+		filename:    filename,
+		errorFuncs:  errorFuncs,
+		packageName: packageName,
+		done:        make(chan struct{}),
+	}
+}
+
+// logf adds the given message to the job logs.
+//
+// The logs are written by runJobs in the order of jobs.
+func (j *compileJob) logf(format string, args ...interface{}) {
+	j.logs = append(j.logs, fmt.Sprintf(format, args...))
+}
+
+// runJobs runs work for the given jobs on up to -j goroutines.
+//
+// The job logs are written in the order of jobs regardless of the order
+// the jobs finish in, so the output is deterministic.
+// runJobs returns the number of successful jobs and the errors
+// in the order of jobs. Each error found in a template file
+// is returned separately.
+func runJobs(jobs []*compileJob, work func(j *compileJob) error) (int, []error) {
+	workers := *jobsCount
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	jobsCh := make(chan *compileJob)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobsCh {
+				j.err = work(j)
+				close(j.done)
+			}
+		}()
+	}
+	go func() {
+		for _, j := range jobs {
+			jobsCh <- j
+		}
+		close(jobsCh)
+	}()
+
+	n := 0
+	var errs []error
+	for _, j := range jobs {
+		<-j.done
+		for _, msg := range j.logs {
+			logger.Printf("%s", msg)
+		}
+		if pe, ok := j.err.(parseErrors); ok {
+			for _, err := range pe {
+				errs = append(errs, err)
+			}
+		} else if j.err != nil {
+			errs = append(errs, &fileError{
+				filename: j.filename,
+				err:      j.err,
+			})
+		} else {
+			n++
+		}
+	}
+	return n, errs
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunJobs(t *testing.T) {
+	for _, workers := range []int{1, 3, 100} {
+		testRunJobs(t, workers)
+	}
+}
+
+func testRunJobs(t *testing.T, workers int) {
+	t.Helper()
+	prevJobsCount := *jobsCount
+	*jobsCount = workers
+	defer func() {
+		*jobsCount = prevJobsCount
+	}()
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	logger.SetFlags(0)
+	defer func() {
+		logger.SetOutput(os.Stderr)
+		logger.SetFlags(defaultLoggerFlags)
+	}()
+
+	var jobs []*compileJob
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, newCompileJob(fmt.Sprintf("%d", i), nil, ""))
+	}
+	n, errs := runJobs(jobs, func(j *compileJob) error {
+		// The first jobs finish last.
+		i, err := strconv.Atoi(j.filename)
+		if err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(10-i) * time.Millisecond)
+		j.logf("job %s", j.filename)
+		if i%3 == 0 {
+			return fmt.Errorf("error %s", j.filename)
+		}
+		return nil
+	})
+	if n != 6 {
+		t.Fatalf("unexpected number of successful jobs: %d. Expecting 6", n)
+	}
+	var errStrs []string
+	for _, err := range errs {
+		errStrs = append(errStrs, err.Error())
+	}
+	if s := strings.Join(errStrs, ","); s != "error 0,error 3,error 6,error 9" {
+		t.Fatalf("unexpected errors for %d workers: %q", workers, s)
+	}
+	expectedLogs := "qtc: job 0\nqtc: job 1\nqtc: job 2\nqtc: job 3\nqtc: job 4\nqtc: job 5\nqtc: job 6\nqtc: job 7\nqtc: job 8\nqtc: job 9\n"
+	if s := logs.String(); s != expectedLogs {
+		t.Fatalf("unexpected logs for %d workers: %q. Expecting %q", workers, s, expectedLogs)
+	}
+}
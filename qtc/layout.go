@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/build"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// layoutArg is the name of the layout func arg containing blocks.
+const layoutArg = "qp" + mangleSuffix
+
+// layoutBlock is a block found in layout func.
+type layoutBlock struct {
+	// f is the method with the default block contents.
+	f *funcType
+
+	// code is the generated code for f.
+	code bytes.Buffer
+}
+
+// layoutExtends is the layout extended by the page.
+type layoutExtends struct {
+	// recv is the page receiver such as "(p *MainPage)".
+	recv string
+
+	// layout is the name of the layout func, possibly with package name.
+	layout string
+
+	// blocks contains the names of the overridden blocks.
+	blocks map[string]bool
+
+	// blockContexts contains the html contexts of the layout blocks.
+	// See getLayoutBlockContexts for details.
+	blockContexts map[string]escContext
+}
+
+// parseBlock parses block tag inside layout func.
+//
+// The block is rendered via the corresponding method of the blocks arg,
+// while the block contents become the default method implementation.
+func (p *parser) parseBlock() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	blockStr := "block " + string(t.Value)
+	name, err := parseBlockName(t.Value)
+	if err != nil {
+		return errorf("invalid %q at %s: %s", blockStr, s.Context(), err)
+	}
+	lf := p.layoutFunc
+	if lf == nil {
+		return errorf("nested blocks are not allowed. Found %q at %s", blockStr, s.Context())
+	}
+	if len(lf.defPrefix) > 0 || len(lf.typeParams) > 0 {
+		return errorf("blocks may be used only in funcs without receiver and type parameters. Found %q at %s", blockStr, s.Context())
+	}
+	for _, b := range p.blocks {
+		if b.f.name == name {
+			return errorf("duplicate %q at %s", blockStr, s.Context())
+		}
+	}
+
+	call := &funcType{
+		name:       name,
+		callPrefix: layoutArg + ".",
+	}
+	p.applyOptions(call)
+	if err = p.emitCall(call, blockStr); err != nil {
+		return err
+	}
+
+	_, baseName := layoutNames(lf.name)
+	b := &layoutBlock{
+		f: &funcType{
+			name:       name,
+			defPrefix:  fmt.Sprintf("(%s *%s) ", layoutArg, baseName),
+			callPrefix: layoutArg + ".",
+		},
+	}
+	p.applyOptions(b.f)
+	// Blocks implement the blocks interface, so all the variants are generated.
+	b.f.variants = allVariants
+
+	// The block is parsed as a separate func, so the state
+	// of the layout func must be restored afterwards.
+	// The block starts in the html context of the layout, so it is escaped
+	// in the same way as if it were inlined into the layout.
+	esc := p.esc
+	state := *p
+	p.w = &b.code
+	p.layoutFunc = nil
+	p.forDepth = 0
+	p.switchDepth = 0
+	p.skipOutputDepth = 0
+	p.Printf("func %s {", b.f.DefStream("qw"+mangleSuffix))
+	p.emitFuncStart(b.f)
+	p.esc = esc
+	if err = p.parseFuncBody(blockStr, "endblock"); err != nil {
+		return err
+	}
+	if err = p.finishBlock(blockStr, esc); err != nil {
+		return err
+	}
+	p.emitFuncEnd(b.f)
+	escEnd := p.esc
+	*p = state
+	p.esc = escEnd
+	p.blocks = append(p.blocks, b)
+	if p.opts.blockContexts != nil {
+		p.opts.blockContexts[lf.name+"."+name] = esc
+	}
+	return nil
+}
+
+// addLayoutArg adds blocks arg to layout func f.
+func addLayoutArg(f *funcType) {
+	ifaceName, _ := layoutNames(f.name)
+	f.args = fmt.Sprintf(", %s %s%s", layoutArg, ifaceName, f.args)
+	f.argNames = ", " + layoutArg + f.argNames
+}
+
+// emitLayout emits blocks interface and the base implementation
+// for layout func f.
+func (p *parser) emitLayout(f *funcType, blocks []*layoutBlock) error {
+	ifaceName, baseName := layoutNames(f.name)
+	p.Printf("type %s interface {", ifaceName)
+	p.prefix = "\t"
+	for _, b := range blocks {
+		m := &funcType{
+			name: b.f.name,
+		}
+		p.applyOptions(m)
+		p.Printf("%s", m.DefString())
+		p.Printf("%s", m.DefStream("qw"+mangleSuffix))
+		p.Printf("%s", m.DefWrite("qq"+mangleSuffix))
+	}
+	p.prefix = ""
+	p.Printf("}\n")
+
+	p.Printf("type %s struct{}\n", baseName)
+	for _, b := range blocks {
+		if _, err := p.w.Write(b.code.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseExtends parses extends tag outside funcs.
+//
+// The subsequent blocks outside funcs override the blocks of the layout.
+func (p *parser) parseExtends() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	recv, recvType, layout, err := parseExtendsDef(t.Value)
+	if err != nil {
+		return errorf("invalid extends tag at %s: %s", s.Context(), err)
+	}
+	p.extends = &layoutExtends{
+		recv:   recv,
+		layout: layout,
+		blocks: make(map[string]bool),
+	}
+	if p.opts.blockContexts == nil && !p.opts.textMode && !p.opts.noAutoEscape {
+		// Layouts aren't resolved when collecting block contexts,
+		// so cyclic extends don't result in endless recursion.
+		p.extends.blockContexts = getLayoutBlockContexts(s.filePath, layout, p.imports)
+	}
+
+	// Make sure the page implements the layout blocks.
+	ifaceName, _ := layoutNames(layout)
+	p.Printf("var _ %s = (*%s)(nil)\n", ifaceName, recvType)
+	return nil
+}
+
+// parseBlockOverride parses block tag outside funcs, which overrides
+// the block of the extended layout.
+func (p *parser) parseBlockOverride() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	blockStr := "block " + string(t.Value)
+	name, err := parseBlockName(t.Value)
+	if err != nil {
+		return errorf("invalid %q at %s: %s", blockStr, s.Context(), err)
+	}
+	e := p.extends
+	if e == nil {
+		return errorf("%q outside func must be preceded by extends tag at %s", blockStr, s.Context())
+	}
+	if e.blocks[name] {
+		return errorf("duplicate %q at %s", blockStr, s.Context())
+	}
+	e.blocks[name] = true
+
+	f, err := parseFuncDef([]byte(fmt.Sprintf("%s %s()", e.recv, name)))
+	if err != nil {
+		return errorf("error in %q at %s: %s", blockStr, s.Context(), err)
+	}
+	p.applyOptions(f)
+	f.variants = allVariants
+
+	// Make sure the layout contains the block.
+	ifaceName, _ := layoutNames(e.layout)
+	p.Printf("var _ = %s.%s\n", ifaceName, f.prefixStream()+f.name)
+
+	p.Printf("func %s {", f.DefStream("qw"+mangleSuffix))
+	p.emitFuncStart(f)
+	esc := e.blockContexts[name]
+	p.esc = esc
+	if err = p.parseFuncBody(blockStr, "endblock"); err != nil {
+		return err
+	}
+	if err = p.finishBlock(blockStr, esc); err != nil {
+		return err
+	}
+	p.emitFuncEnd(f)
+	return nil
+}
+
+// finishBlock sets the html context after the block to p.
+//
+// The block must end in the html context compatible with the context
+// it starts in, since the block may be overridden by a block with arbitrary
+// contents, while the layout continues in the same context after the block.
+func (p *parser) finishBlock(blockStr string, start escContext) error {
+	var bj escJoiner
+	bj.add(p, p.esc)
+	bj.add(p, start)
+	if err := bj.finish(p, start); err != nil {
+		return errorf("error in %q at %s: %s", blockStr, p.s.Context(), err)
+	}
+	return nil
+}
+
+// getLayoutBlockContexts returns the html contexts of the blocks
+// of the given layout keyed by block names.
+//
+// The layout is searched in the template files of the directory
+// with filePath or in the directory of the imported package
+// for layouts such as "pkg.Layout". imports contains import paths
+// keyed by package names. nil is returned if the layout cannot be found,
+// so the blocks start in html text context.
+func getLayoutBlockContexts(filePath, layout string, imports map[string]string) map[string]escContext {
+	filename, src, name := findLayoutFile(filePath, layout, imports)
+	if len(filename) == 0 {
+		return nil
+	}
+	opts := newParseOptions(getConfigOrFlags(filepath.Dir(filename)), nil)
+	opts.blockContexts = make(map[string]escContext)
+	if err := parseWithOptions(ioutil.Discard, bytes.NewReader(src), filename, "layout", opts); err != nil {
+		// The error is reported when compiling the layout.
+		return nil
+	}
+	blockContexts := make(map[string]escContext)
+	for key, esc := range opts.blockContexts {
+		if strings.HasPrefix(key, name+".") {
+			blockContexts[key[len(name)+1:]] = esc
+		}
+	}
+	return blockContexts
+}
+
+// findLayoutFile returns the template file declaring the given layout,
+// its' contents and the layout func name without package name.
+//
+// See getLayoutBlockContexts for details. Empty filename is returned
+// if the layout cannot be found.
+func findLayoutFile(filePath, layout string, imports map[string]string) (string, []byte, string) {
+	dir := filepath.Dir(filePath)
+	if n := strings.LastIndexByte(layout, '.'); n >= 0 {
+		importPath, ok := imports[layout[:n]]
+		if !ok {
+			return "", nil, ""
+		}
+		pkg, err := build.Import(importPath, dir, build.FindOnly)
+		if err != nil {
+			return "", nil, ""
+		}
+		dir = pkg.Dir
+		layout = layout[n+1:]
+	}
+	filenames, err := globTemplates(dir, getConfigOrFlags(dir).exts)
+	if err != nil {
+		return "", nil, ""
+	}
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err == nil && declaresFunc(src, filename, layout) {
+			return filename, src, layout
+		}
+	}
+	return "", nil, ""
+}
+
+// declaresFunc returns true if the template src declares func with the given name.
+func declaresFunc(src []byte, filePath, name string) bool {
+	s := newScanner(bytes.NewReader(src), filePath)
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName || string(t.Value) != "func" || !s.Next() {
+			continue
+		}
+		f, err := parseFuncDef(s.Token().Value)
+		if err == nil && f.name == name && len(f.recvType) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// extendsLayout returns true if the template file contains extends tag.
+func extendsLayout(filename string) bool {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	s := newScanner(bytes.NewReader(src), filename)
+	for s.Next() {
+		t := s.Token()
+		if t.ID == tagName && string(t.Value) == "extends" {
+			return true
+		}
+	}
+	return false
+}
+
+// extendedBlockContexts returns the html contexts of the blocks of layouts
+// extended by the template src keyed by "layout.block".
+func extendedBlockContexts(src []byte, filePath string) map[string]escContext {
+	imports := make(map[string]string)
+	blockContexts := make(map[string]escContext)
+	s := newScanner(bytes.NewReader(src), filePath)
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName {
+			continue
+		}
+		tagNameStr := string(t.Value)
+		if !s.Next() {
+			break
+		}
+		contents := s.Token().Value
+		switch tagNameStr {
+		case "import":
+			addImports(imports, contents)
+		case "extends":
+			_, _, layout, err := parseExtendsDef(contents)
+			if err != nil {
+				continue
+			}
+			for name, esc := range getLayoutBlockContexts(filePath, layout, imports) {
+				blockContexts[layout+"."+name] = esc
+			}
+		}
+	}
+	return blockContexts
+}
+
+// parseBlockName returns the name of block methods for the given block tag
+// contents.
+//
+// The first letter of the name is capitalized, so the blocks may be
+// overridden by pages from other packages.
+func parseBlockName(b []byte) (string, error) {
+	name := string(stripSpace(b))
+	if !gotoken.IsIdentifier(name) {
+		return "", errorf("block name must be a valid identifier")
+	}
+	r, n := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[n:], nil
+}
+
+// layoutNames returns the names of blocks interface and the base
+// implementation for the given layout.
+func layoutNames(layout string) (string, string) {
+	pkg := ""
+	if n := strings.LastIndexByte(layout, '.'); n >= 0 {
+		pkg = layout[:n+1]
+		layout = layout[n+1:]
+	}
+	r, n := utf8.DecodeRuneInString(layout)
+	base := "Base" + layout
+	if !unicode.IsUpper(r) {
+		base = "base" + string(unicode.ToUpper(r)) + layout[n:]
+	}
+	return pkg + layout + "Blocks", pkg + base
+}
+
+// parseExtendsDef parses extends tag contents such as "(p *MainPage) Layout".
+//
+// It returns page receiver, page type and layout name.
+func parseExtendsDef(b []byte) (string, string, string, error) {
+	s := string(stripSpace(b))
+	if len(s) == 0 || s[0] != '(' {
+		return "", "", "", errorf("missing page receiver. Use {%% extends (p *Page) Layout %%}")
+	}
+	n := strings.IndexByte(s, ')')
+	if n < 0 {
+		return "", "", "", errorf("cannot find ')' after page receiver")
+	}
+	recv := s[:n+1]
+	layout := strings.TrimSpace(s[n+1:])
+
+	expr, err := goparser.ParseExpr("func " + recv)
+	if err != nil {
+		return "", "", "", errorf("invalid page receiver %q: %s", recv, err)
+	}
+	ft, ok := expr.(*ast.FuncType)
+	if !ok || len(ft.Params.List) != 1 || len(ft.Params.List[0].Names) != 1 {
+		return "", "", "", errorf("page receiver %q must contain only one param", recv)
+	}
+	typ := ft.Params.List[0].Type
+	if x, ok := typ.(*ast.StarExpr); ok {
+		typ = x.X
+	}
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		return "", "", "", errorf("page receiver %q must have non-generic named type", recv)
+	}
+
+	if len(layout) == 0 {
+		return "", "", "", errorf("missing layout name")
+	}
+	expr, err = goparser.ParseExpr(layout)
+	if err != nil {
+		return "", "", "", errorf("invalid layout name %q: %s", layout, err)
+	}
+	switch x := expr.(type) {
+	case *ast.Ident:
+	case *ast.SelectorExpr:
+		if _, ok := x.X.(*ast.Ident); !ok {
+			return "", "", "", errorf("invalid layout name %q", layout)
+		}
+	default:
+		return "", "", "", errorf("invalid layout name %q", layout)
+	}
+	return recv, ident.Name, layout, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	opts := &parseOptions{}
+	testParseWithOptions(t, opts, `{% func Layout(lang string) %}<html lang="{%s lang %}"><title>{% block title %}Default {%d 1 %}{% endblock %}</title>{% if lang != "" %}{% block body %}{% endblock %}{% endif %}{% endfunc %}`,
+		"func StreamLayout(qw422016 *qt422016.Writer, qp422016 LayoutBlocks, lang string) {",
+		"func WriteLayout(qq422016 qtio422016.Writer, qp422016 LayoutBlocks, lang string) {",
+		"func Layout(qp422016 LayoutBlocks, lang string) string {",
+		"qp422016.StreamTitle(qw422016)",
+		"if lang != \"\" {\n\t\tqp422016.StreamBody(qw422016)\n",
+		"type LayoutBlocks interface {\n\tTitle() string\n\tStreamTitle(qw422016 *qt422016.Writer)\n\tWriteTitle(qq422016 qtio422016.Writer)\n\tBody() string\n",
+		"type BaseLayout struct{}",
+		"func (qp422016 *BaseLayout) StreamTitle(qw422016 *qt422016.Writer) {\n\tqw422016.N().S(`Default `)\n\tqw422016.N().D(1)\n",
+		"func (qp422016 *BaseLayout) WriteTitle(qq422016 qtio422016.Writer) {",
+		"func (qp422016 *BaseLayout) Title() string {",
+		"func (qp422016 *BaseLayout) StreamBody(qw422016 *qt422016.Writer) {\n}")
+
+	// unexported layout
+	testParseWithOptions(t, opts, `{% func layout() %}{% block title %}{% endblock %}{% endfunc %}`,
+		"func streamlayout(qw422016 *qt422016.Writer, qp422016 layoutBlocks) {",
+		"type baseLayout struct{}")
+
+	// funcs without blocks are left as is
+	testParseWithOptions(t, opts, `{% func A(n int) %}{%d n %}{% endfunc %}`,
+		"func StreamA(qw422016 *qt422016.Writer, n int) {")
+
+	// page overrides
+	testParseWithOptions(t, opts, `{% extends (p *MainPage) Layout %}{% block title %}Main {%s p.Name %}{% endblock %}{% block body %}{%= p.BaseLayout.Body() %}{% endblock %}`,
+		"var _ LayoutBlocks = (*MainPage)(nil)",
+		"var _ = LayoutBlocks.StreamTitle",
+		"func (p *MainPage) StreamTitle(qw422016 *qt422016.Writer) {\n\tqw422016.N().S(`Main `)\n\tqw422016.E().S(p.Name)\n",
+		"func (p *MainPage) WriteTitle(qq422016 qtio422016.Writer) {",
+		"func (p *MainPage) Title() string {",
+		"p.BaseLayout.StreamBody(qw422016)")
+
+	// layout from other package
+	testParseWithOptions(t, opts, `{% extends (p MainPage) layouts.Base %}{% block title %}Main{% endblock %}`,
+		"var _ layouts.BaseBlocks = (*MainPage)(nil)",
+		"var _ = layouts.BaseBlocks.StreamTitle",
+		"func (p MainPage) StreamTitle(qw422016 *qt422016.Writer) {")
+
+	// blocks accept ctx and return errors
+	testParseWithOptions(t, &parseOptions{withErrors: true, withContext: true}, `{% func Layout() %}{% block title %}{% endblock %}{% endfunc %}`,
+		"func StreamLayout(ctx qtctx422016.Context, qw422016 *qt422016.Writer, qp422016 LayoutBlocks) error {",
+		"if qerr422016 := qp422016.StreamTitle(ctx, qw422016); qerr422016 != nil {",
+		"Title(ctx qtctx422016.Context) string",
+		"func (qp422016 *BaseLayout) StreamTitle(ctx qtctx422016.Context, qw422016 *qt422016.Writer) error {")
+}
+
+func TestParseLayoutEscaping(t *testing.T) {
+	// blocks are escaped in the html context of the layout
+	testParseEscapingSuccess(t, `{% func Layout() %}<a href="{% block link %}{%s "/" %}{% endblock %}">{% block title %}{%s "t" %}{% endblock %}</a><script>var a = {% block js %}{%s "x" %}{% endblock %};</script>{% endfunc %}`,
+		"qw422016.E().URL(\"/\")",
+		"qw422016.E().S(\"t\")",
+		"qw422016.N().Q(\"x\")")
+
+	// the layout continues in the html context after the block
+	testParseEscapingSuccess(t, `{% func Layout(s string) %}<a href="{% block link %}/{% endblock %}{%s s %}">{% endfunc %}`,
+		"qw422016.E().U(s)")
+}
+
+func TestLayoutBlockContexts(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	*autoEscape = true
+	defer func() { *autoEscape = false }()
+	layoutFile := filepath.Join(dir, "layout.qtpl")
+	writeWatchedFile(t, layoutFile, `{% func Layout() %}<a href="{% block link %}/{% endblock %}">{% block title %}{% endblock %}</a><script>var a = {% block js %}1{% endblock %};</script>{% endfunc %}`)
+	pageFile := filepath.Join(dir, "page.qtpl")
+	writeWatchedFile(t, pageFile, `{% extends (p *Page) Layout %}{% block link %}{%s p.URL %}{% endblock %}{% block title %}{%s p.Title %}{% endblock %}{% block js %}{%s p.Title %}{% endblock %}`)
+
+	// overrides inherit the html context of the layout blocks
+	tf, err := readTemplateFile(pageFile, nil, getTestPackageName(t, pageFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	code, err := tf.generateCode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	code, err = format.Source(removeLineDirectives(code))
+	if err != nil {
+		t.Fatalf("cannot format the generated code: %s", err)
+	}
+	for _, s := range []string{"qw422016.E().URL(p.URL)", "qw422016.E().S(p.Title)", "qw422016.N().Q(p.Title)"} {
+		if !strings.Contains(string(code), s) {
+			t.Fatalf("cannot find %q in the generated code:\n%s", s, code)
+		}
+	}
+
+	// changes in the html contexts of the layout blocks change the source hash
+	writeWatchedFile(t, layoutFile, `{% func Layout() %}{% block link %}{% endblock %}{% block title %}{% endblock %}{% block js %}{% endblock %}{% endfunc %}`)
+	tf1, err := readTemplateFile(pageFile, nil, getTestPackageName(t, pageFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tf1.opts.sourceHash == tf.opts.sourceHash {
+		t.Fatalf("source hash must change after changing the layout")
+	}
+
+	// overrides must end in the html context compatible with the layout
+	writeWatchedFile(t, pageFile, `{% extends (p *Page) Layout %}{% block title %}<a href="{% endblock %}`)
+	tf, err = readTemplateFile(pageFile, nil, getTestPackageName(t, pageFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := tf.generateCode(); err == nil {
+		t.Fatalf("expecting error for block override ending in attribute")
+	}
+}
+
+func TestParseLayoutFailure(t *testing.T) {
+	// invalid block name
+	testParseFailure(t, `{% func Layout() %}{% block %}{% endblock %}{% endfunc %}`)
+	testParseFailure(t, `{% func Layout() %}{% block foo bar %}{% endblock %}{% endfunc %}`)
+
+	// nested blocks
+	testParseFailure(t, `{% func Layout() %}{% block a %}{% block b %}{% endblock %}{% endblock %}{% endfunc %}`)
+
+	// duplicate blocks
+	testParseFailure(t, `{% func Layout() %}{% block a %}{% endblock %}{% block A %}{% endblock %}{% endfunc %}`)
+
+	// blocks in methods and generic funcs
+	testParseFailure(t, `{% func (p *P) Layout() %}{% block a %}{% endblock %}{% endfunc %}`)
+	testParseFailure(t, `{% func Layout[T any]() %}{% block a %}{% endblock %}{% endfunc %}`)
+
+	// missing endblock
+	testParseFailure(t, `{% func Layout() %}{% block a %}{% endfunc %}`)
+
+	// break outside for loop in block
+	testParseFailure(t, `{% func Layout(s []int) %}{% for range s %}{% block a %}{% break %}{% endblock %}{% endfor %}{% endfunc %}`)
+
+	// block ending in html context other than the starting context
+	testParseFailure(t, `{% func Layout() %}{% block a %}<a href="{% endblock %}">{% endfunc %}`)
+
+	// block outside func without extends
+	testParseFailure(t, `{% block a %}{% endblock %}`)
+
+	// invalid extends
+	testParseFailure(t, `{% extends Layout %}`)
+	testParseFailure(t, `{% extends (p *Page) %}`)
+	testParseFailure(t, `{% extends (p *Page[T]) Layout %}`)
+	testParseFailure(t, `{% extends (p *Page) Layout() %}`)
+
+	// duplicate overrides
+	testParseFailure(t, `{% extends (p *Page) Layout %}{% block a %}{% endblock %}{% block a %}{% endblock %}`)
+
+	// nested blocks in overrides
+	testParseFailure(t, `{% extends (p *Page) Layout %}{% block a %}{% block b %}{% endblock %}{% endblock %}`)
+}
+
+func TestLayoutNames(t *testing.T) {
+	testLayoutNames(t, "Layout", "LayoutBlocks", "BaseLayout")
+	testLayoutNames(t, "layout", "layoutBlocks", "baseLayout")
+	testLayoutNames(t, "layouts.Main", "layouts.MainBlocks", "layouts.BaseMain")
+}
+
+func testLayoutNames(t *testing.T, layout, expectedIface, expectedBase string) {
+	iface, base := layoutNames(layout)
+	if iface != expectedIface {
+		t.Fatalf("unexpected interface name for %q: %q. Expecting %q", layout, iface, expectedIface)
+	}
+	if base != expectedBase {
+		t.Fatalf("unexpected base name for %q: %q. Expecting %q", layout, base, expectedBase)
+	}
+}
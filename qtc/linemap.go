@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The generated code contains two kinds of line directives pointing
+// to template positions:
+//
+//   - //line file:line:col at the start of line before each statement.
+//   - /*line file:line:col*/ before Go fragments located in the middle
+//     of the generated statement such as the expression in {%d x %}.
+//
+// Columns in the directives are written for the unformatted code
+// and are adjusted by adjustLineDirectives after formatting. Then
+// removeRedundantLineDirectives removes directives implied by the preceding
+// directives.
+
+// lineDirective returns //line directive for the given 0-based template position.
+func lineDirective(filePath string, line, pos int) string {
+	return fmt.Sprintf("//line %s:%d:%d\n", filePath, line+1, pos+1)
+}
+
+// inlineLineDirective returns /*line*/ directive for the given 0-based
+// template position.
+func inlineLineDirective(filePath string, line, pos int) string {
+	return fmt.Sprintf("/*line %s:%d:%d*/", filePath, line+1, pos+1)
+}
+
+// annotateFragment inserts line directives for the Go fragment at the given
+// 0-based template position into the generated stmt.
+//
+// The inline directive is inserted before the fragment if the fragment
+// doesn't start stmt. Multi-line fragments get //line directive before each
+// line starting with Go token, so the positions remain exact after
+// the fragment is re-indented. The directives are removed after formatting
+// from the lines, which weren't re-indented.
+func annotateFragment(stmt, fragment, filePath string, line, pos int) string {
+	if len(fragment) == 0 {
+		return stmt
+	}
+	k := indexFragment(stmt, fragment)
+	if k < 0 {
+		return annotateCallArgs(stmt, fragment, filePath, line, pos)
+	}
+	var b strings.Builder
+	if n := strings.LastIndexByte(stmt[:k], ','); n >= 0 && strings.TrimLeft(stmt[n+1:k], " ") == "" && pos >= 2 {
+		// go/format moves comments following commas before the commas
+		// and puts single space after the commas, so the directive
+		// is written before the comma.
+		b.WriteString(stmt[:n])
+		b.WriteString(inlineLineDirective(filePath, line, pos-2))
+		b.WriteString(stmt[n:k])
+	} else {
+		b.WriteString(stmt[:k])
+		if k > 0 {
+			b.WriteString(inlineLineDirective(filePath, line, pos))
+		}
+	}
+	lineStarts := fragmentLineStarts(fragment)
+	prev := 0
+	for i, ls := range lineStarts {
+		if ls < 0 {
+			continue
+		}
+		b.WriteString(fragment[prev:ls])
+		lineStr := fragment[ls:]
+		if n := strings.IndexByte(lineStr, '\n'); n >= 0 {
+			lineStr = lineStr[:n]
+		}
+		indent := len(lineStr) - len(strings.TrimLeft(lineStr, " \t"))
+		b.WriteString(lineDirective(filePath, line+i, indent))
+		prev = ls
+	}
+	b.WriteString(fragment[prev:])
+	b.WriteString(stmt[k+len(fragment):])
+	return b.String()
+}
+
+// annotateCallArgs annotates args of the func call in the given fragment.
+//
+// This is used for fragments rewritten in the generated code such as
+// template func calls in {%= f(args) %}, which become StreamF(qw, args).
+func annotateCallArgs(stmt, fragment, filePath string, line, pos int) string {
+	n := strings.IndexByte(fragment, '(')
+	if n < 0 {
+		return stmt
+	}
+	start := n + 1
+	for start < len(fragment) && isSpace(fragment[start]) {
+		start++
+	}
+	args := fragment[start:]
+	if args == ")" {
+		return stmt
+	}
+	if m := strings.LastIndexByte(fragment[:start], '\n'); m >= 0 {
+		line += strings.Count(fragment[:start], "\n")
+		pos = start - m - 1
+	} else {
+		pos += start
+	}
+	return annotateFragment(stmt, args, filePath, line, pos)
+}
+
+// indexFragment returns the index of the Go fragment in stmt
+// or -1 if stmt doesn't contain the fragment.
+//
+// The fragment must start at Go token boundary, so the fragment inside
+// string literals and identifiers isn't found.
+func indexFragment(stmt, fragment string) int {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(stmt))
+	var s goscanner.Scanner
+	s.Init(file, []byte(stmt), func(pos gotoken.Position, msg string) {}, 0)
+	for {
+		pos, tok, _ := s.Scan()
+		if tok == gotoken.EOF {
+			return -1
+		}
+		n := file.Offset(pos)
+		if !strings.HasPrefix(stmt[n:], fragment) {
+			continue
+		}
+		if end := n + len(fragment); end < len(stmt) && isIdentByte(stmt[end]) && isIdentByte(stmt[end-1]) {
+			continue
+		}
+		return n
+	}
+}
+
+// fragmentLineStarts returns offsets of lines in the given Go fragment,
+// which may be preceded by //line directive.
+//
+// The offset is -1 for the first line, for blank lines and for lines
+// inside multi-line tokens such as raw strings and comments.
+func fragmentLineStarts(fragment string) []int {
+	lineStarts := []int{-1}
+	for i := 0; i < len(fragment); i++ {
+		if fragment[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	if len(lineStarts) == 1 {
+		return lineStarts
+	}
+
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(fragment))
+	var s goscanner.Scanner
+	s.Init(file, []byte(fragment), func(pos gotoken.Position, msg string) {}, goscanner.ScanComments)
+	hasToken := make([]bool, len(lineStarts))
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			break
+		}
+		if tok == gotoken.SEMICOLON && lit == "\n" {
+			// automatically inserted semicolon
+			continue
+		}
+		start := file.Offset(pos)
+		end := start + len(lit)
+		if len(lit) == 0 {
+			end = start + len(tok.String())
+		}
+		for i, ls := range lineStarts {
+			if ls < 0 {
+				continue
+			}
+			if ls > start && ls < end {
+				// The line is inside multi-line token.
+				lineStarts[i] = -1
+			}
+		}
+		hasToken[file.Line(pos)-1] = true
+	}
+	for i := range lineStarts {
+		if !hasToken[i] {
+			lineStarts[i] = -1
+		}
+	}
+	return lineStarts
+}
+
+var (
+	lineDirectiveRe       = regexp.MustCompile(`(?m)^//line (.*):(\d+):(\d+)$`)
+	inlineLineDirectiveRe = regexp.MustCompile(`/\*line ([^*\n]*):(\d+):(\d+)\*/ ?`)
+)
+
+// adjustLineDirectives adjusts columns in line directives of the formatted
+// code, so they point to the exact template positions.
+//
+// //line directive sets the column of the first byte of the next line,
+// so the indentation of the next line is subtracted from the column.
+// go/format inserts space after /*line*/ directives, so the space
+// is taken into account.
+func adjustLineDirectives(code []byte) []byte {
+	var b bytes.Buffer
+	prev := 0
+	for _, m := range lineDirectiveRe.FindAllSubmatchIndex(code, -1) {
+		col, _ := strconv.Atoi(string(code[m[6]:m[7]]))
+		if next := code[m[1]:]; len(next) > 0 {
+			next = next[1:]
+			col -= len(next) - len(bytes.TrimLeft(next, "\t "))
+		}
+		b.Write(code[prev:m[6]])
+		if col >= 1 {
+			b.WriteString(strconv.Itoa(col))
+			prev = m[7]
+			continue
+		}
+
+		// The next line is indented deeper than the template column,
+		// so the exact column is set by /*line*/ directive after
+		// the indentation.
+		b.WriteString("1")
+		next := code[m[1]+1:]
+		indent := len(next) - len(bytes.TrimLeft(next, "\t "))
+		b.Write(code[m[7] : m[1]+1+indent])
+		b.WriteString(fmt.Sprintf("/*line %s:%s:%s*/ ", code[m[2]:m[3]], code[m[4]:m[5]], code[m[6]:m[7]]))
+		prev = m[1] + 1 + indent
+	}
+	b.Write(code[prev:])
+	return inlineLineDirectiveRe.ReplaceAllFunc(b.Bytes(), func(d []byte) []byte {
+		if !bytes.HasSuffix(d, []byte(" ")) {
+			return d
+		}
+		m := inlineLineDirectiveRe.FindSubmatchIndex(d)
+		col, _ := strconv.Atoi(string(d[m[6]:m[7]]))
+		if col <= 1 {
+			return d
+		}
+		return []byte(fmt.Sprintf("/*line %s:%s:%d*/ ", d[m[2]:m[3]], d[m[4]:m[5]], col-1))
+	})
+}
+
+// removeRedundantLineDirectives removes //line directives, which point
+// to the position already implied by the preceding directives, from
+// the formatted code with adjusted directives.
+//
+// Such directives are emitted before every line of multi-line Go fragments,
+// but they are needed only for the lines, which were re-indented by go/format
+// or which follow the removed blank lines. The removal of the remaining
+// directives lets go/format align the lines of the fragment.
+func removeRedundantLineDirectives(code []byte) []byte {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s goscanner.Scanner
+	s.Init(file, code, func(pos gotoken.Position, msg string) {}, goscanner.ScanComments)
+
+	// The line n in the resulting code corresponds to the template
+	// line n-lineOffset in the curFile. The resulting code misses
+	// the removed lines.
+	curFile := ""
+	lineOffset := 0
+	removedLines := 0
+	var b bytes.Buffer
+	prev := 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			break
+		}
+		if tok != gotoken.COMMENT {
+			continue
+		}
+		p := file.PositionFor(pos, false)
+		var m []string
+		switch {
+		case strings.HasPrefix(lit, "//line ") && p.Column == 1:
+			m = lineDirectiveRe.FindStringSubmatch(lit)
+		case strings.HasPrefix(lit, "/*line "):
+			m = inlineLineDirectiveRe.FindStringSubmatch(lit)
+		}
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		n := p.Line - removedLines
+		if lit[1] == '*' {
+			curFile = m[1]
+			lineOffset = n - line
+			continue
+		}
+		if m[1] == curFile && col == 1 && n-lineOffset == line {
+			// The next line takes the place of the directive.
+			offset := file.Offset(pos)
+			b.Write(code[prev:offset])
+			prev = offset + len(lit) + 1
+			removedLines++
+			continue
+		}
+		curFile = m[1]
+		lineOffset = n + 1 - line
+	}
+	if prev == 0 {
+		return code
+	}
+	b.Write(code[prev:])
+	return b.Bytes()
+}
+
+// removeLineDirectives removes line directives from the generated code.
+func removeLineDirectives(code []byte) []byte {
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(code, []byte("\n")) {
+		if !bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte("//line ")) {
+			b.Write(inlineLineDirectiveRe.ReplaceAll(line, nil))
+		}
+	}
+	return b.Bytes()
+}
+
+// sourceMap maps offsets in the generated Go file to template positions.
+//
+// It is written to .qtpl.map file if -sourcemap flag is set.
+type sourceMap struct {
+	Version int `json:"version"`
+
+	// File is the name of the generated Go file.
+	File string `json:"file"`
+
+	// Source is the path to the template file relative to the directory
+	// with the generated file.
+	Source string `json:"source"`
+
+	// Mappings are sorted by Offset. The byte at Offset in the generated
+	// file corresponds to the template position Line:Col. The following
+	// bytes on the same generated line correspond to the following
+	// template columns. The following generated lines correspond
+	// to the following template lines up to the next mapping.
+	Mappings []sourceMapping `json:"mappings"`
+}
+
+type sourceMapping struct {
+	// Offset is the byte offset in the generated file.
+	Offset int `json:"offset"`
+
+	// GenLine and GenCol contain 1-based position in the generated file
+	// for Offset. GenCol is measured in bytes.
+	GenLine int `json:"genLine"`
+	GenCol  int `json:"genCol"`
+
+	// Line and Col contain 1-based template position. Col is measured
+	// in bytes.
+	Line int `json:"line"`
+	Col  int `json:"col"`
+}
+
+// sourceMapVersion is the version of sourceMap format.
+const sourceMapVersion = 1
+
+// newSourceMap returns source map for the given generated code
+// built from the line directives in the code.
+func newSourceMap(code []byte, outfile, infile string) *sourceMap {
+	sm := &sourceMap{
+		Version:  sourceMapVersion,
+		File:     filepath.Base(outfile),
+		Source:   relativePath(filepath.Dir(outfile), infile),
+		Mappings: []sourceMapping{},
+	}
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s goscanner.Scanner
+	s.Init(file, code, func(pos gotoken.Position, msg string) {}, goscanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			break
+		}
+		if tok != gotoken.COMMENT {
+			continue
+		}
+		offset := file.Offset(pos)
+		var m []string
+		switch {
+		case strings.HasPrefix(lit, "//line ") && file.PositionFor(pos, false).Column == 1:
+			m = lineDirectiveRe.FindStringSubmatch(lit)
+			offset += len(lit) + 1
+		case strings.HasPrefix(lit, "/*line "):
+			m = inlineLineDirectiveRe.FindStringSubmatch(lit)
+			offset += len(lit)
+		}
+		if m == nil || offset > len(code) {
+			continue
+		}
+		line, _ := strconv.Atoi(m[2])
+		col, _ := strconv.Atoi(m[3])
+		sm.Mappings = append(sm.Mappings, sourceMapping{
+			Offset: offset,
+			Line:   line,
+			Col:    col,
+		})
+	}
+
+	// Generated positions are obtained after the scan, since the file
+	// contains only the scanned lines until then.
+	for i := range sm.Mappings {
+		m := &sm.Mappings[i]
+		p := file.PositionFor(file.Pos(m.Offset), false)
+		m.GenLine, m.GenCol = p.Line, p.Column
+	}
+	return sm
+}
+
+// relativePath returns the path to filename relative to dir.
+//
+// The path is returned with forward slashes.
+func relativePath(dir, filename string) string {
+	dirAbs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	filenameAbs, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	rel, err := filepath.Rel(dirAbs, filenameAbs)
+	if err != nil {
+		return filepath.ToSlash(filenameAbs)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Position returns 1-based template position for the given offset
+// in the generated file.
+//
+// Zero position is returned if the offset precedes all the mappings.
+func (sm *sourceMap) Position(offset int, code []byte) (int, int) {
+	var m *sourceMapping
+	for i := range sm.Mappings {
+		if sm.Mappings[i].Offset > offset {
+			break
+		}
+		m = &sm.Mappings[i]
+	}
+	if m == nil {
+		return 0, 0
+	}
+	lines := bytes.Count(code[m.Offset:offset], []byte("\n"))
+	if lines == 0 {
+		return m.Line, m.Col + offset - m.Offset
+	}
+	lineStart := bytes.LastIndexByte(code[:offset], '\n') + 1
+	return m.Line + lines, offset - lineStart + 1
+}
+
+func (sm *sourceMap) marshal() []byte {
+	data, err := json.MarshalIndent(sm, "", "\t")
+	if err != nil {
+		panic(fmt.Sprintf("BUG: cannot marshal source map: %s", err))
+	}
+	return append(data, '\n')
+}
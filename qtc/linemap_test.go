@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"strings"
+	"testing"
+)
+
+func TestAnnotateFragment(t *testing.T) {
+	// fragment starting the statement
+	testAnnotateFragment(t, "if x > 0 {", "x > 0", "if /*line a.qtpl:3:5*/x > 0 {")
+	testAnnotateFragment(t, "x := 1", "x := 1", "x := 1")
+
+	// fragment in the middle of the statement
+	testAnnotateFragment(t, "qw422016.N().D(n)", "n", "qw422016.N().D(/*line a.qtpl:3:5*/n)")
+
+	// fragment inside string literal and identifier isn't annotated
+	testAnnotateFragment(t, `if qw422016.PushStart("x", "") {`, "x", `if qw422016.PushStart("x", "") {`)
+	testAnnotateFragment(t, `qw422016.N().D(xx + x)`, "x", `qw422016.N().D(xx + /*line a.qtpl:3:5*/x)`)
+
+	// rewritten func call
+	testAnnotateFragment(t, "StreamB(qw422016, x, y)", "B( x, y)", "StreamB(qw422016/*line a.qtpl:3:6*/, x, y)")
+	testAnnotateFragment(t, "StreamB(qw422016)", "B()", "StreamB(qw422016)")
+
+	// multi-line fragment
+	testAnnotateFragment(t, "x := 1\n\n\ty := x\n\tz := `\nfoo`", "x := 1\n\n\ty := x\n\tz := `\nfoo`",
+		"x := 1\n\n//line a.qtpl:5:2\n\ty := x\n//line a.qtpl:6:2\n\tz := `\nfoo`")
+	testAnnotateFragment(t, "qw422016.N().D(f(a,\n  b))", "f(a,\n  b)",
+		"qw422016.N().D(/*line a.qtpl:3:5*/f(a,\n//line a.qtpl:4:3\n  b))")
+}
+
+func testAnnotateFragment(t *testing.T, stmt, fragment, expectedStmt string) {
+	t.Helper()
+	result := annotateFragment(stmt, fragment, "a.qtpl", 2, 4)
+	if result != expectedStmt {
+		t.Fatalf("unexpected result for stmt %q and fragment %q: %q. Expecting %q", stmt, fragment, result, expectedStmt)
+	}
+}
+
+func TestAdjustLineDirectives(t *testing.T) {
+	// //line directive before indented line
+	testAdjustLineDirectives(t, "//line a.qtpl:3:5\n\t\tx := 1\n", "//line a.qtpl:3:3\n\t\tx := 1\n")
+
+	// the line is indented deeper than the template column
+	testAdjustLineDirectives(t, "//line a.qtpl:3:2\n\t\tx := 1\n", "//line a.qtpl:3:1\n\t\t/*line a.qtpl:3:1*/ x := 1\n")
+
+	// space inserted by go/format after /*line*/ directive
+	testAdjustLineDirectives(t, "\tf( /*line a.qtpl:3:5*/ x)\n", "\tf( /*line a.qtpl:3:4*/ x)\n")
+	testAdjustLineDirectives(t, "\tf(/*line a.qtpl:3:5*/x)\n", "\tf(/*line a.qtpl:3:5*/x)\n")
+}
+
+func testAdjustLineDirectives(t *testing.T, code, expectedCode string) {
+	t.Helper()
+	result := string(adjustLineDirectives([]byte(code)))
+	if result != expectedCode {
+		t.Fatalf("unexpected result for %q: %q. Expecting %q", code, result, expectedCode)
+	}
+}
+
+func TestRemoveLineDirectives(t *testing.T) {
+	code := "//line a.qtpl:3:5\n\tf( /*line a.qtpl:3:4*/ x)\n\t//line a.qtpl:4:1\n\tg()\n"
+	result := string(removeLineDirectives([]byte(code)))
+	expectedCode := "\tf( x)\n\tg()\n"
+	if result != expectedCode {
+		t.Fatalf("unexpected result: %q. Expecting %q", result, expectedCode)
+	}
+}
+
+func TestRemoveRedundantLineDirectives(t *testing.T) {
+	// directives implied by the preceding directives
+	testRemoveRedundantLineDirectives(t, "//line a.qtpl:3:5\nx := 1\n//line a.qtpl:4:1\ny := 2\n\n//line a.qtpl:6:1\n}\n",
+		"//line a.qtpl:3:5\nx := 1\ny := 2\n\n}\n")
+	testRemoveRedundantLineDirectives(t, "\tf(/*line a.qtpl:3:5*/x)\n//line a.qtpl:4:1\n\tg()\n", "\tf(/*line a.qtpl:3:5*/x)\n\tg()\n")
+
+	// directives with other lines, columns or files
+	testRemoveRedundantLineDirectives(t, "//line a.qtpl:3:5\nx := 1\n//line a.qtpl:5:1\ny := 2\n", "//line a.qtpl:3:5\nx := 1\n//line a.qtpl:5:1\ny := 2\n")
+	testRemoveRedundantLineDirectives(t, "//line a.qtpl:3:5\nx := 1\n//line a.qtpl:4:2\ny := 2\n", "//line a.qtpl:3:5\nx := 1\n//line a.qtpl:4:2\ny := 2\n")
+	testRemoveRedundantLineDirectives(t, "//line a.qtpl:3:5\nx := 1\n//line b.qtpl:4:1\ny := 2\n", "//line a.qtpl:3:5\nx := 1\n//line b.qtpl:4:1\ny := 2\n")
+	testRemoveRedundantLineDirectives(t, "x := 1\n//line a.qtpl:2:1\ny := 2\n", "x := 1\n//line a.qtpl:2:1\ny := 2\n")
+}
+
+func testRemoveRedundantLineDirectives(t *testing.T, code, expectedCode string) {
+	t.Helper()
+	result := string(removeRedundantLineDirectives([]byte(code)))
+	if result != expectedCode {
+		t.Fatalf("unexpected result for %q: %q. Expecting %q", code, result, expectedCode)
+	}
+}
+
+func TestLineDirectivesPositions(t *testing.T) {
+	// Identifiers starting with "m" must have the given template positions
+	// in the generated code and in the source map.
+	src := `{% code
+type T struct {
+	A int
+}
+
+func f() int {
+	x := 1
+	return x + m1
+}
+%}
+
+{% func A(n int, s []string) %}
+	<p>{%d n + m2 %}</p>
+	{% if n > 0 && m3 %}
+		<b>{%s= "x" %}</b>{%d f(n,
+			m4) %}
+		{% for i := 0; i < m5; i++ %}
+			{% code
+				y := m6
+			%}
+			{%d i + y %}
+		{% endfor %}
+	{% endif %}
+	{%= B(m7) %}
+	{%= x.C(1,
+		m8) %}
+	{%= D(1, m9) %}
+{% endfunc %}
+{% iface I {
+	m10()
+
+	m11(n int)
+} %}
+{% code
+type U struct {
+	A int
+	m12 string
+}
+%}
+`
+	expectedPositions := map[string]string{
+		"m1":  "a.qtpl:8:13",
+		"m2":  "a.qtpl:13:13",
+		"m3":  "a.qtpl:14:17",
+		"m4":  "a.qtpl:16:4",
+		"m5":  "a.qtpl:17:22",
+		"m6":  "a.qtpl:19:10",
+		"m7":  "a.qtpl:24:8",
+		"m8":  "a.qtpl:26:3",
+		"m9":  "a.qtpl:27:11",
+		"m10": "a.qtpl:30:2",
+		"m11": "a.qtpl:32:2",
+		"m12": "a.qtpl:37:2",
+	}
+
+	tf := &templateFile{
+		filename:    "a.qtpl",
+		src:         []byte(src),
+		packageName: "foo",
+		opts:        &parseOptions{},
+	}
+	code, err := tf.generateCode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fset := gotoken.NewFileSet()
+	f, err := goparser.ParseFile(fset, "a.qtpl.go", code, goparser.ParseComments)
+	if err != nil {
+		t.Fatalf("cannot parse generated code: %s\n%s", err, code)
+	}
+	sm := newSourceMap(code, "a.qtpl.go", "a.qtpl")
+	found := make(map[string]bool)
+	ast.Inspect(f, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok || !strings.HasPrefix(id.Name, "m") {
+			return true
+		}
+		expectedPos, ok := expectedPositions[id.Name]
+		if !ok {
+			return true
+		}
+		found[id.Name] = true
+		pos := fset.Position(id.Pos())
+		if s := fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Column); s != expectedPos {
+			t.Fatalf("unexpected position for %s: %s. Expecting %s\n%s", id.Name, s, expectedPos, code)
+		}
+		line, col := sm.Position(fset.File(id.Pos()).Offset(id.Pos()), code)
+		if s := fmt.Sprintf("a.qtpl:%d:%d", line, col); s != expectedPos {
+			t.Fatalf("unexpected source map position for %s: %s. Expecting %s", id.Name, s, expectedPos)
+		}
+		return true
+	})
+	if len(found) != len(expectedPositions) {
+		t.Fatalf("unexpected identifiers found: %v. Expecting %v", found, expectedPositions)
+	}
+
+	// Redundant directives are removed, so struct fields are aligned.
+	if !strings.Contains(string(code), "\tA   int\n\tm12 string\n") {
+		t.Fatalf("struct fields aren't aligned in the generated code:\n%s", code)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+// lspServer is the Language Server Protocol server for template files.
+//
+// The server communicates with the client over r and w. Only full text
+// synchronization is supported, so the client sends the whole document
+// on each change.
+type lspServer struct {
+	r *bufio.Reader
+	w io.Writer
+
+	// docs contains the contents of the documents opened by the client
+	// keyed by file path. The opened documents are used instead of
+	// the files on disk.
+	docs map[string][]byte
+
+	// packages contains package names for templates per directory.
+	// The package name is determined from the files on disk, so it is
+	// dropped when a document in the directory is saved.
+	// See getFilePackageName for details.
+	packages map[string]string
+
+	shutdown bool
+}
+
+// runLSP runs the language server, which reads client messages from r
+// and writes server messages to w until the client sends exit notification.
+func runLSP(r io.Reader, w io.Writer) error {
+	srv := &lspServer{
+		r:        bufio.NewReader(r),
+		w:        w,
+		docs:     make(map[string][]byte),
+		packages: make(map[string]string),
+	}
+	for {
+		msg, err := srv.readMessage()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			if !srv.shutdown {
+				return fmt.Errorf("exit notification received before shutdown request")
+			}
+			return nil
+		}
+		if err := srv.handleMessage(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// lspMessage is JSON-RPC 2.0 message.
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	lspErrInvalidParams  = -32602
+	lspErrMethodNotFound = -32601
+)
+
+func (srv *lspServer) readMessage() (*lspMessage, error) {
+	tr := textproto.NewReader(srv.r)
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("cannot read message header: %s", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(srv.r, body); err != nil {
+		return nil, fmt.Errorf("cannot read message body: %s", err)
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("cannot parse message %q: %s", body, err)
+	}
+	return &msg, nil
+}
+
+func (srv *lspServer) writeMessage(msg *lspMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("cannot marshal message: %s", err)
+	}
+	if _, err := fmt.Fprintf(srv.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		return fmt.Errorf("cannot write message: %s", err)
+	}
+	return nil
+}
+
+func (srv *lspServer) notify(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %s params: %s", method, err)
+	}
+	return srv.writeMessage(&lspMessage{
+		Method: method,
+		Params: data,
+	})
+}
+
+func (srv *lspServer) handleMessage(msg *lspMessage) error {
+	result, rpcErr := srv.handleRequest(msg)
+	if len(msg.ID) == 0 {
+		// Notifications have no responses.
+		if rpcErr != nil {
+			logger.Printf("cannot handle %s notification: %s", msg.Method, rpcErr.Message)
+		}
+		return nil
+	}
+	resp := &lspMessage{
+		ID:    msg.ID,
+		Error: rpcErr,
+	}
+	if rpcErr == nil {
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		resp.Result = result
+	}
+	return srv.writeMessage(resp)
+}
+
+func (srv *lspServer) handleRequest(msg *lspMessage) (interface{}, *lspError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Full text synchronization.
+				"textDocumentSync":   1,
+				"definitionProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"%", " "},
+				},
+			},
+			"serverInfo": map[string]string{
+				"name":    "qtc",
+				"version": qtcVersion,
+			},
+		}, nil
+	case "initialized", "$/cancelRequest", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		srv.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, srv.updateDoc(params.TextDocument.URI, []byte(params.TextDocument.Text))
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, srv.updateDoc(params.TextDocument.URI, []byte(text))
+	case "textDocument/didSave":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(srv.packages, filepath.Dir(path))
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(srv.docs, path)
+		if err := srv.publishDiagnostics(params.TextDocument.URI, nil); err != nil {
+			return nil, &lspError{
+				Message: err.Error(),
+			}
+		}
+		return nil, nil
+	case "textDocument/definition", "textDocument/hover", "textDocument/completion":
+		var params struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Position lspPosition `json:"position"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		path, err := uriToPath(params.TextDocument.URI)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+		src, err := srv.readDoc(path)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+		line, pos := params.Position.bytePos(src)
+		switch msg.Method {
+		case "textDocument/definition":
+			return srv.definition(path, src, line, pos), nil
+		case "textDocument/hover":
+			return srv.hover(path, src, line, pos), nil
+		default:
+			return completion(src, line, pos), nil
+		}
+	default:
+		if len(msg.ID) == 0 {
+			// Unknown notifications are ignored.
+			return nil, nil
+		}
+		return nil, &lspError{
+			Code:    lspErrMethodNotFound,
+			Message: fmt.Sprintf("unsupported method %q", msg.Method),
+		}
+	}
+}
+
+func invalidParams(err error) *lspError {
+	return &lspError{
+		Code:    lspErrInvalidParams,
+		Message: err.Error(),
+	}
+}
+
+// updateDoc stores the given document contents and publishes diagnostics
+// for the document.
+func (srv *lspServer) updateDoc(uri string, src []byte) *lspError {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return invalidParams(err)
+	}
+	srv.docs[path] = src
+	if err := srv.publishDiagnostics(uri, srv.diagnostics(path, src)); err != nil {
+		return &lspError{
+			Message: err.Error(),
+		}
+	}
+	return nil
+}
+
+// readDoc returns the contents of the document at the given path.
+//
+// The contents of the opened document is returned if the document
+// is opened by the client.
+func (srv *lspServer) readDoc(path string) ([]byte, error) {
+	if src, ok := srv.docs[path]; ok {
+		return src, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+	Code     string   `json:"code,omitempty"`
+}
+
+// lspSeverityError is LSP severity for errors.
+const lspSeverityError = 1
+
+func (srv *lspServer) publishDiagnostics(uri string, diagnostics []lspDiagnostic) error {
+	if diagnostics == nil {
+		diagnostics = []lspDiagnostic{}
+	}
+	return srv.notify("textDocument/publishDiagnostics", map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+}
+
+// diagnostics returns diagnostics for the template file at the given path
+// with the given contents.
+func (srv *lspServer) diagnostics(path string, src []byte) []lspDiagnostic {
+	packageName := srv.packageName(path)
+	errorFuncs := make(map[string]bool)
+	for _, filename := range srv.packageFiles(path) {
+		if docSrc, err := srv.readDoc(filename); err == nil {
+			collectErrorFuncs(bytes.NewReader(docSrc), filename, errorFuncs)
+		}
+	}
+	var w bytes.Buffer
+	err := parseWithOptions(&w, bytes.NewReader(src), path, packageName, newParseOptions(getFileConfig(path), errorFuncs))
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(parseErrors)
+	if !ok {
+		return []lspDiagnostic{{
+			Severity: lspSeverityError,
+			Source:   "qtc",
+			Message:  err.Error(),
+		}}
+	}
+	diagnostics := make([]lspDiagnostic, 0, len(errs))
+	for _, e := range errs {
+		diagnostics = append(diagnostics, lspDiagnostic{
+			Range: lspRange{
+				Start: newLSPPosition(src, e.line-1, e.col-1),
+				End:   newLSPPosition(src, e.endLine-1, e.endCol-1),
+			},
+			Severity: lspSeverityError,
+			Source:   "qtc",
+			Message:  e.message(),
+			Code:     e.tagName,
+		})
+	}
+	return diagnostics
+}
+
+// packageFiles returns template files in the directory containing
+// the given template file, including the documents opened by the client.
+func (srv *lspServer) packageFiles(path string) []string {
+	dir := filepath.Dir(path)
+	exts := getFileConfig(path).exts
+	filenames, _ := globTemplates(dir, exts)
+	seen := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		seen[filename] = true
+	}
+	for filename := range srv.docs {
+		if !seen[filename] && filepath.Dir(filename) == dir && hasTemplateExt(filename, exts) {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames
+}
+
+// packageName returns the package name for the template file
+// at the given path.
+//
+// The package name is cached per directory, so template files aren't
+// read on every change of the document.
+func (srv *lspServer) packageName(path string) string {
+	dir := filepath.Dir(path)
+	if packageName, ok := srv.packages[dir]; ok {
+		return packageName
+	}
+	outfile, err := getOutFilename(path)
+	if err != nil {
+		outfile = path + ".go"
+	}
+	packageName, err := getPackageName(path, outfile)
+	if err != nil {
+		packageName = "templates"
+	}
+	srv.packages[dir] = packageName
+	return packageName
+}
+
+// uriToPath returns file path for the given file:// uri.
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("cannot parse uri %q: %s", uri, err)
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported uri scheme in %q. Only file uris are supported", uri)
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// pathToURI returns file:// uri for the given absolute file path.
+func pathToURI(path string) string {
+	u := url.URL{
+		Scheme: "file",
+		Path:   filepath.ToSlash(path),
+	}
+	return u.String()
+}
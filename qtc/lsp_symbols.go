@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"strings"
+	"unicode/utf8"
+)
+
+type lspPosition struct {
+	// Line is 0-based line number.
+	Line int `json:"line"`
+
+	// Character is 0-based offset in the line measured in UTF-16 code units.
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+// newLSPPosition returns LSP position for the given 0-based line
+// and byte pos in src.
+func newLSPPosition(src []byte, line, pos int) lspPosition {
+	lineStr := sourceLine(src, line)
+	if pos > len(lineStr) {
+		pos = len(lineStr)
+	}
+	character := 0
+	for _, r := range string(lineStr[:pos]) {
+		character++
+		if r >= 0x10000 {
+			// The rune is encoded with surrogate pair in UTF-16.
+			character++
+		}
+	}
+	return lspPosition{
+		Line:      line,
+		Character: character,
+	}
+}
+
+// bytePos returns 0-based line and byte pos in src for lp.
+func (lp lspPosition) bytePos(src []byte) (int, int) {
+	lineStr := sourceLine(src, lp.Line)
+	pos := 0
+	character := 0
+	for pos < len(lineStr) && character < lp.Character {
+		r, size := utf8.DecodeRune(lineStr[pos:])
+		pos += size
+		character++
+		if r >= 0x10000 {
+			character++
+		}
+	}
+	return lp.Line, pos
+}
+
+// sourceLine returns the given 0-based line of src without line ending.
+func sourceLine(src []byte, line int) []byte {
+	offset := lineOffset(src, line)
+	if offset < 0 {
+		return nil
+	}
+	src = src[offset:]
+	if n := bytes.IndexByte(src, '\n'); n >= 0 {
+		src = src[:n]
+	}
+	return bytes.TrimSuffix(src, []byte("\r"))
+}
+
+// advancePos returns the position following b starting at the given
+// 0-based line and byte pos.
+func advancePos(line, pos int, b []byte) (int, int) {
+	for _, c := range b {
+		if c == '\n' {
+			line++
+			pos = 0
+		} else {
+			pos++
+		}
+	}
+	return line, pos
+}
+
+// templateTag is the tag found in template file.
+type templateTag struct {
+	name     string
+	contents []byte
+
+	// startLine and startPos contain 0-based position of the tag start.
+	startLine int
+	startPos  int
+
+	// endLine and endPos contain 0-based position following the tag end.
+	endLine int
+	endPos  int
+
+	// contentsLine and contentsPos contain 0-based position
+	// of the tag contents.
+	contentsLine int
+	contentsPos  int
+}
+
+// contains returns true if the given 0-based position is inside the tag.
+func (t *templateTag) contains(line, pos int) bool {
+	if line < t.startLine || (line == t.startLine && pos < t.startPos) {
+		return false
+	}
+	return line < t.endLine || (line == t.endLine && pos < t.endPos)
+}
+
+// scanTags returns the tags found in the given template source.
+//
+// The tags found before the first syntax error are returned
+// for broken templates.
+func scanTags(src []byte, filePath string) []*templateTag {
+	var tags []*templateTag
+	s := newScanner(bytes.NewReader(src), filePath)
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName {
+			continue
+		}
+		tag := &templateTag{
+			name:      string(t.Value),
+			startLine: s.tagLine,
+			startPos:  s.tagPos,
+		}
+		tag.endLine, tag.endPos = tagEnd(src, tag.startLine, tag.startPos)
+		if !s.Next() {
+			break
+		}
+		t = s.Token()
+		if t.ID == tagContents {
+			tag.contents = append([]byte(nil), t.Value...)
+			tag.contentsLine = t.line
+			tag.contentsPos = t.pos
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// findTag returns the tag at the given 0-based position or nil.
+func findTag(tags []*templateTag, line, pos int) *templateTag {
+	for _, tag := range tags {
+		if tag.contains(line, pos) {
+			return tag
+		}
+	}
+	return nil
+}
+
+// templateSymbol is template func or interface method declared
+// in template file.
+type templateSymbol struct {
+	f        *funcType
+	filename string
+
+	// isMethod is set for methods of template funcs and interface methods.
+	isMethod bool
+
+	// inInterface is set for interface methods.
+	inInterface bool
+
+	// line, startPos and endPos contain 0-based position of the symbol name.
+	line     int
+	startPos int
+	endPos   int
+}
+
+// fileSymbols returns template funcs and interface methods declared
+// in the given template source.
+func fileSymbols(src []byte, filename string) []*templateSymbol {
+	var symbols []*templateSymbol
+	for _, tag := range scanTags(src, filename) {
+		switch tag.name {
+		case "func":
+			f, err := parseFuncDef(tag.contents)
+			if err != nil {
+				continue
+			}
+			n := indexFuncName(string(tag.contents), f.name)
+			if n < 0 {
+				continue
+			}
+			line, pos := advancePos(tag.contentsLine, tag.contentsPos, tag.contents[:n])
+			symbols = append(symbols, &templateSymbol{
+				f:        f,
+				filename: filename,
+				isMethod: len(f.defPrefix) > 0,
+				line:     line,
+				startPos: pos,
+				endPos:   pos + len(f.name),
+			})
+		case "interface", "iface":
+			n := bytes.IndexByte(tag.contents, '{')
+			if n < 0 {
+				continue
+			}
+			exprPrefix := "interface "
+			exprStr := exprPrefix + string(tag.contents[n:])
+			expr, err := goparser.ParseExpr(exprStr)
+			if err != nil {
+				continue
+			}
+			it, ok := expr.(*ast.InterfaceType)
+			if !ok {
+				continue
+			}
+			for _, m := range it.Methods.List {
+				if len(m.Names) == 0 {
+					// embedded interface
+					continue
+				}
+				f, err := parseFuncDef([]byte(exprStr[m.Pos()-1 : m.End()-1]))
+				if err != nil {
+					continue
+				}
+				offset := n + int(m.Names[0].Pos()) - 1 - len(exprPrefix)
+				line, pos := advancePos(tag.contentsLine, tag.contentsPos, tag.contents[:offset])
+				symbols = append(symbols, &templateSymbol{
+					f:           f,
+					filename:    filename,
+					isMethod:    true,
+					inInterface: true,
+					line:        line,
+					startPos:    pos,
+					endPos:      pos + len(f.name),
+				})
+			}
+		}
+	}
+	return symbols
+}
+
+// indexFuncName returns the index of the func name in the given func definition
+// or -1 if the name isn't found.
+func indexFuncName(def, name string) int {
+	offset := 0
+	for {
+		n := strings.Index(def[offset:], name)
+		if n < 0 {
+			return -1
+		}
+		n += offset
+		offset = n + len(name)
+		if n > 0 && isIdentByte(def[n-1]) {
+			continue
+		}
+		rest := strings.TrimLeft(def[offset:], " \t\r\n")
+		if strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, "[") {
+			return n
+		}
+	}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// packageSymbols returns template funcs and interface methods declared
+// in template files located in the directory with the given template file.
+func (srv *lspServer) packageSymbols(path string) []*templateSymbol {
+	var symbols []*templateSymbol
+	for _, filename := range srv.packageFiles(path) {
+		src, err := srv.readDoc(filename)
+		if err != nil {
+			continue
+		}
+		symbols = append(symbols, fileSymbols(src, filename)...)
+	}
+	return symbols
+}
+
+// callSymbols returns the symbols called by the tag at the given position.
+//
+// nil is returned if there is no call tag at the given position.
+func (srv *lspServer) callSymbols(path string, src []byte, line, pos int) []*templateSymbol {
+	tag := findTag(scanTags(src, path), line, pos)
+	if tag == nil || (tag.name != "=" && tag.name != "call") {
+		return nil
+	}
+	f, err := parseFuncCall(tag.contents)
+	if err != nil {
+		return nil
+	}
+	isMethod := len(f.callPrefix) > 0
+	var symbols []*templateSymbol
+	for _, sym := range srv.packageSymbols(path) {
+		if sym.f.name == f.name && sym.isMethod == isMethod {
+			symbols = append(symbols, sym)
+		}
+	}
+	return symbols
+}
+
+// definition returns locations of the funcs and interface methods
+// called by the tag at the given position.
+func (srv *lspServer) definition(path string, src []byte, line, pos int) []lspLocation {
+	symbols := srv.callSymbols(path, src, line, pos)
+	if len(symbols) == 0 {
+		return nil
+	}
+	locations := make([]lspLocation, 0, len(symbols))
+	for _, sym := range symbols {
+		symSrc, err := srv.readDoc(sym.filename)
+		if err != nil {
+			continue
+		}
+		locations = append(locations, lspLocation{
+			URI: pathToURI(sym.filename),
+			Range: lspRange{
+				Start: newLSPPosition(symSrc, sym.line, sym.startPos),
+				End:   newLSPPosition(symSrc, sym.line, sym.endPos),
+			},
+		})
+	}
+	return locations
+}
+
+type lspHover struct {
+	Contents lspMarkupContent `json:"contents"`
+}
+
+type lspMarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// hover returns the signatures of the funcs generated for the func tag
+// or for the funcs called by the tag at the given position.
+func (srv *lspServer) hover(path string, src []byte, line, pos int) *lspHover {
+	var symbols []*templateSymbol
+	tag := findTag(scanTags(src, path), line, pos)
+	for _, sym := range fileSymbols(src, path) {
+		isDeclared := sym.line == line && pos >= sym.startPos && pos < sym.endPos
+		if isDeclared || (tag != nil && tag.name == "func" && tag.contains(sym.line, sym.startPos)) {
+			symbols = append(symbols, sym)
+		}
+	}
+	if len(symbols) == 0 {
+		symbols = srv.callSymbols(path, src, line, pos)
+	}
+	if len(symbols) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("```go\n")
+	for i, sym := range symbols {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(generatedSignatures(sym))
+	}
+	b.WriteString("```")
+	return &lspHover{
+		Contents: lspMarkupContent{
+			Kind:  "markdown",
+			Value: b.String(),
+		},
+	}
+}
+
+// signatureReplacer replaces mangled names in the generated signatures
+// with readable names.
+var signatureReplacer = strings.NewReplacer(
+	"qt"+mangleSuffix+".", "quicktemplate.",
+	"qtio"+mangleSuffix+".", "io.",
+	"qtctx"+mangleSuffix+".", "context.",
+)
+
+// generatedSignatures returns the signatures of Stream*, Write* and string
+// funcs generated for sym.
+func generatedSignatures(sym *templateSymbol) string {
+	f := *sym.f
+	f.withErrors = f.withErrors || *withErrors
+	f.withContext = *withContext
+	prefix := "func "
+	if sym.inInterface {
+		prefix = ""
+	}
+	return signatureReplacer.Replace(fmt.Sprintf("%s%s\n%s%s\n%s%s\n",
+		prefix, f.DefString(),
+		prefix, f.DefStream("qw"),
+		prefix, f.DefWrite("w")))
+}
+
+type lspCompletionItem struct {
+	Label string `json:"label"`
+	Kind  int    `json:"kind"`
+}
+
+// lspCompletionKeyword is LSP completion item kind for keywords.
+const lspCompletionKeyword = 14
+
+// completion returns tag names, which may be completed at the given position.
+//
+// Tag names are completed only after {%.
+func completion(src []byte, line, pos int) []lspCompletionItem {
+	lineStr := sourceLine(src, line)
+	if pos > len(lineStr) {
+		pos = len(lineStr)
+	}
+	lineStr = lineStr[:pos]
+	n := bytes.LastIndex(lineStr, strTagOpen)
+	if n < 0 {
+		return nil
+	}
+	prefix := bytes.TrimLeft(lineStr[n+len(strTagOpen):], " \t")
+	for _, c := range prefix {
+		if !isIdentByte(c) && c != '=' && c != '.' {
+			// The tag name is already completed.
+			return nil
+		}
+	}
+	items := []lspCompletionItem{}
+	for _, name := range knownTags {
+		if strings.HasPrefix(name, string(prefix)) {
+			items = append(items, lspCompletionItem{
+				Label: name,
+				Kind:  lspCompletionKeyword,
+			})
+		}
+	}
+	return items
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLSP(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	fileB := filepath.Join(dir, "b.qtpl")
+	srcB := "{% interface Page { Title() } %}\n{% func (b *Base) Title() %}t{% endfunc %}\n{% func Bar(n int) %}{% endfunc %}"
+	if err := ioutil.WriteFile(fileB, []byte(srcB), 0666); err != nil {
+		t.Fatalf("cannot write file %q: %s", fileB, err)
+	}
+	uriA := pathToURI(filepath.Join(dir, "a.qtpl"))
+	uriB := pathToURI(fileB)
+
+	c := newLSPClient(t)
+	c.call("initialize", map[string]interface{}{})
+	c.notify("initialized", map[string]interface{}{})
+
+	// diagnostics
+	c.notify("textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri":  uriA,
+			"text": "{% func Foo(p Page) %}\n{%= Bar(1) %}{%= p.Title() %}\n\t{% edfor %}{% endfunc %}",
+		},
+	})
+	var diagnostics struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	c.readNotification("textDocument/publishDiagnostics", &diagnostics)
+	if diagnostics.URI != uriA || len(diagnostics.Diagnostics) != 1 {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	d := diagnostics.Diagnostics[0]
+	expectedRange := lspRange{
+		Start: lspPosition{Line: 2, Character: 1},
+		End:   lspPosition{Line: 2, Character: 12},
+	}
+	if d.Range != expectedRange || d.Code != "edfor" || !strings.Contains(d.Message, `Did you mean "endfor"?`) || strings.Contains(d.Message, ".qtpl:") {
+		t.Fatalf("unexpected diagnostic: %+v", d)
+	}
+
+	// go to func definition
+	var locations []lspLocation
+	c.call("textDocument/definition", lspPositionParams(uriA, 1, 5)).decode(t, &locations)
+	expectedLocations := []lspLocation{
+		{URI: uriB, Range: lspRange{Start: lspPosition{Line: 2, Character: 8}, End: lspPosition{Line: 2, Character: 11}}},
+	}
+	testLSPLocations(t, locations, expectedLocations)
+
+	// go to method definitions
+	c.call("textDocument/definition", lspPositionParams(uriA, 1, 20)).decode(t, &locations)
+	expectedLocations = []lspLocation{
+		{URI: uriB, Range: lspRange{Start: lspPosition{Line: 0, Character: 20}, End: lspPosition{Line: 0, Character: 25}}},
+		{URI: uriB, Range: lspRange{Start: lspPosition{Line: 1, Character: 18}, End: lspPosition{Line: 1, Character: 23}}},
+	}
+	testLSPLocations(t, locations, expectedLocations)
+
+	// no definition outside call tags
+	c.call("textDocument/definition", lspPositionParams(uriA, 2, 0)).decode(t, &locations)
+	testLSPLocations(t, locations, nil)
+
+	// hover
+	var hover *lspHover
+	c.call("textDocument/hover", lspPositionParams(uriA, 0, 9)).decode(t, &hover)
+	expectedHover := "```go\nfunc Foo(p Page) string\nfunc StreamFoo(qw *quicktemplate.Writer, p Page)\nfunc WriteFoo(w io.Writer, p Page)\n```"
+	if hover == nil || hover.Contents.Value != expectedHover {
+		t.Fatalf("unexpected hover: %+v. Expecting %q", hover, expectedHover)
+	}
+	c.call("textDocument/hover", lspPositionParams(uriA, 1, 5)).decode(t, &hover)
+	expectedHover = "```go\nfunc Bar(n int) string\nfunc StreamBar(qw *quicktemplate.Writer, n int)\nfunc WriteBar(w io.Writer, n int)\n```"
+	if hover == nil || hover.Contents.Value != expectedHover {
+		t.Fatalf("unexpected hover: %+v. Expecting %q", hover, expectedHover)
+	}
+
+	// completion
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": uriA,
+		},
+		"contentChanges": []map[string]interface{}{
+			{"text": "{% func Foo() %}{% endf"},
+		},
+	})
+	c.readNotification("textDocument/publishDiagnostics", &diagnostics)
+	var items []lspCompletionItem
+	c.call("textDocument/completion", lspPositionParams(uriA, 0, 23)).decode(t, &items)
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if strings.Join(labels, ",") != "endfunc,endfragment,endfor" {
+		t.Fatalf("unexpected completion items: %q", labels)
+	}
+
+	// unsupported methods
+	resp := c.call("textDocument/rename", map[string]interface{}{})
+	if resp.Error == nil || resp.Error.Code != lspErrMethodNotFound {
+		t.Fatalf("expecting method not found error; got %+v", resp.Error)
+	}
+
+	c.call("shutdown", nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestLSPPackageName(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	path := filepath.Join(dir, "a.qtpl")
+	writeWatchedFile(t, path, `{% func A() %}{% endfunc %}`)
+
+	srv := &lspServer{
+		docs:     make(map[string][]byte),
+		packages: make(map[string]string),
+	}
+	testLSPPackageName(t, srv, path, "templates")
+
+	// the package name is cached until the document is saved
+	writeWatchedFile(t, filepath.Join(dir, "helpers.go"), "package views\n")
+	testLSPPackageName(t, srv, path, "templates")
+	params, err := json.Marshal(map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": pathToURI(path),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, lerr := srv.handleRequest(&lspMessage{Method: "textDocument/didSave", Params: params}); lerr != nil {
+		t.Fatalf("unexpected error: %+v", lerr)
+	}
+	testLSPPackageName(t, srv, path, "views")
+}
+
+func testLSPPackageName(t *testing.T, srv *lspServer, path, expectedName string) {
+	t.Helper()
+	if name := srv.packageName(path); name != expectedName {
+		t.Fatalf("unexpected package name: %q. Expecting %q", name, expectedName)
+	}
+}
+
+func TestLSPPosition(t *testing.T) {
+	src := []byte("a\nдо 𝄞 {% foo %}\n")
+	lp := newLSPPosition(src, 1, 9)
+	if lp != (lspPosition{Line: 1, Character: 5}) {
+		t.Fatalf("unexpected position: %+v", lp)
+	}
+	line, pos := lp.bytePos(src)
+	if line != 1 || pos != 9 {
+		t.Fatalf("unexpected byte position: line %d, pos %d. Expecting line 1, pos 9", line, pos)
+	}
+}
+
+func testLSPLocations(t *testing.T, locations, expected []lspLocation) {
+	t.Helper()
+	if len(locations) != len(expected) {
+		t.Fatalf("unexpected locations: %+v. Expecting %+v", locations, expected)
+	}
+	for i := range locations {
+		if locations[i] != expected[i] {
+			t.Fatalf("unexpected location #%d: %+v. Expecting %+v", i, locations[i], expected[i])
+		}
+	}
+}
+
+func lspPositionParams(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]interface{}{
+			"uri": uri,
+		},
+		"position": lspPosition{
+			Line:      line,
+			Character: character,
+		},
+	}
+}
+
+// lspClient is scripted LSP client for testing the language server.
+type lspClient struct {
+	t      *testing.T
+	w      io.Writer
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+}
+
+type lspClientMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *lspError       `json:"error"`
+}
+
+func (m *lspClientMessage) decode(t *testing.T, dst interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(m.Result, dst); err != nil {
+		t.Fatalf("cannot decode result %q: %s", m.Result, err)
+	}
+}
+
+func newLSPClient(t *testing.T) *lspClient {
+	serverR, clientW := io.Pipe()
+	clientR, serverW := io.Pipe()
+	c := &lspClient{
+		t:    t,
+		w:    clientW,
+		r:    bufio.NewReader(clientR),
+		done: make(chan error, 1),
+	}
+	go func() {
+		c.done <- runLSP(serverR, serverW)
+		serverW.Close()
+	}()
+	return c
+}
+
+func (c *lspClient) write(msg map[string]interface{}) {
+	c.t.Helper()
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatalf("cannot marshal message: %s", err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("cannot write message: %s", err)
+	}
+}
+
+func (c *lspClient) read() *lspClientMessage {
+	c.t.Helper()
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("cannot read message header: %s", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("cannot parse Content-Length: %s", err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf("cannot read message body: %s", err)
+	}
+	var msg lspClientMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("cannot parse message %q: %s", body, err)
+	}
+	return &msg
+}
+
+func (c *lspClient) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.write(map[string]interface{}{
+		"method": method,
+		"params": params,
+	})
+}
+
+func (c *lspClient) call(method string, params interface{}) *lspClientMessage {
+	c.t.Helper()
+	c.nextID++
+	id := c.nextID
+	c.write(map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": params,
+	})
+	msg := c.read()
+	if msg.ID == nil || *msg.ID != id {
+		c.t.Fatalf("unexpected response to %s: %+v", method, msg)
+	}
+	return msg
+}
+
+func (c *lspClient) readNotification(method string, dst interface{}) {
+	c.t.Helper()
+	msg := c.read()
+	if msg.Method != method {
+		c.t.Fatalf("unexpected message %+v. Expecting %s notification", msg, method)
+	}
+	if err := json.Unmarshal(msg.Params, dst); err != nil {
+		c.t.Fatalf("cannot decode %s params: %s", method, err)
+	}
+}
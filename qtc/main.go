@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"flag"
-	"go/format"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
 )
 
 var (
@@ -20,37 +23,229 @@ var (
 	file = flag.String("file", "", "Path to template file to compile.\n"+
 		"Flags -dir and -ext are ignored if file is set.\n"+
 		"The compiled file will be placed near the original file with .go extension added.")
-	ext = flag.String("ext", "qtpl", "Only files with this extension are compiled")
+	ext = flag.String("ext", "qtpl", "Only files with this extension are compiled.\n"+
+		"The flag overrides extensions set in qtc.toml files if it is set explicitly.")
+
+	outDir = flag.String("out", "", "Path to directory for the generated Go files.\n"+
+		"The directory tree with template files at -dir is mirrored into this directory.\n"+
+		"The package name for the generated files is determined by their' location in this directory.\n"+
+		"By default the generated files are placed near template files.")
+
+	withErrors = flag.Bool("errors", false, "Generate Stream* and Write* funcs returning error.\n"+
+		"The returned error is the first error occurred when writing to the underlying writer.\n"+
+		"Loops in the generated code are stopped as soon as the writer fails.")
+
+	autoEscape = flag.Bool("autoescape", false, "Select escaping for output tags from the surrounding html context such as js, css or url escaping.\n"+
+		"By default all the output tags are html-escaped regardless of the context.\n"+
+		"The flag changes the code generated for output tags inside <script>, <style> and url attributes,\n"+
+		"so templates building js, css or urls by hand must be reviewed before enabling it.")
+
+	withContext = flag.Bool("context", false, "Generate funcs accepting context.Context as the first arg.\n"+
+		"The context is passed to nested templates and is available in templates as ctx.\n"+
+		"Loops in the generated code are stopped when the context is canceled.")
+	contextCheckInterval = flag.Int("ctxcheck", defaultContextCheckInterval, "The number of loop iterations between ctx.Err() checks.\n"+
+		"The flag is used only if -context is set.")
+
+	variants = flag.String("variants", allVariants.String(), "Comma-separated list of variants generated for exported template funcs.\n"+
+		"Supported variants: stream for StreamFoo, write for WriteFoo and string for Foo. stream variant is required.\n"+
+		"The variants may be overridden in template files via variants tag.\n"+
+		"The flag overrides variants set in qtc.toml files if it is set explicitly.")
+	unexportedVariants = flag.String("unexportedvariants", allVariants.String(), "Comma-separated list of variants generated for unexported template funcs.\n"+
+		"For instance, -unexportedvariants=stream,write drops unused string variants of unexported funcs. See -variants for details.")
+
+	watch = flag.Bool("watch", false, "Keep running and compile template files as soon as they change.\n"+
+		"Only the changed files are compiled. Compilation errors are logged without exiting.")
+	check = flag.Bool("check", false, "Check whether the generated Go files are up to date without writing anything.\n"+
+		"Template files are compiled in memory and compared to the existing Go files.\n"+
+		"The compiler exits with non-zero code if stale or missing Go files are found.")
+
+	jobsCount = flag.Int("j", runtime.NumCPU(), "The maximum number of template files compiled concurrently")
+
+	force = flag.Bool("force", false, "Compile template files even if the generated Go files are up to date.\n"+
+		"By default template files are skipped if their' sources, qtc version and flags remain the same\n"+
+		"since the previous compilation.")
+
+	typeCheck = flag.Bool("typecheck", false, "Type-check the generated code together with the code generated for other template files\n"+
+		"in the directory and with sibling .go files before writing the generated code.\n"+
+		"Type errors are reported against template files. Imported packages are type-checked from source,\n"+
+		"so the compilation becomes slower.")
+
+	withSourceMap = flag.Bool("sourcemap", false, "Write source map near each generated Go file. The .go extension is replaced with .map extension in the source map name.\n"+
+		"The source map is JSON mapping byte offsets in the generated Go file to template positions.")
+
+	jsonOutput = flag.Bool("json", false, "Write errors found in template files to stdout as JSON objects one per line.\n"+
+		"Each object contains file, line, col, endLine, endCol, severity, message and tag fields.")
+
+	watchInterval = flag.Duration("watchinterval", 500*time.Millisecond, "The interval between checks for changed template files.\n"+
+		"The flag is used only if -watch is set.")
 )
 
-var logger = log.New(os.Stderr, "qtc: ", log.LstdFlags)
+const defaultLoggerFlags = log.LstdFlags
+
+var logger = log.New(os.Stderr, "qtc: ", defaultLoggerFlags)
 
-var filesCompiled int
+// defaultVariants and defaultUnexportedVariants contain the variants
+// parsed from -variants and -unexportedvariants flags.
+var defaultVariants, defaultUnexportedVariants funcVariants
 
 func main() {
 	flag.Parse()
 
-	if len(*file) > 0 {
-		compileSingleFile(*file)
-		return
+	if *watch && *watchInterval <= 0 {
+		logger.Fatalf("watchinterval must be positive")
+	}
+	if *watch && *check {
+		logger.Fatalf("watch and check flags cannot be used together")
+	}
+	if *jobsCount <= 0 {
+		logger.Fatalf("j must be positive")
+	}
+	var err error
+	if defaultVariants, err = parseVariants(*variants); err != nil {
+		logger.Fatalf("invalid variants: %s", err)
+	}
+	if defaultUnexportedVariants, err = parseVariants(*unexportedVariants); err != nil {
+		logger.Fatalf("invalid unexportedvariants: %s", err)
 	}
-
 	if len(*ext) == 0 {
 		logger.Fatalf("ext cannot be empty")
 	}
 	if len(*dir) == 0 {
 		*dir = "."
 	}
+	if *contextCheckInterval <= 0 {
+		logger.Fatalf("ctxcheck must be positive")
+	}
 	if (*ext)[0] != '.' {
 		*ext = "." + *ext
 	}
 
+	if len(*file) > 0 {
+		if *watch {
+			logger.Printf("Watching template file %q", *file)
+			watchTemplates(*file, *watchInterval)
+		}
+		if *check {
+			logger.Printf("Checking template file %q", *file)
+			checkTemplates(getFileJobs(*file))
+			return
+		}
+		compileTemplates(getFileJobs(*file))
+		return
+	}
+
+	if flag.Arg(0) == "fmt" {
+		if !runFmt(flag.Args()[1:]) {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "vet" {
+		if !runVet(flag.Args()[1:]) {
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "lsp" {
+		logger.Printf("Starting language server for *%s template files", *ext)
+		if err := runLSP(os.Stdin, os.Stdout); err != nil {
+			logger.Fatalf("language server error: %s", err)
+		}
+		return
+	}
+	if *watch {
+		logger.Printf("Watching *%s template files in directory %q", *ext, *dir)
+		watchTemplates(*dir, *watchInterval)
+	}
+	if *check {
+		logger.Printf("Checking *%s template files in directory %q", *ext, *dir)
+		checkTemplates(getDirJobs(*dir, nil))
+		return
+	}
 	logger.Printf("Compiling *%s template files in directory %q", *ext, *dir)
-	compileDir(*dir)
-	logger.Printf("Total files compiled: %d", filesCompiled)
+	compileTemplates(getDirJobs(*dir, nil))
 }
 
-func compileSingleFile(filename string) {
+// compileTemplates compiles template files for the given jobs
+// and exits on errors.
+func compileTemplates(jobs []*compileJob) {
+	n, errs := runJobs(jobs, compileJobFile)
+	skipped := 0
+	for _, j := range jobs {
+		if j.skipped {
+			skipped++
+		}
+	}
+	reportErrors(errs)
+	if len(errs) > 0 {
+		logger.Fatalf("Found %d errors. Total files compiled: %d, skipped: %d", len(errs), n-skipped, skipped)
+	}
+	logger.Printf("Total files compiled: %d, skipped: %d", n-skipped, skipped)
+}
+
+// newParseOptions returns parse options for template files
+// with the given config.
+func newParseOptions(cfg *config, errorFuncs map[string]bool) *parseOptions {
+	return &parseOptions{
+		withErrors:           *withErrors,
+		withContext:          *withContext,
+		noAutoEscape:         !*autoEscape,
+		contextCheckInterval: *contextCheckInterval,
+		errorFuncs:           errorFuncs,
+		variants:             cfg.variants,
+		unexportedVariants:   cfg.unexportedVariants,
+		textMode:             cfg.textMode,
+		runtimePath:          cfg.runtimePath,
+		header:               cfg.header,
+		whitespace:           cfg.whitespace,
+	}
+}
+
+// getErrorFuncs returns funcs declared with error result in template files
+// with the given exts located in the given dir.
+//
+// Broken template files are skipped after collecting the funcs declared
+// before the error, since the errors are reported when compiling the files.
+func getErrorFuncs(dir string, exts []string) (map[string]bool, error) {
+	filenames, err := globTemplates(dir, exts)
+	if err != nil {
+		return nil, err
+	}
+	errorFuncs := make(map[string]bool)
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			continue
+		}
+		collectErrorFuncs(f, filename, errorFuncs)
+		f.Close()
+	}
+	return errorFuncs, nil
+}
+
+// getOutFilename returns the name of the Go file generated
+// for the given template file.
+//
+// The Go file is placed near the template file with .go extension added
+// unless -out is set. Otherwise the path of the template file relative
+// to -dir (or to the directory of -file) is mirrored into -out directory.
+func getOutFilename(infile string) (string, error) {
+	if len(*outDir) == 0 {
+		return infile + ".go", nil
+	}
+	root := *dir
+	if len(*file) > 0 {
+		root = filepath.Dir(*file)
+	}
+	rel, err := filepath.Rel(root, infile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("cannot determine output file for %q: the file is located outside %q", infile, root)
+	}
+	return filepath.Join(*outDir, rel+".go"), nil
+}
+
+// getFileJobs returns the job for compiling the given template file.
+func getFileJobs(filename string) []*compileJob {
 	fi, err := os.Stat(filename)
 	if err != nil {
 		logger.Fatalf("cannot stat file %q: %s", filename, err)
@@ -58,10 +253,23 @@ func compileSingleFile(filename string) {
 	if fi.IsDir() {
 		logger.Fatalf("cannot compile directory %q. Use -dir flag", filename)
 	}
-	compileFile(filename)
+	errorFuncs, err := getErrorFuncs(filepath.Dir(filename), getFileConfig(filename).exts)
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+	packageName, err := getFilePackageName(filename)
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+	return []*compileJob{newCompileJob(filename, errorFuncs, packageName)}
 }
 
-func compileDir(path string) {
+// getDirJobs appends jobs for compiling template files in the given dir
+// and its subdirectories to dst and returns the result.
+//
+// The jobs are sorted by file path, so the compilation output
+// is deterministic.
+func getDirJobs(path string, dst []*compileJob) []*compileJob {
 	fi, err := os.Stat(path)
 	if err != nil {
 		logger.Fatalf("cannot compile files in %q: %s", path, err)
@@ -79,6 +287,9 @@ func compileDir(path string) {
 	if err != nil {
 		logger.Fatalf("cannot read files in %q: %s", path, err)
 	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
 
 	var names []string
 	for _, fi = range fis {
@@ -90,63 +301,94 @@ func compileDir(path string) {
 			names = append(names, name)
 		} else {
 			subPath := filepath.Join(path, name)
-			compileDir(subPath)
+			dst = getDirJobs(subPath, dst)
 		}
 	}
-	sort.Strings(names)
 
+	cfg, err := getConfig(path)
+	if err != nil {
+		logger.Fatalf("%s", err)
+	}
+	var errorFuncs map[string]bool
+	var packageName string
 	for _, name := range names {
-		if strings.HasSuffix(name, *ext) {
+		if hasTemplateExt(name, cfg.exts) {
 			filename := filepath.Join(path, name)
-			compileFile(filename)
+			if errorFuncs == nil {
+				if errorFuncs, err = getErrorFuncs(path, cfg.exts); err != nil {
+					logger.Fatalf("%s", err)
+				}
+				if packageName, err = getFilePackageName(filename); err != nil {
+					logger.Fatalf("%s", err)
+				}
+			}
+			dst = append(dst, newCompileJob(filename, errorFuncs, packageName))
 		}
 	}
+	return dst
 }
 
-func compileFile(infile string) {
-	outfile := infile + ".go"
-	logger.Printf("Compiling %q to %q...", infile, outfile)
-
-	inf, err := os.Open(infile)
+// compileJobFile compiles the template file for the given job.
+func compileJobFile(j *compileJob) error {
+	compiled, err := compileFile(j.filename, j.errorFuncs, j.packageName)
 	if err != nil {
-		logger.Fatalf("cannot open file %q: %s", infile, err)
+		return err
 	}
-
-	tmpfile := outfile + ".tmp"
-	outf, err := os.Create(tmpfile)
-	if err != nil {
-		logger.Fatalf("cannot create file %q: %s", tmpfile, err)
+	if compiled {
+		outfile, _ := getOutFilename(j.filename)
+		j.logf("Compiling %q to %q...", j.filename, outfile)
+	} else {
+		j.logf("Skipping %q, since it is unchanged", j.filename)
+		j.skipped = true
 	}
+	return nil
+}
 
-	packageName, err := getPackageName(infile)
+// compileFile compiles the given template file into Go file.
+//
+// The template file isn't compiled if the Go file has been generated
+// from the same sources by the same qtc version unless -force is set.
+// The Go file isn't rewritten if its' contents remain the same.
+// compileFile returns false if the template file isn't compiled.
+func compileFile(infile string, errorFuncs map[string]bool, packageName string) (bool, error) {
+	tf, err := readTemplateFile(infile, errorFuncs, packageName)
 	if err != nil {
-		logger.Fatalf("cannot determine package name for %q: %s", infile, err)
+		return false, err
 	}
-	if err = parse(outf, inf, infile, packageName); err != nil {
-		logger.Fatalf("error when parsing file %q: %s", infile, err)
+	outfile := tf.outfile
+	mapfile := strings.TrimSuffix(outfile, ".go") + ".map"
+	if !*force && readSourceHash(outfile) == tf.opts.sourceHash && (!*withSourceMap || fileExists(mapfile)) {
+		return false, nil
 	}
-	if err = outf.Close(); err != nil {
-		logger.Fatalf("error when closing file %q: %s", tmpfile, err)
-	}
-	if err = inf.Close(); err != nil {
-		logger.Fatalf("error when closing file %q: %s", infile, err)
-	}
-
-	// prettify the output file
-	uglyCode, err := ioutil.ReadFile(tmpfile)
+	code, err := tf.generateCode()
 	if err != nil {
-		logger.Fatalf("cannot read file %q: %s", tmpfile, err)
+		return false, err
 	}
-	prettyCode, err := format.Source(uglyCode)
-	if err != nil {
-		logger.Fatalf("error when formatting compiled code for %q: %s. See %q for details", infile, err, tmpfile)
+	if *typeCheck {
+		if err := typeCheckTemplate(infile, errorFuncs, packageName); err != nil {
+			return false, err
+		}
 	}
-	if err = ioutil.WriteFile(outfile, prettyCode, 0666); err != nil {
-		logger.Fatalf("error when writing file %q: %s", outfile, err)
+	if oldCode, err := ioutil.ReadFile(outfile); err != nil || !bytes.Equal(code, oldCode) {
+		if err = os.MkdirAll(filepath.Dir(outfile), 0777); err != nil {
+			return false, fmt.Errorf("cannot create directory for file %q: %s", outfile, err)
+		}
+		if err = ioutil.WriteFile(outfile, code, 0666); err != nil {
+			return false, fmt.Errorf("error when writing file %q: %s", outfile, err)
+		}
 	}
-	if err = os.Remove(tmpfile); err != nil {
-		logger.Fatalf("error when removing file %q: %s", tmpfile, err)
+	if *withSourceMap {
+		data := newSourceMap(code, outfile, infile).marshal()
+		if oldData, err := ioutil.ReadFile(mapfile); err != nil || !bytes.Equal(data, oldData) {
+			if err = ioutil.WriteFile(mapfile, data, 0666); err != nil {
+				return false, fmt.Errorf("error when writing file %q: %s", mapfile, err)
+			}
+		}
 	}
+	return true, nil
+}
 
-	filesCompiled++
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetOutFilename(t *testing.T) {
+	testGetOutFilename(t, "", "", "templates/a.qtpl", "templates/a.qtpl.go")
+	testGetOutFilename(t, "gen", "", "templates/a.qtpl", "gen/a.qtpl.go")
+	testGetOutFilename(t, "gen", "", "templates/foo/bar/a.qtpl", "gen/foo/bar/a.qtpl.go")
+	testGetOutFilename(t, "/tmp/gen", "", "templates/foo/a.qtpl", "/tmp/gen/foo/a.qtpl.go")
+	testGetOutFilename(t, "gen", "templates/foo/a.qtpl", "templates/foo/a.qtpl", "gen/a.qtpl.go")
+
+	// the file outside -dir
+	*dir = "templates"
+	*outDir = "gen"
+	defer func() {
+		*dir = "."
+		*outDir = ""
+	}()
+	if _, err := getOutFilename("other/a.qtpl"); err == nil {
+		t.Fatalf("expecting error for the file outside -dir")
+	}
+}
+
+func testGetOutFilename(t *testing.T, out, filename, infile, expectedOutfile string) {
+	t.Helper()
+	*dir = "templates"
+	*outDir = out
+	*file = filename
+	defer func() {
+		*dir = "."
+		*outDir = ""
+		*file = ""
+	}()
+	outfile, err := getOutFilename(infile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if outfile != filepath.FromSlash(expectedOutfile) {
+		t.Fatalf("unexpected outfile for %q: %q. Expecting %q", infile, outfile, expectedOutfile)
+	}
+}
+
+func TestCompileFileOutDir(t *testing.T) {
+	templatesDir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(templatesDir))
+	infile := filepath.Join(templatesDir, "foo", "a.qtpl")
+	if err := os.Mkdir(filepath.Dir(infile), 0777); err != nil {
+		t.Fatalf("cannot create dir: %s", err)
+	}
+	writeWatchedFile(t, infile, `{% func A() %}a{% endfunc %}`)
+
+	*dir = filepath.Dir(infile)
+	*outDir = filepath.Join(filepath.Dir(templatesDir), "gen", "views")
+	defer func() {
+		*dir = "."
+		*outDir = ""
+	}()
+	testCompileFile(t, infile, nil, true)
+
+	if _, err := os.Stat(infile + ".go"); !os.IsNotExist(err) {
+		t.Fatalf("unexpected file generated near the template file")
+	}
+	outfile := filepath.Join(*outDir, "a.qtpl.go")
+	code, err := ioutil.ReadFile(outfile)
+	if err != nil {
+		t.Fatalf("cannot read the generated file: %s", err)
+	}
+	if !strings.Contains(string(code), "\npackage views\n") {
+		t.Fatalf("unexpected package name in the generated code:\n%s", code)
+	}
+
+	// unchanged file is skipped
+	testCompileFile(t, infile, nil, false)
+
+	// the generated file is checked in -out directory
+	problem, err := checkFile(infile, nil, getTestPackageName(t, infile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(problem) > 0 {
+		t.Fatalf("unexpected problem: %s", problem)
+	}
+}
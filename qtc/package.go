@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// getPackageName returns the package name for the Go file outfile
+// generated from the template file infile.
+//
+// The package name is determined in the following order:
+//
+//   - {% package name %} tags in template files located in the directory
+//     with infile.
+//   - Package clauses in .go files located in the directory with outfile
+//     excluding tests and files generated by qtc.
+//   - The name of the directory with outfile. Major version suffixes
+//     such as v2 are skipped, so the name of the parent directory is used.
+//
+// An error is returned if the package tags disagree with each other
+// or with .go files.
+//
+// The package name is the same for all the template files in the directory
+// and its' determination reads all of them, so it is determined once
+// per directory. See getFilePackageName.
+func getPackageName(infile, outfile string) (string, error) {
+	exts := getFileConfig(infile).exts
+	tagPackage, tagFile, err := getTemplatesPackage(infile, exts)
+	if err != nil {
+		return "", err
+	}
+	goDir := filepath.Dir(outfile)
+	goPackage, goFile, err := getGoFilesPackage(goDir, exts)
+	if err != nil {
+		return "", err
+	}
+	if len(tagPackage) > 0 {
+		if len(goPackage) > 0 && goPackage != tagPackage {
+			return "", fmt.Errorf("package %q declared in %q doesn't match package %q in %q", tagPackage, tagFile, goPackage, goFile)
+		}
+		return tagPackage, nil
+	}
+	if len(goPackage) > 0 {
+		return goPackage, nil
+	}
+	return getDirPackageName(goDir)
+}
+
+// getFilePackageName returns the package name for template files located
+// in the directory with the given template file.
+func getFilePackageName(filename string) (string, error) {
+	outfile, err := getOutFilename(filename)
+	if err != nil {
+		return "", err
+	}
+	packageName, err := getPackageName(filename, outfile)
+	if err != nil {
+		return "", fmt.Errorf("cannot determine package name for templates in %q: %s", filepath.Dir(filename), err)
+	}
+	return packageName, nil
+}
+
+// getTemplatesPackage returns the package declared via {% package %} tags
+// in template files with the given exts located in the directory with infile
+// together with the name of the first file containing the tag.
+//
+// Empty package is returned if the templates contain no package tags.
+func getTemplatesPackage(infile string, exts []string) (string, string, error) {
+	filenames := []string{infile}
+	if len(exts) > 0 {
+		var err error
+		filenames, err = globTemplates(filepath.Dir(infile), exts)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	var pkg, pkgFile string
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			continue
+		}
+		name := readPackageTag(f, filename)
+		f.Close()
+		if len(name) == 0 {
+			continue
+		}
+		if len(pkg) == 0 {
+			pkg, pkgFile = name, filename
+			continue
+		}
+		if name != pkg {
+			return "", "", fmt.Errorf("templates in %q declare different packages: %q in %q and %q in %q",
+				filepath.Dir(infile), pkg, pkgFile, name, filename)
+		}
+	}
+	return pkg, pkgFile, nil
+}
+
+// readPackageTag returns the package name from {% package %} tag
+// in the template read from r.
+//
+// Empty name is returned if the template doesn't start with package tag.
+// Broken package tags are reported by the parser.
+func readPackageTag(r io.Reader, filePath string) string {
+	s := newScanner(r, filePath)
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName {
+			continue
+		}
+		switch string(t.Value) {
+		case "package":
+			if s.Next() && s.Token().ID == tagContents {
+				return string(s.Token().Value)
+			}
+			return ""
+		case "import":
+			// The package tag may follow imports, so the parser
+			// reports the misplaced tag.
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// getGoFilesPackage returns the package declared in .go files located
+// in dir together with the name of the first file declaring the package.
+//
+// Tests, files excluded by build constraints and files generated by qtc
+// from template files with the given exts are skipped. Empty package
+// is returned if dir contains no such files.
+func getGoFilesPackage(dir string, exts []string) (string, string, error) {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", "", fmt.Errorf("cannot read files in %q: %s", dir, err)
+	}
+	fset := gotoken.NewFileSet()
+	var pkg, pkgFile string
+	for _, filename := range filenames {
+		name := filepath.Base(filename)
+		if strings.HasSuffix(name, "_test.go") || hasTemplateExt(strings.TrimSuffix(name, ".go"), exts) {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		f, err := goparser.ParseFile(fset, filename, nil, goparser.PackageClauseOnly)
+		if err != nil {
+			// Syntax errors in .go files are left for go build.
+			continue
+		}
+		if len(pkg) == 0 {
+			pkg, pkgFile = f.Name.Name, filename
+			continue
+		}
+		if f.Name.Name != pkg {
+			return "", "", fmt.Errorf(".go files in %q declare different packages: %q in %q and %q in %q",
+				dir, pkg, pkgFile, f.Name.Name, filename)
+		}
+	}
+	return pkg, pkgFile, nil
+}
+
+// majorVersionRe matches major version suffixes in import paths such as v2.
+var majorVersionRe = regexp.MustCompile(`^v([2-9]|[1-9][0-9]+)$`)
+
+// getDirPackageName returns the package name derived from the name of dir.
+func getDirPackageName(dir string) (string, error) {
+	dirAbs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(dirAbs)
+	if majorVersionRe.MatchString(name) {
+		name = filepath.Base(filepath.Dir(dirAbs))
+	}
+	if !isValidPackageName(name) {
+		return "", fmt.Errorf("directory name %q isn't valid package name. Use {%% package name %%} tag in template files", name)
+	}
+	return name, nil
+}
+
+func isValidPackageName(name string) bool {
+	return gotoken.IsIdentifier(name) && name != "_"
+}
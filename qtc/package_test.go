@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetPackageName(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	infile := filepath.Join(dir, "a.qtpl")
+	outfile := infile + ".go"
+	writeWatchedFile(t, infile, `{% func A() %}{% endfunc %}`)
+
+	// directory name
+	testGetPackageName(t, infile, outfile, "templates")
+
+	// package clause in .go files
+	writeWatchedFile(t, filepath.Join(dir, "helpers.go"), "// helpers\npackage views\n")
+	testGetPackageName(t, infile, outfile, "views")
+
+	// tests, ignored files and files generated by qtc are skipped
+	writeWatchedFile(t, filepath.Join(dir, "helpers_test.go"), "package views_test\n")
+	writeWatchedFile(t, filepath.Join(dir, "gen.go"), "//go:build ignore\n\npackage main\n")
+	writeWatchedFile(t, filepath.Join(dir, "b.qtpl.go"), "package templates\n")
+	testGetPackageName(t, infile, outfile, "views")
+
+	// package tag in other template
+	writeWatchedFile(t, filepath.Join(dir, "b.qtpl"), "{% package views %}\n{% func B() %}{% endfunc %}")
+	testGetPackageName(t, infile, outfile, "views")
+
+	// package tag disagreeing with .go files
+	writeWatchedFile(t, filepath.Join(dir, "b.qtpl"), "{% package pages %}\n{% func B() %}{% endfunc %}")
+	testGetPackageNameFailure(t, infile, outfile, `package "pages" declared in`)
+
+	// package tags disagreeing with each other
+	os.Remove(filepath.Join(dir, "helpers.go"))
+	testGetPackageName(t, infile, outfile, "pages")
+	writeWatchedFile(t, infile, "{% import \"fmt\" %}\n{% package views %}")
+	testGetPackageNameFailure(t, infile, outfile, `templates in "`+dir+`" declare different packages`)
+
+	// .go files disagreeing with each other
+	writeWatchedFile(t, infile, `{% func A() %}{% endfunc %}`)
+	os.Remove(filepath.Join(dir, "b.qtpl"))
+	writeWatchedFile(t, filepath.Join(dir, "c.go"), "package views\n")
+	writeWatchedFile(t, filepath.Join(dir, "d.go"), "package pages\n")
+	testGetPackageNameFailure(t, infile, outfile, `.go files in "`+dir+`" declare different packages`)
+}
+
+func TestGetDirPackageName(t *testing.T) {
+	testGetDirPackageName(t, "/foo/templates", "templates")
+	testGetDirPackageName(t, "/foo/bar/v2", "bar")
+	testGetDirPackageName(t, "/foo/bar/v12", "bar")
+	testGetDirPackageName(t, "/foo/bar/v1", "v1")
+
+	for _, dir := range []string{"/foo/email-templates", "/foo/2fa", "/foo/func", "/foo/email-templates/v2"} {
+		if name, err := getDirPackageName(dir); err == nil {
+			t.Fatalf("expecting error for %q. Got package name %q", dir, name)
+		}
+	}
+}
+
+func testGetDirPackageName(t *testing.T, dir, expectedName string) {
+	t.Helper()
+	name, err := getDirPackageName(dir)
+	if err != nil {
+		t.Fatalf("unexpected error for %q: %s", dir, err)
+	}
+	if name != expectedName {
+		t.Fatalf("unexpected package name for %q: %q. Expecting %q", dir, name, expectedName)
+	}
+}
+
+func TestReadPackageTag(t *testing.T) {
+	testReadPackageTag(t, `{% package foo %}`, "foo")
+	testReadPackageTag(t, "comment\n{% import \"bar\" %}\n{%  package   foo %}\n{% func A() %}{% endfunc %}", "foo")
+	testReadPackageTag(t, `{% func A() %}{% endfunc %}{% package foo %}`, "")
+	testReadPackageTag(t, `{% package %}`, "")
+	testReadPackageTag(t, `foobar`, "")
+}
+
+func testReadPackageTag(t *testing.T, src, expectedName string) {
+	t.Helper()
+	name := readPackageTag(strings.NewReader(src), "a.qtpl")
+	if name != expectedName {
+		t.Fatalf("unexpected package name for %q: %q. Expecting %q", src, name, expectedName)
+	}
+}
+
+func getTestPackageName(t *testing.T, filename string) string {
+	t.Helper()
+	packageName, err := getFilePackageName(filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return packageName
+}
+
+func testGetPackageName(t *testing.T, infile, outfile, expectedName string) {
+	t.Helper()
+	name, err := getPackageName(infile, outfile)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if name != expectedName {
+		t.Fatalf("unexpected package name: %q. Expecting %q", name, expectedName)
+	}
+}
+
+func testGetPackageNameFailure(t *testing.T, infile, outfile, expectedErr string) {
+	t.Helper()
+	_, err := getPackageName(infile, outfile)
+	if err == nil {
+		t.Fatalf("expecting error")
+	}
+	if !strings.Contains(err.Error(), expectedErr) {
+		t.Fatalf("unexpected error: %s. Expecting %q", err, expectedErr)
+	}
+}
@@ -7,88 +7,333 @@ import (
 	goparser "go/parser"
 	gotoken "go/token"
 	"io"
+	"io/ioutil"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 )
 
+// parseOptions contains optional settings for the generated code.
+type parseOptions struct {
+	// withErrors makes generated Stream* and Write* funcs return
+	// the first error occurred when writing to the underlying writer.
+	withErrors bool
+
+	// withContext makes all the generated funcs accept context.Context
+	// as the first arg. The context is available in templates as ctx.
+	withContext bool
+
+	// contextCheckInterval is the number of loop iterations between
+	// ctx.Err() checks in the generated loops.
+	contextCheckInterval int
+
+	// errorFuncs contains funcs and methods declared with error result
+	// in the templates of the package. See funcType.errorFuncKey.
+	errorFuncs map[string]bool
+
+	// variants contains the variants generated for exported funcs,
+	// while unexportedVariants contains the variants generated
+	// for unexported funcs. All the variants are generated if zero.
+	// Variants tag overrides these options.
+	variants           funcVariants
+	unexportedVariants funcVariants
+
+	// blockContexts collects the html contexts of the blocks
+	// in layout funcs keyed by "layout.block" if isn't nil.
+	// See getLayoutBlockContexts for details.
+	blockContexts map[string]escContext
+
+	// noAutoEscape disables selecting escaping for output tags
+	// from the html context, so all the escaped output tags are html-escaped.
+	// This is useful for non-html templates, which may contain '<'.
+	noAutoEscape bool
+
+	// textMode disables html escaping in output tags.
+	textMode bool
+
+	// runtimePath is the import path of quicktemplate package.
+	// defaultRuntimePath is used if it is empty.
+	runtimePath string
+
+	// header is the comment written at the top of the generated code.
+	header string
+
+	// whitespace is the whitespace mode for text outside stripspace
+	// and collapsespace tags.
+	whitespace whitespaceMode
+
+	// sourceHash is the hash of the template sources written
+	// to the header of the generated code. See templateFile.sourceHash.
+	sourceHash string
+
+	// vet enables vet checks. Found problems are collected in vet.
+	// See qtc vet command.
+	vet *vetter
+}
+
 type parser struct {
 	s                 *scanner
 	w                 io.Writer
+	src               []byte
 	packageName       string
+	opts              parseOptions
 	prefix            string
 	forDepth          int
 	switchDepth       int
 	skipOutputDepth   int
 	importsUseEmitted bool
+
+	// packageFound is set after package tag.
+	packageFound bool
+
+	// importsFound is set after the first import tag.
+	importsFound bool
+
+	// imports contains import paths keyed by package names.
+	imports map[string]string
+
+	// variants contains the variants set via variants tag
+	// for the subsequent funcs.
+	variants funcVariants
+
+	// esc is the html context of the static text emitted so far
+	// in the current func. It is used for selecting the proper escaping
+	// for output tags.
+	esc escContext
+
+	// escDead is set when the current branch is terminated
+	// by return, break or continue, so its' html context mustn't be joined
+	// with the context of other branches.
+	escDead bool
+
+	// funcTerminated is set when the current func ends with return tag.
+	funcTerminated bool
+
+	// funcWithErrors is set when the current func returns error.
+	funcWithErrors bool
+
+	// argTypes contains the types of the current func args keyed
+	// by arg names. It is set only when vetting.
+	argTypes map[string]string
+
+	// varTypes contains the types of the current func receiver, args,
+	// range loop vars and vars declared in code tags keyed by var names.
+	// It is used for resolving method calls in parser.resolveCallErrors.
+	varTypes map[string]ast.Expr
+
+	// slotArgs contains quicktemplate.Slot args of the current func.
+	slotArgs map[string]bool
+
+	// slotErrVar is the variable for errors occurred inside the current
+	// slot closure, which cannot return errors.
+	slotErrVar string
+
+	// callsCount is the number of call tags in the current func.
+	callsCount int
+
+	// bufferTag is the innermost tag buffering the output of the current
+	// func such as capture or push. Return, break and continue tags cannot
+	// leave such tags, since the buffer must be finalized.
+	bufferTag string
+
+	// loopsCount is the number of loops in the current func.
+	loopsCount int
+
+	// layoutFunc is the func, which may contain block tags.
+	// It is nil inside block tags.
+	layoutFunc *funcType
+
+	// blocks contains blocks found in layoutFunc.
+	blocks []*layoutBlock
+
+	// extends contains the layout extended by the blocks
+	// outside funcs.
+	extends *layoutExtends
+
+	// fragments contains the names of fragments found in the current func.
+	fragments []string
+
+	// inFragment is set inside fragment tag.
+	inFragment bool
+
+	// fragmentPass is set when parsing the func body for the fragment func.
+	fragmentPass bool
+
+	// skipFragmentOutput is set in fragment pass outside fragments.
+	skipFragmentOutput bool
 }
 
 func parse(w io.Writer, r io.Reader, filePath, packageName string) error {
+	return parseWithOptions(w, r, filePath, packageName, &parseOptions{})
+}
+
+func parseWithOptions(w io.Writer, r io.Reader, filePath, packageName string, opts *parseOptions) error {
+	// The template source is kept in memory for error excerpts.
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errorf("cannot read %q: %s", filePath, err)
+	}
 	p := &parser{
-		s:           newScanner(r, filePath),
+		s:           newScanner(bytes.NewReader(src), filePath),
 		w:           w,
+		src:         src,
 		packageName: packageName,
+		opts:        *opts,
 	}
+	p.s.whitespace = opts.whitespace
 	return p.parseTemplate()
 }
 
 func (p *parser) parseTemplate() error {
 	s := p.s
+	if len(p.opts.header) > 0 {
+		fmt.Fprintf(p.w, "%s\n", p.opts.header)
+	}
 	fmt.Fprintf(p.w, `// This file is automatically generated by qtc from %q.
 // See https://github.com/valyala/quicktemplate for details.
-
 `,
 		filepath.Base(s.filePath))
+	if len(p.opts.sourceHash) > 0 {
+		fmt.Fprintf(p.w, "// qtc version: %s\n%s%s\n", qtcVersion, sourceHashPrefix, p.opts.sourceHash)
+	}
+	fmt.Fprintf(p.w, "\n")
 	p.Printf("package %s\n", p.packageName)
-	p.Printf(`import (
+	runtimePath := p.opts.runtimePath
+	if len(runtimePath) == 0 {
+		runtimePath = defaultRuntimePath
+	}
+	if p.opts.withContext {
+		p.Printf(`import (
+	qtctx%s "context"
 	qtio%s "io"
 
-	qt%s "github.com/valyala/quicktemplate"
+	qt%s %q
 )
-`, mangleSuffix, mangleSuffix)
+`, mangleSuffix, mangleSuffix, mangleSuffix, runtimePath)
+	} else {
+		p.Printf(`import (
+	qtio%s "io"
+
+	qt%s %q
+)
+`, mangleSuffix, mangleSuffix, runtimePath)
+	}
+	var errs parseErrors
 	for s.Next() {
-		t := s.Token()
-		switch t.ID {
-		case text:
-			p.emitComment(t.Value)
-		case tagName:
-			if string(t.Value) == "import" {
-				if p.importsUseEmitted {
-					return fmt.Errorf("imports must be at the top of the template. Found at %s", s.Context())
-				}
-				if err := p.parseImport(); err != nil {
-					return err
-				}
-			} else {
-				p.emitImportsUse()
-				switch string(t.Value) {
-				case "interface", "iface":
-					if err := p.parseInterface(); err != nil {
-						return err
-					}
-				case "code":
-					if err := p.parseTemplateCode(); err != nil {
-						return err
-					}
-				case "func":
-					if err := p.parseFunc(); err != nil {
-						return err
-					}
-				default:
-					return fmt.Errorf("unexpected tag found outside func: %q at %s", t.Value, s.Context())
-				}
+		state := *p
+		topTag := ""
+		if t := s.Token(); t.ID == tagName {
+			topTag = string(t.Value)
+		}
+		if err := p.parseTopLevelToken(); err != nil {
+			errs = append(errs, p.newParseError(err))
+			if s.LastError() != nil {
+				// The scanner cannot proceed after errors.
+				return errs
+			}
+			s.stopRecording()
+			*p = state
+			if !p.skipBrokenTag(topTag) {
+				break
 			}
-		default:
-			return fmt.Errorf("unexpected token found %s outside func at %s", t, s.Context())
 		}
 	}
 	p.emitImportsUse()
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse template: %s", err)
+		errs = append(errs, p.newParseError(errorf("cannot parse template: %s", err)))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// parseTopLevelToken parses the current token outside funcs.
+func (p *parser) parseTopLevelToken() error {
+	s := p.s
+	t := s.Token()
+	switch t.ID {
+	case text:
+		p.emitComment(t.Value)
+	case tagName:
+		if string(t.Value) == "package" {
+			if p.packageFound {
+				return errorf("duplicate package tag found at %s", s.Context())
+			}
+			if p.importsFound || p.importsUseEmitted {
+				return errorf("package tag must be at the top of the template before imports. Found at %s", s.Context())
+			}
+			return p.parsePackage()
+		}
+		if string(t.Value) == "import" {
+			if p.importsUseEmitted {
+				return errorf("imports must be at the top of the template. Found at %s", s.Context())
+			}
+			p.importsFound = true
+			return p.parseImport()
+		}
+		p.emitImportsUse()
+		switch string(t.Value) {
+		case "variants":
+			return p.parseVariants()
+		case "interface", "iface":
+			return p.parseInterface()
+		case "code":
+			return p.parseTemplateCode()
+		case "func":
+			return p.parseFunc()
+		case "extends":
+			return p.parseExtends()
+		case "block":
+			return p.parseBlockOverride()
+		default:
+			return errorf("unexpected tag found outside func: %q at %s%s", t.Value, s.Context(), suggestTag(t.Value))
+		}
+	default:
+		return errorf("unexpected token found %s outside func at %s", t, s.Context())
 	}
 	return nil
 }
 
+// skipBrokenTag skips the rest of the top-level tag with the given name
+// after the error, so the parsing may be resumed after the tag.
+//
+// Funcs and block overrides are skipped up to the corresponding end tag.
+// skipBrokenTag returns false if the end of the template is reached.
+func (p *parser) skipBrokenTag(tag string) bool {
+	s := p.s
+	if t := s.Token(); t.ID == tagName && len(tag) > 0 && string(t.Value) == tag {
+		// Skip the contents of the broken tag.
+		if !s.Next() {
+			return false
+		}
+	}
+	var endTag string
+	switch tag {
+	case "func":
+		endTag = "endfunc"
+	case "block":
+		endTag = "endblock"
+	default:
+		return true
+	}
+	if s.lastTagName == endTag {
+		// The error has been found at the end tag.
+		if s.Token().ID == tagName {
+			return s.Next()
+		}
+		return true
+	}
+	for s.Next() {
+		t := s.Token()
+		if t.ID == tagName && string(t.Value) == endTag {
+			return s.Next()
+		}
+	}
+	return false
+}
+
 func (p *parser) emitComment(comment []byte) {
 	isFirstNonemptyLine := false
 	for len(comment) > 0 {
@@ -125,11 +370,20 @@ func (p *parser) emitImportsUse() {
 	if p.importsUseEmitted {
 		return
 	}
-	p.Printf(`var (
+	if p.opts.withContext {
+		p.Printf(`var (
+	_ = qtctx%s.Background
+	_ = qtio%s.Copy
+	_ = qt%s.AcquireByteBuffer
+)
+`, mangleSuffix, mangleSuffix, mangleSuffix)
+	} else {
+		p.Printf(`var (
 	_ = qtio%s.Copy
 	_ = qt%s.AcquireByteBuffer
 )
 `, mangleSuffix, mangleSuffix)
+	}
 	p.importsUseEmitted = true
 }
 
@@ -142,9 +396,68 @@ func (p *parser) parseFunc() error {
 	funcStr := "func " + string(t.Value)
 	f, err := parseFuncDef(t.Value)
 	if err != nil {
-		return fmt.Errorf("error in %q at %s: %s", funcStr, s.Context(), err)
+		return errorf("error in %q at %s: %s", funcStr, s.Context(), err)
 	}
+	f.slotArgs = p.findSlotArgs(f)
+	p.applyOptions(f)
+	var vetErr *parseError
+	if p.opts.vet != nil {
+		vetErr = p.newParseError(nil)
+		p.argTypes = funcArgTypes(f)
+	}
+
+	// Block tags turn the func into a layout accepting blocks as the first arg,
+	// so the func code is emitted only after the func is parsed.
+	var lineComment bytes.Buffer
+	s.WriteLineComment(&lineComment)
+	w := p.w
+	var code bytes.Buffer
+	p.w = &code
+	p.layoutFunc = f
+	p.blocks = nil
+	p.fragments = nil
 	p.emitFuncStart(f)
+	s.startRecording()
+	if err := p.parseFuncBody(funcStr, "endfunc"); err != nil {
+		return err
+	}
+	tokens := s.stopRecording()
+	p.w = w
+	p.layoutFunc = nil
+	blocks := p.blocks
+	p.blocks = nil
+	fragments := p.fragments
+	p.fragments = nil
+	if len(blocks) > 0 {
+		if len(fragments) > 0 {
+			return errorf("fragments cannot be used together with blocks in %q at %s", funcStr, s.Context())
+		}
+		addLayoutArg(f)
+	}
+	if vetErr != nil {
+		body := code.Bytes()
+		for _, b := range blocks {
+			body = append(body, b.code.Bytes()...)
+		}
+		p.vetUnusedArgs(vetErr, f, body)
+	}
+	fmt.Fprintf(w, "%sfunc %s {\n", lineComment.Bytes(), f.DefStream("qw"+mangleSuffix))
+	if _, err := w.Write(code.Bytes()); err != nil {
+		return err
+	}
+	p.emitFuncEnd(f)
+	if len(blocks) > 0 {
+		return p.emitLayout(f, blocks)
+	}
+	if len(fragments) > 0 {
+		return p.emitFragmentFunc(f, funcStr, tokens, lineComment.Bytes())
+	}
+	return nil
+}
+
+// parseFuncBody parses func body until endTag.
+func (p *parser) parseFuncBody(funcStr, endTag string) error {
+	s := p.s
 	for s.Next() {
 		t := s.Token()
 		switch t.ID {
@@ -153,29 +466,25 @@ func (p *parser) parseFunc() error {
 		case tagName:
 			ok, err := p.tryParseCommonTags(t.Value)
 			if err != nil {
-				return fmt.Errorf("error in %q: %s", funcStr, err)
+				return errorf("error in %q: %s", funcStr, err)
 			}
 			if ok {
 				continue
 			}
 			switch string(t.Value) {
-			case "endfunc":
-				if err = skipTagContents(s); err != nil {
-					return err
-				}
-				p.emitFuncEnd(f)
-				return nil
+			case endTag:
+				return skipTagContents(s)
 			default:
-				return fmt.Errorf("unexpected tag found in %q: %q at %s", funcStr, t.Value, s.Context())
+				return errorf("unexpected tag found in %q: %q at %s%s", funcStr, t.Value, s.Context(), suggestTag(t.Value))
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing %q: %s at %s", funcStr, t, s.Context())
+			return errorf("unexpected token found when parsing %q: %s at %s", funcStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse %q: %s", funcStr, err)
+		return errorf("cannot parse %q: %s", funcStr, err)
 	}
-	return fmt.Errorf("cannot find endfunc tag for %q at %s", funcStr, s.Context())
+	return errorf("cannot find %s tag for %q at %s", endTag, funcStr, s.Context())
 }
 
 func (p *parser) parseFor() error {
@@ -186,11 +495,39 @@ func (p *parser) parseFor() error {
 	}
 	forStr := "for " + string(t.Value)
 	if err = validateForStmt(t.Value); err != nil {
-		return fmt.Errorf("invalid statement %q at %s: %s", forStr, s.Context(), err)
+		return errorf("invalid statement %q at %s: %s", forStr, s.Context(), err)
+	}
+	loopCounter := ""
+	if p.opts.withContext {
+		p.loopsCount++
+		loopCounter = fmt.Sprintf("qi%s_%d", mangleSuffix, p.loopsCount)
+		p.Printf("%s := 0", loopCounter)
 	}
 	p.Printf("for %s {", t.Value)
 	p.prefix += "\t"
 	p.forDepth++
+	varTypes := p.varTypes
+	p.varTypes = rangeVarTypes(t.Value, varTypes)
+	defer func() { p.varTypes = varTypes }()
+	if p.funcWithErrors {
+		// Stop the loop as soon as the underlying writer fails.
+		p.Printf("if qw%s.Err() != nil {", mangleSuffix)
+		p.Printf("\treturn qw%s.Err()", mangleSuffix)
+		p.Printf("}")
+	}
+	if p.opts.withContext {
+		// Stop the loop when ctx is canceled. ctx.Err() isn't free,
+		// so it is checked only every contextCheckInterval iterations.
+		p.Printf("%s++", loopCounter)
+		p.Printf("if %s%%%d == 0 && %s.Err() != nil {", loopCounter, p.contextCheckInterval(), contextArg)
+		if p.funcWithErrors || len(p.slotErrVar) > 0 {
+			p.emitReturnError(contextArg + ".Err()")
+		} else {
+			p.Printf("\treturn")
+		}
+		p.Printf("}")
+	}
+	escStart := p.esc
 	for s.Next() {
 		t := s.Token()
 		switch t.ID {
@@ -199,7 +536,7 @@ func (p *parser) parseFor() error {
 		case tagName:
 			ok, err := p.tryParseCommonTags(t.Value)
 			if err != nil {
-				return fmt.Errorf("error in %q: %s", forStr, err)
+				return errorf("error in %q: %s", forStr, err)
 			}
 			if ok {
 				continue
@@ -212,18 +549,24 @@ func (p *parser) parseFor() error {
 				p.forDepth--
 				p.prefix = p.prefix[1:]
 				p.Printf("}")
+				var bj escJoiner
+				bj.add(p, p.esc)
+				bj.add(p, escStart)
+				if err = bj.finish(p, escStart); err != nil {
+					return errorf("error in %q at %s: %s", forStr, s.Context(), err)
+				}
 				return nil
 			default:
-				return fmt.Errorf("unexpected tag found in %q: %q at %s", forStr, t.Value, s.Context())
+				return errorf("unexpected tag found in %q: %q at %s%s", forStr, t.Value, s.Context(), suggestTag(t.Value))
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing %q: %s at %s", forStr, t, s.Context())
+			return errorf("unexpected token found when parsing %q: %s at %s", forStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse %q: %s", forStr, err)
+		return errorf("cannot parse %q: %s", forStr, err)
 	}
-	return fmt.Errorf("cannot find endfor tag for %q at %s", forStr, s.Context())
+	return errorf("cannot find endfor tag for %q at %s", forStr, s.Context())
 }
 
 func (p *parser) parseDefault() error {
@@ -242,7 +585,7 @@ func (p *parser) parseDefault() error {
 		case tagName:
 			ok, err := p.tryParseCommonTags(t.Value)
 			if err != nil {
-				return fmt.Errorf("error in %q: %s", stmtStr, err)
+				return errorf("error in %q: %s", stmtStr, err)
 			}
 			if !ok {
 				s.Rewind()
@@ -250,13 +593,13 @@ func (p *parser) parseDefault() error {
 				return nil
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing %q: %s at %s", stmtStr, t, s.Context())
+			return errorf("unexpected token found when parsing %q: %s at %s", stmtStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse %q: %s", stmtStr, err)
+		return errorf("cannot parse %q: %s", stmtStr, err)
 	}
-	return fmt.Errorf("cannot find end of %q at %s", stmtStr, s.Context())
+	return errorf("cannot find end of %q at %s", stmtStr, s.Context())
 }
 
 func (p *parser) parseCase() error {
@@ -267,7 +610,7 @@ func (p *parser) parseCase() error {
 	}
 	caseStr := "case " + string(t.Value)
 	if err = validateCaseStmt(t.Value); err != nil {
-		return fmt.Errorf("invalid statement %q at %s: %s", caseStr, s.Context(), err)
+		return errorf("invalid statement %q at %s: %s", caseStr, s.Context(), err)
 	}
 	p.Printf("case %s:", t.Value)
 	p.prefix += "\t"
@@ -279,7 +622,7 @@ func (p *parser) parseCase() error {
 		case tagName:
 			ok, err := p.tryParseCommonTags(t.Value)
 			if err != nil {
-				return fmt.Errorf("error in %q: %s", caseStr, err)
+				return errorf("error in %q: %s", caseStr, err)
 			}
 			if !ok {
 				s.Rewind()
@@ -287,13 +630,13 @@ func (p *parser) parseCase() error {
 				return nil
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing %q: %s at %s", caseStr, t, s.Context())
+			return errorf("unexpected token found when parsing %q: %s at %s", caseStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse %q: %s", caseStr, err)
+		return errorf("cannot parse %q: %s", caseStr, err)
 	}
-	return fmt.Errorf("cannot find end of %q at %s", caseStr, s.Context())
+	return errorf("cannot find end of %q at %s", caseStr, s.Context())
 }
 
 func (p *parser) parseCat() error {
@@ -304,17 +647,197 @@ func (p *parser) parseCat() error {
 	}
 	filename, err := strconv.Unquote(string(t.Value))
 	if err != nil {
-		return fmt.Errorf("invalid cat value %q at %s: %s", t.Value, s.Context(), err)
+		return errorf("invalid cat value %q at %s: %s", t.Value, s.Context(), err)
+	}
+	if p.opts.vet != nil && filepath.IsAbs(filename) {
+		p.vetf(vetAbsCat, "{%% cat %q %%} uses absolute path. Use path relative to the template file", filename)
 	}
 
 	data, err := readFile(s.filePath, filename)
 	if err != nil {
-		return fmt.Errorf("cannot cat file %q at %s: %s", filename, s.Context(), err)
+		return errorf("cannot cat file %q at %s: %s", filename, s.Context(), err)
 	}
 	p.emitText(data)
 	return nil
 }
 
+func (p *parser) parseCapture() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	captureStr := "capture " + string(t.Value)
+	name, typ, err := parseCaptureVar(t.Value)
+	if err != nil {
+		return errorf("invalid %q at %s: %s", captureStr, s.Context(), err)
+	}
+	p.Printf("var %s %s", name, typ)
+	p.Printf("{")
+	p.prefix += "\t"
+	p.Printf("qb%s := qt%s.AcquireByteBuffer()", mangleSuffix, mangleSuffix)
+	// The capture writer shares the stacks with the outer writer,
+	// so the pushes inside the capture body aren't lost.
+	p.Printf("qw%s := qw%s.AcquireCaptureWriter(qb%s)", mangleSuffix, mangleSuffix, mangleSuffix)
+
+	// Errors and canceled ctx stop the body, which is wrapped into a closure
+	// for this case, so the pooled objects are released before returning.
+	// Errors are stored in errVar like in slots and are returned afterwards.
+	withClosure := p.funcWithErrors || len(p.slotErrVar) > 0 || p.opts.withContext
+	errVar := ""
+	funcWithErrors := p.funcWithErrors
+	slotErrVar := p.slotErrVar
+	if withClosure {
+		if p.funcWithErrors || len(p.slotErrVar) > 0 {
+			p.callsCount++
+			errVar = fmt.Sprintf("qcerr%s_%d", mangleSuffix, p.callsCount)
+			p.Printf("var %s error", errVar)
+		}
+		p.Printf("func() {")
+		p.prefix += "\t"
+		p.funcWithErrors = false
+		p.slotErrVar = errVar
+	}
+
+	// The captured output is escaped by the tags using the variable,
+	// so the body is escaped as a separate func.
+	if err = p.parseBufferedBody("capture", captureStr, "endcapture"); err != nil {
+		return err
+	}
+
+	if withClosure {
+		p.funcWithErrors = funcWithErrors
+		p.slotErrVar = slotErrVar
+		p.prefix = p.prefix[1:]
+		p.Printf("}()")
+	}
+	p.Printf("qt%s.ReleaseWriter(qw%s)", mangleSuffix, mangleSuffix)
+	if typ == "[]byte" {
+		p.Printf("%s = append([]byte(nil), qb%s.B...)", name, mangleSuffix)
+	} else {
+		p.Printf("%s = string(qb%s.B)", name, mangleSuffix)
+	}
+	p.Printf("qt%s.ReleaseByteBuffer(qb%s)", mangleSuffix, mangleSuffix)
+	if len(errVar) > 0 {
+		p.Printf("if %s != nil {", errVar)
+		p.emitReturnError(errVar)
+		p.Printf("}")
+	}
+	p.prefix = p.prefix[1:]
+	p.Printf("}")
+	return nil
+}
+
+// parseBufferedBody parses the body of tagName, which buffers the output.
+//
+// The body is escaped as a separate func, since the output is emitted
+// in unknown context.
+func (p *parser) parseBufferedBody(tagName, tagStr, endTag string) error {
+	esc := p.esc
+	forDepth := p.forDepth
+	switchDepth := p.switchDepth
+	bufferTag := p.bufferTag
+	skipFragmentOutput := p.skipFragmentOutput
+	p.esc = escContext{}
+	p.forDepth = 0
+	p.switchDepth = 0
+	p.bufferTag = tagName
+	p.skipFragmentOutput = false
+	if err := p.parseFuncBody(tagStr, endTag); err != nil {
+		return err
+	}
+	p.esc = esc
+	p.forDepth = forDepth
+	p.switchDepth = switchDepth
+	p.bufferTag = bufferTag
+	p.skipFragmentOutput = skipFragmentOutput
+	return nil
+}
+
+func (p *parser) parsePush() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	pushStr := "push " + string(t.Value)
+	name, key, err := parsePushArgs(t.Value)
+	if err != nil {
+		return errorf("invalid %q at %s: %s", pushStr, s.Context(), err)
+	}
+	if len(key) == 0 {
+		key = `""`
+	}
+	p.Printf("if qw%s.PushStart(%s, %s) {", mangleSuffix, name, key)
+	p.prefix += "\t"
+	if err = p.parseBufferedBody("push", pushStr, "endpush"); err != nil {
+		return err
+	}
+	p.Printf("qw%s.PushEnd()", mangleSuffix)
+	p.prefix = p.prefix[1:]
+	p.Printf("}")
+	return nil
+}
+
+// parsePushArgs parses push tag contents such as `"scripts", key`.
+//
+// It returns stack name and optional key expressions.
+func parsePushArgs(b []byte) (string, string, error) {
+	exprStr := fmt.Sprintf("f(%s)", b)
+	expr, err := goparser.ParseExpr(exprStr)
+	if err != nil {
+		return "", "", err
+	}
+	ce, ok := expr.(*ast.CallExpr)
+	if !ok || ce.Ellipsis.IsValid() || len(ce.Args) == 0 || len(ce.Args) > 2 {
+		return "", "", errorf("expecting stack name optionally followed by key")
+	}
+	name := exprStr[ce.Args[0].Pos()-1 : ce.Args[0].End()-1]
+	key := ""
+	if len(ce.Args) == 2 {
+		key = exprStr[ce.Args[1].Pos()-1 : ce.Args[1].End()-1]
+	}
+	return name, key, nil
+}
+
+func (p *parser) parseStack() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	if p.bufferTag == "push" {
+		return errorf("found stack tag inside push at %s", s.Context())
+	}
+	if err = validateOutputTagValue(t.Value); err != nil {
+		return errorf("invalid stack name at %s: %s", s.Context(), err)
+	}
+	p.Printf("qw%s.Stack(%s)", mangleSuffix, t.Value)
+	return nil
+}
+
+// parseCaptureVar parses capture tag contents such as "s" or "b []byte".
+//
+// It returns the variable name and type.
+func parseCaptureVar(b []byte) (string, string, error) {
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 || len(fields) > 2 {
+		return "", "", errorf("expecting variable name optionally followed by string or []byte")
+	}
+	name := fields[0]
+	if !gotoken.IsIdentifier(name) || name == "_" {
+		return "", "", errorf("invalid variable name %q", name)
+	}
+	typ := "string"
+	if len(fields) == 2 {
+		typ = fields[1]
+	}
+	if typ != "string" && typ != "[]byte" {
+		return "", "", errorf("unsupported variable type %q. Supported types are string and []byte", typ)
+	}
+	return name, typ, nil
+}
+
 func (p *parser) parseSwitch() error {
 	s := p.s
 	t, err := expectTagContents(s)
@@ -323,12 +846,16 @@ func (p *parser) parseSwitch() error {
 	}
 	switchStr := "switch " + string(t.Value)
 	if err = validateSwitchStmt(t.Value); err != nil {
-		return fmt.Errorf("invalid statement %q at %s: %s", switchStr, s.Context(), err)
+		return errorf("invalid statement %q at %s: %s", switchStr, s.Context(), err)
 	}
 	p.Printf("switch %s {", t.Value)
 	caseNum := 0
 	defaultFound := false
 	p.switchDepth++
+	escStart := p.esc
+	var bj escJoiner
+	varTypes := p.varTypes
+	defer func() { p.varTypes = varTypes }()
 	for s.Next() {
 		t := s.Token()
 		switch t.ID {
@@ -345,39 +872,51 @@ func (p *parser) parseSwitch() error {
 			switch string(t.Value) {
 			case "endswitch":
 				if caseNum == 0 {
-					return fmt.Errorf("empty statement %q found at %s", switchStr, s.Context())
+					return errorf("empty statement %q found at %s", switchStr, s.Context())
 				}
 				if err = skipTagContents(s); err != nil {
 					return err
 				}
 				p.switchDepth--
 				p.Printf("}")
+				if !defaultFound {
+					bj.add(p, escStart)
+				}
+				if err = bj.finish(p, escStart); err != nil {
+					return errorf("error in %q at %s: %s", switchStr, s.Context(), err)
+				}
 				return nil
 			case "case":
 				caseNum++
+				p.esc = escStart
+				p.varTypes = varTypes
 				if err = p.parseCase(); err != nil {
 					return err
 				}
+				bj.add(p, p.esc)
 			case "default":
 				if defaultFound {
-					return fmt.Errorf("duplicate default tag found in %q at %s", switchStr, s.Context())
+					return errorf("duplicate default tag found in %q at %s", switchStr, s.Context())
 				}
 				defaultFound = true
 				caseNum++
+				p.esc = escStart
+				p.varTypes = varTypes
 				if err = p.parseDefault(); err != nil {
 					return err
 				}
+				bj.add(p, p.esc)
 			default:
-				return fmt.Errorf("unexpected tag found in %q: %q at %s", switchStr, t.Value, s.Context())
+				return errorf("unexpected tag found in %q: %q at %s%s", switchStr, t.Value, s.Context(), suggestTag(t.Value))
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing %q: %s at %s", switchStr, t, s.Context())
+			return errorf("unexpected token found when parsing %q: %s at %s", switchStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse %q: %s", switchStr, err)
+		return errorf("cannot parse %q: %s", switchStr, err)
 	}
-	return fmt.Errorf("cannot find endswitch tag for %q at %s", switchStr, s.Context())
+	return errorf("cannot find endswitch tag for %q at %s", switchStr, s.Context())
 }
 
 func (p *parser) parseIf() error {
@@ -387,15 +926,19 @@ func (p *parser) parseIf() error {
 		return err
 	}
 	if len(t.Value) == 0 {
-		return fmt.Errorf("empty if condition at %s", s.Context())
+		return errorf("empty if condition at %s", s.Context())
 	}
 	ifStr := "if " + string(t.Value)
 	if err = validateIfStmt(t.Value); err != nil {
-		return fmt.Errorf("invalid statement %q at %s: %s", ifStr, s.Context(), err)
+		return errorf("invalid statement %q at %s: %s", ifStr, s.Context(), err)
 	}
 	p.Printf("if %s {", t.Value)
 	p.prefix += "\t"
 	elseUsed := false
+	escStart := p.esc
+	var bj escJoiner
+	varTypes := p.varTypes
+	defer func() { p.varTypes = varTypes }()
 	for s.Next() {
 		t := s.Token()
 		switch t.ID {
@@ -404,7 +947,7 @@ func (p *parser) parseIf() error {
 		case tagName:
 			ok, err := p.tryParseCommonTags(t.Value)
 			if err != nil {
-				return fmt.Errorf("error in %q: %s", ifStr, err)
+				return errorf("error in %q: %s", ifStr, err)
 			}
 			if ok {
 				continue
@@ -416,10 +959,17 @@ func (p *parser) parseIf() error {
 				}
 				p.prefix = p.prefix[1:]
 				p.Printf("}")
+				bj.add(p, p.esc)
+				if !elseUsed {
+					bj.add(p, escStart)
+				}
+				if err = bj.finish(p, escStart); err != nil {
+					return errorf("error in %q at %s: %s", ifStr, s.Context(), err)
+				}
 				return nil
 			case "else":
 				if elseUsed {
-					return fmt.Errorf("duplicate else branch found for %q at %s", ifStr, s.Context())
+					return errorf("duplicate else branch found for %q at %s", ifStr, s.Context())
 				}
 				if err = skipTagContents(s); err != nil {
 					return err
@@ -428,9 +978,12 @@ func (p *parser) parseIf() error {
 				p.Printf("} else {")
 				p.prefix += "\t"
 				elseUsed = true
+				bj.add(p, p.esc)
+				p.esc = escStart
+				p.varTypes = varTypes
 			case "elseif":
 				if elseUsed {
-					return fmt.Errorf("unexpected elseif branch found after else branch for %q at %s",
+					return errorf("unexpected elseif branch found after else branch for %q at %s",
 						ifStr, s.Context())
 				}
 				t, err = expectTagContents(s)
@@ -440,22 +993,28 @@ func (p *parser) parseIf() error {
 				p.prefix = p.prefix[1:]
 				p.Printf("} else if %s {", t.Value)
 				p.prefix += "\t"
+				bj.add(p, p.esc)
+				p.esc = escStart
+				p.varTypes = varTypes
 			default:
-				return fmt.Errorf("unexpected tag found in %q: %q at %s", ifStr, t.Value, s.Context())
+				return errorf("unexpected tag found in %q: %q at %s%s", ifStr, t.Value, s.Context(), suggestTag(t.Value))
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing %q: %s at %s", ifStr, t, s.Context())
+			return errorf("unexpected token found when parsing %q: %s at %s", ifStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse %q: %s", ifStr, err)
+		return errorf("cannot parse %q: %s", ifStr, err)
 	}
-	return fmt.Errorf("cannot find endif tag for %q at %s", ifStr, s.Context())
+	return errorf("cannot find endif tag for %q at %s", ifStr, s.Context())
 }
 
 func (p *parser) tryParseCommonTags(tagBytes []byte) (bool, error) {
 	s := p.s
 	tagNameStr, prec := splitTagNamePrec(string(tagBytes))
+	if p.skipFragmentOutput && isOutputTag(tagNameStr) {
+		return p.parseSkippedOutputTag(tagBytes)
+	}
 	switch tagNameStr {
 	case "s", "v", "d", "f", "q", "z", "j", "u",
 		"s=", "v=", "d=", "f=", "q=", "z=", "j=", "u=",
@@ -466,21 +1025,31 @@ func (p *parser) tryParseCommonTags(tagBytes []byte) (bool, error) {
 			return false, err
 		}
 		if err = validateOutputTagValue(t.Value); err != nil {
-			return false, fmt.Errorf("invalid output tag value at %s: %s", s.Context(), err)
+			return false, errorf("invalid output tag value at %s: %s", s.Context(), err)
+		}
+		if p.opts.vet != nil {
+			p.vetOutputTag(tagNameStr, t.Value)
 		}
-		filter := "N()."
+		filter := "N()"
+		method := strings.ToUpper(strings.TrimSuffix(tagNameStr, "="))
 		switch tagNameStr {
 		case "s", "v", "q", "z", "j", "sz", "qz", "jz":
-			filter = "E()."
-		}
-		if strings.HasSuffix(tagNameStr, "=") {
-			tagNameStr = tagNameStr[:len(tagNameStr)-1]
+			switch {
+			case p.opts.textMode:
+			case p.opts.noAutoEscape:
+				filter = "E()"
+			default:
+				filter, method, err = p.esc.outputMethod(tagNameStr)
+				if err != nil {
+					return false, errorf("invalid output tag {%%%s %%} at %s: %s", tagNameStr, s.Context(), err)
+				}
+				p.esc.afterOutput()
+			}
 		}
 		if tagNameStr == "f" && prec >= 0 {
 			p.Printf("qw%s.N().FPrec(%s, %d)", mangleSuffix, t.Value, prec)
 		} else {
-			tagNameStr = strings.ToUpper(tagNameStr)
-			p.Printf("qw%s.%s%s(%s)", mangleSuffix, filter, tagNameStr, t.Value)
+			p.Printf("qw%s.%s.%s(%s)", mangleSuffix, filter, method, t.Value)
 		}
 	case "=":
 		t, err := expectTagContents(s)
@@ -489,25 +1058,89 @@ func (p *parser) tryParseCommonTags(tagBytes []byte) (bool, error) {
 		}
 		f, err := parseFuncCall(t.Value)
 		if err != nil {
-			return false, fmt.Errorf("error at %s: %s", s.Context(), err)
+			return false, errorf("error at %s: %s", s.Context(), err)
+		}
+		if len(f.callPrefix) == 0 && len(f.typeArgs) == 0 && p.slotArgs[f.name] {
+			if len(f.argNames) > 0 {
+				return false, errorf("slot %s cannot accept args at %s", f.name, s.Context())
+			}
+			p.Printf("if %s != nil {", f.name)
+			p.Printf("\t%s(qw%s)", f.name, mangleSuffix)
+			p.Printf("}")
+		} else {
+			p.resolveCallErrors(f)
+			p.applyOptions(f)
+			if err = p.emitCall(f, string(t.Value)); err != nil {
+				return false, err
+			}
+		}
+	case "block":
+		if err := p.parseBlock(); err != nil {
+			return false, err
+		}
+	case "call":
+		if err := p.parseCall(); err != nil {
+			return false, err
+		}
+	case "capture":
+		if err := p.parseCapture(); err != nil {
+			return false, err
+		}
+	case "fragment":
+		if err := p.parseFragment(); err != nil {
+			return false, err
+		}
+	case "push":
+		if err := p.parsePush(); err != nil {
+			return false, err
+		}
+	case "stack":
+		if err := p.parseStack(); err != nil {
+			return false, err
 		}
-		p.Printf("%s", f.CallStream("qw"+mangleSuffix))
 	case "return":
-		if err := p.skipAfterTag(tagNameStr); err != nil {
+		if len(p.bufferTag) > 0 {
+			return false, errorf("found return tag inside %s at %s", p.bufferTag, s.Context())
+		}
+		t, err := expectTagContents(s)
+		if err != nil {
+			return false, err
+		}
+		stmt := tagNameStr
+		switch {
+		case len(t.Value) > 0:
+			if !p.funcWithErrors {
+				return false, errorf("unexpected extra value after return: %q at %s. "+
+					"Only funcs with error result may return a value", t.Value, s.Context())
+			}
+			if err = validateOutputTagValue(t.Value); err != nil {
+				return false, errorf("invalid return value at %s: %s", s.Context(), err)
+			}
+			stmt = fmt.Sprintf("return %s", t.Value)
+		case p.funcWithErrors:
+			stmt = fmt.Sprintf("return qw%s.Err()", mangleSuffix)
+		}
+		if err := p.skipAfterTag(tagNameStr, stmt); err != nil {
 			return false, err
 		}
 	case "break":
 		if p.forDepth <= 0 && p.switchDepth <= 0 {
-			return false, fmt.Errorf("found break tag outside for loop and switch block")
+			return false, errorf("found break tag outside for loop and switch block")
+		}
+		if err := skipTagContents(s); err != nil {
+			return false, err
 		}
-		if err := p.skipAfterTag(tagNameStr); err != nil {
+		if err := p.skipAfterTag(tagNameStr, tagNameStr); err != nil {
 			return false, err
 		}
 	case "continue":
 		if p.forDepth <= 0 {
-			return false, fmt.Errorf("found continue tag outside for loop")
+			return false, errorf("found continue tag outside for loop")
+		}
+		if err := skipTagContents(s); err != nil {
+			return false, err
 		}
-		if err := p.skipAfterTag(tagNameStr); err != nil {
+		if err := p.skipAfterTag(tagNameStr, tagNameStr); err != nil {
 			return false, err
 		}
 	case "code":
@@ -554,12 +1187,10 @@ func splitTagNamePrec(tagName string) (string, int) {
 	return tagName, -1
 }
 
-func (p *parser) skipAfterTag(tagStr string) error {
+func (p *parser) skipAfterTag(tagStr, stmt string) error {
 	s := p.s
-	if err := skipTagContents(s); err != nil {
-		return err
-	}
-	p.Printf("%s", tagStr)
+	p.Printf("%s", stmt)
+	p.escDead = true
 	p.skipOutputDepth++
 	defer func() {
 		p.skipOutputDepth--
@@ -572,26 +1203,29 @@ func (p *parser) skipAfterTag(tagStr string) error {
 		case tagName:
 			ok, err := p.tryParseCommonTags(t.Value)
 			if err != nil {
-				return fmt.Errorf("error when parsing contents after %q: %s", tagStr, err)
+				return errorf("error when parsing contents after %q: %s", tagStr, err)
 			}
 			if ok {
 				continue
 			}
 			switch string(t.Value) {
-			case "endfunc", "endfor", "endif", "else", "elseif", "case", "default", "endswitch":
+			case "endfunc", "endblock", "endslot", "endcall", "endcapture", "endpush", "endfragment", "endfor", "endif", "else", "elseif", "case", "default", "endswitch":
+				if (string(t.Value) == "endfunc" || string(t.Value) == "endblock") && tagStr == "return" && p.skipOutputDepth == 1 {
+					p.funcTerminated = true
+				}
 				s.Rewind()
 				return nil
 			default:
-				return fmt.Errorf("unexpected tag found after %q: %q at %s", tagStr, t.Value, s.Context())
+				return errorf("unexpected tag found after %q: %q at %s%s", tagStr, t.Value, s.Context(), suggestTag(t.Value))
 			}
 		default:
-			return fmt.Errorf("unexpected token found when parsing contents after %q: %s at %s", tagStr, t, s.Context())
+			return errorf("unexpected token found when parsing contents after %q: %s at %s", tagStr, t, s.Context())
 		}
 	}
 	if err := s.LastError(); err != nil {
-		return fmt.Errorf("cannot parse contents after %q: %s", tagStr, err)
+		return errorf("cannot parse contents after %q: %s", tagStr, err)
 	}
-	return fmt.Errorf("cannot find closing tag after %q at %s", tagStr, s.Context())
+	return errorf("cannot find closing tag after %q at %s", tagStr, s.Context())
 }
 
 func (p *parser) parseInterface() error {
@@ -603,42 +1237,85 @@ func (p *parser) parseInterface() error {
 
 	n := bytes.IndexByte(t.Value, '{')
 	if n < 0 {
-		return fmt.Errorf("missing '{' in interface at %s", s.Context())
+		return errorf("missing '{' in interface at %s", s.Context())
 	}
 	ifname := string(stripTrailingSpace(t.Value[:n]))
 	if len(ifname) == 0 {
-		return fmt.Errorf("missing interface name at %s", s.Context())
+		return errorf("missing interface name at %s", s.Context())
 	}
-	p.Printf("type %s interface {", ifname)
-	p.prefix = "\t"
-
 	tail := t.Value[n:]
 	exprStr := fmt.Sprintf("interface %s", tail)
 	expr, err := goparser.ParseExpr(exprStr)
 	if err != nil {
-		return fmt.Errorf("error when parsing interface at %s: %s", s.Context(), err)
+		return errorf("error when parsing interface at %s: %s", s.Context(), err)
 	}
 	it, ok := expr.(*ast.InterfaceType)
 	if !ok {
-		return fmt.Errorf("unexpected interface type at %s: %T", s.Context(), expr)
+		return errorf("unexpected interface type at %s: %T", s.Context(), expr)
 	}
 	methods := it.Methods.List
 	if len(methods) == 0 {
-		return fmt.Errorf("interface must contain at least one method at %s", s.Context())
+		return errorf("interface must contain at least one method at %s", s.Context())
 	}
 
+	// Every method is annotated with its own template position.
+	valuePos := func(pos gotoken.Pos) (int, int) {
+		offset := int(pos) - 1 - len("interface ") + n
+		return advancePos(t.line, t.pos, t.Value[:offset])
+	}
+	p.printfAt(t.line, t.pos, "type %s interface {", ifname)
+	p.prefix = "\t"
 	for _, m := range it.Methods.List {
 		methodStr := exprStr[m.Pos()-1 : m.End()-1]
 		f, err := parseFuncDef([]byte(methodStr))
 		if err != nil {
-			return fmt.Errorf("when when parsing %q at %s: %s", methodStr, s.Context(), err)
+			return errorf("when when parsing %q at %s: %s", methodStr, s.Context(), err)
+		}
+		p.applyOptions(f)
+		line, pos := valuePos(m.Pos())
+		if f.variants.has(variantString) {
+			p.printfAt(line, pos, "%s", f.DefString())
+		}
+		p.printfAt(line, pos, "%s", f.DefStream("qw"+mangleSuffix))
+		if f.variants.has(variantWrite) {
+			p.printfAt(line, pos, "%s", f.DefWrite("qq"+mangleSuffix))
 		}
-		p.Printf("%s string", methodStr)
-		p.Printf("%s", f.DefStream("qw"+mangleSuffix))
-		p.Printf("%s", f.DefWrite("qq"+mangleSuffix))
 	}
 	p.prefix = ""
-	p.Printf("}")
+	line, pos := valuePos(it.Methods.Closing)
+	p.printfAt(line, pos, "}")
+	return nil
+}
+
+// parsePackage parses package tag.
+//
+// The package clause is emitted from the package name passed to the parser,
+// since it is determined for all the templates in the directory.
+// See getPackageName for details.
+func (p *parser) parsePackage() error {
+	t, err := expectTagContents(p.s)
+	if err != nil {
+		return err
+	}
+	p.packageFound = true
+	if !isValidPackageName(string(t.Value)) {
+		return errorf("invalid package name %q found at %s", t.Value, p.s.Context())
+	}
+	return nil
+}
+
+// parseVariants parses variants tag, which sets the variants
+// for the subsequent funcs and interfaces in the template.
+func (p *parser) parseVariants() error {
+	t, err := expectTagContents(p.s)
+	if err != nil {
+		return err
+	}
+	v, err := parseVariants(string(t.Value))
+	if err != nil {
+		return errorf("invalid variants tag at %s: %s", p.s.Context(), err)
+	}
+	p.variants = v
 	return nil
 }
 
@@ -648,11 +1325,15 @@ func (p *parser) parseImport() error {
 		return err
 	}
 	if len(t.Value) == 0 {
-		return fmt.Errorf("empty import found at %s", p.s.Context())
+		return errorf("empty import found at %s", p.s.Context())
 	}
 	if err = validateImport(t.Value); err != nil {
-		return fmt.Errorf("invalid import found at %s: %s", p.s.Context(), err)
+		return errorf("invalid import found at %s: %s", p.s.Context(), err)
+	}
+	if p.imports == nil {
+		p.imports = make(map[string]string)
 	}
+	addImports(p.imports, t.Value)
 	p.Printf("import %s\n", t.Value)
 	return nil
 }
@@ -663,9 +1344,10 @@ func (p *parser) parseTemplateCode() error {
 		return err
 	}
 	if err = validateTemplateCode(t.Value); err != nil {
-		return fmt.Errorf("invalid code at %s: %s", p.s.Context(), err)
+		return errorf("invalid code at %s: %s", p.s.Context(), err)
 	}
 	p.Printf("%s\n", t.Value)
+	p.varTypes = codeVarTypes(t.Value, p.varTypes)
 	return nil
 }
 
@@ -675,13 +1357,20 @@ func (p *parser) parseFuncCode() error {
 		return err
 	}
 	if err = validateFuncCode(t.Value); err != nil {
-		return fmt.Errorf("invalid code at %s: %s", p.s.Context(), err)
+		return errorf("invalid code at %s: %s", p.s.Context(), err)
 	}
 	p.Printf("%s\n", t.Value)
+	p.varTypes = codeVarTypes(t.Value, p.varTypes)
 	return nil
 }
 
 func (p *parser) emitText(text []byte) {
+	if p.skipOutputDepth == 0 && !p.opts.textMode && !p.opts.noAutoEscape {
+		p.esc.feed(text)
+	}
+	if p.skipFragmentOutput {
+		return
+	}
 	for len(text) > 0 {
 		n := bytes.IndexByte(text, '`')
 		if n < 0 {
@@ -695,29 +1384,147 @@ func (p *parser) emitText(text []byte) {
 }
 
 func (p *parser) emitFuncStart(f *funcType) {
-	p.Printf("func %s {", f.DefStream("qw"+mangleSuffix))
 	p.prefix = "\t"
+	p.esc = escContext{}
+	p.escDead = false
+	p.funcTerminated = false
+	p.funcWithErrors = f.withErrors
+	p.slotArgs = f.slotArgs
+	p.varTypes = funcVarTypes(f)
+	p.slotErrVar = ""
+	p.loopsCount = 0
+	p.callsCount = 0
+	p.bufferTag = ""
+}
+
+// emitCall emits the call of Stream* variant of f with the given call string.
+func (p *parser) emitCall(f *funcType, callStr string) error {
+	if !f.withErrors {
+		p.Printf("%s", f.CallStream("qw"+mangleSuffix))
+		return nil
+	}
+	if !p.funcWithErrors && len(p.slotErrVar) == 0 {
+		if f.errorsAssumed {
+			return errorf("cannot determine the receiver type for %s, so it is assumed to return error like %s methods declared in templates. "+
+				"The func calling it must return error too at %s", callStr, f.name, p.s.Context())
+		}
+		return errorf("%s returns error, so the func calling it must return error too at %s", callStr, p.s.Context())
+	}
+	p.Printf("if qerr%s := %s; qerr%s != nil {", mangleSuffix, f.CallStream("qw"+mangleSuffix), mangleSuffix)
+	p.emitReturnError("qerr" + mangleSuffix)
+	p.Printf("}")
+	return nil
+}
+
+// emitReturnError emits returning the error from errVar.
+//
+// Slot closures cannot return errors, so the error is stored in slotErrVar
+// and returned by the func after the call.
+func (p *parser) emitReturnError(errVar string) {
+	if p.funcWithErrors {
+		p.Printf("\treturn %s", errVar)
+		return
+	}
+	p.Printf("\t%s = %s", p.slotErrVar, errVar)
+	p.Printf("\treturn")
 }
 
+// applyOptions applies p.opts to the definition or the call of f.
+func (p *parser) applyOptions(f *funcType) {
+	f.withErrors = f.withErrors || p.opts.withErrors
+	f.withContext = p.opts.withContext
+	f.variants = p.funcVariants(f)
+}
+
+// funcVariants returns the variants generated for f.
+func (p *parser) funcVariants(f *funcType) funcVariants {
+	if p.variants != 0 {
+		return p.variants
+	}
+	v := p.opts.variants
+	if !ast.IsExported(f.name) {
+		v = p.opts.unexportedVariants
+	}
+	if v == 0 {
+		return allVariants
+	}
+	return v
+}
+
+func (p *parser) contextCheckInterval() int {
+	if p.opts.contextCheckInterval <= 0 {
+		return defaultContextCheckInterval
+	}
+	return p.opts.contextCheckInterval
+}
+
+// defaultContextCheckInterval is the default number of loop iterations
+// between ctx.Err() checks.
+const defaultContextCheckInterval = 64
+
 func (p *parser) emitFuncEnd(f *funcType) {
+	if f.withErrors && !p.funcTerminated {
+		p.Printf("return qw%s.Err()", mangleSuffix)
+	}
 	p.prefix = ""
 	p.Printf("}\n")
 
+	if f.variants.has(variantWrite) {
+		p.emitWriteFunc(f)
+	}
+	if f.variants.has(variantString) {
+		p.emitStringFunc(f)
+	}
+}
+
+func (p *parser) emitWriteFunc(f *funcType) {
 	p.Printf("func %s {", f.DefWrite("qq"+mangleSuffix))
 	p.prefix = "\t"
 	p.Printf("qw%s := qt%s.AcquireWriter(qq%s)", mangleSuffix, mangleSuffix, mangleSuffix)
-	p.Printf("%s", f.CallStream("qw"+mangleSuffix))
-	p.Printf("qt%s.ReleaseWriter(qw%s)", mangleSuffix, mangleSuffix)
+	if f.withErrors {
+		p.Printf("qerr%s := %s", mangleSuffix, f.CallStream("qw"+mangleSuffix))
+		p.Printf("if qerr%s == nil {", mangleSuffix)
+		p.Printf("\tqerr%s = qw%s.Flush()", mangleSuffix, mangleSuffix)
+		p.Printf("}")
+		p.Printf("qt%s.ReleaseWriter(qw%s)", mangleSuffix, mangleSuffix)
+		p.Printf("return qerr%s", mangleSuffix)
+	} else {
+		p.Printf("%s", f.CallStream("qw"+mangleSuffix))
+		p.Printf("qt%s.ReleaseWriter(qw%s)", mangleSuffix, mangleSuffix)
+	}
 	p.prefix = ""
 	p.Printf("}\n")
+}
 
+func (p *parser) emitStringFunc(f *funcType) {
 	p.Printf("func %s {", f.DefString())
 	p.prefix = "\t"
 	p.Printf("qb%s := qt%s.AcquireByteBuffer()", mangleSuffix, mangleSuffix)
-	p.Printf("%s", f.CallWrite("qb"+mangleSuffix))
+	call := f.CallWrite("qb" + mangleSuffix)
+	if !f.variants.has(variantWrite) {
+		// Write variant isn't generated, so Stream variant is called.
+		p.Printf("qw%s := qt%s.AcquireWriter(qb%s)", mangleSuffix, mangleSuffix, mangleSuffix)
+		call = f.CallStream("qw" + mangleSuffix)
+	}
+	switch {
+	case f.errorResult:
+		p.Printf("qerr%s := %s", mangleSuffix, call)
+	case f.withErrors:
+		// Writes to ByteBuffer never fail.
+		p.Printf("_ = %s", call)
+	default:
+		p.Printf("%s", call)
+	}
+	if !f.variants.has(variantWrite) {
+		p.Printf("qt%s.ReleaseWriter(qw%s)", mangleSuffix, mangleSuffix)
+	}
 	p.Printf("qs%s := string(qb%s.B)", mangleSuffix, mangleSuffix)
 	p.Printf("qt%s.ReleaseByteBuffer(qb%s)", mangleSuffix, mangleSuffix)
-	p.Printf("return qs%s", mangleSuffix)
+	if f.errorResult {
+		p.Printf("return qs%s, qerr%s", mangleSuffix, mangleSuffix)
+	} else {
+		p.Printf("return qs%s", mangleSuffix)
+	}
 	p.prefix = ""
 	p.Printf("}\n")
 }
@@ -727,11 +1534,21 @@ func (p *parser) Printf(format string, args ...interface{}) {
 		return
 	}
 	w := p.w
-	fmt.Fprintf(w, "%s", p.prefix)
 	p.s.WriteLineComment(w)
-	fmt.Fprintf(w, "%s", p.prefix)
-	fmt.Fprintf(w, format, args...)
-	fmt.Fprintf(w, "\n")
+	stmt := fmt.Sprintf(format, args...)
+	if t := p.s.Token(); t.ID == tagContents {
+		stmt = annotateFragment(stmt, string(t.Value), p.s.filePath, t.line, t.pos)
+	}
+	fmt.Fprintf(w, "%s%s\n", p.prefix, stmt)
+}
+
+// printfAt is like Printf, but annotates the generated stmt with the given
+// 0-based template position instead of the position of the current token.
+func (p *parser) printfAt(line, pos int, format string, args ...interface{}) {
+	if p.skipOutputDepth > 0 {
+		return
+	}
+	fmt.Fprintf(p.w, "%s%s%s\n", lineDirective(p.s.filePath, line, pos), p.prefix, fmt.Sprintf(format, args...))
 }
 
 func skipTagContents(s *scanner) error {
@@ -741,7 +1558,7 @@ func skipTagContents(s *scanner) error {
 		return err
 	}
 	if len(t.Value) > 0 {
-		return fmt.Errorf("unexpected extra value after %s: %q at %s", tagName, t.Value, s.Context())
+		return errorf("unexpected extra value after %s: %q at %s", tagName, t.Value, s.Context())
 	}
 	return err
 }
@@ -752,11 +1569,11 @@ func expectTagContents(s *scanner) (*token, error) {
 
 func expectToken(s *scanner, id int) (*token, error) {
 	if !s.Next() {
-		return nil, fmt.Errorf("cannot find token %s: %v", tokenIDToStr(id), s.LastError())
+		return nil, errorf("cannot find token %s: %v", tokenIDToStr(id), s.LastError())
 	}
 	t := s.Token()
 	if t.ID != id {
-		return nil, fmt.Errorf("unexpected token found %s. Expecting %s at %s", t, tokenIDToStr(id), s.Context())
+		return nil, errorf("unexpected token found %s. Expecting %s at %s", t, tokenIDToStr(id), s.Context())
 	}
 	return t, nil
 }
@@ -804,6 +1621,30 @@ func validateTemplateCode(code []byte) error {
 	return err
 }
 
+// addImports adds import paths from the given import tag contents
+// to dst keyed by package names.
+//
+// The last element of import path is used as package name for imports
+// without explicit name.
+func addImports(dst map[string]string, code []byte) {
+	codeStr := fmt.Sprintf("package foo\nimport %s", code)
+	f, err := goparser.ParseFile(gotoken.NewFileSet(), "", codeStr, goparser.ImportsOnly)
+	if err != nil {
+		return
+	}
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path.Base(importPath)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		dst[name] = importPath
+	}
+}
+
 func validateImport(code []byte) error {
 	codeStr := fmt.Sprintf("package foo\nimport %s", code)
 	fset := gotoken.NewFileSet()
@@ -814,13 +1655,77 @@ func validateImport(code []byte) error {
 	for _, d := range f.Decls {
 		gd, ok := d.(*ast.GenDecl)
 		if !ok {
-			return fmt.Errorf("unexpected code found: %T. Expecting ast.GenDecl", d)
+			return errorf("unexpected code found: %T. Expecting ast.GenDecl", d)
 		}
 		for _, s := range gd.Specs {
 			if _, ok := s.(*ast.ImportSpec); !ok {
-				return fmt.Errorf("unexpected code found: %T. Expecting ast.ImportSpec", s)
+				return errorf("unexpected code found: %T. Expecting ast.ImportSpec", s)
 			}
 		}
 	}
 	return nil
 }
+
+// collectErrorFuncs adds funcs and methods declared with error result
+// in the template read from r to dst.
+//
+// The collected funcs are used for propagating errors from {%= %} calls.
+func collectErrorFuncs(r io.Reader, filePath string, dst map[string]bool) error {
+	s := newScanner(r, filePath)
+	var errorFunc *funcType
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName {
+			continue
+		}
+		tagNameStr := string(t.Value)
+		if tagNameStr == "fragment" && errorFunc != nil {
+			dst[fragmentFunc(errorFunc).errorFuncKey()] = true
+			continue
+		}
+		if tagNameStr != "func" && tagNameStr != "interface" && tagNameStr != "iface" {
+			continue
+		}
+		if !s.Next() {
+			break
+		}
+		t = s.Token()
+		if t.ID != tagContents {
+			continue
+		}
+		if tagNameStr == "func" {
+			errorFunc = nil
+			f, err := parseFuncDef(t.Value)
+			if err == nil && f.errorResult {
+				dst[f.errorFuncKey()] = true
+				errorFunc = f
+			}
+			continue
+		}
+
+		n := bytes.IndexByte(t.Value, '{')
+		if n < 0 {
+			continue
+		}
+		exprStr := fmt.Sprintf("interface %s", t.Value[n:])
+		expr, err := goparser.ParseExpr(exprStr)
+		if err != nil {
+			continue
+		}
+		it, ok := expr.(*ast.InterfaceType)
+		if !ok {
+			continue
+		}
+		ifaceName := string(stripTrailingSpace(t.Value[:n]))
+		if n := strings.IndexByte(ifaceName, '['); n >= 0 {
+			ifaceName = ifaceName[:n]
+		}
+		for _, m := range it.Methods.List {
+			f, err := parseFuncDef([]byte(exprStr[m.Pos()-1 : m.End()-1]))
+			if err == nil && f.errorResult {
+				dst[ifaceName+"."+f.name] = true
+			}
+		}
+	}
+	return s.LastError()
+}
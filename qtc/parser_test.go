@@ -5,6 +5,7 @@ import (
 	"go/format"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/valyala/quicktemplate"
@@ -268,6 +269,32 @@ func TestParseTemplateCodeFailure(t *testing.T) {
 	testParseFailure(t, `{%code () %}`)
 }
 
+func TestParsePackageSuccess(t *testing.T) {
+	testParseSuccess(t, `{% package foo %}`)
+	testParseSuccess(t, `comment
+		{% package foo %}
+		{% import "bar" %}
+		{% func A() %}{% endfunc %}`)
+}
+
+func TestParsePackageFailure(t *testing.T) {
+	// empty package
+	testParseFailure(t, `{% package %}`)
+
+	// invalid package name
+	testParseFailure(t, `{% package foo-bar %}`)
+	testParseFailure(t, `{% package _ %}`)
+	testParseFailure(t, `{% package func %}`)
+	testParseFailure(t, `{% package "foo" %}`)
+
+	// duplicate package
+	testParseFailure(t, `{% package foo %}{% package foo %}`)
+
+	// package after imports and funcs
+	testParseFailure(t, `{% import "bar" %}{% package foo %}`)
+	testParseFailure(t, `{% func A() %}{% endfunc %}{% package foo %}`)
+}
+
 func TestParseImportSuccess(t *testing.T) {
 	// single line import
 	testParseSuccess(t, `{% import "github.com/foo/bar" %}`)
@@ -471,6 +498,162 @@ else
 
 	// method
 	testParseSuccess(t, "{%func (s *S) Foo(bar, baz string) %}{%endfunc%}")
+
+	// generics
+	testParseSuccess(t, "{%func List[T any](items []T) %}{%for _, x := range items %}{%= Item[T](x) %}{%endfor%}{%endfunc%}")
+	testParseSuccess(t, "{%func (l *List[T]) Foo(x T) %}{%endfunc%}")
+	testParseSuccess(t, "{%interface Page[T any] { Body(x T) } %}")
+}
+
+func TestParseWithErrors(t *testing.T) {
+	testParseWithErrors(t, "{% func A(n int) %}{%d n %}{% endfunc %}",
+		"func StreamA(qw422016 *qt422016.Writer, n int) error {",
+		"func WriteA(qq422016 qtio422016.Writer, n int) error {",
+		"func A(n int) string {",
+		"return qw422016.Err()\n}")
+
+	// nested calls propagate errors
+	testParseWithErrors(t, "{% func A() %}{%= b.C() %}{% endfunc %}",
+		"if qerr422016 := b.StreamC(qw422016); qerr422016 != nil {")
+
+	// loops are stopped on the first error
+	testParseWithErrors(t, "{% func A(s []string) %}{% for _, x := range s %}{%s x %}{% endfor %}{% endfunc %}",
+		"for _, x := range s {\n\t\tif qw422016.Err() != nil {")
+
+	// return returns the error
+	testParseWithErrors(t, "{% func A(n int) %}{% if n > 0 %}{% return %}{% endif %}{% endfunc %}",
+		"if n > 0 {\n\t\treturn qw422016.Err()")
+	testParseWithErrors(t, "{% func A() %}foo{% return %}{% endfunc %}",
+		"\treturn qw422016.Err()\n}")
+
+	// interface methods return errors
+	testParseWithErrors(t, "{% interface Page { Title() } %}",
+		"StreamTitle(qw422016 *qt422016.Writer) error",
+		"WriteTitle(qq422016 qtio422016.Writer) error")
+}
+
+func TestParseWithContext(t *testing.T) {
+	opts := &parseOptions{withContext: true}
+	testParseWithOptions(t, opts, "{% func A(n int) %}{%d n %}{% code _ = ctx %}{% endfunc %}",
+		`qtctx422016 "context"`,
+		"func StreamA(ctx qtctx422016.Context, qw422016 *qt422016.Writer, n int) {",
+		"func WriteA(ctx qtctx422016.Context, qq422016 qtio422016.Writer, n int) {",
+		"func A(ctx qtctx422016.Context, n int) string {",
+		"WriteA(ctx, qb422016, n)")
+	testParseWithOptions(t, opts, "{% func A() %}{% endfunc %}",
+		"func A(ctx qtctx422016.Context) string {")
+
+	// ctx is passed to nested calls
+	testParseWithOptions(t, opts, "{% func A() %}{%= b.C(1) %}{% endfunc %}",
+		"b.StreamC(ctx, qw422016, 1)")
+
+	// loops check ctx
+	testParseWithOptions(t, opts, "{% func A(s []string) %}{% for range s %}{% for range s %}{% endfor %}{% endfor %}{% for %}{% endfor %}{% endfunc %}",
+		"qi422016_1 := 0\n\tfor range s {\n\t\tqi422016_1++\n\t\tif qi422016_1%64 == 0 && ctx.Err() != nil {\n\t\t\treturn\n",
+		"qi422016_2 := 0",
+		"qi422016_3 := 0")
+	testParseWithOptions(t, &parseOptions{withContext: true, withErrors: true, contextCheckInterval: 10}, "{% func A(s []string) %}{% for range s %}{% endfor %}{% endfunc %}",
+		"if qi422016_1%10 == 0 && ctx.Err() != nil {\n\t\t\treturn ctx.Err()\n")
+
+	// interface methods accept ctx
+	testParseWithOptions(t, opts, "{% interface Page { Title(s string) } %}",
+		"Title(ctx qtctx422016.Context, s string) string",
+		"StreamTitle(ctx qtctx422016.Context, qw422016 *qt422016.Writer, s string)")
+}
+
+func TestParseErrorFuncs(t *testing.T) {
+	testParseWithOptions(t, &parseOptions{}, "{% func A(err error) error %}{% if err != nil %}{% return err %}{% endif %}foo{% endfunc %}",
+		"func StreamA(qw422016 *qt422016.Writer, err error) error {",
+		"func WriteA(qq422016 qtio422016.Writer, err error) error {",
+		"func A(err error) (string, error) {",
+		"if err != nil {\n\t\treturn err\n",
+		"return qw422016.Err()\n}",
+		"qerr422016 := WriteA(qb422016, err)",
+		"return qs422016, qerr422016")
+
+	// errors from funcs declared in other templates are propagated
+	opts := &parseOptions{errorFuncs: map[string]bool{"B": true, "P.C": true}}
+	testParseWithOptions(t, opts, "{% func A() error %}{%= B() %}{%= D() %}{% endfunc %}",
+		"if qerr422016 := StreamB(qw422016); qerr422016 != nil {",
+		"StreamD(qw422016)\n")
+
+	// methods are resolved via receiver, arg and range var types
+	testParseWithOptions(t, opts, "{% import \"example.com/pkg\" %}{% func (p *P) A(q Q, ps []*P) error %}{%= p.C() %}{%= q.C() %}{%= pkg.C() %}{% for _, x := range ps %}{%= x.C() %}{% endfor %}{% endfunc %}",
+		"if qerr422016 := p.StreamC(qw422016); qerr422016 != nil {",
+		"\tq.StreamC(qw422016)\n",
+		"\tpkg.StreamC(qw422016)\n",
+		"if qerr422016 := x.StreamC(qw422016); qerr422016 != nil {")
+	testParseWithOptions(t, opts, "{% func A(q Q) %}{%= q.C() %}{% endfunc %}",
+		"func StreamA(qw422016 *qt422016.Writer, q Q) {")
+
+	// methods are resolved via the types of vars declared in code tags
+	testParseWithOptions(t, opts, "{% func A() error %}{% code t := &P{} %}{%= t.C() %}{% code var q Q %}{%= q.C() %}{% endfunc %}",
+		"if qerr422016 := t.StreamC(qw422016); qerr422016 != nil {",
+		"\tq.StreamC(qw422016)\n")
+	testParseWithOptions(t, opts, "{% func A(q Q) error %}{% if q.ok %}{% code q := P{} %}{%= q.C() %}{% else %}{%= q.C() %}{% endif %}{% endfunc %}",
+		"if qerr422016 := q.StreamC(qw422016); qerr422016 != nil {",
+		"\tq.StreamC(qw422016)\n")
+
+	// methods called on receivers with unknown types are assumed to return error
+	// if the methods with the same name return error
+	testParseWithOptions(t, opts, "{% func (p *P) A() error %}{%= p.Item.C() %}{% code t := newT() %}{%= t.C() %}{%= t.D() %}{% endfunc %}",
+		"if qerr422016 := p.Item.StreamC(qw422016); qerr422016 != nil {",
+		"if qerr422016 := t.StreamC(qw422016); qerr422016 != nil {",
+		"\tt.StreamD(qw422016)\n")
+	testParseFailureWithOptions(t, opts, "{% func (p *P) A() %}{%= p.Item.C() %}{% endfunc %}")
+	testParseWithOptions(t, opts, "{% func A() %}{%= D() %}{% endfunc %}",
+		"func StreamA(qw422016 *qt422016.Writer) {")
+
+	// funcs without error result cannot call funcs returning error
+	testParseFailureWithOptions(t, opts, "{% func A() %}{%= B() %}{% endfunc %}")
+
+	// funcs without error result cannot return values
+	testParseFailure(t, "{% func A() %}{% return nil %}{% endfunc %}")
+}
+
+func TestCollectErrorFuncs(t *testing.T) {
+	s := `{% func A() error %}{% endfunc %}
+{% func B() %}{% fragment "b" %}{% endfragment %}{% endfunc %}
+{% func (p *P) C(n int) error %}{% fragment "c" %}{% endfragment %}{% endfunc %}
+{% interface Page { Title() error; Body() } %}
+{% plain %}{% func D() error %}{% endplain %}`
+	m := make(map[string]bool)
+	if err := collectErrorFuncs(bytes.NewBufferString(s), "./foobar.tpl", m); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]bool{"A": true, "P.C": true, "P.CFragment": true, "Page.Title": true}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("unexpected error funcs: %v. Expecting %v", m, expected)
+	}
+}
+
+func testParseWithErrors(t *testing.T, str string, expectedCode ...string) {
+	testParseWithOptions(t, &parseOptions{withErrors: true}, str, expectedCode...)
+}
+
+func testParseWithOptions(t *testing.T, opts *parseOptions, str string, expectedCode ...string) {
+	r := bytes.NewBufferString(str)
+	w := &bytes.Buffer{}
+	if err := parseWithOptions(w, r, "./foobar.tpl", "memory", opts); err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", str, err)
+	}
+	code, err := format.Source(removeLineDirectives(w.Bytes()))
+	if err != nil {
+		t.Fatalf("cannot format code generated for %q: %s\n%s", str, err, w.Bytes())
+	}
+	for _, s := range expectedCode {
+		if !bytes.Contains(code, []byte(s)) {
+			t.Fatalf("cannot find %q in the code generated for %q:\n%s", s, str, code)
+		}
+	}
+}
+
+func testParseFailureWithOptions(t *testing.T, opts *parseOptions, str string) {
+	r := bytes.NewBufferString(str)
+	w := &bytes.Buffer{}
+	if err := parseWithOptions(w, r, "./foobar.tpl", "memory", opts); err == nil {
+		t.Fatalf("expecting error when parsing %q", str)
+	}
 }
 
 func testParseFailure(t *testing.T, str string) {
@@ -497,7 +680,7 @@ func TestParseFile(t *testing.T) {
 	}
 	defer f.Close()
 
-	packageName, err := getPackageName(filename)
+	packageName, err := getPackageName(filename, filename+".go")
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -523,3 +706,95 @@ func TestParseFile(t *testing.T) {
 	}
 
 }
+
+func TestParseCapture(t *testing.T) {
+	opts := &parseOptions{}
+	testParseWithOptions(t, opts, `{% func A(n int) %}{% capture s %}<b>{%d n %}</b>{% endcapture %}<a title="{%s s %}">{%s= s %}</a>{% endfunc %}`,
+		"var s string\n\t{\n\t\tqb422016 := qt422016.AcquireByteBuffer()\n\t\tqw422016 := qw422016.AcquireCaptureWriter(qb422016)\n\t\tqw422016.N().S(`<b>`)\n\t\tqw422016.N().D(n)\n",
+		"\t\tqt422016.ReleaseWriter(qw422016)\n\t\ts = string(qb422016.B)\n\t\tqt422016.ReleaseByteBuffer(qb422016)\n\t}\n",
+		"qw422016.E().S(s)",
+		"qw422016.N().S(s)")
+	testParseWithOptions(t, opts, `{% func A() %}{% capture b []byte %}foo{% endcapture %}{%z b %}{% endfunc %}`,
+		"var b []byte",
+		"b = append([]byte(nil), qb422016.B...)")
+
+	// nested captures
+	testParseWithOptions(t, opts, `{% func A() %}{% capture a %}{% capture b %}x{% endcapture %}{%s b %}{% endcapture %}{%s a %}{% endfunc %}`,
+		"var a string",
+		"\t\tvar b string\n\t\t{\n\t\t\tqb422016 := qt422016.AcquireByteBuffer()")
+
+	// pushes inside the body are added to the stacks of the outer writer
+	testParseWithOptions(t, opts, `{% func A() %}{% capture s %}{% push "scripts" %}<script></script>{% endpush %}{% endcapture %}{%s= s %}{% endfunc %}`,
+		"qw422016 := qw422016.AcquireCaptureWriter(qb422016)\n\t\tif qw422016.PushStart(\"scripts\", \"\") {")
+
+	// the body is escaped independently of the surrounding context
+	testParseWithOptions(t, opts, `{% func A(x string) %}<script>{% capture s %}<b>{%s x %}</b>{% endcapture %}var a = {%s s %};</script>{% endfunc %}`,
+		"qw422016.E().S(x)",
+		"qw422016.N().Q(s)")
+
+	// break inside for loop inside capture
+	testParseWithOptions(t, opts, `{% func A(s []int) %}{% capture c %}{% for range s %}{% break %}{% endfor %}{% endcapture %}{%s c %}{% endfunc %}`,
+		"for range s {\n\t\t\tbreak\n")
+
+	// errors stop the body and are returned after releasing the buffers
+	testParseWithErrors(t, `{% func A(s []int) %}{% capture c %}{% for range s %}{%= B() %}{% endfor %}{% endcapture %}{%s c %}{% endfunc %}`,
+		"var qcerr422016_1 error\n\t\tfunc() {\n\t\t\tfor range s {\n\t\t\t\tif qerr422016 := StreamB(qw422016); qerr422016 != nil {\n\t\t\t\t\tqcerr422016_1 = qerr422016\n\t\t\t\t\treturn\n",
+		"qt422016.ReleaseByteBuffer(qb422016)\n\t\tif qcerr422016_1 != nil {\n\t\t\treturn qcerr422016_1\n\t\t}\n")
+	testParseWithOptions(t, &parseOptions{withErrors: true, withContext: true}, `{% func A(s []int) %}{% capture c %}{% for range s %}{% endfor %}{% endcapture %}{%s c %}{% endfunc %}`,
+		"if qi422016_1%64 == 0 && ctx.Err() != nil {\n\t\t\t\t\tqcerr422016_1 = ctx.Err()\n\t\t\t\t\treturn\n")
+	testParseWithOptions(t, &parseOptions{withContext: true}, `{% func A(s []int) %}{% capture c %}{% for range s %}{% endfor %}{% endcapture %}{%s c %}{% endfunc %}`,
+		"\t\tfunc() {\n\t\t\tqi422016_1 := 0\n",
+		"\t\t}()\n\t\tqt422016.ReleaseWriter(qw422016)\n")
+}
+
+func TestParseCaptureFailure(t *testing.T) {
+	// invalid variable
+	testParseFailure(t, `{% func A() %}{% capture %}{% endcapture %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% capture 1s %}{% endcapture %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% capture _ %}{% endcapture %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% capture s int %}{% endcapture %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% capture s string foo %}{% endcapture %}{% endfunc %}`)
+
+	// missing endcapture
+	testParseFailure(t, `{% func A() %}{% capture s %}{% endfunc %}`)
+
+	// leaving capture
+	testParseFailure(t, `{% func A() %}{% capture s %}{% return %}{% endcapture %}{% endfunc %}`)
+	testParseFailure(t, `{% func A(s []int) %}{% for range s %}{% capture c %}{% break %}{% endcapture %}{% endfor %}{% endfunc %}`)
+	testParseFailure(t, `{% func A(s []int) %}{% for range s %}{% capture c %}{% continue %}{% endcapture %}{% endfor %}{% endfunc %}`)
+}
+
+func TestParsePush(t *testing.T) {
+	opts := &parseOptions{}
+	testParseWithOptions(t, opts, `{% func A(src string) %}<b>{% push "scripts" %}<script src="{%s src %}"></script>{% endpush %}</b>{% endfunc %}`,
+		"qw422016.N().S(`<b>`)\n\tif qw422016.PushStart(\"scripts\", \"\") {\n\t\tqw422016.N().S(`<script src=\"`)\n\t\tqw422016.E().URL(src)\n",
+		"\t\tqw422016.PushEnd()\n\t}\n\tqw422016.N().S(`</b>`)")
+	testParseWithOptions(t, opts, `{% func A(name string) %}{% push "styles", "lib/" + name %}<link>{% endpush %}{% endfunc %}`,
+		"if qw422016.PushStart(\"styles\", \"lib/\"+name) {")
+
+	// stacks
+	testParseWithOptions(t, opts, `{% func A() %}<head>{% stack "styles" %}</head>{% endfunc %}`,
+		"qw422016.N().S(`<head>`)\n\tqw422016.Stack(\"styles\")\n\tqw422016.N().S(`</head>`)")
+
+	// the held back output is flushed before returning write errors
+	testParseWithOptions(t, &parseOptions{withErrors: true}, `{% func A() %}{% stack "styles" %}{% endfunc %}`,
+		"qerr422016 := StreamA(qw422016)\n\tif qerr422016 == nil {\n\t\tqerr422016 = qw422016.Flush()\n\t}\n\tqt422016.ReleaseWriter(qw422016)")
+}
+
+func TestParsePushFailure(t *testing.T) {
+	// invalid args
+	testParseFailure(t, `{% func A() %}{% push %}{% endpush %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% push "a", "b", "c" %}{% endpush %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% push "a" "b" %}{% endpush %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% stack %}{% endfunc %}`)
+
+	// missing endpush
+	testParseFailure(t, `{% func A() %}{% push "a" %}{% endfunc %}`)
+
+	// leaving push
+	testParseFailure(t, `{% func A() %}{% push "a" %}{% return %}{% endpush %}{% endfunc %}`)
+	testParseFailure(t, `{% func A(s []int) %}{% for range s %}{% push "a" %}{% break %}{% endpush %}{% endfor %}{% endfunc %}`)
+
+	// stack inside push
+	testParseFailure(t, `{% func A() %}{% push "a" %}{% stack "b" %}{% endpush %}{% endfunc %}`)
+}
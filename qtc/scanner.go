@@ -59,6 +59,14 @@ type scanner struct {
 	line    int
 	lineStr []byte
 
+	// tagLine and tagPos contain the position of the last tag
+	// opened with {%.
+	tagLine int
+	tagPos  int
+
+	// lastTagName is the name of the last tag returned by Next.
+	lastTagName string
+
 	nextTokenID int
 
 	capture       bool
@@ -67,6 +75,23 @@ type scanner struct {
 	collapseSpaceDepth int
 	stripSpaceDepth    int
 	rewind             bool
+
+	// whitespace is the whitespace mode for the text in func bodies
+	// and in blocks outside funcs outside stripspace and collapsespace tags.
+	// Text outside funcs isn't modified, since it is emitted into comments.
+	whitespace whitespaceMode
+	inFunc     bool
+	inBlock    bool
+
+	// recording is set when the tokens returned by Next are recorded
+	// into recorded.
+	recording bool
+	recorded  []token
+
+	// replaying is set when Next returns the tokens from replay
+	// instead of reading r.
+	replaying bool
+	replay    []token
 }
 
 func newScanner(r io.Reader, filePath string) *scanner {
@@ -76,6 +101,30 @@ func newScanner(r io.Reader, filePath string) *scanner {
 	}
 }
 
+// newReplayScanner returns scanner returning the given tokens,
+// which were recorded by another scanner.
+func newReplayScanner(tokens []token, filePath string) *scanner {
+	return &scanner{
+		filePath:  filePath,
+		replaying: true,
+		replay:    tokens,
+	}
+}
+
+// startRecording starts recording the tokens returned by Next.
+func (s *scanner) startRecording() {
+	s.recording = true
+	s.recorded = nil
+}
+
+// stopRecording stops recording the tokens and returns the recorded tokens.
+func (s *scanner) stopRecording() []token {
+	s.recording = false
+	tokens := s.recorded
+	s.recorded = nil
+	return tokens
+}
+
 func (s *scanner) Rewind() {
 	if s.rewind {
 		panic("BUG: duplicate Rewind call")
@@ -88,7 +137,34 @@ func (s *scanner) Next() bool {
 		s.rewind = false
 		return true
 	}
+	if s.replaying {
+		if len(s.replay) == 0 {
+			return false
+		}
+		s.t = s.replay[0]
+		s.replay = s.replay[1:]
+		if s.t.ID == tagName {
+			s.tagLine = s.t.line
+			s.tagPos = s.t.pos
+			s.lastTagName = string(s.t.Value)
+		}
+		return true
+	}
+	if !s.next() {
+		return false
+	}
+	if s.t.ID == tagName {
+		s.lastTagName = string(s.t.Value)
+	}
+	if s.recording {
+		t := s.t
+		t.Value = append([]byte(nil), t.Value...)
+		s.recorded = append(s.recorded, t)
+	}
+	return true
+}
 
+func (s *scanner) next() bool {
 	for {
 		if !s.scanToken() {
 			return false
@@ -128,7 +204,7 @@ func (s *scanner) Next() bool {
 				continue
 			case "endcollapsespace":
 				if s.collapseSpaceDepth == 0 {
-					s.err = fmt.Errorf("endcollapsespace tag found without the corresponding collapsespace tag")
+					s.err = errorf("endcollapsespace tag found without the corresponding collapsespace tag")
 					return false
 				}
 				if !s.readTagContents() {
@@ -138,7 +214,7 @@ func (s *scanner) Next() bool {
 				continue
 			case "endstripspace":
 				if s.stripSpaceDepth == 0 {
-					s.err = fmt.Errorf("endstripspace tag found without the corresponding stripspace tag")
+					s.err = errorf("endstripspace tag found without the corresponding stripspace tag")
 					return false
 				}
 				if !s.readTagContents() {
@@ -160,6 +236,16 @@ func (s *scanner) Next() bool {
 				s.t.init(text, s.t.line, s.t.pos)
 				s.t.Value = append(s.t.Value[:0], '\n')
 				return true
+			case "func":
+				s.inFunc = true
+			case "endfunc":
+				s.inFunc = false
+			case "block":
+				s.inBlock = s.inBlock || !s.inFunc
+			case "endblock":
+				if !s.inFunc {
+					s.inBlock = false
+				}
 			}
 		}
 		return true
@@ -221,7 +307,7 @@ func (s *scanner) skipUntilTag(tagName string) bool {
 		}
 	}
 	if !ok {
-		s.err = fmt.Errorf("cannot find %q tag: %s", tagName, s.err)
+		s.err = errorf("cannot find %q tag: %s", tagName, s.err)
 	}
 	return ok
 }
@@ -258,23 +344,33 @@ func (s *scanner) readText() bool {
 		}
 		if s.c == '%' {
 			s.nextTokenID = tagName
+			s.tagLine = s.line
+			s.tagPos = s.pos() - len(strTagOpen)
 			ok = true
 			break
 		}
 		s.unreadByte('{')
 		s.appendByte()
 	}
-	if s.stripSpaceDepth > 0 {
+	switch {
+	case s.stripSpaceDepth > 0:
 		s.t.Value = stripSpace(s.t.Value)
-	} else if s.collapseSpaceDepth > 0 {
+	case s.collapseSpaceDepth > 0:
 		s.t.Value = collapseSpace(s.t.Value)
+	case s.inFunc || s.inBlock:
+		switch s.whitespace {
+		case whitespaceStrip:
+			s.t.Value = stripSpace(s.t.Value)
+		case whitespaceCollapse:
+			s.t.Value = collapseSpace(s.t.Value)
+		}
 	}
 	return ok
 }
 
 func (s *scanner) readTagName() bool {
 	s.skipSpace()
-	s.t.init(tagName, s.line, s.pos())
+	s.t.init(tagName, s.line, s.lastBytePos())
 	for {
 		if s.isSpace() || s.c == '%' {
 			if s.c == '%' {
@@ -290,7 +386,7 @@ func (s *scanner) readTagName() bool {
 			}
 			continue
 		}
-		s.err = fmt.Errorf("unexpected character: '%c'", s.c)
+		s.err = errorf("unexpected character: '%c'", s.c)
 		s.unreadByte('~')
 		return false
 	}
@@ -298,7 +394,7 @@ func (s *scanner) readTagName() bool {
 
 func (s *scanner) readTagContents() bool {
 	s.skipSpace()
-	s.t.init(tagContents, s.line, s.pos())
+	s.t.init(tagContents, s.line, s.lastBytePos())
 	for {
 		if s.c != '%' {
 			s.appendByte()
@@ -380,15 +476,15 @@ func (s *scanner) LastError() error {
 	}
 	if s.err == io.ErrUnexpectedEOF && s.t.ID == text {
 		if s.collapseSpaceDepth > 0 {
-			return fmt.Errorf("missing endcollapsespace tag at %s", s.Context())
+			return errorf("missing endcollapsespace tag at %s", s.Context())
 		}
 		if s.stripSpaceDepth > 0 {
-			return fmt.Errorf("missing endstripspace tag at %s", s.Context())
+			return errorf("missing endstripspace tag at %s", s.Context())
 		}
 		return nil
 	}
 
-	return fmt.Errorf("error when reading %s at %s: %s",
+	return errorf("error when reading %s at %s: %s",
 		tokenIDToStr(s.t.ID), s.Context(), s.err)
 }
 
@@ -416,19 +512,28 @@ func (s *scanner) pos() int {
 	return len(s.lineStr)
 }
 
-func (s *scanner) Context() string {
-	t := s.Token()
-	return fmt.Sprintf("file %q, line %d, pos %d, token %s, last line %s",
-		s.filePath, t.line+1, t.pos, snippet(t.Value), snippet(s.lineStr))
+// lastBytePos returns the position of the last byte read by nextByte.
+func (s *scanner) lastBytePos() int {
+	if len(s.lineStr) == 0 {
+		return 0
+	}
+	return len(s.lineStr) - 1
 }
 
-func (s *scanner) WriteLineComment(w io.Writer) {
-	fmt.Fprintf(w, "//line %s:%d\n", s.filePath, s.t.line+1)
+// Context returns the position of the current token in file:line:col form.
+func (s *scanner) Context() tagContext {
+	t := s.Token()
+	return tagContext{
+		filePath: s.filePath,
+		line:     t.line + 1,
+		col:      t.pos + 1,
+	}
 }
 
-func snippet(s []byte) string {
-	if len(s) <= 40 {
-		return fmt.Sprintf("%q", s)
-	}
-	return fmt.Sprintf("%q ... %q", s[:20], s[len(s)-20:])
+// WriteLineComment writes //line directive pointing to the current token.
+//
+// The directive is written at the start of line, since Go ignores
+// indented directives.
+func (s *scanner) WriteLineComment(w io.Writer) {
+	fmt.Fprintf(w, "%s", lineDirective(s.filePath, s.t.line, s.t.pos))
 }
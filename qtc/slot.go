@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	gotoken "go/token"
+)
+
+// defaultSlotArg is the name of the variable containing the call body
+// without named slots.
+const defaultSlotArg = "qslot" + mangleSuffix
+
+// parseCall parses call tag.
+//
+// The call body is compiled into closure, which is passed to the callee
+// as the last arg. Named slots are compiled into closures with the given
+// names, so they may be passed to the callee in arbitrary args.
+func (p *parser) parseCall() error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	callStr := "call " + string(t.Value)
+	f, err := parseFuncCall(t.Value)
+	if err != nil {
+		return errorf("error in %q at %s: %s", callStr, s.Context(), err)
+	}
+	p.resolveCallErrors(f)
+	p.applyOptions(f)
+
+	// Slot closures are declared in a separate scope, so they don't clash
+	// with the variables declared in the func.
+	p.Printf("{")
+	p.prefix += "\t"
+	errVar := ""
+	if p.funcWithErrors || len(p.slotErrVar) > 0 {
+		p.callsCount++
+		errVar = fmt.Sprintf("qserr%s_%d", mangleSuffix, p.callsCount)
+		p.Printf("var %s error", errVar)
+	}
+	slots := make(map[string]bool)
+	var space [][]byte
+	for s.Next() {
+		t := s.Token()
+		switch t.ID {
+		case text:
+			if len(stripSpace(t.Value)) == 0 {
+				space = append(space, t.Value)
+				continue
+			}
+		case tagName:
+			switch string(t.Value) {
+			case "slot":
+				if err = p.parseSlot(slots, errVar); err != nil {
+					return errorf("error in %q: %s", callStr, err)
+				}
+				space = space[:0]
+				continue
+			case "endcall":
+				if err = skipTagContents(s); err != nil {
+					return err
+				}
+				if len(slots) == 0 {
+					// The call with empty body.
+					if err = p.emitSlot(defaultSlotArg, callStr, errVar, space, ""); err != nil {
+						return err
+					}
+				}
+				return p.emitCallEnd(f, callStr, errVar, len(slots) == 0)
+			}
+		}
+		if len(slots) > 0 {
+			return errorf("unexpected %s found between slots in %q at %s", t, callStr, s.Context())
+		}
+
+		// The call body without named slots.
+		s.Rewind()
+		if err = p.emitSlot(defaultSlotArg, callStr, errVar, space, "endcall"); err != nil {
+			return err
+		}
+		return p.emitCallEnd(f, callStr, errVar, true)
+	}
+	if err := s.LastError(); err != nil {
+		return errorf("cannot parse %q: %s", callStr, err)
+	}
+	return errorf("cannot find endcall tag for %q at %s", callStr, s.Context())
+}
+
+// parseSlot parses named slot inside call tag.
+func (p *parser) parseSlot(slots map[string]bool, errVar string) error {
+	s := p.s
+	t, err := expectTagContents(s)
+	if err != nil {
+		return err
+	}
+	slotStr := "slot " + string(t.Value)
+	name := string(stripSpace(t.Value))
+	if !gotoken.IsIdentifier(name) {
+		return errorf("invalid %q at %s: slot name must be a valid identifier", slotStr, s.Context())
+	}
+	if slots[name] {
+		return errorf("duplicate %q at %s", slotStr, s.Context())
+	}
+	slots[name] = true
+	return p.emitSlot(name, slotStr, errVar, nil, "endslot")
+}
+
+// emitSlot emits closure with the given name for the slot body ending
+// with endTag. The closure body starts with the given space.
+// Errors occurred inside the closure are stored in errVar.
+//
+// The slot is considered empty if endTag is empty.
+func (p *parser) emitSlot(name, slotStr, errVar string, space [][]byte, endTag string) error {
+	p.Printf("%s := func(qw%s *qt%s.Writer) {", name, mangleSuffix, mangleSuffix)
+
+	// The slot is parsed as a separate func, so the state
+	// of the current func must be restored afterwards.
+	state := *p
+	p.prefix += "\t"
+	p.esc = escContext{}
+	p.escDead = false
+	p.funcTerminated = false
+	p.funcWithErrors = false
+	p.slotErrVar = errVar
+	p.forDepth = 0
+	p.switchDepth = 0
+	p.bufferTag = ""
+	p.layoutFunc = nil
+	for _, text := range space {
+		p.emitText(text)
+	}
+	if len(endTag) > 0 {
+		if err := p.parseFuncBody(slotStr, endTag); err != nil {
+			return err
+		}
+	}
+	loopsCount := p.loopsCount
+	callsCount := p.callsCount
+	*p = state
+	p.loopsCount = loopsCount
+	p.callsCount = callsCount
+	p.Printf("}")
+	return nil
+}
+
+// emitCallEnd emits the call of f with slots and closes the call scope.
+func (p *parser) emitCallEnd(f *funcType, callStr, errVar string, withDefaultSlot bool) error {
+	if withDefaultSlot {
+		f.argNames += ", " + defaultSlotArg
+	}
+	if err := p.emitCall(f, callStr); err != nil {
+		return err
+	}
+	if len(errVar) > 0 {
+		p.Printf("if %s != nil {", errVar)
+		p.emitReturnError(errVar)
+		p.Printf("}")
+	}
+	p.prefix = p.prefix[1:]
+	p.Printf("}")
+	return nil
+}
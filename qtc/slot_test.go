@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseCall(t *testing.T) {
+	opts := &parseOptions{}
+
+	// call body is passed as the last arg
+	testParseWithOptions(t, opts, `{% func A(s string) %}{% call Card(s) %} <b>{%s s %}</b>{% endcall %}{% endfunc %}`,
+		"{\n\t\tqslot422016 := func(qw422016 *qt422016.Writer) {\n\t\t\tqw422016.N().S(` <b>`)\n\t\t\tqw422016.E().S(s)\n",
+		"StreamCard(qw422016, s, qslot422016)\n\t}")
+	testParseWithOptions(t, opts, `{% func A() %}{% call p.Card[int]() %}{% endcall %}{% endfunc %}`,
+		"qslot422016 := func(qw422016 *qt422016.Writer) {\n\t\t}",
+		"p.StreamCard[int](qw422016, qslot422016)")
+
+	// named slots
+	testParseWithOptions(t, opts, `{% func A() %}{% call Modal(header, nil, footer) %}
+		{% slot header %}<h1>{% endslot %}
+		{% slot footer %}{% return %}{% endslot %}
+	{% endcall %}{% endfunc %}`,
+		"header := func(qw422016 *qt422016.Writer) {\n\t\t\tqw422016.N().S(`<h1>`)\n\t\t}",
+		"footer := func(qw422016 *qt422016.Writer) {\n\t\t\treturn\n\t\t}",
+		"StreamModal(qw422016, header, nil, footer)")
+
+	// nested calls
+	testParseWithOptions(t, opts, `{% func A() %}{% call Card() %}{% call Card() %}{% endcall %}{% endcall %}{% endfunc %}`,
+		"qslot422016 := func(qw422016 *qt422016.Writer) {\n\t\t\t{\n\t\t\t\tqslot422016 := func(qw422016 *qt422016.Writer) {")
+
+	// slot args are rendered via {%= %}
+	testParseWithOptions(t, opts, `{% import "github.com/valyala/quicktemplate" %}{% func Card(title string, body, footer quicktemplate.Slot) %}{%= body() %}{%= footer() %}{% endfunc %}`,
+		"if body != nil {\n\t\tbody(qw422016)\n\t}",
+		"if footer != nil {\n\t\tfooter(qw422016)\n\t}")
+	testParseWithOptions(t, &parseOptions{runtimePath: "example.com/qt"}, `{% import qt "example.com/qt" %}{% func Card(body qt.Slot) %}{%= body() %}{% endfunc %}`,
+		"if body != nil {\n\t\tbody(qw422016)\n\t}")
+
+	// Slot types from other packages aren't slots
+	testParseWithOptions(t, opts, `{% import "example.com/models" %}{% func Card(body models.Slot) %}{%= body() %}{% endfunc %}`,
+		"\tstreambody(qw422016)\n")
+
+	// errors inside slots are returned after the call
+	testParseWithOptions(t, &parseOptions{withErrors: true}, `{% func A() %}{% call Card() %}{%= B() %}{% endcall %}{% endfunc %}`,
+		"var qserr422016_1 error",
+		"if qerr422016 := StreamB(qw422016); qerr422016 != nil {\n\t\t\t\tqserr422016_1 = qerr422016\n\t\t\t\treturn\n",
+		"if qerr422016 := StreamCard(qw422016, qslot422016); qerr422016 != nil {\n\t\t\treturn qerr422016\n\t\t}\n\t\tif qserr422016_1 != nil {\n\t\t\treturn qserr422016_1\n\t\t}")
+
+	// slots capture ctx
+	testParseWithOptions(t, &parseOptions{withContext: true}, `{% func A() %}{% call Card() %}{%= B() %}{% endcall %}{% endfunc %}`,
+		"StreamB(ctx, qw422016)",
+		"StreamCard(ctx, qw422016, qslot422016)")
+}
+
+func TestParseCallFailure(t *testing.T) {
+	// missing endcall
+	testParseFailure(t, `{% func A() %}{% call Card() %}foo{% endfunc %}`)
+
+	// invalid call
+	testParseFailure(t, `{% func A() %}{% call Card %}{% endcall %}{% endfunc %}`)
+
+	// text between named slots
+	testParseFailure(t, `{% func A() %}{% call Card(a) %}{% slot a %}{% endslot %}foo{% endcall %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% call Card(a) %}{% slot a %}{% endslot %}{%s "foo" %}{% endcall %}{% endfunc %}`)
+
+	// named slot after the call body
+	testParseFailure(t, `{% func A() %}{% call Card(a) %}foo{% slot a %}{% endslot %}{% endcall %}{% endfunc %}`)
+
+	// invalid and duplicate slot names
+	testParseFailure(t, `{% func A() %}{% call Card(a) %}{% slot %}{% endslot %}{% endcall %}{% endfunc %}`)
+	testParseFailure(t, `{% func A() %}{% call Card(a) %}{% slot a %}{% endslot %}{% slot a %}{% endslot %}{% endcall %}{% endfunc %}`)
+
+	// break inside slot
+	testParseFailure(t, `{% func A(s []int) %}{% for range s %}{% call Card() %}{% break %}{% endcall %}{% endfor %}{% endfunc %}`)
+
+	// slot args cannot accept args
+	testParseFailure(t, `{% import "github.com/valyala/quicktemplate" %}{% func Card(body quicktemplate.Slot) %}{%= body(1) %}{% endfunc %}`)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// qtcVersion is the version of the code generated by qtc.
+//
+// It must be bumped on changes affecting the generated code, since
+// template files are compiled again only if their' source hash changes.
+// The source hash includes qtcVersion. TestQtcVersion fails
+// if the generated code changes while qtcVersion remains the same.
+const qtcVersion = "1.9.2"
+
+// sourceHashPrefix is the prefix of the line with the source hash
+// in the header of the generated code.
+const sourceHashPrefix = "// Source hash: "
+
+// templateFile is the template file to compile.
+type templateFile struct {
+	filename string
+
+	// outfile is the name of the Go file generated for the template file.
+	// See getOutFilename for details.
+	outfile string
+
+	src         []byte
+	packageName string
+	opts        *parseOptions
+}
+
+// readTemplateFile reads the given template file.
+//
+// errorFuncs contains error funcs in the directory with the file.
+// See getErrorFuncs for details. packageName is the package name
+// for the generated file. See getFilePackageName for details.
+func readTemplateFile(filename string, errorFuncs map[string]bool, packageName string) (*templateFile, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file %q: %s", filename, err)
+	}
+	cfg, err := getConfig(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	outfile, err := getOutFilename(filename)
+	if err != nil {
+		return nil, err
+	}
+	tf := &templateFile{
+		filename:    filename,
+		outfile:     outfile,
+		src:         src,
+		packageName: packageName,
+		opts:        newParseOptions(cfg, errorFuncs),
+	}
+	tf.opts.sourceHash = tf.sourceHash()
+	return tf, nil
+}
+
+// generateCode returns formatted Go code for tf.
+func (tf *templateFile) generateCode() ([]byte, error) {
+	var uglyCode bytes.Buffer
+	if err := parseWithOptions(&uglyCode, bytes.NewReader(tf.src), tf.filename, tf.packageName, tf.opts); err != nil {
+		if _, ok := err.(parseErrors); ok {
+			// Parse errors already contain the file name.
+			return nil, err
+		}
+		return nil, fmt.Errorf("error when parsing file %q: %s", tf.filename, err)
+	}
+
+	// prettify the generated code
+	prettyCode, err := format.Source(uglyCode.Bytes())
+	if err != nil {
+		if *check {
+			return nil, fmt.Errorf("error when formatting compiled code for %q: %s", tf.filename, err)
+		}
+		tmpfile := tf.filename + ".go.tmp"
+		if err := ioutil.WriteFile(tmpfile, uglyCode.Bytes(), 0666); err != nil {
+			return nil, fmt.Errorf("cannot write file %q: %s", tmpfile, err)
+		}
+		return nil, fmt.Errorf("error when formatting compiled code for %q: %s. See %q for details", tf.filename, err, tmpfile)
+	}
+	code := adjustLineDirectives(prettyCode)
+	if c := removeRedundantLineDirectives(code); len(c) < len(code) {
+		// Format the code again, so the lines, which were separated
+		// by the removed directives, are aligned.
+		if code, err = format.Source(c); err != nil {
+			return nil, fmt.Errorf("error when formatting compiled code for %q: %s", tf.filename, err)
+		}
+	}
+	return code, nil
+}
+
+// sourceHash returns the hash of everything the generated code depends on:
+// qtc version, parse options including settings from qtc.toml files,
+// package name, template contents, the html contexts of the extended
+// layout blocks and the contents of files included via cat tags.
+func (tf *templateFile) sourceHash() string {
+	h := sha256.New()
+	opts := tf.opts
+	fmt.Fprintf(h, "qtc %s\n", qtcVersion)
+	fmt.Fprintf(h, "package %s\n", tf.packageName)
+	fmt.Fprintf(h, "errors=%v context=%v ctxcheck=%d\n", opts.withErrors, opts.withContext, opts.contextCheckInterval)
+	fmt.Fprintf(h, "variants=%s unexportedVariants=%s\n", opts.variants, opts.unexportedVariants)
+	fmt.Fprintf(h, "noAutoEscape=%v text=%v runtime=%q whitespace=%s\n", opts.noAutoEscape, opts.textMode, opts.runtimePath, opts.whitespace)
+	fmt.Fprintf(h, "header %q\n", opts.header)
+	errorFuncs := make([]string, 0, len(opts.errorFuncs))
+	for name := range opts.errorFuncs {
+		errorFuncs = append(errorFuncs, name)
+	}
+	sort.Strings(errorFuncs)
+	fmt.Fprintf(h, "errorFuncs=%s\n", strings.Join(errorFuncs, ","))
+	fmt.Fprintf(h, "%d\n", len(tf.src))
+	h.Write(tf.src)
+
+	// Block overrides are escaped in the html contexts of the layout blocks,
+	// which may be declared in other template files.
+	if !opts.textMode && !opts.noAutoEscape {
+		blockContexts := extendedBlockContexts(tf.src, tf.filename)
+		blocks := make([]string, 0, len(blockContexts))
+		for block := range blockContexts {
+			blocks = append(blocks, block)
+		}
+		sort.Strings(blocks)
+		for _, block := range blocks {
+			fmt.Fprintf(h, "block %s %+v\n", block, blockContexts[block])
+		}
+	}
+
+	// The contents of cat files is included, since it is embedded
+	// into the generated code. Missing files are reported by the parser.
+	s := newScanner(bytes.NewReader(tf.src), tf.filename)
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName || string(t.Value) != "cat" || !s.Next() {
+			continue
+		}
+		filename, err := strconv.Unquote(string(s.Token().Value))
+		if err != nil {
+			continue
+		}
+		data, err := readFile(tf.filename, filename)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(h, "cat %q %d\n", filename, len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// templateDeps returns the files the code generated for the template src
+// at filePath depends on besides the template itself, i.e. the files
+// included via cat tags and the template files declaring extended layouts.
+//
+// Missing cat files are returned too, so the template may be compiled
+// again after they appear.
+func templateDeps(src []byte, filePath string) []string {
+	var deps []string
+	imports := make(map[string]string)
+	s := newScanner(bytes.NewReader(src), filePath)
+	for s.Next() {
+		t := s.Token()
+		if t.ID != tagName {
+			continue
+		}
+		tagNameStr := string(t.Value)
+		if !s.Next() {
+			break
+		}
+		contents := s.Token().Value
+		switch tagNameStr {
+		case "import":
+			addImports(imports, contents)
+		case "cat":
+			filename, err := strconv.Unquote(string(contents))
+			if err != nil {
+				continue
+			}
+			if path, err := getFilePath(filePath, filename); err == nil {
+				deps = append(deps, path)
+			}
+		case "extends":
+			_, _, layout, err := parseExtendsDef(contents)
+			if err != nil {
+				continue
+			}
+			if filename, _, _ := findLayoutFile(filePath, layout, imports); len(filename) > 0 {
+				deps = append(deps, filename)
+			}
+		}
+	}
+	return deps
+}
+
+// readSourceHash returns the source hash from the header
+// of the given generated file.
+//
+// Empty string is returned if the file or the hash is missing.
+func readSourceHash(filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// The hash is located in the header before the package clause.
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, sourceHashPrefix) {
+			return line[len(sourceHashPrefix):]
+		}
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateFileSourceHash(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	infile := filepath.Join(dir, "a.qtpl")
+	catFile := filepath.Join(dir, "a.txt")
+	writeWatchedFile(t, infile, `{% func A() %}{% cat "a.txt" %}{% endfunc %}`)
+	writeWatchedFile(t, catFile, `foo`)
+
+	h := getSourceHash(t, infile, nil)
+	if h1 := getSourceHash(t, infile, nil); h1 != h {
+		t.Fatalf("unstable source hash: %q vs %q", h, h1)
+	}
+	hashes := map[string]string{
+		"initial": h,
+	}
+	addHash := func(name, h string) {
+		t.Helper()
+		for prevName, prevHash := range hashes {
+			if h == prevHash {
+				t.Fatalf("source hash for %s matches source hash for %s", name, prevName)
+			}
+		}
+		hashes[name] = h
+	}
+
+	// error funcs
+	addHash("errorFuncs", getSourceHash(t, infile, map[string]bool{"B": true}))
+
+	// options
+	*withErrors = true
+	addHash("errors", getSourceHash(t, infile, nil))
+	*withErrors = false
+
+	// cat file contents
+	writeWatchedFile(t, catFile, `bar`)
+	addHash("cat", getSourceHash(t, infile, nil))
+
+	// template contents
+	writeWatchedFile(t, infile, `{% func A() %}{% cat "a.txt" %} {% endfunc %}`)
+	addHash("template", getSourceHash(t, infile, nil))
+}
+
+func getSourceHash(t *testing.T, infile string, errorFuncs map[string]bool) string {
+	t.Helper()
+	tf, err := readTemplateFile(infile, errorFuncs, getTestPackageName(t, infile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return tf.opts.sourceHash
+}
+
+func TestCompileFileSkipUnchanged(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	infile := filepath.Join(dir, "a.qtpl")
+	outfile := infile + ".go"
+	writeWatchedFile(t, infile, `{% func A() %}a{% endfunc %}`)
+
+	testCompileFile(t, infile, nil, true)
+	if h := readSourceHash(outfile); h != getSourceHash(t, infile, nil) {
+		t.Fatalf("unexpected source hash in %q: %q. Expecting %q", outfile, h, getSourceHash(t, infile, nil))
+	}
+	fi, err := os.Stat(outfile)
+	if err != nil {
+		t.Fatalf("cannot stat %q: %s", outfile, err)
+	}
+	modTime := fi.ModTime()
+
+	// unchanged file is skipped
+	testCompileFile(t, infile, nil, false)
+
+	// unchanged file is compiled with -force, but isn't rewritten
+	*force = true
+	testCompileFile(t, infile, nil, true)
+	*force = false
+	if fi, err = os.Stat(outfile); err != nil {
+		t.Fatalf("cannot stat %q: %s", outfile, err)
+	}
+	if !fi.ModTime().Equal(modTime) {
+		t.Fatalf("unexpected rewrite of %q", outfile)
+	}
+
+	// changed error funcs
+	testCompileFile(t, infile, map[string]bool{"B": true}, true)
+
+	// changed file
+	writeWatchedFile(t, infile, `{% func A() %}b{% endfunc %}`)
+	testCompileFile(t, infile, map[string]bool{"B": true}, true)
+
+	// the generated file without hash
+	writeWatchedFile(t, outfile, "package foo\n")
+	testCompileFile(t, infile, map[string]bool{"B": true}, true)
+}
+
+func testCompileFile(t *testing.T, infile string, errorFuncs map[string]bool, expectedCompiled bool) {
+	t.Helper()
+	compiled, err := compileFile(infile, errorFuncs, getTestPackageName(t, infile))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if compiled != expectedCompiled {
+		t.Fatalf("unexpected compiled=%v for %q. Expecting %v", compiled, infile, expectedCompiled)
+	}
+}
+
+// TestQtcVersion verifies that qtcVersion is bumped on changes
+// in the generated code, so the templates compiled by the previous
+// qtc version aren't skipped.
+//
+// The hash of the code generated for the templates from the repository
+// is recorded together with qtcVersion in qtcVersionFilename.
+func TestQtcVersion(t *testing.T) {
+	data, err := ioutil.ReadFile(qtcVersionFilename)
+	if err != nil {
+		t.Fatalf("cannot read %q: %s", qtcVersionFilename, err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		t.Fatalf("unexpected contents of %q: %q. Expecting qtc version followed by code hash", qtcVersionFilename, data)
+	}
+	version, codeHash := fields[0], fields[1]
+	h := generatedCodeHash(t)
+	if version == qtcVersion && codeHash != h {
+		t.Fatalf("the generated code has been changed, so qtcVersion must be bumped. "+
+			"Put the new version followed by %s into %q after that", h, qtcVersionFilename)
+	}
+	if version != qtcVersion || codeHash != h {
+		t.Fatalf("unexpected contents of %q: %q. Expecting %q", qtcVersionFilename, data, qtcVersion+" "+h)
+	}
+}
+
+const qtcVersionFilename = "testdata/qtc_version.txt"
+
+// generatedCodeHash returns the hash of the code generated for the templates
+// from the repository with various parse options.
+func generatedCodeHash(t *testing.T) string {
+	var filenames []string
+	for _, pattern := range []string{"testdata/*.qtpl", "../testdata/templates/*.qtpl", "../examples/*/templates/*.qtpl"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatalf("cannot glob %q: %s", pattern, err)
+		}
+		filenames = append(filenames, matches...)
+	}
+	if len(filenames) == 0 {
+		t.Fatalf("cannot find template files")
+	}
+	optss := []*parseOptions{
+		{},
+		{noAutoEscape: true},
+		{withErrors: true, withContext: true, contextCheckInterval: 10},
+		{textMode: true},
+	}
+	h := sha256.New()
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("cannot read %q: %s", filename, err)
+		}
+		for _, opts := range optss {
+			var code bytes.Buffer
+			if err := parseWithOptions(&code, bytes.NewReader(src), filename, "templates", opts); err != nil {
+				t.Fatalf("cannot parse %q: %s", filename, err)
+			}
+			fmt.Fprintf(h, "%s %d\n", filename, code.Len())
+			h.Write(code.Bytes())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	goparser "go/parser"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// packageCheck contains the results of type checking the code generated
+// for template files in a directory.
+type packageCheck struct {
+	once sync.Once
+
+	// errs contains type errors keyed by template file names.
+	errs map[string]parseErrors
+}
+
+// The results of type checking and imported packages are cached until
+// resetTypeChecks call, so the cache doesn't grow across watcher polls
+// and changes in imported packages are picked up.
+var (
+	packageChecksLock sync.Mutex
+	packageChecks     = make(map[string]*packageCheck)
+
+	// typeCheckLock serializes type checking, since the importer
+	// isn't safe for concurrent use.
+	typeCheckLock sync.Mutex
+	typeCheckFset *gotoken.FileSet
+
+	// typeCheckImporter imports packages from source, so it works
+	// without compiled packages. Imported packages are cached.
+	typeCheckImporter types.ImporterFrom
+)
+
+// resetTypeChecks drops cached type checking results and imported packages.
+func resetTypeChecks() {
+	packageChecksLock.Lock()
+	packageChecks = make(map[string]*packageCheck)
+	packageChecksLock.Unlock()
+
+	typeCheckLock.Lock()
+	typeCheckFset = nil
+	typeCheckImporter = nil
+	typeCheckLock.Unlock()
+}
+
+// typeCheckTemplate returns type errors found in the code generated
+// for the given template file.
+//
+// The generated code is type-checked together with the code generated
+// for other template files in the same directory and with .go files
+// in the package of the generated code.
+// The errors point to the template file. errorFuncs contains error funcs
+// in the directory with the file. See getErrorFuncs for details.
+// packageName is the package name for templates in the directory.
+//
+// Nothing is returned if other template files in the directory cannot
+// be compiled, since their' errors are reported separately.
+func typeCheckTemplate(filename string, errorFuncs map[string]bool, packageName string) error {
+	dir := filepath.Dir(filename)
+	templates, err := readPackageTemplates(dir, getFileConfig(filename).exts, errorFuncs, packageName)
+	if err != nil {
+		return nil
+	}
+
+	// The results are cached per directory contents, so the package
+	// is type-checked only once for all its' template files.
+	goFiles := readGoFiles(templates)
+	key := packageCheckKey(dir, templates, goFiles)
+	packageChecksLock.Lock()
+	pc := packageChecks[key]
+	if pc == nil {
+		pc = &packageCheck{}
+		packageChecks[key] = pc
+	}
+	packageChecksLock.Unlock()
+	pc.once.Do(func() {
+		pc.errs = typeCheckPackage(templates, goFiles)
+	})
+
+	if errs := pc.errs[filepath.Base(filename)]; len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// readPackageTemplates reads template files with the given exts in dir.
+func readPackageTemplates(dir string, exts []string, errorFuncs map[string]bool, packageName string) ([]*templateFile, error) {
+	filenames, err := globTemplates(dir, exts)
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]*templateFile, 0, len(filenames))
+	for _, filename := range filenames {
+		tf, err := readTemplateFile(filename, errorFuncs, packageName)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, tf)
+	}
+	return templates, nil
+}
+
+// packageCheckKey returns the key for packageChecks.
+//
+// The key depends on the template files and the contents of .go files
+// type-checked together with the generated code.
+func packageCheckKey(dir string, templates []*templateFile, goFiles []goFile) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", dir)
+	for _, tf := range templates {
+		fmt.Fprintf(h, "%s %s\n", tf.filename, tf.opts.sourceHash)
+	}
+	for _, gf := range goFiles {
+		fmt.Fprintf(h, "%s %d\n", gf.filename, len(gf.src))
+		h.Write(gf.src)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// goFile is .go file from the package of the generated code.
+type goFile struct {
+	filename string
+	src      []byte
+}
+
+// readGoFiles reads .go files located in the directory with the code
+// generated for the given templates. See getOutFilename for details.
+//
+// The generated files, tests and files excluded by build constraints
+// are skipped.
+func readGoFiles(templates []*templateFile) []goFile {
+	if len(templates) == 0 {
+		return nil
+	}
+	outfiles := make(map[string]bool, len(templates))
+	for _, tf := range templates {
+		outfiles[tf.outfile] = true
+	}
+	goDir := filepath.Dir(templates[0].outfile)
+	filenames, _ := filepath.Glob(filepath.Join(goDir, "*.go"))
+	var goFiles []goFile
+	for _, filename := range filenames {
+		name := filepath.Base(filename)
+		if outfiles[filename] || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := build.Default.MatchFile(goDir, name); err != nil || !ok {
+			continue
+		}
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		goFiles = append(goFiles, goFile{
+			filename: filename,
+			src:      src,
+		})
+	}
+	return goFiles
+}
+
+// typeCheckPackage type-checks the code generated for the given templates
+// together with the given .go files. See readGoFiles for details.
+//
+// It returns type errors keyed by template file names. Errors in sibling
+// .go files are left for go build.
+func typeCheckPackage(templates []*templateFile, goFiles []goFile) map[string]parseErrors {
+	typeCheckLock.Lock()
+	defer typeCheckLock.Unlock()
+
+	if typeCheckImporter == nil {
+		typeCheckFset = gotoken.NewFileSet()
+		typeCheckImporter = importer.ForCompiler(typeCheckFset, "source", nil).(types.ImporterFrom)
+	}
+	fset := typeCheckFset
+	generated := make(map[string][]byte)
+	// Line directives in the generated code point to template files.
+	templateFiles := make(map[string]*templateFile)
+	var files []*ast.File
+	for _, tf := range templates {
+		code, err := tf.generateCode()
+		if err != nil {
+			return nil
+		}
+		outfile := tf.outfile
+		f, err := goparser.ParseFile(fset, outfile, code, goparser.ParseComments)
+		if err != nil {
+			return nil
+		}
+		files = append(files, f)
+		generated[outfile] = code
+		templateFiles[filepath.Base(tf.filename)] = tf
+	}
+
+	for _, gf := range goFiles {
+		f, err := goparser.ParseFile(fset, gf.filename, gf.src, 0)
+		if err != nil {
+			// Syntax errors in .go files are left for go build.
+			return nil
+		}
+		files = append(files, f)
+	}
+
+	errs := make(map[string]parseErrors)
+	conf := types.Config{
+		Importer: typeCheckImporter,
+		Error: func(err error) {
+			te, ok := err.(types.Error)
+			if !ok {
+				return
+			}
+			pos := te.Fset.Position(te.Pos)
+			name := filepath.Base(pos.Filename)
+			tf, ok := templateFiles[name]
+			if !ok || pos.Line <= 0 {
+				return
+			}
+			code := generated[te.Fset.PositionFor(te.Pos, false).Filename]
+			errs[name] = append(errs[name], newTypeError(te, tf, code, pos))
+		},
+	}
+	conf.Check(files[0].Name.Name, fset, files, nil)
+	return errs
+}
+
+// newTypeError returns parseError for the type error te at the given
+// position in the template tf. code is the generated code containing te.
+func newTypeError(te types.Error, tf *templateFile, code []byte, pos gotoken.Position) *parseError {
+	src := tf.src
+	line := pos.Line - 1
+	col := pos.Column - 1
+	length := 0
+	if col < 0 {
+		line, col, length = findTypeErrorPos(te, src, code, line)
+	}
+	endLine, endCol := line, col+length
+	if length == 0 {
+		endLine, endCol = tagEnd(src, line, col)
+	}
+	msg := typeErrorReplacer.Replace(te.Msg)
+
+	// Template funcs are called via Stream* funcs in the generated code,
+	// so the names of Stream* funcs are replaced with the names used
+	// in the template.
+	if offset := te.Fset.PositionFor(te.Pos, false).Offset; offset >= 0 && offset < len(code) {
+		genToken := string(typeErrorToken(code[offset:]))
+		name := strings.TrimPrefix(genToken, "Stream")
+		fragment := src[lineOffset(src, line)+col:]
+		if len(name) > 0 && name != genToken && bytes.HasPrefix(fragment, []byte(name)) {
+			msg = strings.Replace(msg, genToken, name, -1)
+		}
+	}
+	return &parseError{
+		filePath: tf.filename,
+		line:     line + 1,
+		col:      col + 1,
+		endLine:  endLine + 1,
+		endCol:   endCol + 1,
+		excerpt:  sourceExcerpt(src, line, col),
+		err:      fmt.Errorf("%s", msg),
+	}
+}
+
+// findTypeErrorPos returns 0-based position and length of the Go fragment
+// with the type error te in the template src. code is the generated code
+// containing te.
+//
+// The fragment is looked up in the tags starting at the given 0-based line.
+// The position of the first tag on the line is returned if the fragment
+// isn't found.
+func findTypeErrorPos(te types.Error, src, code []byte, line int) (int, int, int) {
+	offset := lineOffset(src, line)
+	if offset < 0 {
+		return line, 0, 0
+	}
+	lineStr := src[offset:]
+	if n := bytes.IndexByte(lineStr, '\n'); n >= 0 {
+		lineStr = lineStr[:n]
+	}
+	genOffset := te.Fset.PositionFor(te.Pos, false).Offset
+	if genOffset < 0 || genOffset >= len(code) {
+		return line, 0, 0
+	}
+	token := typeErrorToken(code[genOffset:])
+	genLine, genCol := sourceLineAt(code, genOffset)
+
+	tagPos := bytes.Index(lineStr, strTagOpen)
+	if tagPos < 0 {
+		// The line is inside multi-line code tag.
+		if m := fragmentOffset(lineStr, genLine, genCol, ""); m >= 0 {
+			return line, m, len(token)
+		}
+		return line, len(lineStr) - len(bytes.TrimLeft(lineStr, " \t")), 0
+	}
+
+	// Look for the tag contents containing the error in the generated line.
+	// Tags opened on the line may span multiple lines.
+	end := offset + len(lineStr)
+	for pos := tagPos; pos < len(lineStr); {
+		n := bytes.Index(lineStr[pos:], strTagOpen)
+		if n < 0 {
+			break
+		}
+		pos += n
+		endLine, endPos := tagEnd(src, line, pos)
+		tagEndOffset := lineOffset(src, endLine) + endPos
+		if tagEndOffset > end {
+			end = tagEndOffset
+		}
+		contentsOffset := offset + pos + len(strTagOpen)
+		contents := bytes.TrimSuffix(src[contentsOffset:tagEndOffset], strTagClose)
+		n = 0
+		for n < len(contents) && (contents[n] == ' ' || contents[n] == '\t') {
+			n++
+		}
+		nameStart := n
+		for n < len(contents) && isTagNameByte(contents[n]) {
+			n++
+		}
+		if m := fragmentOffset(contents[n:], genLine, genCol, outputMethodCall(string(contents[nameStart:n]))); m >= 0 {
+			l, p := advancePos(line, 0, src[offset:contentsOffset+n+m])
+			return l, p, len(token)
+		}
+		pos += len(strTagOpen)
+	}
+
+	// Fall back to looking for the generated code following the error.
+	// Calls of template funcs are generated with Stream prefix.
+	genCode := genLine[genCol:]
+	if bytes.HasPrefix(token, []byte("Stream")) && len(token) > len("Stream") {
+		genCode = genCode[len("Stream"):]
+		token = token[len("Stream"):]
+	}
+	region := src[offset:end]
+	for n := len(genCode); n >= len(token) && n > 0; n-- {
+		if m := bytes.Index(region, genCode[:n]); m >= 0 {
+			l, p := advancePos(line, 0, region[:m])
+			return l, p, len(token)
+		}
+	}
+	return line, tagPos, 0
+}
+
+// fragmentOffset returns the offset in the tag contents of the Go fragment
+// covering genCol position in the generated genLine. The fragment must be
+// preceded by the given prefix in genLine.
+//
+// -1 is returned if the fragment isn't found.
+func fragmentOffset(contents, genLine []byte, genCol int, prefix string) int {
+	offset := 0
+	for _, piece := range bytes.Split(contents, []byte("\n")) {
+		trimmed := bytes.TrimLeft(piece, " \t")
+		pieceOffset := offset + len(piece) - len(trimmed)
+		trimmed = bytes.TrimRight(trimmed, " \t\r")
+		offset += len(piece) + 1
+		if len(trimmed) == 0 {
+			continue
+		}
+		for k := 0; k <= len(genLine); {
+			n := bytes.Index(genLine[k:], trimmed)
+			if n < 0 {
+				break
+			}
+			k += n
+			if genCol >= k && genCol < k+len(trimmed) && bytes.HasSuffix(genLine[:k], []byte(prefix)) {
+				return pieceOffset + genCol - k
+			}
+			k++
+		}
+	}
+	return -1
+}
+
+// outputMethodCall returns the beginning of the writer method call generated
+// for the output tag with the given name.
+//
+// Empty string is returned for other tags.
+func outputMethodCall(tagNameStr string) string {
+	tagNameStr, prec := splitTagNamePrec(tagNameStr)
+	if !isOutputTagName(tagNameStr) || tagNameStr == "=" {
+		return ""
+	}
+	if tagNameStr == "f" && prec >= 0 {
+		return ".FPrec("
+	}
+	return "." + strings.ToUpper(strings.TrimSuffix(tagNameStr, "=")) + "("
+}
+
+// sourceLineAt returns the line of src containing the given offset
+// and the offset in the line.
+func sourceLineAt(src []byte, offset int) ([]byte, int) {
+	start := bytes.LastIndexByte(src[:offset], '\n') + 1
+	end := len(src)
+	if n := bytes.IndexByte(src[offset:], '\n'); n >= 0 {
+		end = offset + n
+	}
+	return src[start:end], offset - start
+}
+
+// typeErrorToken returns the Go token at the start of code.
+func typeErrorToken(code []byte) []byte {
+	if n := bytes.IndexByte(code, '\n'); n >= 0 {
+		code = code[:n]
+	}
+	var s goscanner.Scanner
+	file := gotoken.NewFileSet().AddFile("", -1, len(code))
+	s.Init(file, code, nil, 0)
+	pos, tok, lit := s.Scan()
+	if tok == gotoken.EOF || file.Offset(pos) != 0 {
+		return nil
+	}
+	if len(lit) == 0 {
+		lit = tok.String()
+	}
+	if len(lit) > len(code) {
+		return nil
+	}
+	return code[:len(lit)]
+}
+
+// typeErrorReplacer replaces mangled names in type errors with readable names.
+var typeErrorReplacer = strings.NewReplacer(
+	"qw"+mangleSuffix, "qw",
+	"qt"+mangleSuffix+".", "quicktemplate.",
+	"qtio"+mangleSuffix+".", "io.",
+	"qtctx"+mangleSuffix+".", "context.",
+)
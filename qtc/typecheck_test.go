@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeCheckTemplate(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	writeTypeCheckFile(t, filepath.Join(dir, "b.qtpl"), "{% func Bar(n int) %}{%d n %}{% endfunc %}")
+	writeTypeCheckFile(t, filepath.Join(dir, "c.go"), "package templates\n\nfunc helper() string { return Bar(1) }\n")
+
+	// valid template using funcs from sibling template and .go files
+	testTypeCheckTemplate(t, dir, "{% func Foo() %}{%= Bar(1) %}{%s helper() %}{% endfunc %}")
+
+	// type errors
+	testTypeCheckTemplate(t, dir, `{% func Foo(n int64, xs []string) %}
+	<p>{%d n %}</p>{%= Baz() %}
+	{% for _, x := range xs %}{%s x %}{%d x %}{% endfor %}
+	{% code
+		y := 1
+		var z string = y
+	%}
+	{%s z %}
+{% endfunc %}`,
+		"2:9:cannot use n (variable of type int64) as int value in argument to qw.N().D",
+		"2:21:undefined: Baz",
+		"3:40:cannot use x (variable of type string) as int value in argument to qw.N().D",
+		"6:18:cannot use y (variable of type int) as string value in variable declaration")
+	testTypeCheckTemplate(t, dir, `{% import "strings" %}{% func Foo() %}{%s strings.ToLower(1) %}{% endfunc %}`,
+		"1:59:cannot use 1 (untyped int constant) as string value in argument to strings.ToLower")
+
+	// changes in .go files are picked up
+	writeTypeCheckFile(t, filepath.Join(dir, "c.go"), "package templates\n\nfunc helper() int { return 1 }\n")
+	testTypeCheckTemplate(t, dir, "{% func Foo() %}{%= Bar(1) %}{%s helper() %}{% endfunc %}",
+		"1:34:cannot use helper() (value of type int) as string value in argument to qw.E().S")
+
+	// the cache is dropped by resetTypeChecks
+	resetTypeChecks()
+	if len(packageChecks) != 0 || typeCheckImporter != nil {
+		t.Fatalf("type checking results must be dropped")
+	}
+}
+
+func testTypeCheckTemplate(t *testing.T, dir, src string, expectedErrors ...string) {
+	t.Helper()
+	filename := filepath.Join(dir, "a.qtpl")
+	writeTypeCheckFile(t, filename, src)
+	errorFuncs, err := getErrorFuncs(dir, []string{".qtpl"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = typeCheckTemplate(filename, errorFuncs, getTestPackageName(t, filename))
+	var result []string
+	if err != nil {
+		errs, ok := err.(parseErrors)
+		if !ok {
+			t.Fatalf("unexpected error type %T: %s", err, err)
+		}
+		for _, e := range errs {
+			if e.filePath != filename {
+				t.Fatalf("unexpected file path in error: %q. Expecting %q", e.filePath, filename)
+			}
+			result = append(result, fmt.Sprintf("%d:%d:%s", e.line, e.col, e.err))
+		}
+	}
+	if strings.Join(result, "\n") != strings.Join(expectedErrors, "\n") {
+		t.Fatalf("unexpected type errors for\n%s\n\n%q\n\nExpecting\n%q", src, result, expectedErrors)
+	}
+}
+
+func writeTypeCheckFile(t *testing.T, filename, s string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filename, []byte(s), 0666); err != nil {
+		t.Fatalf("cannot write file %q: %s", filename, err)
+	}
+}
@@ -76,27 +76,27 @@ func isUpper(c byte) bool {
 	return unicode.IsUpper(rune(c))
 }
 
-func getPackageName(filename string) (string, error) {
-	filenameAbs, err := filepath.Abs(filename)
+func readFile(cwd, filename string) ([]byte, error) {
+	path, err := getFilePath(cwd, filename)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	dir, _ := filepath.Split(filenameAbs)
-	return filepath.Base(dir), nil
+	return ioutil.ReadFile(path)
 }
 
-func readFile(cwd, filename string) ([]byte, error) {
+// getFilePath returns the path to filename relative to the directory
+// of the file cwd.
+func getFilePath(cwd, filename string) (string, error) {
 	if len(filename) == 0 {
-		return nil, errors.New("filename cannot be empty")
+		return "", errors.New("filename cannot be empty")
 	}
-	if filename[0] != '/' {
-		cwdAbs, err := filepath.Abs(cwd)
-		if err != nil {
-			return nil, err
-		}
-		dir, _ := filepath.Split(cwdAbs)
-		filename = filepath.Join(dir, filename)
+	if filename[0] == '/' {
+		return filename, nil
 	}
-
-	return ioutil.ReadFile(filename)
+	cwdAbs, err := filepath.Abs(cwd)
+	if err != nil {
+		return "", err
+	}
+	dir, _ := filepath.Split(cwdAbs)
+	return filepath.Join(dir, filename), nil
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// funcVariants is the set of funcs generated for each template func:
+// StreamFoo, WriteFoo and Foo.
+type funcVariants uint8
+
+const (
+	variantStream funcVariants = 1 << iota
+	variantWrite
+	variantString
+
+	allVariants = variantStream | variantWrite | variantString
+)
+
+var variantNames = []struct {
+	v    funcVariants
+	name string
+}{
+	{variantStream, "stream"},
+	{variantWrite, "write"},
+	{variantString, "string"},
+}
+
+// parseVariants parses the list of variant names separated by commas
+// or spaces such as "stream, write".
+//
+// Stream variant is required, since it contains the template code
+// used by other variants and by {%= Foo() %} calls.
+func parseVariants(s string) (funcVariants, error) {
+	var v funcVariants
+	names := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || isSpaceRune(r)
+	})
+	if len(names) == 0 {
+		return 0, fmt.Errorf("missing variants. Supported variants: %s", allVariants)
+	}
+	for _, name := range names {
+		found := false
+		for _, vn := range variantNames {
+			if vn.name == name {
+				v |= vn.v
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unsupported variant %q. Supported variants: %s", name, allVariants)
+		}
+	}
+	if !v.has(variantStream) {
+		return 0, fmt.Errorf("stream variant is required, since other variants and template calls use it")
+	}
+	return v, nil
+}
+
+func (v funcVariants) has(x funcVariants) bool {
+	return v&x != 0
+}
+
+// String returns comma-separated variant names.
+func (v funcVariants) String() string {
+	var names []string
+	for _, vn := range variantNames {
+		if v.has(vn.v) {
+			names = append(names, vn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
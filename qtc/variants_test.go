@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"strings"
+	"testing"
+)
+
+func TestParseVariantsSuccess(t *testing.T) {
+	testParseVariantsSuccess(t, "stream", "stream")
+	testParseVariantsSuccess(t, "stream,write", "stream,write")
+	testParseVariantsSuccess(t, " string, stream ", "stream,string")
+	testParseVariantsSuccess(t, "write stream\tstring", "stream,write,string")
+}
+
+func testParseVariantsSuccess(t *testing.T, s, expectedVariants string) {
+	t.Helper()
+	v, err := parseVariants(s)
+	if err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", s, err)
+	}
+	if v.String() != expectedVariants {
+		t.Fatalf("unexpected variants for %q: %q. Expecting %q", s, v, expectedVariants)
+	}
+}
+
+func TestParseVariantsFailure(t *testing.T) {
+	for _, s := range []string{"", " , ", "write", "write,string", "stream,foo", "Stream"} {
+		if v, err := parseVariants(s); err == nil {
+			t.Fatalf("expecting error when parsing %q. Got %q", s, v)
+		}
+	}
+}
+
+func TestVariantsTag(t *testing.T) {
+	// all the variants by default
+	testVariants(t, &parseOptions{}, `{% func A() %}a{% endfunc %}`,
+		[]string{"func StreamA(", "func WriteA(", "func A("}, nil)
+
+	// stream-only func
+	testVariants(t, &parseOptions{}, `{% variants stream %}{% func A() %}a{% endfunc %}`,
+		[]string{"func StreamA("}, []string{"func WriteA(", "func A("})
+
+	// the tag applies to the subsequent funcs
+	testVariants(t, &parseOptions{}, `{% func A() %}a{% endfunc %}
+		{% variants stream, write %}{% func B() %}b{% endfunc %}
+		{% variants stream write string %}{% func C() %}c{% endfunc %}`,
+		[]string{"func A(", "func WriteB(", "func C("}, []string{"func B("})
+
+	// string variant without write variant
+	testVariants(t, &parseOptions{}, `{% variants stream string %}{% func A() %}a{% endfunc %}`,
+		[]string{"func A() string {\n\tqb422016 := qt422016.AcquireByteBuffer()\n\tqw422016 := qt422016.AcquireWriter(qb422016)\n\tStreamA(qw422016)\n\tqt422016.ReleaseWriter(qw422016)\n"},
+		[]string{"func WriteA("})
+	testVariants(t, &parseOptions{}, `{% variants stream string %}{% func A() error %}{% return nil %}{% endfunc %}`,
+		[]string{"func A() (string, error) {", "\tqerr422016 := StreamA(qw422016)\n"},
+		[]string{"func WriteA("})
+	testVariants(t, &parseOptions{withErrors: true}, `{% variants stream string %}{% func A() %}a{% endfunc %}`,
+		[]string{"func A() string {", "\t_ = StreamA(qw422016)\n"},
+		[]string{"func WriteA("})
+
+	// interfaces
+	testVariants(t, &parseOptions{}, `{% variants stream %}{% interface Page { Body() } %}`,
+		[]string{"StreamBody(qw422016 *qt422016.Writer)"}, []string{"WriteBody(", "Body() string"})
+
+	// layout blocks ignore variants
+	testVariants(t, &parseOptions{}, `{% variants stream %}{% func Layout() %}{% block title %}t{% endblock %}{% endfunc %}`,
+		[]string{"func StreamLayout(", "WriteTitle(", ") Title() string {"}, []string{"func WriteLayout("})
+}
+
+func TestVariantsOptions(t *testing.T) {
+	opts := &parseOptions{
+		variants:           variantStream | variantWrite,
+		unexportedVariants: variantStream,
+	}
+	testVariants(t, opts, `{% func A() %}a{% endfunc %}{% func b() %}b{% endfunc %}{% func (x *X) c() %}c{% endfunc %}`,
+		[]string{"func StreamA(", "func WriteA(", "func streamb(", ") streamc("},
+		[]string{"func A(", "func writeb(", "func b(", ") writec(", ") c("})
+
+	// variants tag overrides the options
+	testVariants(t, opts, `{% variants stream write string %}{% func a() %}a{% endfunc %}`,
+		[]string{"func streama(", "func writea(", "func a("}, nil)
+}
+
+func TestVariantsTagFailure(t *testing.T) {
+	testParseFailure(t, `{% variants %}`)
+	testParseFailure(t, `{% variants write %}`)
+	testParseFailure(t, `{% variants stream foo %}`)
+	testParseFailure(t, `{% func A() %}{% variants stream %}{% endfunc %}`)
+}
+
+func testVariants(t *testing.T, opts *parseOptions, str string, expectedCode, unexpectedCode []string) {
+	t.Helper()
+	var w bytes.Buffer
+	if err := parseWithOptions(&w, strings.NewReader(str), "./foobar.tpl", "memory", opts); err != nil {
+		t.Fatalf("unexpected error when parsing %q: %s", str, err)
+	}
+	code, err := format.Source(removeLineDirectives(w.Bytes()))
+	if err != nil {
+		t.Fatalf("cannot format code generated for %q: %s\n%s", str, err, w.Bytes())
+	}
+	for _, s := range expectedCode {
+		if !bytes.Contains(code, []byte(s)) {
+			t.Fatalf("cannot find %q in the code generated for %q:\n%s", s, str, code)
+		}
+	}
+	for _, s := range unexpectedCode {
+		if bytes.Contains(code, []byte(s)) {
+			t.Fatalf("unexpected %q found in the code generated for %q:\n%s", s, str, code)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Vet checks. Each check may be suppressed with qtc:novet directive.
+// See parseVetDirectives.
+const (
+	// vetUnescaped reports {%s= %}, {%z= %} and {%sz= %} tags
+	// with arguments, which aren't constants or trusted values.
+	vetUnescaped = "unescaped"
+
+	// vetPrintV reports {%v %} tags with string and number arguments,
+	// which may be written faster with {%s %}, {%d %} or {%f %}.
+	vetPrintV = "printv"
+
+	// vetStringCall reports {%s= Foo() %} tags, which may be replaced
+	// by {%= Foo() %} for avoiding the string allocation.
+	vetStringCall = "stringcall"
+
+	// vetUnusedArg reports template func args unused in the func body.
+	vetUnusedArg = "unusedarg"
+
+	// vetAbsCat reports {% cat %} tags with absolute paths, which break
+	// when the templates are compiled on other hosts.
+	vetAbsCat = "abscat"
+)
+
+var vetChecks = []string{vetUnescaped, vetPrintV, vetStringCall, vetUnusedArg, vetAbsCat}
+
+// vetter collects problems found by vet checks when parsing templates.
+//
+// See parseOptions.vet.
+type vetter struct {
+	// templateFuncs contains funcs and methods declared in the templates
+	// of the package. See funcType.errorFuncKey.
+	templateFuncs map[string]bool
+
+	problems []*parseError
+
+	// seen contains the reported problems. The same problem may be found
+	// multiple times, since fragment funcs are parsed twice.
+	seen map[string]bool
+}
+
+func newVetter(templateFuncs map[string]bool) *vetter {
+	return &vetter{
+		templateFuncs: templateFuncs,
+		seen:          make(map[string]bool),
+	}
+}
+
+// vetf reports the given problem for the last tag read by the scanner.
+func (p *parser) vetf(check, format string, args ...interface{}) {
+	p.vetProblem(p.newParseError(nil), check, format, args...)
+}
+
+func (p *parser) vetProblem(e *parseError, check, format string, args ...interface{}) {
+	v := p.opts.vet
+	e.check = check
+	e.err = fmt.Errorf(format, args...)
+	key := fmt.Sprintf("%d:%d:%s", e.line, e.col, e.err)
+	if v.seen[key] {
+		return
+	}
+	v.seen[key] = true
+	v.problems = append(v.problems, e)
+}
+
+// vetOutputTag runs vet checks for the output tag with the given value.
+func (p *parser) vetOutputTag(tagNameStr string, value []byte) {
+	expr, err := goparser.ParseExpr(string(value))
+	if err != nil {
+		return
+	}
+	switch tagNameStr {
+	case "s=", "z=", "sz=":
+		if p.isTemplateCall(expr) {
+			if tagNameStr == "s=" {
+				p.vetf(vetStringCall, "{%%s= %s %%} allocates a string for the template output. Use {%%= %s %%} instead", value, value)
+			}
+			return
+		}
+		// Output isn't escaped in text mode anyway.
+		if !p.opts.textMode && !isConstExpr(expr) && !p.isTrustedExpr(expr) {
+			p.vetf(vetUnescaped, "{%%%s %s %%} writes unescaped non-constant value. Use {%%%s %s %%} unless the value is trusted",
+				tagNameStr, value, strings.TrimSuffix(tagNameStr, "="), value)
+		}
+	case "v", "v=":
+		suffix := strings.TrimPrefix(tagNameStr, "v")
+		switch p.exprKind(expr) {
+		case "string":
+			p.vetf(vetPrintV, "{%%%s %s %%} is used on string. Use faster {%%s%s %s %%} instead", tagNameStr, value, suffix, value)
+		case "int":
+			p.vetf(vetPrintV, "{%%%s %s %%} is used on int. Use faster {%%d %s %%} instead", tagNameStr, value, value)
+		case "float64":
+			p.vetf(vetPrintV, "{%%%s %s %%} is used on float64. Use faster {%%f %s %%} instead", tagNameStr, value, value)
+		}
+	}
+}
+
+// isTemplateCall returns true if expr calls template func declared
+// in the package.
+func (p *parser) isTemplateCall(expr ast.Expr) bool {
+	ce, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	fun := ce.Fun
+	if ie, ok := fun.(*ast.IndexExpr); ok {
+		fun = ie.X
+	}
+	if ie, ok := fun.(*ast.IndexListExpr); ok {
+		fun = ie.X
+	}
+	switch x := fun.(type) {
+	case *ast.Ident:
+		return p.opts.vet.templateFuncs[x.Name]
+	case *ast.SelectorExpr:
+		recv, ok := x.X.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		typ := typeName(p.varTypes[recv.Name])
+		return len(typ) > 0 && p.opts.vet.templateFuncs[typ+"."+x.Sel.Name]
+	default:
+		return false
+	}
+}
+
+// isConstExpr returns true if expr consists only of literals.
+func isConstExpr(expr ast.Expr) bool {
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isConstExpr(x.X)
+	case *ast.BinaryExpr:
+		return isConstExpr(x.X) && isConstExpr(x.Y)
+	case *ast.CallExpr:
+		// []byte("foo") conversion
+		at, ok := x.Fun.(*ast.ArrayType)
+		if !ok || at.Len != nil || len(x.Args) != 1 {
+			return false
+		}
+		if id, ok := at.Elt.(*ast.Ident); !ok || id.Name != "byte" {
+			return false
+		}
+		return isConstExpr(x.Args[0])
+	default:
+		return false
+	}
+}
+
+// isTrustedExpr returns true if expr has html/template.HTML type,
+// which is trusted to contain safe html.
+func (p *parser) isTrustedExpr(expr ast.Expr) bool {
+	switch x := expr.(type) {
+	case *ast.ParenExpr:
+		return p.isTrustedExpr(x.X)
+	case *ast.Ident:
+		return p.argTypes[x.Name] == "template.HTML"
+	case *ast.CallExpr:
+		return len(x.Args) == 1 && exprString(x.Fun) == "template.HTML"
+	default:
+		return false
+	}
+}
+
+// exprKind returns the type of expr if it is string, int or float64.
+//
+// Empty string is returned if the type cannot be determined without
+// type checking.
+func (p *parser) exprKind(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		switch x.Kind {
+		case gotoken.STRING:
+			return "string"
+		case gotoken.INT:
+			return "int"
+		case gotoken.FLOAT:
+			return "float64"
+		}
+	case *ast.ParenExpr:
+		return p.exprKind(x.X)
+	case *ast.Ident:
+		return vetKind(p.argTypes[x.Name])
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case gotoken.ADD, gotoken.SUB, gotoken.MUL, gotoken.QUO:
+			kindX := p.exprKind(x.X)
+			kindY := p.exprKind(x.Y)
+			if kindX == kindY || len(kindY) == 0 {
+				return kindX
+			}
+			if len(kindX) == 0 {
+				return kindY
+			}
+		}
+	case *ast.CallExpr:
+		id, ok := x.Fun.(*ast.Ident)
+		if !ok || len(x.Args) != 1 {
+			return ""
+		}
+		switch id.Name {
+		case "len", "cap":
+			return "int"
+		default:
+			return vetKind(id.Name)
+		}
+	}
+	return ""
+}
+
+func vetKind(typ string) string {
+	switch typ {
+	case "string", "int", "float64":
+		return typ
+	default:
+		return ""
+	}
+}
+
+func exprString(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.SelectorExpr:
+		return exprString(x.X) + "." + x.Sel.Name
+	default:
+		return ""
+	}
+}
+
+// funcArgTypes returns the types of f args keyed by arg names.
+func funcArgTypes(f *funcType) map[string]string {
+	argTypes := make(map[string]string)
+	expr, err := goparser.ParseExpr(fmt.Sprintf("func(%s)", strings.TrimPrefix(f.args, ", ")))
+	if err != nil {
+		return argTypes
+	}
+	ft, ok := expr.(*ast.FuncType)
+	if !ok {
+		return argTypes
+	}
+	for _, field := range ft.Params.List {
+		typ := exprString(field.Type)
+		for _, name := range field.Names {
+			argTypes[name.Name] = typ
+		}
+	}
+	return argTypes
+}
+
+// vetUnusedArgs reports f args, which aren't used in the given func code.
+func (p *parser) vetUnusedArgs(e *parseError, f *funcType, code []byte) {
+	used := usedIdents(code)
+	var unused []string
+	for name := range p.argTypes {
+		if name != "_" && !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	for _, name := range unused {
+		ee := *e
+		p.vetProblem(&ee, vetUnusedArg, "arg %s is unused in func %s. Use _ for unused args", name, f.name)
+	}
+}
+
+// usedIdents returns identifiers used in the given Go code.
+//
+// Selectors such as Bar in foo.Bar aren't considered as identifiers.
+func usedIdents(code []byte) map[string]bool {
+	used := make(map[string]bool)
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s goscanner.Scanner
+	s.Init(file, code, nil, 0)
+	prevTok := gotoken.ILLEGAL
+	for {
+		_, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			break
+		}
+		if tok == gotoken.IDENT && prevTok != gotoken.PERIOD {
+			used[lit] = true
+		}
+		prevTok = tok
+	}
+	return used
+}
+
+// vetDirective is the prefix of comments suppressing vet checks.
+//
+// {% comment %}qtc:novet unescaped,unusedarg{% endcomment %} suppresses
+// the given checks on the line where the comment ends and on the next line.
+const vetDirective = "qtc:novet"
+
+// parseVetDirectives returns checks suppressed in src keyed by 1-based
+// line numbers.
+func parseVetDirectives(src []byte) (map[int]map[string]bool, error) {
+	suppressed := make(map[int]map[string]bool)
+	offset := 0
+	for {
+		n := bytes.Index(src[offset:], strTagOpen)
+		if n < 0 {
+			return suppressed, nil
+		}
+		offset += n
+		name, _, tail, err := readFmtTag(src[offset:])
+		if err != nil {
+			return nil, err
+		}
+		offset = len(src) - len(tail)
+		if name != "comment" {
+			continue
+		}
+		m := indexFmtTag(tail, "endcomment")
+		if m < 0 {
+			return nil, fmt.Errorf("cannot find endcomment tag")
+		}
+		line := bytes.Count(src[:offset+m], []byte("\n")) + 1
+		addVetDirectives(suppressed, string(tail[:m]), line)
+		offset += m
+	}
+}
+
+func addVetDirectives(suppressed map[int]map[string]bool, comment string, line int) {
+	for _, s := range strings.Split(comment, "\n") {
+		n := strings.Index(s, vetDirective)
+		if n < 0 {
+			continue
+		}
+		fields := strings.Fields(s[n+len(vetDirective):])
+		if len(fields) == 0 {
+			continue
+		}
+		for _, check := range strings.Split(fields[0], ",") {
+			for _, l := range []int{line, line + 1} {
+				if suppressed[l] == nil {
+					suppressed[l] = make(map[string]bool)
+				}
+				suppressed[l][check] = true
+			}
+		}
+	}
+}
+
+// vetTemplate runs vet checks for the given template source.
+//
+// Template parse errors are returned as err.
+func vetTemplate(src []byte, filePath string, templateFuncs map[string]bool, errorFuncs map[string]bool) ([]*parseError, error) {
+	opts := newParseOptions(getFileConfig(filePath), errorFuncs)
+	opts.vet = newVetter(templateFuncs)
+	if err := parseWithOptions(ioutil.Discard, bytes.NewReader(src), filePath, "templates", opts); err != nil {
+		return nil, err
+	}
+	suppressed, err := parseVetDirectives(src)
+	if err != nil {
+		return nil, err
+	}
+	var problems []*parseError
+	for _, e := range opts.vet.problems {
+		if !suppressed[e.line][e.check] {
+			problems = append(problems, e)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].line != problems[j].line {
+			return problems[i].line < problems[j].line
+		}
+		return problems[i].col < problems[j].col
+	})
+	return problems, nil
+}
+
+// getTemplateFuncs returns funcs and methods declared in template files
+// with the given exts in the given dir.
+func getTemplateFuncs(dir string, exts []string) map[string]bool {
+	templateFuncs := make(map[string]bool)
+	filenames, _ := globTemplates(dir, exts)
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		for _, sym := range fileSymbols(src, filename) {
+			templateFuncs[sym.f.errorFuncKey()] = true
+		}
+	}
+	return templateFuncs
+}
+
+// runVet runs vet checks for template files at the given paths.
+//
+// runVet returns false if problems are found.
+func runVet(args []string) bool {
+	fs := flag.NewFlagSet("vet", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: qtc vet [path ...]\n\nChecks: %s\n", strings.Join(vetChecks, ", "))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{*dir}
+	}
+	var errs []error
+	for _, path := range paths {
+		filenames, err := fmtFilenames(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, filename := range filenames {
+			errs = append(errs, vetFile(filename)...)
+		}
+	}
+	if len(errs) > 0 {
+		reportErrors(errs)
+		return false
+	}
+	return true
+}
+
+func vetFile(filename string) []error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return []error{&fileError{filename: filename, err: err}}
+	}
+	dir := filepath.Dir(filename)
+	if _, err := getConfig(dir); err != nil {
+		return []error{&fileError{filename: filename, err: err}}
+	}
+	exts := getFileConfig(filename).exts
+	errorFuncs, err := getErrorFuncs(dir, exts)
+	if err != nil {
+		return []error{&fileError{filename: filename, err: err}}
+	}
+	problems, err := vetTemplate(src, filename, getTemplateFuncs(dir, exts), errorFuncs)
+	if err != nil {
+		if pe, ok := err.(parseErrors); ok {
+			errs := make([]error, len(pe))
+			for i, e := range pe {
+				errs[i] = e
+			}
+			return errs
+		}
+		return []error{&fileError{filename: filename, err: err}}
+	}
+	errs := make([]error, len(problems))
+	for i, e := range problems {
+		errs[i] = e
+	}
+	return errs
+}
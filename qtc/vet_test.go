@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVetOutputTags(t *testing.T) {
+	// unescaped
+	testVet(t, "{% func A(s string) %}{%s= s %}{%z= []byte(s) %}{%sz= []byte(s) %}{% endfunc %}",
+		"1:23:unescaped", "1:32:unescaped", "1:49:unescaped")
+	testVet(t, `{% import "html/template" %}{% func A(h template.HTML) %}{%s= "<br>" %}{%z= []byte("a" + "b") %}{%s= h %}{%s= template.HTML("x") %}{% endfunc %}`)
+
+	// printv
+	testVet(t, "{% func A(s string, n int, f float64, x interface{}) %}{%v s %}{%v n*2 %}{%v= f %}{%v len(s) %}{%v 1.5 %}{%v x %}{%v string(x) %}{% endfunc %}",
+		"1:56:printv", "1:64:printv", "1:74:printv", "1:83:printv", "1:96:printv", "1:114:printv")
+
+	// stringcall
+	testVet(t, "{% func A(b *B) %}{%s= B() %}{%s= b.C() %}{%= B() %}{%s B() %}{% endfunc %}{% func B() %}{% endfunc %}{% func (b *B) C() %}{% endfunc %}",
+		"1:19:stringcall", "1:30:stringcall")
+}
+
+func TestVetUnusedArgs(t *testing.T) {
+	testVet(t, "{% func A(a, b int, _ string, c struct{ a int }) %}{%d a %}{%d c.a %}{% endfunc %}", "1:1:unusedarg")
+	testVet(t, "{% func A(a, b int) %}{% code x := a %}{% if b > 0 %}{%d x %}{% endif %}{% endfunc %}")
+
+	// args used only in blocks
+	testVet(t, "{% func A(title string) %}{% block Body %}{%s title %}{% endblock %}{% endfunc %}")
+}
+
+func TestVetAbsCat(t *testing.T) {
+	testVet(t, `{% func A() %}{% cat "/etc/hostname" %}{% endfunc %}`, "1:15:abscat")
+}
+
+func TestVetDirectives(t *testing.T) {
+	src := `{% func A(s, unused string) %}
+{% comment %}
+	qtc:novet unescaped
+{% endcomment %}{%s= s %}
+{%s= s %}
+{% comment %}qtc:novet printv,unescaped{% endcomment %}
+{%v s %}{%s= s %}
+{%v s %}
+{% endfunc %}
+{% comment %}qtc:novet unusedarg{% endcomment %}
+{% func B(unused string) %}{% endfunc %}`
+	testVet(t, src, "1:1:unusedarg", "8:1:printv")
+}
+
+func testVet(t *testing.T, src string, expectedProblems ...string) {
+	t.Helper()
+	templateFuncs := make(map[string]bool)
+	for _, sym := range fileSymbols([]byte(src), "foo.qtpl") {
+		templateFuncs[sym.f.errorFuncKey()] = true
+	}
+	problems, err := vetTemplate([]byte(src), "foo.qtpl", templateFuncs, nil)
+	if err != nil {
+		t.Fatalf("unexpected error when vetting %q: %s", src, err)
+	}
+	var result []string
+	for _, e := range problems {
+		result = append(result, fmt.Sprintf("%d:%d:%s", e.line, e.col, e.check))
+	}
+	if strings.Join(result, ",") != strings.Join(expectedProblems, ",") {
+		t.Fatalf("unexpected problems found in\n%s\n\n%q\n\nExpecting\n%q", src, result, expectedProblems)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watcher compiles template files changed since the previous poll.
+type watcher struct {
+	// path is the watched directory or template file.
+	path string
+
+	// files contains the state of template files and qtc.toml files
+	// seen by the previous poll.
+	files map[string]watchedFile
+
+	// errorFuncs contains error funcs per directory.
+	// See getErrorFuncs for details.
+	errorFuncs map[string]map[string]bool
+
+	// packages contains package names per directory.
+	// See getPackageName for details.
+	packages map[string]string
+
+	// deps contains the dependencies of template files.
+	// See templateDeps for details.
+	deps map[string]watchedDeps
+
+	// depFiles contains the state of the dependencies seen
+	// by the previous poll.
+	depFiles map[string]watchedFile
+}
+
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+}
+
+// watchedDeps contains the dependencies of the template file
+// with the given state.
+type watchedDeps struct {
+	file watchedFile
+	deps []string
+}
+
+func newWatcher(path string) *watcher {
+	return &watcher{
+		path:       path,
+		files:      make(map[string]watchedFile),
+		errorFuncs: make(map[string]map[string]bool),
+		packages:   make(map[string]string),
+		deps:       make(map[string]watchedDeps),
+		depFiles:   make(map[string]watchedFile),
+	}
+}
+
+// watchTemplates polls template files at the given path with the given
+// interval and compiles the changed files.
+//
+// It never returns. Compilation errors are logged, so the broken files
+// may be fixed without restarting the compiler.
+func watchTemplates(path string, interval time.Duration) {
+	w := newWatcher(path)
+	for {
+		changed, n, errs := w.poll()
+		reportErrors(errs)
+		if changed {
+			logger.Printf("Files compiled: %d, errors found: %d. Waiting for changes in %q...", n, len(errs), path)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// poll compiles template files changed since the previous poll.
+//
+// All the files in the directory are compiled if error funcs
+// in the directory change, since the funcs calling error funcs
+// must be re-generated. The same applies to the package name changed
+// via package tag. All the files in the directory and in its' subdirectories
+// are compiled if qtc.toml file in the directory changes. Template files
+// are compiled if the files included via cat tags or the extended layouts
+// change.
+//
+// poll returns true if template files have been changed. It also returns
+// the number of compiled files and the errors occurred during compilation.
+// The files failed to compile are compiled again after the next change.
+func (w *watcher) poll() (bool, int, []error) {
+	// qtc.toml files and packages imported by the generated code
+	// may change between polls.
+	resetConfigs()
+	resetTypeChecks()
+	files, err := w.list()
+	if err != nil {
+		return false, 0, []error{fmt.Errorf("cannot list template files in %q: %s", w.path, err)}
+	}
+
+	var configDirs []string
+	changedFiles := make(map[string][]string)
+	for filename, wf := range files {
+		if prev, ok := w.files[filename]; !ok || prev != wf {
+			dir := filepath.Dir(filename)
+			if isConfigFile(filename) {
+				configDirs = append(configDirs, dir)
+				continue
+			}
+			changedFiles[dir] = append(changedFiles[dir], filename)
+		}
+	}
+	for filename := range w.files {
+		if _, ok := files[filename]; !ok {
+			dir := filepath.Dir(filename)
+			if isConfigFile(filename) {
+				logger.Printf("Config file %q has been removed", filename)
+				configDirs = append(configDirs, dir)
+				continue
+			}
+			// The removed file may contain error funcs.
+			logger.Printf("Template file %q has been removed", filename)
+			if _, ok := changedFiles[dir]; !ok {
+				changedFiles[dir] = nil
+			}
+		}
+	}
+	for _, configDir := range configDirs {
+		for filename := range files {
+			dir := filepath.Dir(filename)
+			if !isConfigFile(filename) && isSubdir(configDir, dir) && !hasString(changedFiles[dir], filename) {
+				changedFiles[dir] = append(changedFiles[dir], filename)
+			}
+		}
+	}
+	depFiles := make(map[string]watchedFile)
+	for filename, wf := range files {
+		if isConfigFile(filename) {
+			continue
+		}
+		for _, dep := range w.getDeps(filename, wf) {
+			df, ok := depFiles[dep]
+			if !ok {
+				df = statFile(dep)
+				depFiles[dep] = df
+			}
+			dir := filepath.Dir(filename)
+			if prev, ok := w.depFiles[dep]; ok && prev != df && !hasString(changedFiles[dir], filename) {
+				changedFiles[dir] = append(changedFiles[dir], filename)
+				// The dependencies may change too, for instance
+				// after the layout is moved to another file.
+				delete(w.deps, filename)
+			}
+		}
+	}
+	for filename := range w.deps {
+		if _, ok := files[filename]; !ok {
+			delete(w.deps, filename)
+		}
+	}
+	w.depFiles = depFiles
+	w.files = files
+	if len(changedFiles) == 0 {
+		return false, 0, nil
+	}
+
+	dirs := make([]string, 0, len(changedFiles))
+	for dir := range changedFiles {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	var jobs []*compileJob
+	var errs []error
+	for _, dir := range dirs {
+		filenames := changedFiles[dir]
+		errorFuncs, err := getErrorFuncs(dir, getConfigOrFlags(dir).exts)
+		if err != nil {
+			errs = append(errs, err)
+			errorFuncs = w.errorFuncs[dir]
+		} else if !reflect.DeepEqual(errorFuncs, w.errorFuncs[dir]) {
+			w.errorFuncs[dir] = errorFuncs
+			filenames = getDirFiles(files, dir)
+		}
+		packageName, err := getDirPackage(files, dir)
+		if packageName != w.packages[dir] {
+			w.packages[dir] = packageName
+			filenames = getDirFiles(files, dir)
+		}
+		if err != nil {
+			// The templates cannot be compiled without the package name,
+			// so the error is reported once for the directory.
+			errs = append(errs, err)
+			continue
+		}
+		// Block overrides are escaped in the html contexts of the layout
+		// blocks, so the pages extending layouts are compiled again.
+		// Pages with unchanged source hash are skipped by compileFile.
+		for _, filename := range getDirFiles(files, dir) {
+			if !hasString(filenames, filename) && extendsLayout(filename) {
+				filenames = append(filenames, filename)
+			}
+		}
+		sort.Strings(filenames)
+		for _, filename := range filenames {
+			jobs = append(jobs, newCompileJob(filename, errorFuncs, packageName))
+		}
+	}
+	n, jobErrs := runJobs(jobs, compileJobFile)
+	return true, n, append(errs, jobErrs...)
+}
+
+// getDeps returns the dependencies of the template file with the given state.
+//
+// The dependencies are cached until the file changes.
+func (w *watcher) getDeps(filename string, wf watchedFile) []string {
+	if wd, ok := w.deps[filename]; ok && wd.file == wf {
+		return wd.deps
+	}
+	var deps []string
+	if src, err := ioutil.ReadFile(filename); err == nil {
+		deps = templateDeps(src, filename)
+	}
+	w.deps[filename] = watchedDeps{
+		file: wf,
+		deps: deps,
+	}
+	return deps
+}
+
+// statFile returns the state of the given file.
+//
+// Zero state is returned for missing files.
+func statFile(filename string) watchedFile {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return watchedFile{}
+	}
+	return watchedFile{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}
+}
+
+// getDirFiles returns template files located in dir.
+func getDirFiles(files map[string]watchedFile, dir string) []string {
+	var filenames []string
+	for filename := range files {
+		if filepath.Dir(filename) == dir && !isConfigFile(filename) {
+			filenames = append(filenames, filename)
+		}
+	}
+	return filenames
+}
+
+// getDirPackage returns the package name for template files located in dir.
+//
+// Empty name is returned together with the error if the package name
+// cannot be determined.
+func getDirPackage(files map[string]watchedFile, dir string) (string, error) {
+	for _, filename := range getDirFiles(files, dir) {
+		return getFilePackageName(filename)
+	}
+	return "", nil
+}
+
+// isConfigFile returns true if filename is qtc.toml file.
+func isConfigFile(filename string) bool {
+	return filepath.Base(filename) == configFilename
+}
+
+// isSubdir returns true if dir is located inside parent or equals to it.
+func isSubdir(parent, dir string) bool {
+	return dir == parent || strings.HasPrefix(dir, parent+string(filepath.Separator))
+}
+
+// list returns template files and qtc.toml files at w.path.
+func (w *watcher) list() (map[string]watchedFile, error) {
+	files := make(map[string]watchedFile)
+	err := filepath.Walk(w.path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == w.path {
+				return err
+			}
+			// The file may be removed during the walk.
+			return nil
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if path != w.path && !isConfigFile(path) && !hasTemplateExt(path, getConfigOrFlags(filepath.Dir(path)).exts) {
+			return nil
+		}
+		files[path] = watchedFile{
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
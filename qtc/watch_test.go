@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatcherPoll(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	fileA := filepath.Join(dir, "a.qtpl")
+	fileB := filepath.Join(dir, "b.qtpl")
+	writeWatchedFile(t, fileA, `{% func A() %}{%= B() %}{% endfunc %}`)
+	writeWatchedFile(t, fileB, `{% func B() %}b{% endfunc %}`)
+	w := newWatcher(dir)
+
+	// all the files are compiled initially
+	testWatcherPoll(t, w, true, 0, 2)
+
+	// unchanged files aren't compiled
+	testWatcherPoll(t, w, false, 0, 0)
+
+	// errors don't stop the watcher
+	writeWatchedFile(t, fileB, `{% func B() %}b{% endfor %}`)
+	testWatcherPoll(t, w, true, 1, 0)
+	testWatcherPoll(t, w, false, 0, 0)
+	writeWatchedFile(t, fileB, `{% func B() %}bb{% endfunc %}`)
+	testWatcherPoll(t, w, true, 0, 1)
+
+	// the files calling error funcs are compiled after error funcs change
+	writeWatchedFile(t, fileB, `{% func B() error %}b{% endfunc %}`)
+	testWatcherPoll(t, w, true, 1, 1)
+	writeWatchedFile(t, fileA, `{% func A() error %}{%= B() %}{% endfunc %}`)
+	testWatcherPoll(t, w, true, 0, 2)
+
+	// new files are compiled
+	writeWatchedFile(t, filepath.Join(dir, "c.qtpl"), `{% func C() %}{% endfunc %}`)
+	testWatcherPoll(t, w, true, 0, 1)
+
+	// all the files are compiled after the package name changes
+	writeWatchedFile(t, fileB, "{% package views %}\n{% func B() error %}b{% endfunc %}")
+	testWatcherPoll(t, w, true, 0, 3)
+	if code, err := ioutil.ReadFile(fileA + ".go"); err != nil || !strings.Contains(string(code), "\npackage views\n") {
+		t.Fatalf("unexpected package in the generated code for %q; err=%v", fileA, err)
+	}
+
+	// conflicting package names are reported once for the directory
+	writeWatchedFile(t, fileA, "{% package other %}\n{% func A() error %}{%= B() %}{% endfunc %}")
+	testWatcherPoll(t, w, true, 1, 0)
+	writeWatchedFile(t, fileA, "{% package views %}\n{% func A() error %}{%= B() %}{% endfunc %}")
+	testWatcherPoll(t, w, true, 0, 3)
+
+	// non-template files are ignored
+	writeWatchedFile(t, filepath.Join(dir, "c.txt"), `foo`)
+	testWatcherPoll(t, w, false, 0, 0)
+
+	// all the files are compiled after qtc.toml changes
+	configFile := filepath.Join(dir, configFilename)
+	writeWatchedFile(t, configFile, `mode = "text"`)
+	testWatcherPoll(t, w, true, 0, 3)
+
+	// files with extensions added in qtc.toml are compiled
+	writeWatchedFile(t, configFile, `extensions = ["qtpl", "txt"]`)
+	testWatcherPoll(t, w, true, 0, 4)
+
+	// broken qtc.toml is reported
+	writeWatchedFile(t, configFile, `mode = "foo"`)
+	testWatcherPoll(t, w, true, 3, 0)
+}
+
+func TestWatcherPollLayout(t *testing.T) {
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	*autoEscape = true
+	defer func() { *autoEscape = false }()
+
+	layoutFile := filepath.Join(dir, "layout.qtpl")
+	pageFile := filepath.Join(dir, "page.qtpl")
+	writeWatchedFile(t, layoutFile, `{% func Layout() %}{% block title %}{% endblock %}{% endfunc %}`)
+	writeWatchedFile(t, pageFile, `{% extends (p *Page) Layout %}{% block title %}{%s p.Title %}{% endblock %}`)
+	w := newWatcher(dir)
+	testWatcherPoll(t, w, true, 0, 2)
+
+	// pages are compiled after the html contexts of the layout blocks change
+	writeWatchedFile(t, layoutFile, `{% func Layout() %}<script>var a = {% block title %}{% endblock %};</script>{% endfunc %}`)
+	testWatcherPoll(t, w, true, 0, 2)
+	if code, err := ioutil.ReadFile(pageFile + ".go"); err != nil || !strings.Contains(string(code), "qw422016.N().Q(") {
+		t.Fatalf("unexpected escaping in the generated code for %q; err=%v", pageFile, err)
+	}
+}
+
+func TestWatcherPollDeps(t *testing.T) {
+	*autoEscape = true
+	defer func() { *autoEscape = false }()
+	dir := createTemplatesDir(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+
+	layoutsDir := filepath.Join(dir, "layouts")
+	if err := os.Mkdir(layoutsDir, 0777); err != nil {
+		t.Fatalf("cannot create dir: %s", err)
+	}
+	layoutFile := filepath.Join(layoutsDir, "layout.qtpl")
+	pageFile := filepath.Join(dir, "page.qtpl")
+	fileA := filepath.Join(dir, "a.qtpl")
+	catFile := filepath.Join(dir, "a.txt")
+	writeWatchedFile(t, layoutFile, `{% func Layout() %}{% block title %}{% endblock %}{% endfunc %}`)
+	writeWatchedFile(t, pageFile, `{% import "./layouts" %}{% extends (p *Page) layouts.Layout %}{% block title %}{%s p.Title %}{% endblock %}`)
+	writeWatchedFile(t, fileA, `{% func A() %}{% cat "a.txt" %}{% endfunc %}`)
+	writeWatchedFile(t, catFile, `foo`)
+	w := newWatcher(dir)
+	testWatcherPoll(t, w, true, 0, 3)
+
+	// files are compiled after the files included via cat tags change.
+	// The page extending the layout is checked too, but it is skipped
+	// by compileFile.
+	writeWatchedFile(t, catFile, `bar`)
+	testWatcherPoll(t, w, true, 0, 2)
+	if code, err := ioutil.ReadFile(fileA + ".go"); err != nil || !strings.Contains(string(code), "bar") {
+		t.Fatalf("unexpected cat contents in the generated code for %q; err=%v", fileA, err)
+	}
+	testWatcherPoll(t, w, false, 0, 0)
+
+	// pages are compiled after the layouts from other directories change
+	writeWatchedFile(t, layoutFile, `{% func Layout() %}<script>var a = {% block title %}{% endblock %};</script>{% endfunc %}`)
+	testWatcherPoll(t, w, true, 0, 2)
+	if code, err := ioutil.ReadFile(pageFile + ".go"); err != nil || !strings.Contains(string(code), "qw422016.N().Q(") {
+		t.Fatalf("unexpected escaping in the generated code for %q; err=%v", pageFile, err)
+	}
+	testWatcherPoll(t, w, false, 0, 0)
+}
+
+func testWatcherPoll(t *testing.T, w *watcher, expectedChanged bool, expectedErrs, expectedCompiled int) {
+	t.Helper()
+	changed, compiled, errs := w.poll()
+	if changed != expectedChanged {
+		t.Fatalf("unexpected changed=%v. Expecting %v", changed, expectedChanged)
+	}
+	if len(errs) != expectedErrs {
+		t.Fatalf("unexpected number of errors: %d. Expecting %d. Errors: %v", len(errs), expectedErrs, errs)
+	}
+	if compiled != expectedCompiled {
+		t.Fatalf("unexpected number of compiled files: %d. Expecting %d", compiled, expectedCompiled)
+	}
+}
+
+// createTemplatesDir creates temporary dir for template files.
+//
+// The dir name is a valid package name. The parent dir must be removed
+// after the test.
+func createTemplatesDir(t *testing.T) string {
+	t.Helper()
+	tmpDir, err := ioutil.TempDir("", "qtc-test")
+	if err != nil {
+		t.Fatalf("cannot create temporary dir: %s", err)
+	}
+	dir := filepath.Join(tmpDir, "templates")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("cannot create dir: %s", err)
+	}
+	return dir
+}
+
+// modTime is incremented for each written file, so the changes
+// are detected regardless of the file system time resolution.
+var modTime = time.Now()
+
+func writeWatchedFile(t *testing.T, filename, s string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filename, []byte(s), 0666); err != nil {
+		t.Fatalf("cannot write file %q: %s", filename, err)
+	}
+	modTime = modTime.Add(time.Second)
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatalf("cannot change times for %q: %s", filename, err)
+	}
+}
@@ -0,0 +1,199 @@
+package quicktemplate
+
+import (
+	"io"
+)
+
+// writerStacks contains named stacks of Writer.
+type writerStacks struct {
+	stacks map[string]*stack
+
+	// pushes contains the state of the nested PushStart calls.
+	pushes []pushState
+
+	// segments contains the output held back after Stack calls.
+	segments []stackSegment
+
+	// w is the underlying writer for the held back output.
+	w io.Writer
+}
+
+// stack contains the output pushed to the named stack.
+type stack struct {
+	b    []byte
+	keys map[string]struct{}
+}
+
+type pushState struct {
+	w   io.Writer
+	s   *stack
+	key string
+	b   *ByteBuffer
+}
+
+type stackSegment struct {
+	name string
+	b    *ByteBuffer
+}
+
+// PushStart starts pushing the output to the stack with the given name
+// until PushEnd call.
+//
+// Pushes with the same non-empty key are added to the stack only once.
+// Pushes with empty key are deduplicated by their contents.
+// PushStart returns false if the push with the given key is already
+// in the stack. PushEnd mustn't be called in this case.
+//
+// PushStart is used by {% push %} tag.
+func (qw *Writer) PushStart(name, key string) bool {
+	ws := qw.getStacks()
+	s := qw.rootStacks().getStack(name)
+	if len(key) > 0 {
+		if _, ok := s.keys[key]; ok {
+			return false
+		}
+		s.keys[key] = struct{}{}
+	}
+	b := AcquireByteBuffer()
+	ws.pushes = append(ws.pushes, pushState{
+		w:   qw.n.w,
+		s:   s,
+		key: key,
+		b:   b,
+	})
+	qw.setWriter(b)
+	return true
+}
+
+// PushEnd finishes pushing the output started by PushStart.
+func (qw *Writer) PushEnd() {
+	ws := qw.stacks
+	n := len(ws.pushes) - 1
+	ps := ws.pushes[n]
+	ws.pushes = ws.pushes[:n]
+	qw.setWriter(ps.w)
+
+	s := ps.s
+	if len(ps.key) == 0 {
+		key := string(ps.b.B)
+		if _, ok := s.keys[key]; ok {
+			ReleaseByteBuffer(ps.b)
+			return
+		}
+		s.keys[key] = struct{}{}
+	}
+	s.b = append(s.b, ps.b.B...)
+	ReleaseByteBuffer(ps.b)
+}
+
+// Stack emits the contents of the stack with the given name.
+//
+// The contents may be pushed after Stack call, so the output after
+// Stack call is held back until Flush call.
+//
+// Stack is used by {% stack %} tag.
+func (qw *Writer) Stack(name string) {
+	ws := qw.getStacks()
+	qw.rootStacks().getStack(name)
+	if len(ws.segments) == 0 {
+		ws.w = qw.n.w
+	}
+	b := AcquireByteBuffer()
+	ws.segments = append(ws.segments, stackSegment{
+		name: name,
+		b:    b,
+	})
+	qw.setWriter(b)
+}
+
+// Flush writes the output held back after Stack calls
+// to the underlying writer.
+//
+// Flush returns the first error occurred when writing
+// to the underlying writer. ReleaseWriter calls Flush automatically.
+func (qw *Writer) Flush() error {
+	ws := qw.stacks
+	if ws == nil || len(ws.segments) == 0 {
+		return qw.Err()
+	}
+	qw.setWriter(ws.w)
+	stacks := qw.rootStacks().stacks
+	for i := range ws.segments {
+		seg := &ws.segments[i]
+		if b := stacks[seg.name].b; len(b) > 0 {
+			qw.n.Write(b)
+		}
+		if len(seg.b.B) > 0 {
+			qw.n.Write(seg.b.B)
+		}
+		ReleaseByteBuffer(seg.b)
+		seg.b = nil
+	}
+	ws.segments = ws.segments[:0]
+	ws.w = nil
+	return qw.Err()
+}
+
+// AcquireCaptureWriter returns new writer from the pool for capturing
+// the output to w.
+//
+// The output pushed to the returned writer is added to the stacks of qw,
+// so it isn't lost when the returned writer is released. Return unneeded
+// writer to the pool by calling ReleaseWriter.
+//
+// AcquireCaptureWriter is used by {% capture %} tag.
+func (qw *Writer) AcquireCaptureWriter(w io.Writer) *Writer {
+	cw := AcquireWriter(w)
+	cw.parent = qw
+	return cw
+}
+
+// rootStacks returns the stacks of the outermost writer passed
+// to AcquireCaptureWriter.
+func (qw *Writer) rootStacks() *writerStacks {
+	for qw.parent != nil {
+		qw = qw.parent
+	}
+	return qw.getStacks()
+}
+
+func (qw *Writer) getStacks() *writerStacks {
+	if qw.stacks == nil {
+		qw.stacks = &writerStacks{
+			stacks: make(map[string]*stack),
+		}
+	}
+	return qw.stacks
+}
+
+func (ws *writerStacks) getStack(name string) *stack {
+	s := ws.stacks[name]
+	if s == nil {
+		s = &stack{
+			keys: make(map[string]struct{}),
+		}
+		ws.stacks[name] = s
+	}
+	return s
+}
+
+func (ws *writerStacks) reset() {
+	for _, ps := range ws.pushes {
+		ReleaseByteBuffer(ps.b)
+	}
+	ws.pushes = ws.pushes[:0]
+	for _, seg := range ws.segments {
+		ReleaseByteBuffer(seg.b)
+	}
+	ws.segments = ws.segments[:0]
+	ws.w = nil
+	for name := range ws.stacks {
+		delete(ws.stacks, name)
+	}
+}
+
+// setWriter sets the writer for both escaped and non-escaped output.
+func (qw *Writer) setWriter(w io.Writer) {
+	qw.n.w = w
+	qw.e.w.(*htmlEscapeWriter).w = w
+}
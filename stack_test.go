@@ -0,0 +1,127 @@
+package quicktemplate
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriterStack(t *testing.T) {
+	var w bytes.Buffer
+	qw := AcquireWriter(&w)
+	qw.N().S("<head>")
+	qw.Stack("styles")
+	qw.N().S("</head><body>")
+	if qw.PushStart("scripts", "") {
+		qw.N().S("<script>a</script>")
+		qw.PushEnd()
+	}
+	if qw.PushStart("styles", "main") {
+		qw.E().S("<link main>")
+		qw.PushEnd()
+	}
+
+	// duplicate key
+	if qw.PushStart("styles", "main") {
+		t.Fatalf("unexpected push with duplicate key")
+	}
+
+	// duplicate contents
+	if qw.PushStart("scripts", "") {
+		qw.N().S("<script>a</script>")
+		qw.PushEnd()
+	}
+
+	// nested pushes
+	if qw.PushStart("scripts", "b") {
+		qw.N().S("<script>b</script>")
+		if qw.PushStart("styles", "") {
+			qw.N().S("<link b>")
+			qw.PushEnd()
+		}
+		qw.PushEnd()
+	}
+	qw.Stack("scripts")
+	qw.N().S("</body>")
+	if s := w.String(); s != "<head>" {
+		t.Fatalf("unexpected output before flush: %q. Expecting %q", s, "<head>")
+	}
+	if err := qw.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedS := "<head>&lt;link main&gt;<link b></head><body><script>a</script><script>b</script></body>"
+	if s := w.String(); s != expectedS {
+		t.Fatalf("unexpected output: %q. Expecting %q", s, expectedS)
+	}
+
+	// the output after flush isn't held back
+	qw.N().S("foo")
+	if s := w.String(); s != expectedS+"foo" {
+		t.Fatalf("unexpected output: %q. Expecting %q", s, expectedS+"foo")
+	}
+	ReleaseWriter(qw)
+
+	// released writer mustn't contain stacks
+	w.Reset()
+	qw = AcquireWriter(&w)
+	qw.Stack("scripts")
+	if !qw.PushStart("scripts", "b") {
+		t.Fatalf("unexpected duplicate key after ReleaseWriter")
+	}
+	qw.N().S("bar")
+	qw.PushEnd()
+	ReleaseWriter(qw)
+	if s := w.String(); s != "bar" {
+		t.Fatalf("unexpected output: %q. Expecting %q", s, "bar")
+	}
+}
+
+func TestWriterStackCapture(t *testing.T) {
+	var w bytes.Buffer
+	qw := AcquireWriter(&w)
+	qw.Stack("scripts")
+	if qw.PushStart("scripts", "a") {
+		qw.N().S("<script>a</script>")
+		qw.PushEnd()
+	}
+
+	// pushes to capture writers are added to the stacks of the outer writer
+	var b1, b2 bytes.Buffer
+	cw1 := qw.AcquireCaptureWriter(&b1)
+	cw1.N().S("foo")
+	if cw1.PushStart("scripts", "a") {
+		t.Fatalf("unexpected push with duplicate key inside capture")
+	}
+	cw2 := cw1.AcquireCaptureWriter(&b2)
+	if cw2.PushStart("scripts", "") {
+		cw2.N().S("<script>b</script>")
+		cw2.PushEnd()
+	}
+	cw2.N().S("bar")
+	ReleaseWriter(cw2)
+	ReleaseWriter(cw1)
+	if b1.String() != "foo" || b2.String() != "bar" {
+		t.Fatalf("unexpected captured output: %q, %q. Expecting %q, %q", b1.String(), b2.String(), "foo", "bar")
+	}
+
+	qw.N().S("baz")
+	ReleaseWriter(qw)
+	expectedS := "<script>a</script><script>b</script>baz"
+	if s := w.String(); s != expectedS {
+		t.Fatalf("unexpected output: %q. Expecting %q", s, expectedS)
+	}
+}
+
+func TestWriterStackErr(t *testing.T) {
+	fw := &failingWriter{n: 1}
+	qw := AcquireWriter(fw)
+	qw.N().S("foo")
+	qw.Stack("scripts")
+	qw.N().S("bar")
+	if err := qw.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := qw.Flush(); err != errFailingWriter {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errFailingWriter)
+	}
+	ReleaseWriter(qw)
+}
@@ -1,94 +1,96 @@
 // This file is automatically generated by qtc from "bench.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: 77f31f711aaa583c6f344161ac618d3a0031188b0867c57301e2c4be38bb0962
 
-//line testdata/templates/bench.qtpl:1
+//line testdata/templates/bench.qtpl:1:1
 package templates
 
-//line testdata/templates/bench.qtpl:1
+//line testdata/templates/bench.qtpl:1:1
 import (
 	qtio422016 "io"
 
 	qt422016 "github.com/valyala/quicktemplate"
 )
 
-//line testdata/templates/bench.qtpl:1
+//line testdata/templates/bench.qtpl:1:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line testdata/templates/bench.qtpl:3
+//line testdata/templates/bench.qtpl:3:1
 type BenchRow struct {
 	ID      int
 	Message string
 	Print   bool
 }
 
-//line testdata/templates/bench.qtpl:11
+//line testdata/templates/bench.qtpl:11:9
 func StreamBenchPage(qw422016 *qt422016.Writer, rows []BenchRow) {
-	//line testdata/templates/bench.qtpl:11
+//line testdata/templates/bench.qtpl:11:37
 	qw422016.N().S(`<html>
 	<head><title>test</title></head>
 	<body>
 		<ul>
 		`)
-	//line testdata/templates/bench.qtpl:15
-	for _, row := range rows {
-		//line testdata/templates/bench.qtpl:15
+//line testdata/templates/bench.qtpl:15:9
+	for /*line testdata/templates/bench.qtpl:15:9*/ _, row := range rows {
+//line testdata/templates/bench.qtpl:15:31
 		qw422016.N().S(`
 			`)
-		//line testdata/templates/bench.qtpl:16
-		if row.Print {
-			//line testdata/templates/bench.qtpl:16
+//line testdata/templates/bench.qtpl:16:8
+		if /*line testdata/templates/bench.qtpl:16:9*/ row.Print {
+//line testdata/templates/bench.qtpl:16:19
 			qw422016.N().S(`
 				<li>ID=`)
-			//line testdata/templates/bench.qtpl:17
-			qw422016.N().D(row.ID)
-			//line testdata/templates/bench.qtpl:17
+//line testdata/templates/bench.qtpl:17:13
+			qw422016.N().D( /*line testdata/templates/bench.qtpl:17:15*/ row.ID)
+//line testdata/templates/bench.qtpl:17:22
 			qw422016.N().S(`, Message=`)
-			//line testdata/templates/bench.qtpl:17
-			qw422016.E().S(row.Message)
-			//line testdata/templates/bench.qtpl:17
+//line testdata/templates/bench.qtpl:17:36
+			qw422016.E().S( /*line testdata/templates/bench.qtpl:17:38*/ row.Message)
+//line testdata/templates/bench.qtpl:17:50
 			qw422016.N().S(`</li>
 			`)
-			//line testdata/templates/bench.qtpl:18
+//line testdata/templates/bench.qtpl:18:11
 		}
-		//line testdata/templates/bench.qtpl:18
+//line testdata/templates/bench.qtpl:18:13
 		qw422016.N().S(`
 		`)
-		//line testdata/templates/bench.qtpl:19
+//line testdata/templates/bench.qtpl:19:12
 	}
-	//line testdata/templates/bench.qtpl:19
+//line testdata/templates/bench.qtpl:19:14
 	qw422016.N().S(`
 		</ul>
 	</body>
 </html>
 `)
-//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:12
 }
 
-//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:12
 func WriteBenchPage(qq422016 qtio422016.Writer, rows []BenchRow) {
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	StreamBenchPage(qw422016, rows)
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:12
 }
 
-//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:12
 func BenchPage(rows []BenchRow) string {
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	WriteBenchPage(qb422016, rows)
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:11
 	return qs422016
-//line testdata/templates/bench.qtpl:23
+//line testdata/templates/bench.qtpl:23:12
 }
@@ -1,10 +1,12 @@
 // This file is automatically generated by qtc from "integration.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: 1610288c7e6353b8dde2a46873288c0227548782153bfff511af0db1722a3706
 
-//line testdata/templates/integration.qtpl:1
+//line testdata/templates/integration.qtpl:1:1
 package templates
 
-//line testdata/templates/integration.qtpl:1
+//line testdata/templates/integration.qtpl:1:1
 import (
 	qtio422016 "io"
 
@@ -15,236 +17,289 @@ import (
 // It should contains all the quicktemplate stuff.
 //
 
-//line testdata/templates/integration.qtpl:4
-import "fmt"
+//line testdata/templates/integration.qtpl:4:11
+import /*line testdata/templates/integration.qtpl:4:10*/ "fmt"
 
-//line testdata/templates/integration.qtpl:6
+//line testdata/templates/integration.qtpl:6:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line testdata/templates/integration.qtpl:6
+//line testdata/templates/integration.qtpl:6:9
 func StreamIntegration(qw422016 *qt422016.Writer) {
-	//line testdata/templates/integration.qtpl:6
+//line testdata/templates/integration.qtpl:6:24
 	qw422016.N().S(`
 	Output tags`)
-	//line testdata/templates/integration.qtpl:6
+//line testdata/templates/integration.qtpl:6:24
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:6
+//line testdata/templates/integration.qtpl:6:24
 	qw422016.N().S(` verification.
 
 	`)
-	//line testdata/templates/integration.qtpl:10
+//line testdata/templates/integration.qtpl:10:2
 	p := &integrationPage{
+//line testdata/templates/integration.qtpl:11:2
 		S: "foobar",
+//line testdata/templates/integration.qtpl:12:2
 	}
 
-	//line testdata/templates/integration.qtpl:13
+//line testdata/templates/integration.qtpl:13:3
 	qw422016.N().S(`
 	Embedded func template: `)
-	//line testdata/templates/integration.qtpl:14
-	streamembeddedFunc(qw422016, p)
-	//line testdata/templates/integration.qtpl:14
+//line testdata/templates/integration.qtpl:14:29
+	streamembeddedFunc(qw422016 /*line testdata/templates/integration.qtpl:14:41*/, p)
+//line testdata/templates/integration.qtpl:14:47
 	qw422016.N().S(`
 
 	Html-escaped output tags:
 	<ul>
 		<li>`)
-	//line testdata/templates/integration.qtpl:18
-	qw422016.E().S("<b>html-escaped `string</b>")
-	//line testdata/templates/integration.qtpl:18
+//line testdata/templates/integration.qtpl:18:10
+	qw422016.E().S( /*line testdata/templates/integration.qtpl:18:10*/ "<b>html-escaped `string</b>")
+//line testdata/templates/integration.qtpl:18:42
 	qw422016.N().S(`</li>
 		<li>`)
-	//line testdata/templates/integration.qtpl:19
-	qw422016.E().Z([]byte("<b>html-escaped `byte slice</b>"))
-	//line testdata/templates/integration.qtpl:19
+//line testdata/templates/integration.qtpl:19:10
+	qw422016.E().Z( /*line testdata/templates/integration.qtpl:19:10*/ []byte("<b>html-escaped `byte slice</b>"))
+//line testdata/templates/integration.qtpl:19:54
 	qw422016.N().S(`</li>
 		<li>Int: `)
-	//line testdata/templates/integration.qtpl:20
-	qw422016.N().D(42)
-	//line testdata/templates/integration.qtpl:20
+//line testdata/templates/integration.qtpl:20:15
+	qw422016.N().D( /*line testdata/templates/integration.qtpl:20:15*/ 42)
+//line testdata/templates/integration.qtpl:20:20
 	qw422016.N().S(`</li>
 		<li>Float: `)
-	//line testdata/templates/integration.qtpl:21
-	qw422016.N().F(3.14)
-	//line testdata/templates/integration.qtpl:21
+//line testdata/templates/integration.qtpl:21:17
+	qw422016.N().F( /*line testdata/templates/integration.qtpl:21:17*/ 3.14)
+//line testdata/templates/integration.qtpl:21:24
 	qw422016.N().S(`</li>
 		<li>`)
-	//line testdata/templates/integration.qtpl:22
-	qw422016.E().Q(`<quoted> "json"
+//line testdata/templates/integration.qtpl:22:10
+	qw422016.E().Q( /*line testdata/templates/integration.qtpl:22:10*/ `<quoted> "json"
 				string`)
-	//line testdata/templates/integration.qtpl:23
+//line testdata/templates/integration.qtpl:23:14
 	qw422016.N().S(`</li>
 		<li>alert("foo `)
-	//line testdata/templates/integration.qtpl:24
-	qw422016.E().J(`"json"-safe
+//line testdata/templates/integration.qtpl:24:21
+	qw422016.E().J( /*line testdata/templates/integration.qtpl:24:21*/ `"json"-safe
 				<string>`)
-	//line testdata/templates/integration.qtpl:25
+//line testdata/templates/integration.qtpl:25:16
 	qw422016.N().S(` aa" + 'bar `)
-	//line testdata/templates/integration.qtpl:25
-	qw422016.E().J(`';alert("evil")</script>`)
-	//line testdata/templates/integration.qtpl:25
+//line testdata/templates/integration.qtpl:25:32
+	qw422016.E().J( /*line testdata/templates/integration.qtpl:25:32*/ `';alert("evil")</script>`)
+//line testdata/templates/integration.qtpl:25:61
 	qw422016.N().S(`')</li>
 		<li><a href="?`)
-	//line testdata/templates/integration.qtpl:26
-	qw422016.N().U("ключ")
-	//line testdata/templates/integration.qtpl:26
+//line testdata/templates/integration.qtpl:26:20
+	qw422016.N().U( /*line testdata/templates/integration.qtpl:26:20*/ "ключ")
+//line testdata/templates/integration.qtpl:26:33
 	qw422016.N().S(`=`)
-	//line testdata/templates/integration.qtpl:26
-	qw422016.N().U("значение&=?123")
-	//line testdata/templates/integration.qtpl:26
+//line testdata/templates/integration.qtpl:26:38
+	qw422016.N().U( /*line testdata/templates/integration.qtpl:26:38*/ "значение&=?123")
+//line testdata/templates/integration.qtpl:26:65
 	qw422016.N().S(`">test</a></li>
 		<li>`)
-	//line testdata/templates/integration.qtpl:27
-	qw422016.E().V(struct{ A string }{A: "<b>foobar`</b>"})
-	//line testdata/templates/integration.qtpl:27
+//line testdata/templates/integration.qtpl:27:10
+	qw422016.E().V( /*line testdata/templates/integration.qtpl:27:10*/ struct{ A string }{A: "<b>foobar`</b>"})
+//line testdata/templates/integration.qtpl:27:52
 	qw422016.N().S(`</li>
 	</ul>
 
 	Output tags without html escaping
 	<ul>
 		<li>`)
-	//line testdata/templates/integration.qtpl:32
-	qw422016.N().S("<b>html-escaped `string</b>")
-	//line testdata/templates/integration.qtpl:32
+//line testdata/templates/integration.qtpl:32:11
+	qw422016.N().S( /*line testdata/templates/integration.qtpl:32:11*/ "<b>html-escaped `string</b>")
+//line testdata/templates/integration.qtpl:32:43
 	qw422016.N().S(`</li>
 		<li>`)
-	//line testdata/templates/integration.qtpl:33
-	qw422016.N().Z([]byte("<b>html-escaped `byte slice</b>"))
-	//line testdata/templates/integration.qtpl:33
+//line testdata/templates/integration.qtpl:33:11
+	qw422016.N().Z( /*line testdata/templates/integration.qtpl:33:11*/ []byte("<b>html-escaped `byte slice</b>"))
+//line testdata/templates/integration.qtpl:33:55
 	qw422016.N().S(`</li>
 		<li>Int: `)
-	//line testdata/templates/integration.qtpl:34
-	qw422016.N().D(42)
-	//line testdata/templates/integration.qtpl:34
+//line testdata/templates/integration.qtpl:34:16
+	qw422016.N().D( /*line testdata/templates/integration.qtpl:34:16*/ 42)
+//line testdata/templates/integration.qtpl:34:21
 	qw422016.N().S(`</li>
 		<li>Float: `)
-	//line testdata/templates/integration.qtpl:35
-	qw422016.N().F(3.14)
-	//line testdata/templates/integration.qtpl:35
+//line testdata/templates/integration.qtpl:35:18
+	qw422016.N().F( /*line testdata/templates/integration.qtpl:35:18*/ 3.14)
+//line testdata/templates/integration.qtpl:35:25
 	qw422016.N().S(`</li>
 		<li>`)
-	//line testdata/templates/integration.qtpl:36
-	qw422016.N().Q(`<quoted> "json"
+//line testdata/templates/integration.qtpl:36:11
+	qw422016.N().Q( /*line testdata/templates/integration.qtpl:36:11*/ `<quoted> "json"
 				string`)
-	//line testdata/templates/integration.qtpl:37
+//line testdata/templates/integration.qtpl:37:14
 	qw422016.N().S(`</li>
 		<li>alert("foo `)
-	//line testdata/templates/integration.qtpl:38
-	qw422016.N().J(`"json"-safe
+//line testdata/templates/integration.qtpl:38:22
+	qw422016.N().J( /*line testdata/templates/integration.qtpl:38:22*/ `"json"-safe
 				<string>`)
-	//line testdata/templates/integration.qtpl:39
+//line testdata/templates/integration.qtpl:39:16
 	qw422016.N().S(` aa" + 'bar `)
-	//line testdata/templates/integration.qtpl:39
-	qw422016.N().J(`';alert("evil")</script>`)
-	//line testdata/templates/integration.qtpl:39
+//line testdata/templates/integration.qtpl:39:33
+	qw422016.N().J( /*line testdata/templates/integration.qtpl:39:33*/ `';alert("evil")</script>`)
+//line testdata/templates/integration.qtpl:39:62
 	qw422016.N().S(`')</li>
 		<li><a href="?`)
-	//line testdata/templates/integration.qtpl:40
-	qw422016.N().U("ключ")
-	//line testdata/templates/integration.qtpl:40
+//line testdata/templates/integration.qtpl:40:21
+	qw422016.N().U( /*line testdata/templates/integration.qtpl:40:21*/ "ключ")
+//line testdata/templates/integration.qtpl:40:34
 	qw422016.N().S(`=`)
-	//line testdata/templates/integration.qtpl:40
-	qw422016.N().U("значение&=?123")
-	//line testdata/templates/integration.qtpl:40
+//line testdata/templates/integration.qtpl:40:40
+	qw422016.N().U( /*line testdata/templates/integration.qtpl:40:40*/ "значение&=?123")
+//line testdata/templates/integration.qtpl:40:67
 	qw422016.N().S(`">test</a></li>
 		<li>`)
-	//line testdata/templates/integration.qtpl:41
-	qw422016.N().V(struct{ A string }{A: "<b>foobar`</b>"})
-	//line testdata/templates/integration.qtpl:41
+//line testdata/templates/integration.qtpl:41:11
+	qw422016.N().V( /*line testdata/templates/integration.qtpl:41:11*/ struct{ A string }{A: "<b>foobar`</b>"})
+//line testdata/templates/integration.qtpl:41:53
 	qw422016.N().S(`</li>
 	</ul>
 
+	Context-aware escaping
+	<ul>
+		<li><a href="`)
+//line testdata/templates/integration.qtpl:46:19
+	qw422016.E().URL( /*line testdata/templates/integration.qtpl:46:19*/ "javascript:alert('evil')")
+//line testdata/templates/integration.qtpl:46:48
+	qw422016.N().S(`" title="`)
+//line testdata/templates/integration.qtpl:46:61
+	qw422016.E().S( /*line testdata/templates/integration.qtpl:46:61*/ `"quoted"`)
+//line testdata/templates/integration.qtpl:46:74
+	qw422016.N().S(`">unsafe url</a></li>
+		<li><a href="/search/`)
+//line testdata/templates/integration.qtpl:47:27
+	qw422016.E().U( /*line testdata/templates/integration.qtpl:47:27*/ "a/b")
+//line testdata/templates/integration.qtpl:47:35
+	qw422016.N().S(`?q=`)
+//line testdata/templates/integration.qtpl:47:42
+	qw422016.E().U( /*line testdata/templates/integration.qtpl:47:42*/ "foo&bar baz")
+//line testdata/templates/integration.qtpl:47:58
+	qw422016.N().S(`">url parts</a></li>
+		<li><button onclick="alert('`)
+//line testdata/templates/integration.qtpl:48:34
+	qw422016.E().J( /*line testdata/templates/integration.qtpl:48:34*/ `';alert("evil")</script>`)
+//line testdata/templates/integration.qtpl:48:63
+	qw422016.N().S(`')">js string</button></li>
+		<li><div style="color: `)
+//line testdata/templates/integration.qtpl:49:29
+	qw422016.E().CSS( /*line testdata/templates/integration.qtpl:49:29*/ "red;background:url(evil)")
+//line testdata/templates/integration.qtpl:49:58
+	qw422016.N().S(`">css</div></li>
+	</ul>
+	<script>
+		var s = `)
+//line testdata/templates/integration.qtpl:52:14
+	qw422016.N().Q( /*line testdata/templates/integration.qtpl:52:14*/ "</script><script>alert('evil')")
+//line testdata/templates/integration.qtpl:52:49
+	qw422016.N().S(`;
+		var t = "`)
+//line testdata/templates/integration.qtpl:53:15
+	qw422016.N().J( /*line testdata/templates/integration.qtpl:53:15*/ `"quoted"`)
+//line testdata/templates/integration.qtpl:53:28
+	qw422016.N().S(`";
+	</script>
+	<style>
+		.foo { font-family: `)
+//line testdata/templates/integration.qtpl:56:26
+	qw422016.N().CSS( /*line testdata/templates/integration.qtpl:56:26*/ "</style>")
+//line testdata/templates/integration.qtpl:56:39
+	qw422016.N().S(`; }
+	</style>
+
 	`)
-	//line testdata/templates/integration.qtpl:44
+//line testdata/templates/integration.qtpl:59:17
 	qw422016.N().S(`Strip space`)
-	//line testdata/templates/integration.qtpl:45
+//line testdata/templates/integration.qtpl:60:21
 	qw422016.N().S(` `)
-	//line testdata/templates/integration.qtpl:45
+//line testdata/templates/integration.qtpl:60:23
 	qw422016.N().S(`between lines and tags`)
-	//line testdata/templates/integration.qtpl:47
+//line testdata/templates/integration.qtpl:62:11
 	qw422016.N().S(`
 			Tags aren't parsed {%inside %}
 			plain
 		`)
-	//line testdata/templates/integration.qtpl:51
+//line testdata/templates/integration.qtpl:66:10
 	// one-liner comment
 
-	//line testdata/templates/integration.qtpl:53
+//line testdata/templates/integration.qtpl:68:2
 	// multi-line
 	// comment
 
-	//line testdata/templates/integration.qtpl:57
+//line testdata/templates/integration.qtpl:72:2
 	/*
 	  yet another
 	  multi-line comment
 	*/
 
-	//line testdata/templates/integration.qtpl:62
+//line testdata/templates/integration.qtpl:77:20
 	qw422016.N().S(`
 
 	`)
-	//line testdata/templates/integration.qtpl:64
+//line testdata/templates/integration.qtpl:79:20
 	qw422016.N().S(`Collapse space `)
-	//line testdata/templates/integration.qtpl:65
+//line testdata/templates/integration.qtpl:80:25
 	qw422016.N().S(` `)
-	//line testdata/templates/integration.qtpl:65
+//line testdata/templates/integration.qtpl:80:27
 	qw422016.N().S(`between `)
-	//line testdata/templates/integration.qtpl:66
+//line testdata/templates/integration.qtpl:81:19
 	qw422016.N().S(`
 `)
-	//line testdata/templates/integration.qtpl:66
+//line testdata/templates/integration.qtpl:81:21
 	qw422016.N().S(`lines and tags `)
-	//line testdata/templates/integration.qtpl:72
-	for _, s := range []string{"foo", "bar", "baz"} {
-		//line testdata/templates/integration.qtpl:73
-		if s == "bar" {
-			//line testdata/templates/integration.qtpl:73
+//line testdata/templates/integration.qtpl:87:9
+	for /*line testdata/templates/integration.qtpl:87:9*/ _, s := range []string{"foo", "bar", "baz"} {
+//line testdata/templates/integration.qtpl:88:8
+		if /*line testdata/templates/integration.qtpl:88:9*/ s == "bar" {
+//line testdata/templates/integration.qtpl:88:20
 			qw422016.N().S(`Bar `)
-			//line testdata/templates/integration.qtpl:75
-		} else if s == "baz" {
-			//line testdata/templates/integration.qtpl:75
+//line testdata/templates/integration.qtpl:90:12
+		} else if /*line testdata/templates/integration.qtpl:90:13*/ s == "baz" {
+//line testdata/templates/integration.qtpl:90:24
 			qw422016.N().S(`Baz `)
-			//line testdata/templates/integration.qtpl:77
+//line testdata/templates/integration.qtpl:92:11
 			break
-			//line testdata/templates/integration.qtpl:78
+//line testdata/templates/integration.qtpl:93:10
 		} else {
-			//line testdata/templates/integration.qtpl:79
-			if s == "never" {
-				//line testdata/templates/integration.qtpl:80
+//line testdata/templates/integration.qtpl:94:8
+			if /*line testdata/templates/integration.qtpl:94:10*/ s == "never" {
+//line testdata/templates/integration.qtpl:95:12
 				return
-				//line testdata/templates/integration.qtpl:81
+//line testdata/templates/integration.qtpl:96:11
 			}
-			//line testdata/templates/integration.qtpl:83
-			switch s {
-			//line testdata/templates/integration.qtpl:84
-			case "foobar":
-				//line testdata/templates/integration.qtpl:84
+//line testdata/templates/integration.qtpl:98:12
+			switch /*line testdata/templates/integration.qtpl:98:14*/ s {
+//line testdata/templates/integration.qtpl:99:10
+			case /*line testdata/templates/integration.qtpl:99:12*/ "foobar":
+//line testdata/templates/integration.qtpl:99:20
 				qw422016.N().S(`s = foobar `)
-			//line testdata/templates/integration.qtpl:86
-			case "barbaz":
-				//line testdata/templates/integration.qtpl:86
+//line testdata/templates/integration.qtpl:101:10
+			case /*line testdata/templates/integration.qtpl:101:12*/ "barbaz":
+//line testdata/templates/integration.qtpl:101:20
 				qw422016.N().S(`s = barbaz `)
-			//line testdata/templates/integration.qtpl:88
+//line testdata/templates/integration.qtpl:103:13
 			default:
-				//line testdata/templates/integration.qtpl:88
+//line testdata/templates/integration.qtpl:103:14
 				qw422016.N().S(`s = `)
-				//line testdata/templates/integration.qtpl:89
-				qw422016.E().S(s)
-				//line testdata/templates/integration.qtpl:90
+//line testdata/templates/integration.qtpl:104:10
+				qw422016.E().S( /*line testdata/templates/integration.qtpl:104:13*/ s)
+//line testdata/templates/integration.qtpl:105:15
 			}
-			//line testdata/templates/integration.qtpl:92
+//line testdata/templates/integration.qtpl:107:14
 			continue
-			//line testdata/templates/integration.qtpl:93
+//line testdata/templates/integration.qtpl:108:11
 		}
-		//line testdata/templates/integration.qtpl:94
+//line testdata/templates/integration.qtpl:109:12
 	}
-	//line testdata/templates/integration.qtpl:95
+//line testdata/templates/integration.qtpl:110:23
 	qw422016.N().S(`
 
 	`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`This is a template for integration test.
 It should contains all the quicktemplate stuff.
 
@@ -252,9 +307,9 @@ It should contains all the quicktemplate stuff.
 
 {% func Integration() %}
 	Output tags`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` verification.
 
 	{% code
@@ -267,103 +322,142 @@ It should contains all the quicktemplate stuff.
 	Html-escaped output tags:
 	<ul>
 		<li>{%s "<b>html-escaped `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`string</b>" %}</li>
 		<li>{%z []byte("<b>html-escaped `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`byte slice</b>") %}</li>
 		<li>Int: {%d 42 %}</li>
 		<li>Float: {%f 3.14 %}</li>
 		<li>{%q `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`<quoted> "json"
 				string`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` %}</li>
 		<li>alert("foo {%j `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`"json"-safe
 				<string>`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` %} aa" + 'bar {%j `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`';alert("evil")</script>`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` %}')</li>
 		<li><a href="?{%u "ключ" %}={%u "значение&=?123" %}">test</a></li>
 		<li>{%v struct{ A string }{A: "<b>foobar`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`</b>"} %}</li>
 	</ul>
 
 	Output tags without html escaping
 	<ul>
 		<li>{%s= "<b>html-escaped `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`string</b>" %}</li>
 		<li>{%z= []byte("<b>html-escaped `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`byte slice</b>") %}</li>
 		<li>Int: {%d= 42 %}</li>
 		<li>Float: {%f= 3.14 %}</li>
 		<li>{%q= `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`<quoted> "json"
 				string`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` %}</li>
 		<li>alert("foo {%j= `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`"json"-safe
 				<string>`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` %} aa" + 'bar {%j= `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`';alert("evil")</script>`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(` %}')</li>
 		<li><a href="?{%u= "ключ" %}={%u= "значение&=?123" %}">test</a></li>
 		<li>{%v= struct{ A string }{A: "<b>foobar`)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S("`")
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:8
 	qw422016.N().S(`</b>"} %}</li>
 	</ul>
 
+	Context-aware escaping
+	<ul>
+		<li><a href="{%s "javascript:alert('evil')" %}" title="{%s `)
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S("`")
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S(`"quoted"`)
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S("`")
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S(` %}">unsafe url</a></li>
+		<li><a href="/search/{%s "a/b" %}?q={%s "foo&bar baz" %}">url parts</a></li>
+		<li><button onclick="alert('{%s `)
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S("`")
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S(`';alert("evil")</script>`)
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S("`")
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S(` %}')">js string</button></li>
+		<li><div style="color: {%s "red;background:url(evil)" %}">css</div></li>
+	</ul>
+	<script>
+		var s = {%s "</script><script>alert('evil')" %};
+		var t = "{%s `)
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S("`")
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S(`"quoted"`)
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S("`")
+//line testdata/templates/integration.qtpl:112:8
+	qw422016.N().S(` %}";
+	</script>
+	<style>
+		.foo { font-family: {%s "</style>" %}; }
+	</style>
+
 	{% stripspace %}
 		Strip space {%space%}
 		between lines and tags
@@ -446,173 +540,171 @@ type integrationPage struct {
 	S={%q p.S %}
 {% endfunc %}
 `)
-	//line testdata/templates/integration.qtpl:97
+//line testdata/templates/integration.qtpl:112:29
 	qw422016.N().S(`
 
 	tail of the func
 `)
-//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:12
 }
 
-//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:12
 func WriteIntegration(qq422016 qtio422016.Writer) {
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	StreamIntegration(qw422016)
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:12
 }
 
-//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:12
 func Integration() string {
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	WriteIntegration(qb422016)
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:11
 	return qs422016
-//line testdata/templates/integration.qtpl:100
+//line testdata/templates/integration.qtpl:115:12
 }
 
-//line testdata/templates/integration.qtpl:103
+//line testdata/templates/integration.qtpl:118:11
 type Page interface {
-	//line testdata/templates/integration.qtpl:103
 	Header() string
-	//line testdata/templates/integration.qtpl:103
+//line testdata/templates/integration.qtpl:119:1
 	StreamHeader(qw422016 *qt422016.Writer)
-	//line testdata/templates/integration.qtpl:103
+//line testdata/templates/integration.qtpl:119:1
 	WriteHeader(qq422016 qtio422016.Writer)
-	//line testdata/templates/integration.qtpl:103
 	Body() string
-	//line testdata/templates/integration.qtpl:103
+//line testdata/templates/integration.qtpl:120:1
 	StreamBody(qw422016 *qt422016.Writer)
-	//line testdata/templates/integration.qtpl:103
+//line testdata/templates/integration.qtpl:120:1
 	WriteBody(qq422016 qtio422016.Writer)
-//line testdata/templates/integration.qtpl:103
 }
 
-//line testdata/templates/integration.qtpl:109
+//line testdata/templates/integration.qtpl:124:9
 func streamembeddedFunc(qw422016 *qt422016.Writer, p Page) {
-	//line testdata/templates/integration.qtpl:109
+//line testdata/templates/integration.qtpl:124:31
 	qw422016.N().S(`
 	Page's header: `)
-	//line testdata/templates/integration.qtpl:110
+//line testdata/templates/integration.qtpl:125:20
 	p.StreamHeader(qw422016)
-	//line testdata/templates/integration.qtpl:110
+//line testdata/templates/integration.qtpl:125:33
 	qw422016.N().S(`
 	Body: `)
-	//line testdata/templates/integration.qtpl:111
-	qw422016.N().S(fmt.Sprintf("<b>%s</b>", p.Body()))
-	//line testdata/templates/integration.qtpl:111
+//line testdata/templates/integration.qtpl:126:12
+	qw422016.N().S( /*line testdata/templates/integration.qtpl:126:12*/ fmt.Sprintf("<b>%s</b>", p.Body()))
+//line testdata/templates/integration.qtpl:126:49
 	qw422016.N().S(`
 `)
-//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:12
 }
 
-//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:12
 func writeembeddedFunc(qq422016 qtio422016.Writer, p Page) {
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	streamembeddedFunc(qw422016, p)
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:12
 }
 
-//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:12
 func embeddedFunc(p Page) string {
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	writeembeddedFunc(qb422016, p)
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:11
 	return qs422016
-//line testdata/templates/integration.qtpl:112
+//line testdata/templates/integration.qtpl:127:12
 }
 
-//line testdata/templates/integration.qtpl:115
+//line testdata/templates/integration.qtpl:130:1
 type integrationPage struct {
 	S string
 }
 
-//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:9
 func (p *integrationPage) StreamHeader(qw422016 *qt422016.Writer) {
-//line testdata/templates/integration.qtpl:120
-qw422016.N().S(`Header`) }
+//line testdata/templates/integration.qtpl:135:40
+	qw422016.N().S(`Header`)
+//line testdata/templates/integration.qtpl:135:58
+}
 
-//line testdata/templates/integration.qtpl:120
-//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:58
 func (p *integrationPage) WriteHeader(qq422016 qtio422016.Writer) {
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	p.StreamHeader(qw422016)
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:58
 }
 
-//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:58
 func (p *integrationPage) Header() string {
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	p.WriteHeader(qb422016)
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:57
 	return qs422016
-//line testdata/templates/integration.qtpl:120
+//line testdata/templates/integration.qtpl:135:58
 }
 
-//line testdata/templates/integration.qtpl:122
+//line testdata/templates/integration.qtpl:137:9
 func (p *integrationPage) StreamBody(qw422016 *qt422016.Writer) {
-	//line testdata/templates/integration.qtpl:122
+//line testdata/templates/integration.qtpl:137:38
 	qw422016.N().S(`
 	S=`)
-	//line testdata/templates/integration.qtpl:123
-	qw422016.E().Q(p.S)
-	//line testdata/templates/integration.qtpl:123
+//line testdata/templates/integration.qtpl:138:7
+	qw422016.E().Q( /*line testdata/templates/integration.qtpl:138:7*/ p.S)
+//line testdata/templates/integration.qtpl:138:13
 	qw422016.N().S(`
 `)
-//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:12
 }
 
-//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:12
 func (p *integrationPage) WriteBody(qq422016 qtio422016.Writer) {
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	p.StreamBody(qw422016)
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:12
 }
 
-//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:12
 func (p *integrationPage) Body() string {
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	p.WriteBody(qb422016)
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:11
 	return qs422016
-//line testdata/templates/integration.qtpl:124
+//line testdata/templates/integration.qtpl:139:12
 }
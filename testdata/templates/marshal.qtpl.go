@@ -1,10 +1,12 @@
 // This file is automatically generated by qtc from "marshal.qtpl".
 // See https://github.com/valyala/quicktemplate for details.
+// qtc version: 1.9.2
+// Source hash: e4be31933699961b7682cb1a3989a5e738f2069aa410f52720ca22e5edb0d022
 
-//line testdata/templates/marshal.qtpl:1
+//line testdata/templates/marshal.qtpl:1:1
 package templates
 
-//line testdata/templates/marshal.qtpl:1
+//line testdata/templates/marshal.qtpl:1:1
 import (
 	qtio422016 "io"
 
@@ -14,13 +16,13 @@ import (
 // Templates for marshal_timing_test.go
 //
 
-//line testdata/templates/marshal.qtpl:3
+//line testdata/templates/marshal.qtpl:3:4
 var (
 	_ = qtio422016.Copy
 	_ = qt422016.AcquireByteBuffer
 )
 
-//line testdata/templates/marshal.qtpl:4
+//line testdata/templates/marshal.qtpl:4:1
 type MarshalRow struct {
 	Msg string
 	N   int
@@ -34,124 +36,124 @@ type MarshalData struct {
 
 // JSON marshaling
 
-//line testdata/templates/marshal.qtpl:18
+//line testdata/templates/marshal.qtpl:18:9
 func (d *MarshalData) StreamJSON(qw422016 *qt422016.Writer) {
-	//line testdata/templates/marshal.qtpl:18
+//line testdata/templates/marshal.qtpl:18:34
 	qw422016.N().S(`{"Foo":`)
-	//line testdata/templates/marshal.qtpl:20
-	qw422016.N().D(d.Foo)
-	//line testdata/templates/marshal.qtpl:20
+//line testdata/templates/marshal.qtpl:20:12
+	qw422016.N().D( /*line testdata/templates/marshal.qtpl:20:12*/ d.Foo)
+//line testdata/templates/marshal.qtpl:20:20
 	qw422016.N().S(`,"Bar":`)
-	//line testdata/templates/marshal.qtpl:21
-	qw422016.N().Q(d.Bar)
-	//line testdata/templates/marshal.qtpl:21
+//line testdata/templates/marshal.qtpl:21:13
+	qw422016.N().Q( /*line testdata/templates/marshal.qtpl:21:13*/ d.Bar)
+//line testdata/templates/marshal.qtpl:21:21
 	qw422016.N().S(`,"Rows":[`)
-	//line testdata/templates/marshal.qtpl:23
-	for i, r := range d.Rows {
-		//line testdata/templates/marshal.qtpl:23
+//line testdata/templates/marshal.qtpl:23:9
+	for /*line testdata/templates/marshal.qtpl:23:9*/ i, r := range d.Rows {
+//line testdata/templates/marshal.qtpl:23:31
 		qw422016.N().S(`{"Msg":`)
-		//line testdata/templates/marshal.qtpl:25
-		qw422016.N().Q(r.Msg)
-		//line testdata/templates/marshal.qtpl:25
+//line testdata/templates/marshal.qtpl:25:15
+		qw422016.N().Q( /*line testdata/templates/marshal.qtpl:25:16*/ r.Msg)
+//line testdata/templates/marshal.qtpl:25:23
 		qw422016.N().S(`,"N":`)
-		//line testdata/templates/marshal.qtpl:26
-		qw422016.N().D(r.N)
-		//line testdata/templates/marshal.qtpl:26
+//line testdata/templates/marshal.qtpl:26:12
+		qw422016.N().D( /*line testdata/templates/marshal.qtpl:26:13*/ r.N)
+//line testdata/templates/marshal.qtpl:26:18
 		qw422016.N().S(`}`)
-		//line testdata/templates/marshal.qtpl:28
-		if i+1 < len(d.Rows) {
-			//line testdata/templates/marshal.qtpl:28
+//line testdata/templates/marshal.qtpl:28:8
+		if /*line testdata/templates/marshal.qtpl:28:9*/ i+1 < len(d.Rows) {
+//line testdata/templates/marshal.qtpl:28:29
 			qw422016.N().S(`,`)
-			//line testdata/templates/marshal.qtpl:28
+//line testdata/templates/marshal.qtpl:28:40
 		}
-		//line testdata/templates/marshal.qtpl:29
+//line testdata/templates/marshal.qtpl:29:12
 	}
-	//line testdata/templates/marshal.qtpl:29
+//line testdata/templates/marshal.qtpl:29:14
 	qw422016.N().S(`]}`)
-//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:12
 }
 
-//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:12
 func (d *MarshalData) WriteJSON(qq422016 qtio422016.Writer) {
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	d.StreamJSON(qw422016)
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:12
 }
 
-//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:12
 func (d *MarshalData) JSON() string {
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	d.WriteJSON(qb422016)
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:11
 	return qs422016
-//line testdata/templates/marshal.qtpl:32
+//line testdata/templates/marshal.qtpl:32:12
 }
 
 // XML marshaling
 
-//line testdata/templates/marshal.qtpl:37
+//line testdata/templates/marshal.qtpl:37:9
 func (d *MarshalData) StreamXML(qw422016 *qt422016.Writer) {
-	//line testdata/templates/marshal.qtpl:37
+//line testdata/templates/marshal.qtpl:37:33
 	qw422016.N().S(`<MarshalData><Foo>`)
-	//line testdata/templates/marshal.qtpl:39
-	qw422016.N().D(d.Foo)
-	//line testdata/templates/marshal.qtpl:39
+//line testdata/templates/marshal.qtpl:39:10
+	qw422016.N().D( /*line testdata/templates/marshal.qtpl:39:10*/ d.Foo)
+//line testdata/templates/marshal.qtpl:39:18
 	qw422016.N().S(`</Foo><Bar>`)
-	//line testdata/templates/marshal.qtpl:40
-	qw422016.E().S(d.Bar)
-	//line testdata/templates/marshal.qtpl:40
+//line testdata/templates/marshal.qtpl:40:10
+	qw422016.E().S( /*line testdata/templates/marshal.qtpl:40:10*/ d.Bar)
+//line testdata/templates/marshal.qtpl:40:18
 	qw422016.N().S(`</Bar>`)
-	//line testdata/templates/marshal.qtpl:41
-	for _, r := range d.Rows {
-		//line testdata/templates/marshal.qtpl:41
+//line testdata/templates/marshal.qtpl:41:8
+	for /*line testdata/templates/marshal.qtpl:41:8*/ _, r := range d.Rows {
+//line testdata/templates/marshal.qtpl:41:30
 		qw422016.N().S(`<Rows><Msg>`)
-		//line testdata/templates/marshal.qtpl:43
-		qw422016.E().S(r.Msg)
-		//line testdata/templates/marshal.qtpl:43
+//line testdata/templates/marshal.qtpl:43:11
+		qw422016.E().S( /*line testdata/templates/marshal.qtpl:43:12*/ r.Msg)
+//line testdata/templates/marshal.qtpl:43:19
 		qw422016.N().S(`</Msg><N>`)
-		//line testdata/templates/marshal.qtpl:44
-		qw422016.N().D(r.N)
-		//line testdata/templates/marshal.qtpl:44
+//line testdata/templates/marshal.qtpl:44:9
+		qw422016.N().D( /*line testdata/templates/marshal.qtpl:44:10*/ r.N)
+//line testdata/templates/marshal.qtpl:44:15
 		qw422016.N().S(`</N></Rows>`)
-		//line testdata/templates/marshal.qtpl:46
+//line testdata/templates/marshal.qtpl:46:11
 	}
-	//line testdata/templates/marshal.qtpl:46
+//line testdata/templates/marshal.qtpl:46:13
 	qw422016.N().S(`</MarshalData>`)
-//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:12
 }
 
-//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:12
 func (d *MarshalData) WriteXML(qq422016 qtio422016.Writer) {
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	qw422016 := qt422016.AcquireWriter(qq422016)
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	d.StreamXML(qw422016)
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	qt422016.ReleaseWriter(qw422016)
-//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:12
 }
 
-//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:12
 func (d *MarshalData) XML() string {
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	qb422016 := qt422016.AcquireByteBuffer()
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	d.WriteXML(qb422016)
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	qs422016 := string(qb422016.B)
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	qt422016.ReleaseByteBuffer(qb422016)
-	//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:11
 	return qs422016
-//line testdata/templates/marshal.qtpl:48
+//line testdata/templates/marshal.qtpl:48:12
 }
@@ -30,3 +30,69 @@ func hexCharUpper(c byte) byte {
 	}
 	return c - 10 + 'A'
 }
+
+// unsafeURL is written instead of urls with unsafe schemes such as javascript:.
+const unsafeURL = "#ZqtplZ"
+
+func appendURLFilter(dst []byte, src string) []byte {
+	if !isSafeURL(src) {
+		return append(dst, unsafeURL...)
+	}
+	return appendURLNormalize(dst, src)
+}
+
+func isSafeURL(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '/', '?', '#':
+			// relative url
+			return true
+		case ':':
+			scheme := s[:i]
+			return strEqualFold(scheme, "http") || strEqualFold(scheme, "https") || strEqualFold(scheme, "mailto")
+		}
+	}
+	return true
+}
+
+func strEqualFold(s, lower string) bool {
+	if len(s) != len(lower) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != lower[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func appendURLNormalize(dst []byte, src string) []byte {
+	n := len(src)
+	if n > 0 {
+		// Hint the compiler to remove bounds checks in the loop below.
+		_ = src[n-1]
+	}
+	for i := 0; i < n; i++ {
+		c := src[i]
+
+		// See https://tools.ietf.org/html/rfc3986#section-2.2 .
+		// Reserved characters and '%' are left as is, since they
+		// are already meaningful in the url.
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			dst = append(dst, c)
+			continue
+		}
+		switch c {
+		case '-', '.', '_', '~', '!', '#', '$', '&', '*', '+', ',', '/', ':', ';', '=', '?', '@', '[', ']', '%':
+			dst = append(dst, c)
+		default:
+			dst = append(dst, '%', hexCharUpper(c>>4), hexCharUpper(c&15))
+		}
+	}
+	return dst
+}
@@ -20,3 +20,27 @@ func testAppendURLEncode(t *testing.T, s string) {
 		t.Fatalf("unexpected result %q. Expecting %q. str=%q", result, expectedResult, s)
 	}
 }
+
+func TestAppendURLFilter(t *testing.T) {
+	testAppendURLFilter(t, "", "")
+	testAppendURLFilter(t, "/foo/bar?a=b&c=d#baz", "/foo/bar?a=b&c=d#baz")
+	testAppendURLFilter(t, "http://example.com/", "http://example.com/")
+	testAppendURLFilter(t, "HTTPS://example.com/", "HTTPS://example.com/")
+	testAppendURLFilter(t, "mailto:foo@example.com", "mailto:foo@example.com")
+	testAppendURLFilter(t, "foo/bar:baz", "foo/bar:baz")
+	testAppendURLFilter(t, "?next=javascript:alert(1)", "?next=javascript:alert%281%29")
+	testAppendURLFilter(t, `/a b"<тест>'`, "/a%20b%22%3C%D1%82%D0%B5%D1%81%D1%82%3E%27")
+
+	// unsafe schemes
+	testAppendURLFilter(t, "javascript:alert(1)", unsafeURL)
+	testAppendURLFilter(t, "JavaScript:alert(1)", unsafeURL)
+	testAppendURLFilter(t, "data:text/html,foo", unsafeURL)
+	testAppendURLFilter(t, "vbscript:foo", unsafeURL)
+}
+
+func testAppendURLFilter(t *testing.T, s, expectedResult string) {
+	result := appendURLFilter(nil, s)
+	if string(result) != expectedResult {
+		t.Fatalf("unexpected result %q. Expecting %q. str=%q", result, expectedResult, s)
+	}
+}
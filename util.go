@@ -1,3 +1,3 @@
 package quicktemplate
 
-//go:generate qtc -dir=testdata/templates
+//go:generate qtc -dir=testdata/templates -autoescape
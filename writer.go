@@ -13,6 +13,11 @@ import (
 type Writer struct {
 	e QWriter
 	n QWriter
+
+	stacks *writerStacks
+
+	// parent is the writer passed to AcquireCaptureWriter.
+	parent *Writer
 }
 
 // W returns the underlying writer passed to AcquireWriter.
@@ -30,6 +35,22 @@ func (qw *Writer) N() *QWriter {
 	return &qw.n
 }
 
+// Err returns the first error occurred when writing to the underlying writer.
+//
+// Subsequent writes to the failed QWriter are ignored, so template funcs
+// may check Err in order to stop rendering early.
+func (qw *Writer) Err() error {
+	if qw.n.err != nil {
+		return qw.n.err
+	}
+	return qw.e.err
+}
+
+// Slot is a template body passed to template func via {% call %} tag.
+//
+// Template funcs render slot args via {%= slot() %} tag.
+type Slot func(qw *Writer)
+
 // AcquireWriter returns new writer from the pool.
 //
 // Return unneeded writer to the pool by calling ReleaseWriter
@@ -49,8 +70,16 @@ func AcquireWriter(w io.Writer) *Writer {
 
 // ReleaseWriter returns the writer to the pool.
 //
+// The output held back by Writer.Stack is flushed to the underlying writer.
+//
 // Do not access released writer, otherwise data races may occur.
 func ReleaseWriter(qw *Writer) {
+	if qw.stacks != nil {
+		qw.Flush()
+		qw.stacks.reset()
+	}
+	qw.parent = nil
+
 	hw := qw.e.w.(*htmlEscapeWriter)
 	hw.w = nil
 	qw.e.Reset()
@@ -178,3 +207,44 @@ func (w *QWriter) U(s string) {
 func (w *QWriter) UZ(z []byte) {
 	w.U(unsafeBytesToStr(z))
 }
+
+// URL writes s to w if s is a safe url.
+//
+// Urls with schemes other than http, https and mailto are replaced
+// by "#ZqtplZ", so javascript:... urls cannot be injected into
+// href and src attributes. Characters not allowed in urls are
+// percent-encoded.
+func (w *QWriter) URL(s string) {
+	bb, ok := w.w.(*ByteBuffer)
+	if ok {
+		bb.B = appendURLFilter(bb.B, s)
+	} else {
+		w.b = appendURLFilter(w.b[:0], s)
+		w.Write(w.b)
+	}
+}
+
+// URLZ writes z to w if z is a safe url.
+//
+// See URL for details.
+func (w *QWriter) URLZ(z []byte) {
+	w.URL(unsafeBytesToStr(z))
+}
+
+// CSS writes css-escaped s to w.
+//
+// The result is safe for embedding into <style> and style attributes.
+func (w *QWriter) CSS(s string) {
+	bb, ok := w.w.(*ByteBuffer)
+	if ok {
+		bb.B = appendCSSEscape(bb.B, s)
+	} else {
+		w.b = appendCSSEscape(w.b[:0], s)
+		w.Write(w.b)
+	}
+}
+
+// CSSZ writes css-escaped z to w.
+func (w *QWriter) CSSZ(z []byte) {
+	w.CSS(unsafeBytesToStr(z))
+}
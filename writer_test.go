@@ -1,6 +1,7 @@
 package quicktemplate
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -56,6 +57,52 @@ func TestWriter(t *testing.T) {
 	ReleaseByteBuffer(bb)
 }
 
+type failingWriter struct {
+	n int
+}
+
+var errFailingWriter = errors.New("failing writer error")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, errFailingWriter
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestWriterErr(t *testing.T) {
+	fw := &failingWriter{n: 2}
+	qw := AcquireWriter(fw)
+	if err := qw.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	qw.N().S("foo")
+	qw.E().S("bar")
+	if err := qw.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	qw.N().S("baz")
+	if err := qw.Err(); err != errFailingWriter {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errFailingWriter)
+	}
+
+	// subsequent writes must be ignored
+	fw.n = 10
+	qw.N().S("aaa")
+	if fw.n != 10 {
+		t.Fatalf("unexpected write after the error")
+	}
+	ReleaseWriter(qw)
+
+	// released writer mustn't contain the error
+	qw = AcquireWriter(fw)
+	if err := qw.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ReleaseWriter(qw)
+}
+
 func TestQWriterS(t *testing.T) {
 	testQWriter(t, func(wn, we *QWriter) string {
 		s := "\u0000" + `foo<>&'" bar
@@ -187,6 +234,39 @@ func TestQWriterFPrec(t *testing.T) {
 	})
 }
 
+func TestQWriterURL(t *testing.T) {
+	testQWriter(t, func(wn, we *QWriter) string {
+		wn.URL("javascript:alert(1)")
+		we.URL(`/foo?a=1&b="<2>"`)
+		return "#ZqtplZ/foo?a=1&amp;b=%22%3C2%3E%22"
+	})
+}
+
+func TestQWriterURLZ(t *testing.T) {
+	testQWriter(t, func(wn, we *QWriter) string {
+		wn.URLZ([]byte("data:text/html,foo"))
+		we.URLZ([]byte("https://example.com/a b"))
+		return "#ZqtplZhttps://example.com/a%20b"
+	})
+}
+
+func TestQWriterCSS(t *testing.T) {
+	testQWriter(t, func(wn, we *QWriter) string {
+		s := `red;x:"</style>"`
+		wn.CSS(s)
+		we.CSS(s)
+		return `red\3b x\3a \22 \3c \2f style\3e \22 red\3b x\3a \22 \3c \2f style\3e \22 `
+	})
+}
+
+func TestQWriterCSSZ(t *testing.T) {
+	testQWriter(t, func(wn, we *QWriter) string {
+		wn.CSSZ([]byte("#fff"))
+		we.CSSZ([]byte("a b"))
+		return `#fffa\20 b`
+	})
+}
+
 func testQWriter(t *testing.T, f func(wn, we *QWriter) (expectedS string)) {
 	bb := AcquireByteBuffer()
 	qw := AcquireWriter(bb)